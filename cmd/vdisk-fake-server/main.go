@@ -0,0 +1,69 @@
+// Command vdisk-fake-server serves the fake VDisk gRPC service from package
+// vdisktest on a local port, so that vdisk-client can be run offline.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk/vdisktest"
+)
+
+var (
+	listenAddress   = flag.String("listen", "127.0.0.1:9090", "Address to serve the fake VDisk service on")
+	diskSize        = flag.Int64("disk_size", vdisktest.DefaultDiskSize, "Size in bytes of disks created on first use")
+	maxResponseSize = flag.Int64("max_response_size", vdisktest.DefaultMaxResponseSize, "Data bytes per read response when the request sets no max_response_size")
+	zeroBlockSize   = flag.Int64("zero_block_size", vdisktest.DefaultZeroBlockSize, "Granularity at which reads report zero_data ranges")
+	diskDir         = flag.String("disk_dir", "", "Keep disks as sparse files in this directory instead of in memory")
+	faultsFile      = flag.String("faults", "", "JSON fault injection script")
+)
+
+func main() {
+	flag.Parse()
+
+	opts := vdisktest.ServerOptions{
+		DiskSize:        *diskSize,
+		MaxResponseSize: *maxResponseSize,
+		ZeroBlockSize:   *zeroBlockSize,
+	}
+	if *diskDir != "" {
+		if err := os.MkdirAll(*diskDir, 0o755); err != nil {
+			log.Fatalf("Failed to create disk_dir: %v", err)
+		}
+		opts.NewDisk = func(key string, size int64) (vdisktest.Disk, error) {
+			name := strings.NewReplacer(":", "_", "/", "_").Replace(key) + ".img"
+			return vdisktest.OpenFileDisk(filepath.Join(*diskDir, name), size)
+		}
+	}
+
+	if *faultsFile != "" {
+		script, err := vdisktest.LoadFaultScript(*faultsFile)
+		if err != nil {
+			log.Fatalf("Failed to load fault script: %v", err)
+		}
+		opts.Faults = script
+		fmt.Printf("Loaded %d fault injection rules from %s\n", len(script.Faults), *faultsFile)
+	}
+
+	server := vdisktest.NewServer(opts)
+	addr, err := server.Start(*listenAddress)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *listenAddress, err)
+	}
+	fmt.Printf("Fake VDisk server listening on %s (no TLS, no auth)\n", addr)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	server.Stop()
+	stats := server.Stats()
+	fmt.Printf("Served %d read requests (%d bytes) and %d write requests (%d bytes), injected %d faults\n",
+		stats.ReadRequests, stats.BytesRead, stats.WriteRequests, stats.BytesWritten, stats.FaultsInjected)
+}
@@ -0,0 +1,314 @@
+// Package histogram implements an HDR-style histogram for latency
+// recording. Values are bucketed with a fixed number of significant
+// decimal digits across the whole trackable range, using the same counts
+// layout as HdrHistogram so that histograms can be exchanged with its tools
+// through the log format written by LogWriter.
+package histogram
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyMax is the largest latency tracked by NewLatency
+const DefaultLatencyMax = time.Hour
+
+// Histogram counts int64 values in [1, highest] with sigFigs significant
+// decimal digits of precision. Larger values are clamped to highest and
+// values below 1 are recorded as 1. It is safe for concurrent use.
+type Histogram struct {
+	mu sync.Mutex
+
+	highest int64
+	sigFigs int
+
+	subBucketHalfCountMagnitude int
+	subBucketHalfCount          int
+	subBucketMask               int64
+	subBucketCount              int
+
+	counts []int64
+	total  int64
+	sum    float64
+	min    int64
+	max    int64
+}
+
+// New returns a Histogram tracking values from 1 to highest with sigFigs
+// (1 to 5) significant digits
+func New(highest int64, sigFigs int) *Histogram {
+	if sigFigs < 1 || sigFigs > 5 {
+		panic(fmt.Sprintf("histogram: sigFigs must be between 1 and 5, got %d", sigFigs))
+	}
+	if highest < 2 {
+		highest = 2
+	}
+
+	largestSingleUnit := 2 * int64(math.Pow10(sigFigs))
+	subBucketCountMagnitude := int(math.Ceil(math.Log2(float64(largestSingleUnit))))
+	h := &Histogram{
+		highest:                     highest,
+		sigFigs:                     sigFigs,
+		subBucketHalfCountMagnitude: max(subBucketCountMagnitude, 1) - 1,
+	}
+	h.subBucketCount = 1 << (h.subBucketHalfCountMagnitude + 1)
+	h.subBucketHalfCount = h.subBucketCount / 2
+	h.subBucketMask = int64(h.subBucketCount - 1)
+
+	// Each bucket doubles the range covered by the previous one
+	buckets := 1
+	for smallestUntrackable := int64(h.subBucketCount); smallestUntrackable <= highest; buckets++ {
+		if smallestUntrackable > math.MaxInt64/2 {
+			buckets++
+			break
+		}
+		smallestUntrackable <<= 1
+	}
+	h.counts = make([]int64, (buckets+1)*h.subBucketHalfCount)
+	h.min = math.MaxInt64
+	return h
+}
+
+// NewLatency returns a Histogram of nanosecond latencies up to
+// DefaultLatencyMax with three significant digits
+func NewLatency() *Histogram {
+	return New(int64(DefaultLatencyMax), 3)
+}
+
+// Highest returns the largest trackable value
+func (h *Histogram) Highest() int64 {
+	return h.highest
+}
+
+// SignificantFigures returns the precision the histogram was created with
+func (h *Histogram) SignificantFigures() int {
+	return h.sigFigs
+}
+
+func (h *Histogram) bucketIndex(v int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(v|h.subBucketMask))
+	return pow2Ceiling - (h.subBucketHalfCountMagnitude + 1)
+}
+
+func (h *Histogram) countsIndex(v int64) int {
+	bucket := h.bucketIndex(v)
+	subBucket := int(v >> bucket)
+	return (bucket+1)<<h.subBucketHalfCountMagnitude + (subBucket - h.subBucketHalfCount)
+}
+
+// valueAt returns the lowest value counted at counts index i
+func (h *Histogram) valueAt(i int) int64 {
+	bucket := (i >> h.subBucketHalfCountMagnitude) - 1
+	subBucket := (i & (h.subBucketHalfCount - 1)) + h.subBucketHalfCount
+	if bucket < 0 {
+		subBucket -= h.subBucketHalfCount
+		bucket = 0
+	}
+	return int64(subBucket) << bucket
+}
+
+// highestEquivalent returns the largest value counted in the same slot as v
+func (h *Histogram) highestEquivalent(v int64) int64 {
+	bucket := h.bucketIndex(v)
+	subBucket := int(v >> bucket)
+	lowest := int64(subBucket) << bucket
+	if subBucket >= h.subBucketCount {
+		bucket++
+	}
+	return lowest + int64(1)<<bucket - 1
+}
+
+// Record counts one value
+func (h *Histogram) Record(v int64) {
+	h.RecordN(v, 1)
+}
+
+// RecordDuration counts one latency in nanoseconds
+func (h *Histogram) RecordDuration(d time.Duration) {
+	h.RecordN(int64(d), 1)
+}
+
+// RecordN counts v n times
+func (h *Histogram) RecordN(v int64, n int64) {
+	if n <= 0 {
+		return
+	}
+	v = min(max(v, 1), h.highest)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[h.countsIndex(v)] += n
+	h.total += n
+	h.sum += float64(v) * float64(n)
+	h.min = min(h.min, v)
+	h.max = max(h.max, v)
+}
+
+// Count returns the number of recorded values
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.total
+}
+
+// Min returns the smallest recorded value, or 0 when empty
+func (h *Histogram) Min() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the largest recorded value, or 0 when empty
+func (h *Histogram) Max() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.max
+}
+
+// Mean returns the exact mean of the recorded values, or 0 when empty
+func (h *Histogram) Mean() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+// ValueAtPercentile returns the value below which percentile percent of
+// the recorded values fall, reported as the highest value equivalent to
+// it at the histogram's precision. It returns 0 when empty.
+func (h *Histogram) ValueAtPercentile(percentile float64) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.valueAtPercentile(percentile)
+}
+
+func (h *Histogram) valueAtPercentile(percentile float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	percentile = min(max(percentile, 0), 100)
+	target := max(int64(percentile/100*float64(h.total)+0.5), 1)
+
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			return min(h.highestEquivalent(h.valueAt(i)), h.max)
+		}
+	}
+	return h.max
+}
+
+// Percentile pairs a percentile with its value
+type Percentile struct {
+	Percentile float64 `json:"percentile"`
+	Value      int64   `json:"value"`
+}
+
+// StandardPercentiles are the percentiles included in reports
+var StandardPercentiles = []float64{50, 90, 99, 99.9, 99.99}
+
+// Percentiles returns the values at each of percentiles
+func (h *Histogram) Percentiles(percentiles []float64) []Percentile {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]Percentile, len(percentiles))
+	for i, p := range percentiles {
+		out[i] = Percentile{Percentile: p, Value: h.valueAtPercentile(p)}
+	}
+	return out
+}
+
+// Merge adds every value recorded in other to h. Both histograms must
+// have been created with the same highest value and precision.
+func (h *Histogram) Merge(other *Histogram) error {
+	snap := other.Snapshot(false)
+	if snap.highest != h.highest || snap.sigFigs != h.sigFigs {
+		return fmt.Errorf("histogram: cannot merge a [1, %d] %d digit histogram into a [1, %d] %d digit one",
+			snap.highest, snap.sigFigs, h.highest, h.sigFigs)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, c := range snap.counts {
+		h.counts[i] += c
+	}
+	if snap.total > 0 {
+		h.total += snap.total
+		h.sum += snap.sum
+		h.min = min(h.min, snap.min)
+		h.max = max(h.max, snap.max)
+	}
+	return nil
+}
+
+// Snapshot returns a copy of h, clearing h when reset is set. Taking a
+// resetting snapshot at fixed intervals yields interval histograms.
+func (h *Histogram) Snapshot(reset bool) *Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snap := &Histogram{
+		highest:                     h.highest,
+		sigFigs:                     h.sigFigs,
+		subBucketHalfCountMagnitude: h.subBucketHalfCountMagnitude,
+		subBucketHalfCount:          h.subBucketHalfCount,
+		subBucketMask:               h.subBucketMask,
+		subBucketCount:              h.subBucketCount,
+		counts:                      append([]int64(nil), h.counts...),
+		total:                       h.total,
+		sum:                         h.sum,
+		min:                         h.min,
+		max:                         h.max,
+	}
+	if reset {
+		h.reset()
+	}
+	return snap
+}
+
+// Reset clears all recorded values
+func (h *Histogram) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reset()
+}
+
+func (h *Histogram) reset() {
+	clear(h.counts)
+	h.total = 0
+	h.sum = 0
+	h.min = math.MaxInt64
+	h.max = 0
+}
+
+// Bucket is one non-empty histogram slot
+type Bucket struct {
+	// From and To are the lowest and highest values counted in the slot
+	From  int64 `json:"from"`
+	To    int64 `json:"to"`
+	Count int64 `json:"count"`
+}
+
+// Buckets returns the non-empty slots in ascending value order
+func (h *Histogram) Buckets() []Bucket {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []Bucket
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		v := h.valueAt(i)
+		out = append(out, Bucket{From: v, To: h.highestEquivalent(v), Count: c})
+	}
+	return out
+}
@@ -0,0 +1,251 @@
+package histogram
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// resolution returns the width of the slot holding v at three significant
+// digits, derived independently of the index arithmetic: values below 2048
+// are exact, and every power of two above doubles the width
+func resolution(v int64) int64 {
+	magnitude := 63 - bits.LeadingZeros64(uint64(v))
+	if magnitude < 11 {
+		return 1
+	}
+	return 1 << (magnitude - 10)
+}
+
+func TestLayout(t *testing.T) {
+	h := New(int64(time.Hour), 3)
+	if h.subBucketCount != 2048 || h.subBucketHalfCount != 1024 || h.subBucketHalfCountMagnitude != 10 {
+		t.Fatalf("sub-buckets %d/%d/%d, want 2048/1024/10", h.subBucketCount, h.subBucketHalfCount, h.subBucketHalfCountMagnitude)
+	}
+	// 2^41 is the first power of two above one hour in nanoseconds, so 31
+	// buckets follow the first 2048 exact values
+	if want := (31 + 2) * 1024; len(h.counts) != want {
+		t.Errorf("%d counts, want %d", len(h.counts), want)
+	}
+	if i := h.countsIndex(h.highest); i >= len(h.counts) {
+		t.Errorf("highest value maps to index %d of %d", i, len(h.counts))
+	}
+}
+
+func TestBucketBoundaries(t *testing.T) {
+	h := New(int64(time.Hour), 3)
+	for _, tc := range []struct {
+		v, lowest, highest int64
+	}{
+		{1, 1, 1},
+		{1000, 1000, 1000},
+		{2047, 2047, 2047},
+		{2048, 2048, 2049},
+		{2049, 2048, 2049},
+		{4095, 4094, 4095},
+		{4096, 4096, 4099},
+		{10007, 10000, 10007},
+		{1_000_000, 999_936, 1_000_447},
+		{int64(time.Second), 999_817_216, 1_000_341_503},
+	} {
+		i := h.countsIndex(tc.v)
+		if got := h.valueAt(i); got != tc.lowest {
+			t.Errorf("valueAt(countsIndex(%d)) = %d, want %d", tc.v, got, tc.lowest)
+		}
+		if got := h.highestEquivalent(tc.v); got != tc.highest {
+			t.Errorf("highestEquivalent(%d) = %d, want %d", tc.v, got, tc.highest)
+		}
+	}
+}
+
+func TestSlotsAreContiguous(t *testing.T) {
+	h := New(int64(time.Hour), 3)
+	last := h.countsIndex(h.highest)
+	for i := 1; i < last; i++ {
+		lowest, next := h.valueAt(i), h.valueAt(i+1)
+		if highest := h.highestEquivalent(lowest); highest+1 != next {
+			t.Fatalf("slot %d covers [%d, %d] but slot %d starts at %d", i, lowest, highest, i+1, next)
+		}
+		if width := next - lowest; width != resolution(lowest) {
+			t.Fatalf("slot %d at %d is %d wide, want %d", i, lowest, width, resolution(lowest))
+		}
+		if h.countsIndex(lowest) != i || h.countsIndex(next-1) != i {
+			t.Fatalf("values %d and %d do not map back to slot %d", lowest, next-1, i)
+		}
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, sigFigs := range []int{1, 2, 3, 4, 5} {
+		h := New(math.MaxInt64/4, sigFigs)
+		limit := 1 / math.Pow10(sigFigs)
+		for n := 0; n < 10000; n++ {
+			v := rng.Int63n(h.highest) + 1
+			lowest, highest := h.valueAt(h.countsIndex(v)), h.highestEquivalent(v)
+			if v < lowest || v > highest {
+				t.Fatalf("%d digits: %d outside its slot [%d, %d]", sigFigs, v, lowest, highest)
+			}
+			if rel := float64(highest-lowest) / float64(lowest); rel > limit {
+				t.Fatalf("%d digits: slot [%d, %d] is %.2g wide relative to its value", sigFigs, lowest, highest, rel)
+			}
+		}
+	}
+}
+
+func TestRecordClamps(t *testing.T) {
+	h := New(1000, 3)
+	h.Record(0)
+	h.Record(-5)
+	h.Record(5000)
+	if h.Count() != 3 || h.Min() != 1 || h.Max() != 1000 {
+		t.Errorf("count %d, min %d, max %d; want 3, 1, 1000", h.Count(), h.Min(), h.Max())
+	}
+	h.RecordN(10, 0)
+	h.RecordN(10, -1)
+	if h.Count() != 3 {
+		t.Errorf("RecordN with no count recorded values")
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	uniform := NewLatency()
+	for v := int64(1); v <= 10000; v++ {
+		uniform.Record(v)
+	}
+	constant := NewLatency()
+	constant.RecordN(42, 1000)
+	bimodal := NewLatency()
+	bimodal.RecordN(100, 900)
+	bimodal.RecordN(1_000_000, 100)
+
+	for _, tc := range []struct {
+		name       string
+		h          *Histogram
+		percentile float64
+		want       int64
+	}{
+		// Values are reported as the highest equivalent of their slot
+		{"uniform", uniform, 0, 1},
+		{"uniform", uniform, 50, 5003},
+		{"uniform", uniform, 90, 9007},
+		{"uniform", uniform, 99, 9903},
+		{"uniform", uniform, 99.99, 9999},
+		{"uniform", uniform, 100, 10000},
+		{"constant", constant, 0, 42},
+		{"constant", constant, 50, 42},
+		{"constant", constant, 100, 42},
+		{"bimodal", bimodal, 50, 100},
+		{"bimodal", bimodal, 90, 100},
+		{"bimodal", bimodal, 90.1, 1_000_000},
+		{"bimodal", bimodal, 99, 1_000_000},
+	} {
+		if got := tc.h.ValueAtPercentile(tc.percentile); got != tc.want {
+			t.Errorf("%s: p%g = %d, want %d", tc.name, tc.percentile, got, tc.want)
+		}
+	}
+
+	if got := uniform.Mean(); got != 5000.5 {
+		t.Errorf("uniform mean %g, want 5000.5", got)
+	}
+	if got := NewLatency().ValueAtPercentile(50); got != 0 {
+		t.Errorf("empty histogram p50 = %d, want 0", got)
+	}
+
+	ps := bimodal.Percentiles(StandardPercentiles)
+	for i, p := range ps {
+		if p.Percentile != StandardPercentiles[i] || p.Value != bimodal.ValueAtPercentile(p.Percentile) {
+			t.Errorf("Percentiles[%d] = %+v", i, p)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b, all := NewLatency(), NewLatency(), NewLatency()
+	rng := rand.New(rand.NewSource(2))
+	for n := 0; n < 5000; n++ {
+		v := rng.Int63n(int64(time.Second))
+		if n%3 == 0 {
+			a.Record(v)
+		} else {
+			b.Record(v)
+		}
+		all.Record(v)
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count() != all.Count() || a.Min() != all.Min() || a.Max() != all.Max() {
+		t.Errorf("merged count %d, min %d, max %d; want %d, %d, %d",
+			a.Count(), a.Min(), a.Max(), all.Count(), all.Min(), all.Max())
+	}
+	if math.Abs(a.Mean()-all.Mean()) > 1e-6*all.Mean() {
+		t.Errorf("merged mean %g, want %g", a.Mean(), all.Mean())
+	}
+	for i := range all.counts {
+		if a.counts[i] != all.counts[i] {
+			t.Fatalf("merged count at slot %d is %d, want %d", i, a.counts[i], all.counts[i])
+		}
+	}
+	if b.Count() == 0 {
+		t.Error("Merge cleared its argument")
+	}
+
+	// Merging an empty histogram changes nothing, also min
+	if err := a.Merge(NewLatency()); err != nil || a.Min() != all.Min() {
+		t.Errorf("merging an empty histogram: min %d, %v", a.Min(), err)
+	}
+	if err := a.Merge(New(int64(time.Hour), 2)); err == nil {
+		t.Error("merged histograms of different precision")
+	}
+	if err := a.Merge(New(int64(time.Minute), 3)); err == nil {
+		t.Error("merged histograms of different range")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	h := NewLatency()
+	h.Record(100)
+	h.Record(200)
+
+	snap := h.Snapshot(false)
+	h.Record(300)
+	if snap.Count() != 2 || snap.Max() != 200 {
+		t.Errorf("snapshot changed with its source: count %d, max %d", snap.Count(), snap.Max())
+	}
+	if h.Count() != 3 {
+		t.Errorf("Snapshot(false) changed the count to %d", h.Count())
+	}
+
+	interval := h.Snapshot(true)
+	if interval.Count() != 3 || interval.Min() != 100 || interval.Max() != 300 {
+		t.Errorf("interval count %d, min %d, max %d; want 3, 100, 300", interval.Count(), interval.Min(), interval.Max())
+	}
+	if h.Count() != 0 || h.Min() != 0 || h.Max() != 0 || h.Mean() != 0 || len(h.Buckets()) != 0 {
+		t.Errorf("Snapshot(true) left count %d, min %d, max %d, mean %g", h.Count(), h.Min(), h.Max(), h.Mean())
+	}
+
+	h.Record(50)
+	if next := h.Snapshot(true); next.Count() != 1 || next.Min() != 50 || next.Max() != 50 {
+		t.Errorf("next interval count %d, min %d, max %d; want 1, 50, 50", next.Count(), next.Min(), next.Max())
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	h := NewLatency()
+	h.RecordN(5, 2)
+	h.RecordN(4097, 3)
+	h.RecordN(4098, 1)
+	want := []Bucket{{From: 5, To: 5, Count: 2}, {From: 4096, To: 4099, Count: 4}}
+	got := h.Buckets()
+	if len(got) != len(want) {
+		t.Fatalf("Buckets = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Buckets[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package histogram
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Cookies of the HdrHistogram V2 encoding, with the word size bits set as
+// the reference implementation does
+const (
+	encodingCookie           = 0x1c849303 | 0x10
+	compressedEncodingCookie = 0x1c849304 | 0x10
+)
+
+// Encode returns h in the compressed HdrHistogram V2 encoding used by
+// histogram logs
+func (h *Histogram) Encode() ([]byte, error) {
+	h.mu.Lock()
+	var payload bytes.Buffer
+	relevant := 0
+	if h.total > 0 {
+		relevant = h.countsIndex(h.max) + 1
+	}
+	var varint [binary.MaxVarintLen64]byte
+	for i := 0; i < relevant; {
+		count := h.counts[i]
+		i++
+		if count == 0 {
+			zeros := int64(1)
+			for i < relevant && h.counts[i] == 0 {
+				zeros++
+				i++
+			}
+			if zeros > 1 {
+				count = -zeros
+			}
+		}
+		payload.Write(varint[:putZigZag(varint[:], count)])
+	}
+	sigFigs, highest := h.sigFigs, h.highest
+	h.mu.Unlock()
+
+	var raw bytes.Buffer
+	header := []any{
+		int32(encodingCookie),
+		int32(payload.Len()),
+		int32(0), // normalizing index offset
+		int32(sigFigs),
+		int64(1), // lowest discernible value
+		highest,
+		float64(1), // integer to double conversion ratio
+	}
+	for _, field := range header {
+		binary.Write(&raw, binary.BigEndian, field)
+	}
+	raw.Write(payload.Bytes())
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	if _, err := w.Write(raw.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 8, 8+compressed.Len())
+	binary.BigEndian.PutUint32(out[0:4], compressedEncodingCookie)
+	binary.BigEndian.PutUint32(out[4:8], uint32(compressed.Len()))
+	return append(out, compressed.Bytes()...), nil
+}
+
+// putZigZag writes v in the ZigZag LEB128 form used by HdrHistogram, in
+// which a ninth byte carries the remaining eight bits without a
+// continuation flag
+func putZigZag(buf []byte, v int64) int {
+	u := uint64((v << 1) ^ (v >> 63))
+	n := 0
+	for ; n < 8 && u >= 0x80; n++ {
+		buf[n] = byte(u) | 0x80
+		u >>= 7
+	}
+	buf[n] = byte(u)
+	return n + 1
+}
+
+// LogWriter writes interval histograms in the HdrHistogram log format
+// (version 1.3), readable by HistogramLogProcessor and similar tools.
+// Interval maxima are written in milliseconds for nanosecond histograms.
+type LogWriter struct {
+	w     io.Writer
+	start time.Time
+}
+
+// NewLogWriter writes the log header to w with start as the base time
+func NewLogWriter(w io.Writer, start time.Time) (*LogWriter, error) {
+	secs := float64(start.UnixNano()) / 1e9
+	_, err := fmt.Fprintf(w, "#[Histogram log format version 1.3]\n"+
+		"#[StartTime: %.3f (seconds since epoch), %s]\n"+
+		"#[BaseTime: %.3f (seconds since epoch)]\n"+
+		"\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n",
+		secs, start.Format(time.RFC1123), secs)
+	if err != nil {
+		return nil, err
+	}
+	return &LogWriter{w: w, start: start}, nil
+}
+
+// WriteInterval appends the histogram of values recorded in
+// [from, from+length)
+func (l *LogWriter) WriteInterval(h *Histogram, from time.Time, length time.Duration) error {
+	encoded, err := h.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(l.w, "%.3f,%.3f,%.3f,%s\n",
+		from.Sub(l.start).Seconds(), length.Seconds(), float64(h.Max())/1e6,
+		base64.StdEncoding.EncodeToString(encoded))
+	return err
+}
+
+// Summary is the JSON form of a histogram written by WriteJSON
+type Summary struct {
+	Unit               string       `json:"unit"`
+	SignificantFigures int          `json:"significant_figures"`
+	HighestTrackable   int64        `json:"highest_trackable"`
+	Count              int64        `json:"count"`
+	Min                int64        `json:"min"`
+	Max                int64        `json:"max"`
+	Mean               float64      `json:"mean"`
+	Percentiles        []Percentile `json:"percentiles"`
+	Buckets            []Bucket     `json:"buckets"`
+}
+
+// Summarize returns the JSON summary of h with StandardPercentiles. unit
+// names the recorded values, such as "ns".
+func (h *Histogram) Summarize(unit string) Summary {
+	snap := h.Snapshot(false)
+	return Summary{
+		Unit:               unit,
+		SignificantFigures: snap.sigFigs,
+		HighestTrackable:   snap.highest,
+		Count:              snap.Count(),
+		Min:                snap.Min(),
+		Max:                snap.Max(),
+		Mean:               snap.Mean(),
+		Percentiles:        snap.Percentiles(StandardPercentiles),
+		Buckets:            snap.Buckets(),
+	}
+}
+
+// WriteJSON writes the summary of h, including every non-empty bucket, as
+// indented JSON
+func (h *Histogram) WriteJSON(w io.Writer, unit string) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(h.Summarize(unit))
+}
@@ -0,0 +1,218 @@
+package histogram
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decoded is a histogram read back from the V2 encoding
+type decoded struct {
+	sigFigs int32
+	lowest  int64
+	highest int64
+	counts  []int64
+}
+
+// decode parses the compressed HdrHistogram V2 encoding as specified by the
+// reference implementation, without using the encoder's helpers
+func decode(t *testing.T, encoded []byte) decoded {
+	t.Helper()
+	if len(encoded) < 8 {
+		t.Fatalf("%d byte encoding", len(encoded))
+	}
+	if cookie := binary.BigEndian.Uint32(encoded[0:4]); cookie != 0x1c849314 {
+		t.Fatalf("compressed cookie %08x, want 1c849314", cookie)
+	}
+	if n := binary.BigEndian.Uint32(encoded[4:8]); int(n) != len(encoded)-8 {
+		t.Fatalf("compressed length %d, have %d bytes", n, len(encoded)-8)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(encoded[8:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header struct {
+		Cookie          int32
+		PayloadLength   int32
+		NormalizingBase int32
+		SigFigs         int32
+		Lowest          int64
+		Highest         int64
+		Ratio           float64
+	}
+	r := bytes.NewReader(raw)
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Cookie != 0x1c849313 {
+		t.Fatalf("encoding cookie %08x, want 1c849313", header.Cookie)
+	}
+	if header.NormalizingBase != 0 || header.Ratio != 1 {
+		t.Errorf("normalizing offset %d, conversion ratio %g; want 0, 1", header.NormalizingBase, header.Ratio)
+	}
+	if int(header.PayloadLength) != r.Len() {
+		t.Fatalf("payload length %d, have %d bytes", header.PayloadLength, r.Len())
+	}
+
+	d := decoded{sigFigs: header.SigFigs, lowest: header.Lowest, highest: header.Highest}
+	for r.Len() > 0 {
+		// ZigZag LEB128, with a ninth byte holding the last eight bits
+		var u uint64
+		for shift := 0; ; shift += 7 {
+			b, err := r.ReadByte()
+			if err != nil {
+				t.Fatal("truncated varint")
+			}
+			if shift == 56 {
+				u |= uint64(b) << 56
+				break
+			}
+			u |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		v := int64(u>>1) ^ -int64(u&1)
+		if v < 0 {
+			d.counts = append(d.counts, make([]int64, -v)...)
+		} else {
+			d.counts = append(d.counts, v)
+		}
+	}
+	return d
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		record func(h *Histogram)
+	}{
+		{"empty", func(h *Histogram) {}},
+		{"single value", func(h *Histogram) { h.Record(1) }},
+		{"exact range", func(h *Histogram) {
+			for v := int64(1); v < 2048; v += 7 {
+				h.Record(v)
+			}
+		}},
+		{"latencies", func(h *Histogram) {
+			for v := int64(1000); v < int64(time.Minute); v = v*3/2 + 1 {
+				h.RecordN(v, v%5+1)
+			}
+		}},
+		{"highest value", func(h *Histogram) { h.Record(h.Highest()) }},
+		{"nine byte counts", func(h *Histogram) { h.RecordN(5000, 1<<60) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewLatency()
+			tc.record(h)
+			encoded, err := h.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := decode(t, encoded)
+			if d.sigFigs != 3 || d.lowest != 1 || d.highest != int64(DefaultLatencyMax) {
+				t.Errorf("header %d digits, [%d, %d]", d.sigFigs, d.lowest, d.highest)
+			}
+			if len(d.counts) > len(h.counts) {
+				t.Fatalf("%d counts decoded, histogram has %d", len(d.counts), len(h.counts))
+			}
+			for i, c := range h.counts {
+				var got int64
+				if i < len(d.counts) {
+					got = d.counts[i]
+				}
+				if got != c {
+					t.Fatalf("count at slot %d (value %d) decoded as %d, want %d", i, h.valueAt(i), got, c)
+				}
+			}
+		})
+	}
+}
+
+func TestLogRoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 500_000_000)
+	var buf bytes.Buffer
+	log, err := NewLogWriter(&buf, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewLatency()
+	var intervals []*Histogram
+	for i := 0; i < 3; i++ {
+		for v := int64(1); v <= 100; v++ {
+			h.Record(v * int64(time.Millisecond) * int64(i+1))
+		}
+		interval := h.Snapshot(true)
+		intervals = append(intervals, interval)
+		if err := log.WriteInterval(interval, start.Add(time.Duration(i)*time.Second), time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sc := bufio.NewScanner(&buf)
+	sc.Buffer(nil, 1<<20)
+	var lines []string
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	want := []string{
+		"#[Histogram log format version 1.3]",
+		"#[StartTime: 1700000000.500 (seconds since epoch), ",
+		"#[BaseTime: 1700000000.500 (seconds since epoch)]",
+		`"StartTimestamp","Interval_Length","Interval_Max","Interval_Compressed_Histogram"`,
+	}
+	if len(lines) != len(want)+len(intervals) {
+		t.Fatalf("%d log lines, want %d:\n%s", len(lines), len(want)+len(intervals), strings.Join(lines, "\n"))
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("line %d is %q, want %q", i+1, lines[i], prefix)
+		}
+	}
+
+	for i, line := range lines[len(want):] {
+		fields := strings.Split(line, ",")
+		if len(fields) != 4 {
+			t.Fatalf("interval %d: %d fields in %q", i, len(fields), line)
+		}
+		if got := fields[0]; got != strconv.Itoa(i)+".000" {
+			t.Errorf("interval %d: start timestamp %s", i, got)
+		}
+		if got := fields[1]; got != "1.000" {
+			t.Errorf("interval %d: length %s", i, got)
+		}
+		// Interval maxima are written in milliseconds
+		if got, want := fields[2], strconv.Itoa(100*(i+1))+".000"; got != want {
+			t.Errorf("interval %d: max %s, want %s", i, got, want)
+		}
+
+		encoded, err := base64.StdEncoding.DecodeString(fields[3])
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := decode(t, encoded)
+		var total int64
+		for j, c := range d.counts {
+			if c != intervals[i].counts[j] {
+				t.Fatalf("interval %d: count at slot %d decoded as %d, want %d", i, j, c, intervals[i].counts[j])
+			}
+			total += c
+		}
+		if total != 100 {
+			t.Errorf("interval %d: %d values decoded, want 100", i, total)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+// Package jobfile reads fio style job files describing throughput jobs
+// against vdisks. Files are INI, in the same layout as fio job files, or
+// YAML with the same option names:
+//
+//	[global]
+//	runtime=60s
+//	vm_disk_uuid=12345678-1234-5678-9012-123456789012
+//
+//	[randread-4k]
+//	rw=randread
+//	bs=4k
+//	iodepth=32
+//
+//	[seqwrite-1m]
+//	stonewall
+//	rw=write
+//	bs=1m
+//	numjobs=2
+//
+// Jobs run concurrently unless a job sets stonewall, which makes it wait for
+// every job before it, as in fio.
+package jobfile
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
+)
+
+// Job is one job of a job file
+type Job struct {
+	Name string
+	// Operation is read, write or mixed
+	Operation string
+	// Profile holds rw, rwmixread, bs/bssplit, blockalign, offset, size,
+	// random_distribution and randseed. A zero RegionSize extends the
+	// region to the end of the disk.
+	Profile workload.Profile
+
+	IODepth  int
+	NumJobs  int
+	Runtime  time.Duration
+	RampTime time.Duration
+	// Rate is the fio rate option in bytes per second and RateIOPS the
+	// rate_iops option; zero means unthrottled
+	Rate     int64
+	RateIOPS float64
+
+	// Disks are the target disks. Every disk gets NumJobs copies of the job.
+	Disks []*protos.DiskIdentifier
+
+	// Stonewall makes the job wait for all previous jobs to finish
+	Stonewall bool
+	// PipelineWindow pipelines requests on long-lived streams as
+	// -pipeline_window does
+	PipelineWindow int
+}
+
+// ignoredOptions are fio options accepted for compatibility that have no
+// meaning against the VDisk API
+var ignoredOptions = map[string]bool{
+	"description":     true,
+	"direct":          true,
+	"group_reporting": true,
+	"ioengine":        true,
+	"thread":          true,
+	"time_based":      true,
+}
+
+// Load reads a job file, choosing YAML for .yaml and .yml files and INI
+// otherwise
+func Load(path string) ([]Job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sections []section
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		sections, err = parseYAML(f)
+	default:
+		sections, err = parseINI(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("%s: no jobs defined", path)
+	}
+
+	jobs := make([]Job, 0, len(sections))
+	names := map[string]bool{}
+	for _, s := range sections {
+		if names[s.name] {
+			return nil, fmt.Errorf("%s: duplicate job %q", path, s.name)
+		}
+		names[s.name] = true
+		job, err := newJob(s)
+		if err != nil {
+			if s.line > 0 {
+				return nil, fmt.Errorf("%s:%d: job %q: %v", path, s.line, s.name, err)
+			}
+			return nil, fmt.Errorf("%s: job %q: %v", path, s.name, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Groups splits jobs into groups that run one after another. Jobs within a
+// group run concurrently; each stonewall job starts a new group.
+func Groups(jobs []Job) [][]Job {
+	var groups [][]Job
+	for i, job := range jobs {
+		if i == 0 || job.Stonewall {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], job)
+	}
+	return groups
+}
+
+// newJob interprets the options of one section
+func newJob(s section) (Job, error) {
+	job := Job{
+		Name:      s.name,
+		Operation: workload.OpRead,
+		IODepth:   1,
+		NumJobs:   1,
+		Profile: workload.Profile{
+			ReadPercent: 100,
+			Pattern:     workload.Sequential,
+			BlockSizes:  []workload.BlockSize{{Size: 4096, Weight: 1}},
+		},
+	}
+	mix := 50.0
+
+	// Apply options in a fixed order so errors are reproducible, with rw
+	// first since random_distribution refines the pattern it selects
+	keys := make([]string, 0, len(s.options))
+	for k := range s.options {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ri, rj := isRW(keys[i]), isRW(keys[j]); ri != rj {
+			return ri
+		}
+		return keys[i] < keys[j]
+	})
+
+	var err error
+	for _, key := range keys {
+		value := s.options[key]
+		switch key {
+		case "rw", "readwrite":
+			job.Operation, job.Profile.Pattern, err = parseRW(value)
+		case "rwmixread":
+			mix, err = parsePercent(value)
+		case "rwmixwrite":
+			mix, err = parsePercent(value)
+			mix = 100 - mix
+		case "bs", "bssplit":
+			job.Profile.BlockSizes, err = parseBlockSizes(value)
+		case "blockalign":
+			job.Profile.Alignment, err = workload.ParseSize(value)
+		case "offset":
+			job.Profile.RegionOffset, err = workload.ParseSize(value)
+		case "size":
+			job.Profile.RegionSize, err = workload.ParseSize(value)
+		case "random_distribution":
+			err = parseDistribution(value, &job.Profile)
+		case "randseed":
+			job.Profile.Seed, err = strconv.ParseInt(value, 10, 64)
+		case "iodepth":
+			job.IODepth, err = parsePositive(value)
+		case "numjobs":
+			job.NumJobs, err = parsePositive(value)
+		case "runtime":
+			job.Runtime, err = parseTime(value)
+		case "ramp_time":
+			job.RampTime, err = parseTime(value)
+		case "rate":
+			job.Rate, err = workload.ParseSize(value)
+		case "rate_iops":
+			job.RateIOPS, err = strconv.ParseFloat(value, 64)
+			if err == nil && job.RateIOPS < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		case "vm_disk_uuid":
+			job.Disks = appendDisks(job.Disks, value, vdisk.VMDisk)
+		case "vg_disk_uuid":
+			job.Disks = appendDisks(job.Disks, value, vdisk.VGDisk)
+		case "disk_recovery_point_uuid":
+			job.Disks = appendDisks(job.Disks, value, vdisk.RecoveryPointDisk)
+		case "stonewall", "wait_for_previous":
+			job.Stonewall, err = parseBool(value)
+		case "pipeline_window":
+			job.PipelineWindow, err = strconv.Atoi(value)
+		default:
+			if !ignoredOptions[key] {
+				err = fmt.Errorf("unknown option")
+			}
+		}
+		if err != nil {
+			return job, fmt.Errorf("%s=%s: %v", key, value, err)
+		}
+	}
+
+	switch job.Operation {
+	case workload.OpRead:
+		job.Profile.ReadPercent = 100
+	case workload.OpWrite:
+		job.Profile.ReadPercent = 0
+	default:
+		job.Profile.ReadPercent = mix
+	}
+	if job.Profile.Alignment == 0 {
+		// fio aligns random offsets to the block size by default
+		job.Profile.Alignment = job.Profile.BlockSizes[0].Size
+		for _, b := range job.Profile.BlockSizes {
+			job.Profile.Alignment = min(job.Profile.Alignment, b.Size)
+		}
+	}
+	if job.Runtime <= 0 {
+		return job, fmt.Errorf("runtime is required")
+	}
+	if len(job.Disks) == 0 {
+		return job, fmt.Errorf("no target disk (vm_disk_uuid, vg_disk_uuid or disk_recovery_point_uuid)")
+	}
+	return job, nil
+}
+
+func isRW(key string) bool {
+	return key == "rw" || key == "readwrite"
+}
+
+// parseRW maps the fio rw option to an operation and access pattern
+func parseRW(value string) (string, string, error) {
+	switch value {
+	case "read":
+		return workload.OpRead, workload.Sequential, nil
+	case "write":
+		return workload.OpWrite, workload.Sequential, nil
+	case "randread":
+		return workload.OpRead, workload.Uniform, nil
+	case "randwrite":
+		return workload.OpWrite, workload.Uniform, nil
+	case "rw", "readwrite":
+		return "mixed", workload.Sequential, nil
+	case "randrw":
+		return "mixed", workload.Uniform, nil
+	}
+	return "", "", fmt.Errorf("must be read, write, randread, randwrite, rw or randrw")
+}
+
+// parseBlockSizes accepts a single size, fio bssplit syntax
+// (4k/30:64k/50:1m/20) or the workload syntax (4K:30%,64K:50%,1M:20%)
+func parseBlockSizes(value string) ([]workload.BlockSize, error) {
+	if strings.Contains(value, "/") {
+		value = strings.NewReplacer(":", ",", "/", ":").Replace(value)
+	}
+	return workload.ParseBlockSizes(value)
+}
+
+// parseDistribution applies the fio random_distribution option. random is
+// uniform, zipf:<exponent> is Zipfian and zoned:<io>/<region>:<io>/<region>
+// with two zones is a hotspot of the first zone. The zones' I/O and region
+// percentages must each add up to 100.
+func parseDistribution(value string, p *workload.Profile) error {
+	if p.Pattern != workload.Uniform {
+		return fmt.Errorf("only applies to randread, randwrite and randrw")
+	}
+	kind, arg, _ := strings.Cut(value, ":")
+	switch kind {
+	case "random":
+		return nil
+	case "zipf":
+		p.Pattern = workload.Zipfian
+		if arg == "" {
+			return nil
+		}
+		exp, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return err
+		}
+		p.ZipfExponent = exp
+		return nil
+	case "zoned":
+		zones := strings.Split(arg, ":")
+		if len(zones) != 2 {
+			return fmt.Errorf("zoned needs exactly two zones, e.g. zoned:90/10:10/90")
+		}
+		var ioPercent, regionPercent [2]float64
+		for i, zone := range zones {
+			io, region, ok := strings.Cut(zone, "/")
+			if !ok {
+				return fmt.Errorf("invalid zone %q", zone)
+			}
+			var err error
+			if ioPercent[i], err = parsePercent(io); err != nil {
+				return fmt.Errorf("zone %q: %v", zone, err)
+			}
+			if regionPercent[i], err = parsePercent(region); err != nil {
+				return fmt.Errorf("zone %q: %v", zone, err)
+			}
+		}
+		// As in fio, the zones split both the I/O and the region between them
+		if math.Abs(ioPercent[0]+ioPercent[1]-100) > 1e-9 {
+			return fmt.Errorf("zone I/O percentages add up to %g, not 100", ioPercent[0]+ioPercent[1])
+		}
+		if math.Abs(regionPercent[0]+regionPercent[1]-100) > 1e-9 {
+			return fmt.Errorf("zone region percentages add up to %g, not 100", regionPercent[0]+regionPercent[1])
+		}
+		if regionPercent[0] == 0 || regionPercent[1] == 0 {
+			return fmt.Errorf("each zone must cover part of the region")
+		}
+		p.Pattern = workload.Hotspot
+		p.HotspotPercent = ioPercent[0]
+		p.HotspotFraction = regionPercent[0] / 100
+		return nil
+	}
+	return fmt.Errorf("must be random, zipf[:exponent] or zoned:<io>/<region>:<io>/<region>")
+}
+
+// parseTime parses fio times: plain numbers are seconds, otherwise a Go
+// duration such as 500ms or 2m
+func parseTime(value string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func parsePercent(value string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || v < 0 || v > 100 {
+		return 0, fmt.Errorf("must be a percentage from 0 to 100")
+	}
+	return v, nil
+}
+
+func parsePositive(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("must be a positive integer")
+	}
+	return n, nil
+}
+
+// parseBool treats a bare option as true, as fio does
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// appendDisks adds the disks of a comma or colon separated UUID list
+func appendDisks(disks []*protos.DiskIdentifier, value string, disk func(string) *protos.DiskIdentifier) []*protos.DiskIdentifier {
+	for _, uuid := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ':' }) {
+		if uuid = strings.TrimSpace(uuid); uuid != "" {
+			disks = append(disks, disk(uuid))
+		}
+	}
+	return disks
+}
@@ -0,0 +1,304 @@
+package jobfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
+)
+
+// load writes content to a file called name and loads it
+func load(t *testing.T, name, content string) ([]Job, string, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := Load(path)
+	return jobs, path, err
+}
+
+func diskKeys(job Job) []string {
+	keys := make([]string, len(job.Disks))
+	for i, d := range job.Disks {
+		keys[i] = vdisk.DiskKey(d)
+	}
+	return keys
+}
+
+func TestLoadINI(t *testing.T) {
+	jobs, _, err := load(t, "jobs.fio", `
+; fio style comments
+# in both forms
+[global]
+runtime=60
+vm_disk_uuid=disk-a
+ioengine=libaio
+direct=1
+
+[randread-4k]
+rw=randread
+bs=4k
+iodepth=32
+ramp_time=10s
+random_distribution=zipf:1.2
+
+[seqwrite]
+rw=write
+bssplit=4k/30:64k/50:1m/20
+numjobs=2
+rate=100m
+
+[global]
+runtime=2m
+
+[mixed]
+stonewall
+rw=randrw
+rwmixwrite=30
+bs=16k
+blockalign=4k
+offset=1g
+size=10g
+rate_iops=2000
+randseed=7
+pipeline_window=8
+vm_disk_uuid=disk-b,disk-c
+vg_disk_uuid=disk-d
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 3 {
+		t.Fatalf("%d jobs, want 3", len(jobs))
+	}
+	randread, seqwrite, mixed := jobs[0], jobs[1], jobs[2]
+
+	if randread.Name != "randread-4k" || randread.Operation != workload.OpRead || randread.Profile.ReadPercent != 100 {
+		t.Errorf("randread-4k: %s, %s, %g%% reads", randread.Name, randread.Operation, randread.Profile.ReadPercent)
+	}
+	if randread.Profile.Pattern != workload.Zipfian || randread.Profile.ZipfExponent != 1.2 {
+		t.Errorf("randread-4k: pattern %s, exponent %g", randread.Profile.Pattern, randread.Profile.ZipfExponent)
+	}
+	if randread.IODepth != 32 || randread.NumJobs != 1 || randread.Runtime != time.Minute || randread.RampTime != 10*time.Second {
+		t.Errorf("randread-4k: iodepth %d, numjobs %d, runtime %v, ramp_time %v", randread.IODepth, randread.NumJobs, randread.Runtime, randread.RampTime)
+	}
+	// fio aligns to the block size unless blockalign is set
+	if randread.Profile.Alignment != 4096 {
+		t.Errorf("randread-4k: alignment %d, want 4096", randread.Profile.Alignment)
+	}
+	if got := diskKeys(randread); !reflect.DeepEqual(got, []string{"vm_disk:disk-a"}) {
+		t.Errorf("randread-4k: disks %v inherited from [global]", got)
+	}
+
+	wantSizes := []workload.BlockSize{{Size: 4 << 10, Weight: 30}, {Size: 64 << 10, Weight: 50}, {Size: 1 << 20, Weight: 20}}
+	if !reflect.DeepEqual(seqwrite.Profile.BlockSizes, wantSizes) {
+		t.Errorf("seqwrite: block sizes %v, want %v", seqwrite.Profile.BlockSizes, wantSizes)
+	}
+	if seqwrite.Operation != workload.OpWrite || seqwrite.Profile.Pattern != workload.Sequential || seqwrite.Profile.ReadPercent != 0 {
+		t.Errorf("seqwrite: %s, %s, %g%% reads", seqwrite.Operation, seqwrite.Profile.Pattern, seqwrite.Profile.ReadPercent)
+	}
+	if seqwrite.NumJobs != 2 || seqwrite.Rate != 100<<20 || seqwrite.Profile.Alignment != 4096 {
+		t.Errorf("seqwrite: numjobs %d, rate %d, alignment %d", seqwrite.NumJobs, seqwrite.Rate, seqwrite.Profile.Alignment)
+	}
+
+	// The second [global] applies to jobs after it only
+	if seqwrite.Runtime != time.Minute || mixed.Runtime != 2*time.Minute {
+		t.Errorf("runtimes %v and %v, want 1m and 2m", seqwrite.Runtime, mixed.Runtime)
+	}
+	if !mixed.Stonewall || randread.Stonewall || seqwrite.Stonewall {
+		t.Errorf("stonewall %t, %t, %t; want only the bare option on mixed", randread.Stonewall, seqwrite.Stonewall, mixed.Stonewall)
+	}
+	if mixed.Operation != "mixed" || mixed.Profile.Pattern != workload.Uniform || mixed.Profile.ReadPercent != 70 {
+		t.Errorf("mixed: %s, %s, %g%% reads; want rwmixwrite=30 as 70%% reads", mixed.Operation, mixed.Profile.Pattern, mixed.Profile.ReadPercent)
+	}
+	p := mixed.Profile
+	if p.Alignment != 4096 || p.RegionOffset != 1<<30 || p.RegionSize != 10<<30 || p.Seed != 7 {
+		t.Errorf("mixed: alignment %d, region %d+%d, seed %d", p.Alignment, p.RegionOffset, p.RegionSize, p.Seed)
+	}
+	if mixed.RateIOPS != 2000 || mixed.PipelineWindow != 8 {
+		t.Errorf("mixed: rate_iops %g, pipeline_window %d", mixed.RateIOPS, mixed.PipelineWindow)
+	}
+	if got, want := diskKeys(mixed), []string{"vg_disk:disk-d", "vm_disk:disk-b", "vm_disk:disk-c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mixed: disks %v, want %v", got, want)
+	}
+
+	groups := Groups(jobs)
+	if len(groups) != 2 || len(groups[0]) != 2 || len(groups[1]) != 1 || groups[1][0].Name != "mixed" {
+		t.Errorf("stonewall grouped the jobs as %v", groups)
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	for _, ext := range []string{".yaml", ".yml"} {
+		jobs, _, err := load(t, "jobs"+ext, `
+global:
+  runtime: 2m
+  vm_disk_uuid: disk-a
+jobs:
+  - name: randread-4k
+    rw: randread
+    bs: 4k
+    iodepth: 32
+    random_distribution: zoned:60/10:40/90
+  - name: seqwrite
+    stonewall: true
+    rw: write
+    bssplit: 4k/25:8k/75
+    runtime: 30
+    vm_disk_uuid:
+      - disk-b
+      - disk-c
+  - name: mixed
+    wait_for_previous:
+    rw: rw
+    rwmixread: 20
+`)
+		if err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		if len(jobs) != 3 {
+			t.Fatalf("%s: %d jobs, want 3", ext, len(jobs))
+		}
+		randread, seqwrite, mixed := jobs[0], jobs[1], jobs[2]
+
+		if randread.Runtime != 2*time.Minute || randread.IODepth != 32 {
+			t.Errorf("randread-4k: runtime %v, iodepth %d", randread.Runtime, randread.IODepth)
+		}
+		if p := randread.Profile; p.Pattern != workload.Hotspot || p.HotspotPercent != 60 || p.HotspotFraction != 0.1 {
+			t.Errorf("randread-4k: pattern %s, %g%% of I/O to %g of the region", p.Pattern, p.HotspotPercent, p.HotspotFraction)
+		}
+		if seqwrite.Runtime != 30*time.Second || !seqwrite.Stonewall {
+			t.Errorf("seqwrite: runtime %v, stonewall %t", seqwrite.Runtime, seqwrite.Stonewall)
+		}
+		if got, want := diskKeys(seqwrite), []string{"vm_disk:disk-b", "vm_disk:disk-c"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("seqwrite: disks %v, want %v", got, want)
+		}
+		if want := []workload.BlockSize{{Size: 4096, Weight: 25}, {Size: 8192, Weight: 75}}; !reflect.DeepEqual(seqwrite.Profile.BlockSizes, want) {
+			t.Errorf("seqwrite: block sizes %v, want %v", seqwrite.Profile.BlockSizes, want)
+		}
+		if !mixed.Stonewall || mixed.Operation != "mixed" || mixed.Profile.ReadPercent != 20 {
+			t.Errorf("mixed: stonewall %t, %s, %g%% reads", mixed.Stonewall, mixed.Operation, mixed.Profile.ReadPercent)
+		}
+		if got := diskKeys(mixed); !reflect.DeepEqual(got, []string{"vm_disk:disk-a"}) {
+			t.Errorf("mixed: disks %v inherited from global", got)
+		}
+	}
+}
+
+func TestParseBlockSizes(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []workload.BlockSize
+	}{
+		{"4k", []workload.BlockSize{{Size: 4096, Weight: 1}}},
+		{"4k/30:64k/50", []workload.BlockSize{{Size: 4096, Weight: 30}, {Size: 65536, Weight: 50}}},
+		{"4K:30%,64K:50%", []workload.BlockSize{{Size: 4096, Weight: 30}, {Size: 65536, Weight: 50}}},
+	} {
+		got, err := parseBlockSizes(tc.in)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseBlockSizes(%q) = %v, %v; want %v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"", "4k/0", "4k/x:8k/10", "0/100"} {
+		if got, err := parseBlockSizes(in); err == nil {
+			t.Errorf("parseBlockSizes(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestParseDistribution(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want workload.Profile
+	}{
+		{"random", workload.Profile{Pattern: workload.Uniform}},
+		{"zipf", workload.Profile{Pattern: workload.Zipfian}},
+		{"zipf:1.5", workload.Profile{Pattern: workload.Zipfian, ZipfExponent: 1.5}},
+		{"zoned:90/10:10/90", workload.Profile{Pattern: workload.Hotspot, HotspotPercent: 90, HotspotFraction: 0.1}},
+		{"zoned:0/10:100/90", workload.Profile{Pattern: workload.Hotspot, HotspotPercent: 0, HotspotFraction: 0.1}},
+		{"zoned:75%/25%:25%/75%", workload.Profile{Pattern: workload.Hotspot, HotspotPercent: 75, HotspotFraction: 0.25}},
+	} {
+		p := workload.Profile{Pattern: workload.Uniform}
+		if err := parseDistribution(tc.in, &p); err != nil || !reflect.DeepEqual(p, tc.want) {
+			t.Errorf("parseDistribution(%q) = %+v, %v; want %+v", tc.in, p, err, tc.want)
+		}
+	}
+
+	for _, in := range []string{
+		"pareto:0.5",
+		"zipf:x",
+		"zoned:90/10",
+		"zoned:80/10:10/80:10/10",
+		"zoned:90:10/90",
+		"zoned:90/10:20/90",
+		"zoned:90/10:10/80",
+		"zoned:100/0:0/100",
+		"zoned:0/100:100/0",
+		"zoned:110/10:-10/90",
+	} {
+		p := workload.Profile{Pattern: workload.Uniform}
+		if err := parseDistribution(in, &p); err == nil {
+			t.Errorf("parseDistribution(%q) = %+v, want an error", in, p)
+		}
+	}
+
+	// Sequential jobs have no random distribution to refine
+	p := workload.Profile{Pattern: workload.Sequential}
+	if err := parseDistribution("zipf", &p); err == nil {
+		t.Error("random_distribution accepted for a sequential job")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	const base = "[global]\nruntime=10\nvm_disk_uuid=d\n"
+	for _, tc := range []struct {
+		name, file, content string
+		// want is the expected message after the file path, or empty when
+		// any error will do
+		want string
+	}{
+		{"unknown option", "j.fio", base + "[a]\nbogus=1\n", `:4: job "a": bogus=1: unknown option`},
+		{"invalid value", "j.fio", base + "[a]\n\n[b]\niodepth=0\n", `:6: job "b": iodepth=0: must be a positive integer`},
+		{"invalid zone", "j.fio", base + "[a]\nrw=randread\nrandom_distribution=zoned:90/10:20/90\n", `:4: job "a": random_distribution=zoned:90/10:20/90: zone I/O percentages add up to 110, not 100`},
+		{"sequential distribution", "j.fio", base + "[a]\nrandom_distribution=zipf\n", `:4: job "a": random_distribution=zipf: only applies to randread, randwrite and randrw`},
+		{"duplicate job", "j.fio", base + "[a]\n[a]\n", `: duplicate job "a"`},
+		{"no runtime", "j.fio", "[a]\nvm_disk_uuid=d\n", `:1: job "a": runtime is required`},
+		{"no disk", "j.fio", "[a]\nruntime=1\n", `:1: job "a": no target disk (vm_disk_uuid, vg_disk_uuid or disk_recovery_point_uuid)`},
+		{"option outside a section", "j.fio", "runtime=10\n", `: line 1: option "runtime=10" outside of a section`},
+		{"unterminated header", "j.fio", base + "[a\n", `: line 4: unterminated section header "[a"`},
+		{"empty section name", "j.fio", base + "[ ]\n", `: line 4: empty section name`},
+		{"no jobs", "j.fio", base, `: no jobs defined`},
+		{"yaml invalid value", "j.yaml", "global:\n  runtime: 10\n  vm_disk_uuid: d\njobs:\n  - name: a\n    numjobs: -1\n", `: job "a": numjobs=-1: must be a positive integer`},
+		{"yaml unnamed job", "j.yaml", "jobs:\n  - rw: read\n", `: job 0: name is required`},
+		{"yaml nested option", "j.yaml", "jobs:\n  - name: a\n    bs:\n      size: 4k\n", `: job 0: option "bs" must be a scalar or a list`},
+		{"yaml unknown field", "j.yaml", "globals:\n  runtime: 10\n", ""},
+		{"yaml duplicate job", "j.yml", "global:\n  runtime: 10\n  vm_disk_uuid: d\njobs:\n  - name: a\n  - name: a\n", `: duplicate job "a"`},
+		{"yaml no jobs", "j.yaml", "global:\n  runtime: 10\n", `: no jobs defined`},
+	} {
+		_, path, err := load(t, tc.file, tc.content)
+		if err == nil {
+			t.Errorf("%s: loaded, want %q", tc.name, tc.want)
+			continue
+		}
+		if got := strings.TrimPrefix(err.Error(), path); tc.want != "" && got != tc.want {
+			t.Errorf("%s: error %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestOptionOrder(t *testing.T) {
+	// rw is applied first, so random_distribution may precede it
+	jobs, _, err := load(t, "j.fio", "[a]\nrandom_distribution=zipf\nrw=randwrite\nruntime=1\nvm_disk_uuid=d\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].Profile.Pattern != workload.Zipfian {
+		t.Errorf("pattern %s, want %s", jobs[0].Profile.Pattern, workload.Zipfian)
+	}
+}
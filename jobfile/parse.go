@@ -0,0 +1,130 @@
+package jobfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// section is one job's options in file order, before interpretation
+type section struct {
+	name    string
+	line    int // Line of the section header, 0 for YAML
+	options map[string]string
+}
+
+// parseINI reads fio style INI: [name] headers followed by key=value or
+// bare key lines. Options in [global] sections apply to the jobs after them.
+func parseINI(r io.Reader) ([]section, error) {
+	var sections []section
+	global := map[string]string{}
+	var current *section
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section header %q", lineNo, line)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, fmt.Errorf("line %d: empty section name", lineNo)
+			}
+			if name == "global" {
+				current = &section{name: name, options: global}
+				continue
+			}
+			sections = append(sections, section{name: name, line: lineNo, options: copyOptions(global)})
+			current = &sections[len(sections)-1]
+			continue
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf("line %d: option %q outside of a section", lineNo, line)
+		}
+		key, value, _ := strings.Cut(line, "=")
+		current.options[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// yamlFile is the YAML layout: optional global options and a list of jobs,
+// each with a name and options using the INI keys
+type yamlFile struct {
+	Global map[string]any   `yaml:"global"`
+	Jobs   []map[string]any `yaml:"jobs"`
+}
+
+// parseYAML reads the YAML job file layout
+func parseYAML(r io.Reader) ([]section, error) {
+	var file yamlFile
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	global, err := yamlOptions(file.Global)
+	if err != nil {
+		return nil, fmt.Errorf("global: %v", err)
+	}
+	sections := make([]section, 0, len(file.Jobs))
+	for i, job := range file.Jobs {
+		options, err := yamlOptions(job)
+		if err != nil {
+			return nil, fmt.Errorf("job %d: %v", i, err)
+		}
+		name := options["name"]
+		delete(options, "name")
+		if name == "" {
+			return nil, fmt.Errorf("job %d: name is required", i)
+		}
+		merged := copyOptions(global)
+		for k, v := range options {
+			merged[k] = v
+		}
+		sections = append(sections, section{name: name, options: merged})
+	}
+	return sections, nil
+}
+
+// yamlOptions flattens scalar YAML values to the strings INI files carry
+func yamlOptions(m map[string]any) (map[string]string, error) {
+	options := make(map[string]string, len(m))
+	for k, v := range m {
+		switch v := v.(type) {
+		case nil:
+			options[k] = ""
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			options[k] = strings.Join(items, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("option %q must be a scalar or a list", k)
+		default:
+			options[k] = fmt.Sprint(v)
+		}
+	}
+	return options, nil
+}
+
+func copyOptions(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
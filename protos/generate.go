@@ -0,0 +1,7 @@
+package protos
+
+// Regenerate the .pb.go files after editing the .proto. The versions are
+// pinned so that the output, including the embedded descriptor, is
+// reproducible: protoc 29.3 (v5.29.3), protoc-gen-go v1.36.6 and
+// protoc-gen-go-grpc v1.5.1.
+//go:generate protoc --proto_path=.. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative protos/stargate_vdisk_rpc_svc.proto
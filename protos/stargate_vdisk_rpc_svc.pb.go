@@ -630,7 +630,9 @@ type VDiskWriteRet struct {
 	// Sequence number from the request
 	SequenceNumber *int64 `protobuf:"varint,5,opt,name=sequence_number,json=sequenceNumber" json:"sequence_number,omitempty"`
 	// Bytes actually written
-	BytesWritten  *int64 `protobuf:"varint,6,opt,name=bytes_written,json=bytesWritten" json:"bytes_written,omitempty"`
+	BytesWritten *int64 `protobuf:"varint,6,opt,name=bytes_written,json=bytesWritten" json:"bytes_written,omitempty"`
+	// Write retry count if write failed
+	RetryCount    *int32 `protobuf:"varint,7,opt,name=retry_count,json=retryCount" json:"retry_count,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -707,6 +709,13 @@ func (x *VDiskWriteRet) GetBytesWritten() int64 {
 	return 0
 }
 
+func (x *VDiskWriteRet) GetRetryCount() int32 {
+	if x != nil && x.RetryCount != nil {
+		return *x.RetryCount
+	}
+	return 0
+}
+
 var File_protos_stargate_vdisk_rpc_svc_proto protoreflect.FileDescriptor
 
 const file_protos_stargate_vdisk_rpc_svc_proto_rawDesc = "" +
@@ -744,14 +753,16 @@ const file_protos_stargate_vdisk_rpc_svc_proto_rawDesc = "" +
 	"\rchecksum_type\x18\x04 \x01(\x0e2\r.ChecksumTypeR\fchecksumType\x12\x12\n" +
 	"\x04data\x18\x05 \x01(\fR\x04data\x12\x1a\n" +
 	"\bchecksum\x18\x06 \x01(\rR\bchecksum\x12'\n" +
-	"\x0fsequence_number\x18\a \x01(\x03R\x0esequenceNumber\"\xcc\x01\n" +
+	"\x0fsequence_number\x18\a \x01(\x03R\x0esequenceNumber\"\xed\x01\n" +
 	"\rVDiskWriteRet\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x16\n" +
 	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x16\n" +
 	"\x06length\x18\x03 \x01(\x03R\x06length\x12#\n" +
 	"\rerror_message\x18\x04 \x01(\tR\ferrorMessage\x12'\n" +
 	"\x0fsequence_number\x18\x05 \x01(\x03R\x0esequenceNumber\x12#\n" +
-	"\rbytes_written\x18\x06 \x01(\x03R\fbytesWritten*h\n" +
+	"\rbytes_written\x18\x06 \x01(\x03R\fbytesWritten\x12\x1f\n" +
+	"\vretry_count\x18\a \x01(\x05R\n" +
+	"retryCount*h\n" +
 	"\x0fCompressionType\x12\x12\n" +
 	"\x0ekNoCompression\x10\x00\x12\x13\n" +
 	"\x0fkLZ4Compression\x10\x01\x12\x16\n" +
@@ -0,0 +1,583 @@
+//go:build scaletest
+// +build scaletest
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/histogram"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
+)
+
+var (
+	// Scale test flags
+	scaleDisks         = flag.String("disks", "", "Comma separated disks to test, each vm_disk:<uuid>, vg_disk:<uuid>, recovery_point:<uuid> or a bare VM disk UUID")
+	scaleDiskFile      = flag.String("disk_file", "", "File listing disks to test, one per line, optionally followed by the disk's max concurrent requests")
+	perDiskConcurrency = flag.Int("per_disk_concurrency", 0, "Maximum concurrent requests per disk (0 uses max_concurrent)")
+	bandwidthLimitMBps = flag.Float64("bandwidth_limit_mbps", 0, "Expected global bandwidth limit in MB/s to compare the aggregate against (0 to skip)")
+	streamLimit        = flag.Int("stream_limit", 0, "Expected global limit on concurrent streams to compare the test against (0 to skip)")
+)
+
+// streamSampleInterval is how often the scale test samples the number of
+// requests in flight across all disks
+const streamSampleInterval = 10 * time.Millisecond
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, `VDisk Scale Tester - Runs throughput tests against many disks in parallel
+
+Usage:
+  %s [flags]
+
+Examples:
+  # Read from three VM disks for 5 minutes with 8 concurrent requests per disk
+  %s -vdisk_server=localhost:9090 -vdisk_operation=read -disks=uuid1,uuid2,uuid3 -test_duration=5m -per_disk_concurrency=8 -read_length=65536
+
+  # Random writes to every disk listed in a file, checked against a 500 MB/s global limit
+  %s -vdisk_server=localhost:9090 -vdisk_operation=write -disk_file=disks.txt -access_pattern=uniform -block_sizes=64K -write_source=random -bandwidth_limit_mbps=500
+
+  # Mixed workload against VM and volume group disks, 100 requests/sec per disk
+  %s -vdisk_server=localhost:9090 -vdisk_operation=mixed -disks=vm_disk:uuid1,vg_disk:uuid2 -target_rps=100 -block_sizes=4K -write_source=random
+
+Disk files list one disk per line in the -disks syntax. A number after the
+disk overrides -per_disk_concurrency for it; '#' starts a comment:
+
+  vm_disk:12345678-1234-5678-9012-123456789012 16
+  vg_disk:87654321-4321-8765-2109-210987654321
+
+Flags:
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = printUsage
+	flag.Parse()
+
+	// Check if help was requested
+	if flag.NFlag() == 0 {
+		printUsage()
+		return
+	}
+
+	if err := runScaleTest(); err != nil {
+		log.Fatalf("Scale test failed: %v", err)
+	}
+}
+
+// scaleDisk is one disk of the scale test and its concurrency limit
+type scaleDisk struct {
+	disk          *protos.DiskIdentifier
+	maxConcurrent int
+}
+
+// runScaleTest runs the throughput engine against every disk in parallel
+// and reports per-disk, aggregate and fairness results
+func runScaleTest() error {
+	if *vdiskServerAddress == "" && !*fakeServer {
+		return fmt.Errorf("vdisk_server address is required")
+	}
+	switch *vdiskOperation {
+	case "read", "write", "mixed":
+	case "":
+		return fmt.Errorf("vdisk_operation is required (read, write or mixed)")
+	default:
+		return fmt.Errorf("invalid operation for a scale test: %s (must be read, write or mixed)", *vdiskOperation)
+	}
+
+	if *rampUp > 0 || *stepConcurrency != "" || *stepRPS != "" || *stepMBps != "" {
+		return fmt.Errorf("ramp_up and step-load stages are not supported by the scale test")
+	}
+
+	disks, err := loadScaleDisks()
+	if err != nil {
+		return err
+	}
+
+	if *vdiskOperation != "read" {
+		if err := workloadWriteLength(); err != nil {
+			return err
+		}
+		payload, err := loadWritePayload()
+		if err != nil {
+			return err
+		}
+		writePayload = payload
+	}
+
+	if *fakeServer {
+		if err := startFakeServer(); err != nil {
+			return err
+		}
+		defer stopFakeServer()
+	}
+
+	if err := startTracing(); err != nil {
+		return err
+	}
+	defer stopTracing()
+
+	client, err := newVDiskClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		fmt.Fprintln(logOutput, "Cleaning up connection pool...")
+		client.Close()
+	}()
+	if err := startMetricsServer(client); err != nil {
+		return err
+	}
+	defer stopMetricsServer()
+
+	jobs := make([]*throughputJob, len(disks))
+	live := make([]*ThroughputMetrics, len(disks))
+	var liveMu sync.Mutex
+	totalConcurrency := 0
+	for i, d := range disks {
+		name := vdisk.DiskKey(d.disk)
+		p, err := workloadProfile(client, d.disk)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		// Give every disk its own sequence of offsets and block sizes
+		p.Seed += int64(i)
+		if p.ReadPercent < 100 && p.MaxBlockSize() > int64(len(writePayload)) {
+			return fmt.Errorf("write payload of %d bytes is smaller than the largest block size %d", len(writePayload), p.MaxBlockSize())
+		}
+		gen, err := workload.NewGenerator(p)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		totalConcurrency += d.maxConcurrent
+		jobs[i] = &throughputJob{
+			Name:            name,
+			Operation:       *vdiskOperation,
+			Disk:            d.disk,
+			Generator:       gen,
+			Duration:        *testDuration,
+			RampTime:        *warmup,
+			MaxConcurrent:   d.maxConcurrent,
+			TargetRPS:       *targetRPS,
+			TargetMBps:      *targetMBps,
+			PipelineWindow:  *pipelineWindow,
+			PipelineStreams: *pipelineStreams,
+			MetricsCSV:      jobOutputPath(*metricsCSVPath, name),
+			LatencyFile:     jobOutputPath(*latencyHistogramFile, name),
+			Quiet:           true,
+			OnStart: func(m *ThroughputMetrics) {
+				liveMu.Lock()
+				live[i] = m
+				liveMu.Unlock()
+			},
+		}
+	}
+
+	fmt.Fprintf(logOutput, "Starting scale test: %s operations against %d disks for %v\n", *vdiskOperation, len(disks), *testDuration)
+	fmt.Fprintf(logOutput, "Workload: %s\n", jobs[0].Generator.Profile())
+	fmt.Fprintf(logOutput, "Concurrency: %d requests in total over %d connections\n", totalConcurrency, *connectionPoolSize)
+	if *targetRPS > 0 || *targetMBps > 0 {
+		fmt.Fprintf(logOutput, "Per-disk target rate: %.2f requests/sec, %.2f MB/s (open loop)\n", *targetRPS, *targetMBps)
+	}
+	fmt.Fprintf(logOutput, "Report interval: %v\n", *reportInterval)
+
+	// Sample the streams in flight and print aggregate reports until every
+	// disk has finished
+	streams := &streamStats{}
+	reportCtx, stopReports := context.WithCancel(context.Background())
+	reportDone := make(chan struct{})
+	go func() {
+		defer close(reportDone)
+		snapshot := func() []*ThroughputMetrics {
+			liveMu.Lock()
+			defer liveMu.Unlock()
+			return append([]*ThroughputMetrics(nil), live...)
+		}
+		reportScaleTestPeriodically(reportCtx, snapshot, streams)
+	}()
+
+	report := newRunReport("scale_test")
+	results := make([]*ThroughputMetrics, len(jobs))
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(i int, job *throughputJob) {
+			defer wg.Done()
+			results[i], errs[i] = runThroughputJob(context.Background(), client, job)
+		}(i, job)
+	}
+	wg.Wait()
+	stopReports()
+	<-reportDone
+
+	var firstErr error
+	var names []string
+	var finished []*ThroughputMetrics
+	for i, m := range results {
+		if errs[i] != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %v", jobs[i].Name, errs[i])
+		}
+		if m != nil {
+			names = append(names, jobs[i].Name)
+			finished = append(finished, m)
+			report.Jobs = append(report.Jobs, newThroughputReport(m))
+		}
+	}
+	if len(finished) == 0 {
+		return report.finish(client, firstErr)
+	}
+
+	aggregate := mergeThroughputMetrics("all disks", finished)
+	printPerDiskResults(names, finished)
+	printFinalThroughputResults(aggregate)
+	printDiskFairness(names, finished)
+	printGlobalLimits(finished, totalConcurrency, streams)
+	printConnectionDistribution(client)
+	report.Aggregate = newThroughputReport(aggregate)
+	return report.finish(client, firstErr)
+}
+
+// loadScaleDisks collects the disks of -disks and -disk_file, falling back
+// to the single disk identifier flags
+func loadScaleDisks() ([]scaleDisk, error) {
+	defaultConcurrency := *perDiskConcurrency
+	if defaultConcurrency == 0 {
+		defaultConcurrency = *maxConcurrent
+	}
+	if defaultConcurrency < 1 {
+		return nil, fmt.Errorf("per_disk_concurrency must be positive")
+	}
+
+	var disks []scaleDisk
+	seen := map[string]bool{}
+	add := func(disk *protos.DiskIdentifier, concurrency int) error {
+		key := vdisk.DiskKey(disk)
+		if seen[key] {
+			return fmt.Errorf("disk %s is listed more than once", key)
+		}
+		seen[key] = true
+		disks = append(disks, scaleDisk{disk: disk, maxConcurrent: concurrency})
+		return nil
+	}
+
+	for _, key := range strings.Split(*scaleDisks, ",") {
+		if strings.TrimSpace(key) == "" {
+			continue
+		}
+		disk, err := vdisk.ParseDiskKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(disk, defaultConcurrency); err != nil {
+			return nil, err
+		}
+	}
+
+	if *scaleDiskFile != "" {
+		f, err := os.Open(*scaleDiskFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) > 2 {
+				return nil, fmt.Errorf("%s:%d: expected a disk and an optional concurrency", *scaleDiskFile, lineNo)
+			}
+			disk, err := vdisk.ParseDiskKey(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", *scaleDiskFile, lineNo, err)
+			}
+			concurrency := defaultConcurrency
+			if len(fields) == 2 {
+				concurrency, err = strconv.Atoi(fields[1])
+				if err != nil || concurrency < 1 {
+					return nil, fmt.Errorf("%s:%d: invalid concurrency %q", *scaleDiskFile, lineNo, fields[1])
+				}
+			}
+			if err := add(disk, concurrency); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", *scaleDiskFile, lineNo, err)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(disks) == 0 && (*diskRecoveryPointUuid != "" || *vmDiskUuid != "" || *vgDiskUuid != "") {
+		if err := add(createDiskIdentifier(), defaultConcurrency); err != nil {
+			return nil, err
+		}
+	}
+	if len(disks) == 0 {
+		return nil, fmt.Errorf("no disks given (use -disks or -disk_file)")
+	}
+	return disks, nil
+}
+
+// streamStats tracks the requests in flight across all disks, which is the
+// number of streams open against the server when requests are not pipelined
+type streamStats struct {
+	peak    int64
+	sum     int64
+	samples int64
+}
+
+func (s *streamStats) sample(inFlight int64) {
+	s.peak = max(s.peak, inFlight)
+	s.sum += inFlight
+	s.samples++
+}
+
+func (s *streamStats) average() float64 {
+	if s.samples == 0 {
+		return 0
+	}
+	return float64(s.sum) / float64(s.samples)
+}
+
+// reportScaleTestPeriodically samples the requests in flight and prints
+// aggregate intermediate reports until ctx is done
+func reportScaleTestPeriodically(ctx context.Context, snapshot func() []*ThroughputMetrics, streams *streamStats) {
+	sampler := time.NewTicker(streamSampleInterval)
+	defer sampler.Stop()
+	reporter := time.NewTicker(*reportInterval)
+	defer reporter.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sampler.C:
+			var inFlight int64
+			for _, m := range snapshot() {
+				if m != nil {
+					inFlight += atomic.LoadInt64(&m.InFlight)
+				}
+			}
+			streams.sample(inFlight)
+		case <-reporter.C:
+			printIntermediateScaleReport(snapshot(), streams)
+		}
+	}
+}
+
+// printIntermediateScaleReport prints aggregate throughput so far and how
+// evenly it is spread over the disks
+func printIntermediateScaleReport(live []*ThroughputMetrics, streams *streamStats) {
+	var running, totalReqs, failedReqs, totalBytes int64
+	var elapsed time.Duration
+	var rates []float64
+	latency := histogram.NewLatency()
+	for _, m := range live {
+		if m == nil || time.Now().Before(m.StartTime) {
+			continue
+		}
+		running++
+		reqs := atomic.LoadInt64(&m.TotalRequests)
+		totalReqs += reqs
+		failedReqs += atomic.LoadInt64(&m.FailedRequests)
+		totalBytes += atomic.LoadInt64(&m.TotalBytes)
+		latency.Merge(m.Latency)
+
+		diskElapsed := time.Since(m.StartTime)
+		elapsed = max(elapsed, diskElapsed)
+		rates = append(rates, float64(reqs)/diskElapsed.Seconds())
+	}
+	if running == 0 {
+		return
+	}
+
+	var rps, bps float64
+	if elapsed > 0 {
+		rps = float64(totalReqs) / elapsed.Seconds()
+		bps = float64(totalBytes) / elapsed.Seconds()
+	}
+	f := newFairness(rates)
+
+	fmt.Fprintf(logOutput, "\n=== Intermediate Scale Test Report (Elapsed: %v) ===\n", elapsed.Truncate(time.Second))
+	fmt.Fprintf(logOutput, "Disks Running: %d\n", running)
+	fmt.Fprintf(logOutput, "Total Requests: %d, Failed: %d\n", totalReqs, failedReqs)
+	fmt.Fprintf(logOutput, "Requests/sec: %.2f\n", rps)
+	fmt.Fprintf(logOutput, "Bytes/sec: %.2f (%.2f MB/s)\n", bps, bps/(1024*1024))
+	fmt.Fprintf(logOutput, "Streams In Flight: peak %d, average %.1f\n", streams.peak, streams.average())
+	if latency.Count() > 0 {
+		fmt.Fprintf(logOutput, "Latency Percentiles: %s\n", formatPercentiles(latency))
+	}
+	fmt.Fprintf(logOutput, "Per-Disk Requests/sec: min %.2f, max %.2f, Jain's fairness %.4f\n", f.Min, f.Max, f.Jain)
+	fmt.Fprintln(logOutput, "========================================")
+}
+
+// printPerDiskResults prints one line of results per disk
+func printPerDiskResults(names []string, results []*ThroughputMetrics) {
+	width := len("Disk")
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+
+	fmt.Fprintf(logOutput, "\n"+strings.Repeat("=", 60)+"\n")
+	fmt.Fprintf(logOutput, "PER-DISK RESULTS\n")
+	fmt.Fprintf(logOutput, strings.Repeat("=", 60)+"\n")
+	fmt.Fprintf(logOutput, "%-*s %6s %10s %8s %12s %10s %12s %12s %12s\n",
+		width, "Disk", "Conc", "Requests", "Failed", "Req/s", "MB/s", "Avg", "p99", "Max")
+	for i, m := range results {
+		var avg, p99, maxLatency time.Duration
+		if m.Latency.Count() > 0 {
+			avg = time.Duration(m.Latency.Mean())
+			p99 = time.Duration(m.Latency.ValueAtPercentile(99))
+			maxLatency = time.Duration(m.Latency.Max())
+		}
+		fmt.Fprintf(logOutput, "%-*s %6d %10d %8d %12.2f %10.2f %12v %12v %12v\n",
+			width, names[i], m.MaxConcurrent, m.TotalRequests, m.FailedRequests,
+			m.RequestsPerSecond, m.BytesPerSecond/(1024*1024),
+			avg.Round(time.Microsecond), p99.Round(time.Microsecond), maxLatency.Round(time.Microsecond))
+	}
+}
+
+// fairness summarises how evenly a quantity is shared across disks
+type fairness struct {
+	Jain   float64 // Jain's fairness index: 1 when all are equal, 1/n when one disk gets everything
+	Min    float64
+	Max    float64
+	Mean   float64
+	StdDev float64
+}
+
+// newFairness computes the fairness of values, one per disk
+func newFairness(values []float64) fairness {
+	f := fairness{Jain: 1}
+	if len(values) == 0 {
+		return f
+	}
+	var sum, squares float64
+	f.Min, f.Max = values[0], values[0]
+	for _, v := range values {
+		sum += v
+		squares += v * v
+		f.Min = min(f.Min, v)
+		f.Max = max(f.Max, v)
+	}
+	n := float64(len(values))
+	f.Mean = sum / n
+	f.StdDev = math.Sqrt(max(squares/n-f.Mean*f.Mean, 0))
+	if squares > 0 {
+		f.Jain = sum * sum / (n * squares)
+	}
+	return f
+}
+
+// CoV returns the coefficient of variation: the standard deviation relative
+// to the mean
+func (f fairness) CoV() float64 {
+	if f.Mean == 0 {
+		return 0
+	}
+	return f.StdDev / f.Mean
+}
+
+// MinMaxRatio returns the share of the best served disk that the worst
+// served disk gets
+func (f fairness) MinMaxRatio() float64 {
+	if f.Max == 0 {
+		return 1
+	}
+	return f.Min / f.Max
+}
+
+// printDiskFairness prints how evenly throughput and latency are spread
+// over the disks
+func printDiskFairness(names []string, results []*ThroughputMetrics) {
+	rates := make([]float64, len(results))
+	bandwidth := make([]float64, len(results))
+	p99 := make([]float64, len(results))
+	for i, m := range results {
+		rates[i] = m.RequestsPerSecond
+		bandwidth[i] = m.BytesPerSecond / (1024 * 1024)
+		if m.Latency.Count() > 0 {
+			p99[i] = float64(m.Latency.ValueAtPercentile(99))
+		}
+	}
+
+	fmt.Fprintf(logOutput, "\nFairness Across %d Disks:\n", len(results))
+	for _, row := range []struct {
+		name   string
+		values []float64
+	}{
+		{"Requests/sec", rates},
+		{"MB/sec", bandwidth},
+	} {
+		f := newFairness(row.values)
+		fmt.Fprintf(logOutput, "  %s: Jain's index %.4f, min/max %.4f, CoV %.4f (min %.2f, mean %.2f, max %.2f)\n",
+			row.name, f.Jain, f.MinMaxRatio(), f.CoV(), f.Min, f.Mean, f.Max)
+	}
+
+	f := newFairness(p99)
+	fmt.Fprintf(logOutput, "  p99 Latency: min %v, mean %v, max %v, CoV %.4f\n",
+		time.Duration(f.Min), time.Duration(f.Mean), time.Duration(f.Max), f.CoV())
+
+	// Name the least and most served disks, which is where an unfair
+	// limiter shows first
+	if len(results) > 1 {
+		order := make([]int, len(results))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return rates[order[a]] < rates[order[b]] })
+		lo, hi := order[0], order[len(order)-1]
+		fmt.Fprintf(logOutput, "  Slowest Disk: %s (%.2f req/s, %.2f MB/s)\n", names[lo], rates[lo], bandwidth[lo])
+		fmt.Fprintf(logOutput, "  Fastest Disk: %s (%.2f req/s, %.2f MB/s)\n", names[hi], rates[hi], bandwidth[hi])
+	}
+}
+
+// printGlobalLimits compares the aggregate load with the expected global
+// bandwidth and stream limits of the server
+func printGlobalLimits(results []*ThroughputMetrics, totalConcurrency int, streams *streamStats) {
+	var bps float64
+	for _, m := range results {
+		bps += m.BytesPerSecond
+	}
+	mbps := bps / (1024 * 1024)
+
+	fmt.Fprintf(logOutput, "\nGlobal Limits:\n")
+	fmt.Fprintf(logOutput, "  Aggregate Bandwidth: %.2f MB/s", mbps)
+	if *bandwidthLimitMBps > 0 {
+		fairShare := *bandwidthLimitMBps / float64(len(results))
+		fmt.Fprintf(logOutput, " (%.1f%% of the %.2f MB/s limit, fair share %.2f MB/s per disk)",
+			mbps / *bandwidthLimitMBps * 100, *bandwidthLimitMBps, fairShare)
+	}
+	fmt.Fprintln(logOutput)
+	fmt.Fprintf(logOutput, "  Streams In Flight: peak %d, average %.1f of %d configured", streams.peak, streams.average(), totalConcurrency)
+	if *streamLimit > 0 {
+		fmt.Fprintf(logOutput, " (limit %d)", *streamLimit)
+	}
+	fmt.Fprintln(logOutput)
+
+	if *bandwidthLimitMBps > 0 && mbps > *bandwidthLimitMBps {
+		fmt.Fprintf(logOutput, "  WARNING: aggregate bandwidth exceeds the expected limit by %.2f MB/s\n", mbps-*bandwidthLimitMBps)
+	}
+	if *streamLimit > 0 && streams.peak > int64(*streamLimit) {
+		fmt.Fprintf(logOutput, "  WARNING: %d streams were in flight at once, above the expected limit of %d\n", streams.peak, *streamLimit)
+	}
+}
@@ -0,0 +1,121 @@
+//go:build scaletest
+// +build scaletest
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+func TestFairness(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		values            []float64
+		jain, minV, maxV  float64
+		mean, stdDev, cov float64
+		minMaxRatio       float64
+	}{
+		{name: "equal", values: []float64{50, 50, 50, 50}, jain: 1, minV: 50, maxV: 50, mean: 50, minMaxRatio: 1},
+		{name: "one disk gets everything", values: []float64{0, 0, 0, 0, 80}, jain: 1.0 / 5, maxV: 80, mean: 16, stdDev: 32, cov: 2},
+		{name: "uneven", values: []float64{10, 20, 30, 40}, jain: 10000.0 / (4 * 3000), minV: 10, maxV: 40, mean: 25, stdDev: math.Sqrt(125), cov: math.Sqrt(125) / 25, minMaxRatio: 0.25},
+		{name: "single disk", values: []float64{7}, jain: 1, minV: 7, maxV: 7, mean: 7, minMaxRatio: 1},
+		{name: "all idle", values: []float64{0, 0, 0}, jain: 1, minMaxRatio: 1},
+		{name: "no disks", jain: 1, minMaxRatio: 1},
+	} {
+		f := newFairness(tc.values)
+		for _, c := range []struct {
+			what      string
+			got, want float64
+		}{
+			{"Jain's index", f.Jain, tc.jain},
+			{"min", f.Min, tc.minV},
+			{"max", f.Max, tc.maxV},
+			{"mean", f.Mean, tc.mean},
+			{"standard deviation", f.StdDev, tc.stdDev},
+			{"CoV", f.CoV(), tc.cov},
+			{"min/max ratio", f.MinMaxRatio(), tc.minMaxRatio},
+		} {
+			if math.Abs(c.got-c.want) > 1e-9 {
+				t.Errorf("%s: %s %g, want %g", tc.name, c.what, c.got, c.want)
+			}
+		}
+	}
+}
+
+func TestLoadScaleDisks(t *testing.T) {
+	dir := t.TempDir()
+	diskFile := func(t *testing.T, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, "disks.txt")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	setFlags(t, map[string]string{
+		"disks":                "uuid1, vg_disk:uuid2,",
+		"disk_file":            diskFile(t, "# disks\nrp:uuid3 16\n\n  vm_disk:uuid4   # default concurrency\n"),
+		"per_disk_concurrency": "4",
+	})
+	disks, err := loadScaleDisks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range disks {
+		got = append(got, fmt.Sprintf("%s=%d", vdisk.DiskKey(d.disk), d.maxConcurrent))
+	}
+	want := []string{
+		vdisk.DiskKey(vdisk.VMDisk("uuid1")) + "=4",
+		vdisk.DiskKey(vdisk.VGDisk("uuid2")) + "=4",
+		vdisk.DiskKey(vdisk.RecoveryPointDisk("uuid3")) + "=16",
+		vdisk.DiskKey(vdisk.VMDisk("uuid4")) + "=4",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("disks %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		name              string
+		disks, file, conc string
+		err               string
+	}{
+		{name: "unknown kind", disks: "nfs:uuid1", err: "unknown kind"},
+		{name: "empty uuid", disks: "vm_disk:", err: "empty UUID"},
+		{name: "duplicate", disks: "uuid1,vm_disk:uuid1", err: "listed more than once"},
+		{name: "duplicate across file", disks: "uuid1", file: "vm:uuid1\n", err: ":1: disk vm_disk:uuid1 is listed more than once"},
+		{name: "file line number", file: "uuid1\n\nbad:uuid2\n", err: ":3: invalid disk"},
+		{name: "bad concurrency", file: "uuid1 many\n", err: `:1: invalid concurrency "many"`},
+		{name: "zero concurrency", file: "uuid1 0\n", err: `invalid concurrency "0"`},
+		{name: "extra field", file: "uuid1 4 8\n", err: "expected a disk and an optional concurrency"},
+		// "-" names a file that does not exist
+		{name: "missing file", file: "-", err: "no such file"},
+		{name: "no disks", file: "# nothing\n", err: "no disks given"},
+		{name: "bad default concurrency", disks: "uuid1", conc: "-1", err: "per_disk_concurrency must be positive"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			flags := map[string]string{"disks": tc.disks, "disk_file": "", "per_disk_concurrency": "4"}
+			if tc.file == "-" {
+				flags["disk_file"] = filepath.Join(dir, "missing.txt")
+			} else if tc.file != "" {
+				flags["disk_file"] = diskFile(t, tc.file)
+			}
+			if tc.conc != "" {
+				flags["per_disk_concurrency"] = tc.conc
+			}
+			setFlags(t, flags)
+			_, err := loadScaleDisks()
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+var (
+	backupChunkSize  = flag.Int64("backup_chunk_size", vdisk.DefaultBackupChunkSize, "Bytes of the disk read per backup chunk (the unit of checkpointing)")
+	backupStreams    = flag.Int("backup_streams", 0, "Number of chunks read in parallel during backup (0 uses connection_pool_size)")
+	backupFormat     = flag.String("backup_format", "sparse", "Backup image format (sparse, raw)")
+	backupCheckpoint = flag.String("backup_checkpoint", "", "Backup checkpoint manifest path (default <output_file>.checkpoint)")
+	backupRestart    = flag.Bool("backup_restart", false, "Discard an existing backup checkpoint and start from the beginning")
+)
+
+// runBackup copies the whole disk to -output_file, resuming from the
+// checkpoint manifest when one exists
+func runBackup(client *vdisk.Client) error {
+	if *outputFile == "" || *outputFile == "-" {
+		return fmt.Errorf("backup requires -output_file to name an image file")
+	}
+	if *backupFormat != "sparse" && *backupFormat != "raw" {
+		return fmt.Errorf("invalid backup_format: %s (must be sparse or raw)", *backupFormat)
+	}
+
+	checkpoint := *backupCheckpoint
+	if checkpoint == "" {
+		checkpoint = *outputFile + ".checkpoint"
+	}
+	if *backupRestart {
+		if err := os.Remove(checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove backup checkpoint: %v", err)
+		}
+	}
+
+	prev, err := vdisk.LoadBackupManifest(checkpoint)
+	if err != nil {
+		return err
+	}
+	if prev != nil {
+		if _, err := os.Stat(*outputFile); err != nil {
+			return fmt.Errorf("checkpoint %s exists but image %s cannot be resumed: %v (use -backup_restart)", checkpoint, *outputFile, err)
+		}
+		if prev.Complete {
+			fmt.Fprintf(logOutput, "Backup of %s to %s is already complete (checkpoint %s)\n", prev.Disk, *outputFile, checkpoint)
+			return nil
+		}
+		fmt.Fprintf(logOutput, "Resuming backup from checkpoint %s: %d of %d bytes already copied\n",
+			checkpoint, prev.CompletedBytes(), prev.DiskSize)
+	}
+
+	f, err := os.OpenFile(*outputFile, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %v", err)
+	}
+	defer f.Close()
+
+	// Stop cleanly on interrupt so the checkpoint reflects completed chunks
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var mu sync.Mutex
+	lastReport := time.Now()
+	opts := vdisk.BackupOptions{
+		ChunkSize:       *backupChunkSize,
+		Streams:         *backupStreams,
+		MaxResponseSize: *maxResponseSize,
+		Raw:             *backupFormat == "raw",
+		Checkpoint:      checkpoint,
+		Progress: func(p vdisk.BackupProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			if time.Since(lastReport) < *reportInterval && p.CompletedChunks < p.Chunks {
+				return
+			}
+			lastReport = time.Now()
+			fmt.Fprintf(logOutput, "[%v] Backup progress: %d/%d chunks, %d/%d bytes (%.1f%%)\n",
+				p.Elapsed.Round(time.Second), p.CompletedChunks, p.Chunks,
+				p.CompletedBytes, p.DiskSize, float64(p.CompletedBytes)/float64(max(p.DiskSize, 1))*100)
+		},
+	}
+
+	streams := opts.Streams
+	if streams <= 0 {
+		streams = client.PoolSize()
+	}
+	fmt.Fprintf(logOutput, "Starting backup of %s to %s (%s image, %d byte chunks, %d streams)\n",
+		vdisk.DiskKey(createDiskIdentifier()), *outputFile, *backupFormat, opts.ChunkSize, streams)
+
+	stats, err := client.Backup(ctx, createDiskIdentifier(), f, opts)
+	printBackupSummary(stats)
+	if err != nil {
+		if stats.Chunks > 0 {
+			fmt.Fprintf(logOutput, "Backup interrupted; rerun the same command to resume from %s\n", checkpoint)
+		}
+		return err
+	}
+	return nil
+}
+
+func printBackupSummary(stats vdisk.BackupStats) {
+	fmt.Fprintf(logOutput, "\n=== Backup Summary ===\n")
+	fmt.Fprintf(logOutput, "Disk Size: %d bytes (%d chunks)\n", stats.DiskSize, stats.Chunks)
+	if stats.ResumedChunks > 0 {
+		fmt.Fprintf(logOutput, "Resumed: %d chunks (%d bytes) from checkpoint\n", stats.ResumedChunks, stats.ResumedBytes)
+	}
+	fmt.Fprintf(logOutput, "Copied: %d chunks (%d bytes)\n", stats.CopiedChunks, stats.CopiedBytes)
+	if stats.Retries > 0 {
+		fmt.Fprintf(logOutput, "Stream Retries: %d (reads resumed on a new stream)\n", stats.Retries)
+	}
+	fmt.Fprintf(logOutput, "Image Data: %d bytes written, %d zero bytes left sparse or zero filled\n",
+		stats.Image.DataBytes, stats.Image.ZeroBytes)
+	fmt.Fprintf(logOutput, "Duration: %v\n", stats.Duration)
+	if secs := stats.Duration.Seconds(); secs > 0 {
+		fmt.Fprintf(logOutput, "Throughput: %.2f MB/s\n", float64(stats.CopiedBytes)/secs/(1024*1024))
+	}
+}
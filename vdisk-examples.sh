@@ -38,8 +38,9 @@ echo -e "\n3. VDisk Write Operation (VG Disk UUID - non-TLS)"
     -vdisk_operation=write \
     -vg_disk_uuid="12345678-1234-5678-9012-123456789012" \
     -write_offset=0 \
-    -write_length=1024 \
+    -write_length=13 \
     -write_data="Hello, VDisk!" \
+    -write_data_encoding=raw \
     -compression_type=lz4 \
     -checksum_type=crc32 \
     -sequence_number=1 \
@@ -52,8 +53,8 @@ echo -e "\n4. VDisk Write Operation (VM Disk UUID with TLS)"
     -vdisk_operation=write \
     -vm_disk_uuid="12345678-1234-5678-9012-123456789012" \
     -write_offset=4096 \
-    -write_length=2048 \
-    -write_data="VDisk Data with TLS" \
+    -write_length=4 \
+    -write_data="deadbeef" \
     -compression_type=snappy \
     -checksum_type=sha256 \
     -sequence_number=2 \
@@ -97,7 +98,7 @@ echo -e "\n7. Batch VDisk Write Operations (3 concurrent writes)"
     -batch_size=3 \
     -write_offset=0 \
     -write_length=1024 \
-    -write_data="BatchData" \
+    -write_source=random \
     -compression_type=lz4 \
     -checksum_type=crc32 \
     -sequence_number=100 \
@@ -128,7 +129,7 @@ echo -e "\n9. Large Batch VDisk Write Operations with TLS (20 concurrent writes)
     -batch_delay=25ms \
     -write_offset=0 \
     -write_length=2048 \
-    -write_data="ConcurrentWriteTest" \
+    -write_source=random \
     -compression_type=snappy \
     -checksum_type=sha256 \
     -sequence_number=200 \
@@ -165,7 +166,7 @@ echo -e "\n11. Throughput Test - Write Operations (1 minute, max 8 concurrent, 1
     -report_interval=15s \
     -write_offset=0 \
     -write_length=1024 \
-    -write_data="ThroughputTestData" \
+    -write_source=random \
     -compression_type=lz4 \
     -checksum_type=crc32 \
     -sequence_number=1000 \
@@ -200,7 +201,8 @@ echo -e "\n13. Stress Test - Write Operations (30 seconds, max 25 concurrent, 10
     -report_interval=10s \
     -write_offset=0 \
     -write_length=4096 \
-    -write_data="StressTestPayload" \
+    -write_source=compressible \
+    -write_compress_ratio=4 \
     -compression_type=snappy \
     -checksum_type=sha256 \
     -sequence_number=2000 \
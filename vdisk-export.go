@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+// logOutput receives progress messages and results. It is stdout unless
+// -output_file=- reserves stdout for image data.
+var logOutput io.Writer = os.Stdout
+
+// redirectLogsForStdoutImage sends all progress output to stderr so that
+// stdout carries only image data
+func redirectLogsForStdoutImage() {
+	if *outputFile == "-" {
+		logOutput = os.Stderr
+	}
+}
+
+// openOutputImage opens the -output_file image for a read starting at base.
+// It returns a nil writer when no output file was requested.
+func openOutputImage(path string, base int64) (vdisk.ImageWriter, func(), error) {
+	switch path {
+	case "":
+		return nil, func() {}, nil
+	case "-":
+		return vdisk.NewSequentialImageWriter(os.Stdout, base), func() {}, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open output file: %v", err)
+	}
+	return vdisk.NewSparseImageWriter(f, base), func() { f.Close() }, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk/vdisktest"
+)
+
+var (
+	fakeServer   = flag.Bool("fake_server", false, "Run against an in-process fake VDisk server instead of -vdisk_server")
+	fakeDiskSize = flag.Int64("fake_disk_size", vdisktest.DefaultDiskSize, "Size in bytes of disks created by -fake_server")
+	fakeFaults   = flag.String("fake_faults", "", "JSON fault injection script applied by -fake_server")
+)
+
+// fake is the in-process server started for -fake_server
+var fake *vdisktest.Server
+
+// startFakeServer starts the in-process server on a bufconn listener
+func startFakeServer() error {
+	opts := vdisktest.ServerOptions{DiskSize: *fakeDiskSize}
+	if *fakeFaults != "" {
+		script, err := vdisktest.LoadFaultScript(*fakeFaults)
+		if err != nil {
+			return err
+		}
+		opts.Faults = script
+		fmt.Fprintf(logOutput, "Loaded %d fault injection rules from %s\n", len(script.Faults), *fakeFaults)
+	}
+
+	fake = vdisktest.NewServer(opts)
+	fake.StartBufconn()
+	fmt.Fprintf(logOutput, "Started in-process fake VDisk server (%d byte disks)\n", *fakeDiskSize)
+	return nil
+}
+
+// applyFakeServer points opts at the in-process server when one is running
+func applyFakeServer(opts *vdisk.Options) {
+	if fake == nil {
+		return
+	}
+	fakeOpts := fake.ClientOptions()
+	opts.Address = fakeOpts.Address
+	opts.Dialer = fakeOpts.Dialer
+	opts.UseTLS = fakeOpts.UseTLS
+	opts.AuthType = fakeOpts.AuthType
+}
+
+// stopFakeServer stops the in-process server and prints what it handled
+func stopFakeServer() {
+	if fake == nil {
+		return
+	}
+	stats := fake.Stats()
+	fake.Stop()
+	fmt.Fprintf(logOutput, "\n=== Fake Server Summary ===\n")
+	fmt.Fprintf(logOutput, "Read Streams: %d, Requests: %d, Responses: %d, Bytes: %d\n",
+		stats.ReadStreams, stats.ReadRequests, stats.ReadResponses, stats.BytesRead)
+	fmt.Fprintf(logOutput, "Write Streams: %d, Requests: %d, Failures: %d, Bytes: %d\n",
+		stats.WriteStreams, stats.WriteRequests, stats.WriteFailures, stats.BytesWritten)
+	if stats.FaultsInjected > 0 {
+		fmt.Fprintf(logOutput, "Faults Injected: %d\n", stats.FaultsInjected)
+	}
+}
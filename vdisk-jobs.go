@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/histogram"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/jobfile"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
+)
+
+var jobFile = flag.String("job_file", "", "Run the throughput jobs of an fio style INI or YAML job file")
+
+// runJobFile runs every job of -job_file, group by group, and prints
+// per-job and aggregate results
+func runJobFile() error {
+	if *vdiskServerAddress == "" && !*fakeServer {
+		return fmt.Errorf("vdisk_server address is required")
+	}
+
+	jobs, err := jobfile.Load(*jobFile)
+	if err != nil {
+		return err
+	}
+	if err := loadJobPayload(jobs); err != nil {
+		return err
+	}
+
+	if *fakeServer {
+		if err := startFakeServer(); err != nil {
+			return err
+		}
+		defer stopFakeServer()
+	}
+
+	if err := startTracing(); err != nil {
+		return err
+	}
+	defer stopTracing()
+
+	client, err := newVDiskClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		fmt.Fprintln(logOutput, "Cleaning up connection pool...")
+		client.Close()
+	}()
+	if err := startMetricsServer(client); err != nil {
+		return err
+	}
+	defer stopMetricsServer()
+
+	groups := jobfile.Groups(jobs)
+	fmt.Fprintf(logOutput, "Loaded %d jobs in %d groups from %s\n", len(jobs), len(groups), *jobFile)
+
+	report := newRunReport("job_file")
+
+	var all []*ThroughputMetrics
+	var firstErr error
+	for i, group := range groups {
+		names := make([]string, len(group))
+		for j, job := range group {
+			names[j] = job.Name
+		}
+		fmt.Fprintf(logOutput, "\n=== Job Group %d/%d: %s ===\n", i+1, len(groups), strings.Join(names, ", "))
+
+		results, err := runJobGroup(client, group)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		for _, metrics := range results {
+			printFinalThroughputResults(metrics)
+			all = append(all, metrics)
+			report.Jobs = append(report.Jobs, newThroughputReport(metrics))
+		}
+		if err != nil && results == nil {
+			break
+		}
+	}
+
+	if len(all) > 1 {
+		aggregate := mergeThroughputMetrics("all jobs", all)
+		printFinalThroughputResults(aggregate)
+		report.Aggregate = newThroughputReport(aggregate)
+	}
+	printConnectionDistribution(client)
+	return report.finish(client, firstErr)
+}
+
+// runJobGroup runs the jobs of one group concurrently. Every job runs
+// NumJobs copies against each of its disks, and the copies are merged into
+// one result per job.
+func runJobGroup(client *vdisk.Client, group []jobfile.Job) ([]*ThroughputMetrics, error) {
+	type instance struct {
+		job     int
+		tj      *throughputJob
+		metrics *ThroughputMetrics
+		err     error
+	}
+
+	diskSizes := map[string]int64{}
+	var instances []*instance
+	for i, job := range group {
+		copies := len(job.Disks) * job.NumJobs
+		for d, disk := range job.Disks {
+			for c := 0; c < job.NumJobs; c++ {
+				profile := job.Profile
+				profile.Seed += int64(d*job.NumJobs + c)
+				if profile.RegionSize == 0 {
+					size, err := cachedDiskSize(client, diskSizes, disk)
+					if err != nil {
+						return nil, fmt.Errorf("job %s: %v", job.Name, err)
+					}
+					profile.RegionSize = size - profile.RegionOffset
+				}
+				gen, err := workload.NewGenerator(profile)
+				if err != nil {
+					return nil, fmt.Errorf("job %s: %v", job.Name, err)
+				}
+
+				name := job.Name
+				if copies > 1 {
+					name = fmt.Sprintf("%s.%d", job.Name, d*job.NumJobs+c)
+				}
+				instances = append(instances, &instance{job: i, tj: &throughputJob{
+					Name:           name,
+					Operation:      job.Operation,
+					Disk:           disk,
+					Generator:      gen,
+					Duration:       job.Runtime,
+					RampTime:       job.RampTime,
+					MaxConcurrent:  job.IODepth,
+					TargetRPS:      job.RateIOPS,
+					TargetMBps:     float64(job.Rate) / (1024 * 1024),
+					PipelineWindow: job.PipelineWindow,
+					MetricsCSV:     jobOutputPath(*metricsCSVPath, name),
+					LatencyFile:    jobOutputPath(*latencyHistogramFile, name),
+				}})
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func(inst *instance) {
+			defer wg.Done()
+			inst.metrics, inst.err = runThroughputJob(context.Background(), client, inst.tj)
+		}(inst)
+	}
+	wg.Wait()
+
+	var firstErr error
+	results := make([]*ThroughputMetrics, 0, len(group))
+	for i, job := range group {
+		var parts []*ThroughputMetrics
+		for _, inst := range instances {
+			if inst.job != i {
+				continue
+			}
+			if inst.err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("job %s: %v", inst.tj.Name, inst.err)
+			}
+			if inst.metrics != nil {
+				parts = append(parts, inst.metrics)
+			}
+		}
+		switch len(parts) {
+		case 0:
+		case 1:
+			parts[0].Job = job.Name
+			results = append(results, parts[0])
+		default:
+			results = append(results, mergeThroughputMetrics(job.Name, parts))
+		}
+	}
+	return results, firstErr
+}
+
+// cachedDiskSize probes the size of a disk once per job group
+func cachedDiskSize(client *vdisk.Client, sizes map[string]int64, disk *protos.DiskIdentifier) (int64, error) {
+	key := vdisk.DiskKey(disk)
+	if size, ok := sizes[key]; ok {
+		return size, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *requestTimeout)
+	defer cancel()
+	size, err := client.DiskSize(ctx, disk)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get size of %s: %v", key, err)
+	}
+	sizes[key] = size
+	return size, nil
+}
+
+// loadJobPayload loads the write payload for jobs that write. Without
+// write payload flags a random payload covering the largest block is used.
+func loadJobPayload(jobs []jobfile.Job) error {
+	var largest int64
+	for _, job := range jobs {
+		if job.Operation != workload.OpRead {
+			largest = max(largest, job.Profile.MaxBlockSize())
+		}
+	}
+	if largest == 0 {
+		return nil
+	}
+
+	if *writeSource == "data" && *writeData == "" && *writeFile == "" {
+		*writeSource = "random"
+	}
+	if *writeLength == 0 && *writeFile == "" && *writeSource != "data" && *writeSource != "stdin" {
+		*writeLength = largest
+	}
+	payload, err := loadWritePayload()
+	if err != nil {
+		return err
+	}
+	if int64(len(payload)) < largest {
+		return fmt.Errorf("write payload of %d bytes is smaller than the largest block size %d", len(payload), largest)
+	}
+	writePayload = payload
+	return nil
+}
+
+// jobOutputPath inserts the job name before the extension of a per-test
+// output file path, so that concurrent jobs write separate files
+func jobOutputPath(path, name string) string {
+	if strings.TrimSpace(path) == "" {
+		return ""
+	}
+	safe := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + safe + ext
+}
+
+// mergeThroughputMetrics combines the results of tests that ran side by
+// side or one after another into one result spanning all of them
+func mergeThroughputMetrics(name string, parts []*ThroughputMetrics) *ThroughputMetrics {
+	merged := &ThroughputMetrics{
+		Job:            name,
+		Operation:      parts[0].Operation,
+		Workload:       parts[0].Workload,
+		PipelineWindow: parts[0].PipelineWindow,
+		StartTime:      parts[0].StartTime,
+		EndTime:        parts[0].EndTime,
+		Latency:        histogram.NewLatency(),
+	}
+
+	mixed, openLoop := false, true
+	merged.Disk = parts[0].Disk
+	for _, m := range parts {
+		if m.Disk != merged.Disk {
+			merged.Disk = ""
+		}
+		openLoop = openLoop && m.TargetRate > 0
+		if m.Operation != merged.Operation || m.Operation == "mixed" {
+			mixed = true
+		}
+		if m.Workload != merged.Workload {
+			merged.Workload = "multiple workloads"
+		}
+	}
+	if mixed {
+		merged.Operation = "mixed"
+		merged.Operations = map[string]*OperationMetrics{
+			workload.OpRead:  {Latency: histogram.NewLatency()},
+			workload.OpWrite: {Latency: histogram.NewLatency()},
+		}
+	}
+
+	for _, m := range parts {
+		merged.MaxConcurrent += m.MaxConcurrent
+		merged.TotalRequests += m.TotalRequests
+		merged.WarmupRequests += m.WarmupRequests
+		merged.SuccessfulRequests += m.SuccessfulRequests
+		merged.FailedRequests += m.FailedRequests
+		merged.TotalBytes += m.TotalBytes
+		merged.PayloadBytes += m.PayloadBytes
+		merged.WireBytes += m.WireBytes
+		if m.StartTime.Before(merged.StartTime) {
+			merged.StartTime = m.StartTime
+		}
+		if m.EndTime.After(merged.EndTime) {
+			merged.EndTime = m.EndTime
+		}
+		merged.Retries += m.Retries
+		merged.Latency.Merge(m.Latency)
+		for kind, n := range m.Errors.snapshot() {
+			merged.Errors.add(kind, n)
+		}
+
+		// Schedule statistics only add up when every part ran open loop
+		if openLoop {
+			if merged.ServiceLatency == nil {
+				merged.ServiceLatency = histogram.NewLatency()
+			}
+			merged.ServiceLatency.Merge(m.ServiceLatency)
+			merged.TargetRate += m.TargetRate
+			merged.ScheduledRequests += m.ScheduledRequests
+			merged.MissedSchedule += m.MissedSchedule
+			merged.UnsentRequests += m.UnsentRequests
+			merged.MaxScheduleLag = max(merged.MaxScheduleLag, m.MaxScheduleLag)
+		}
+
+		if merged.Operations == nil {
+			continue
+		}
+		if m.Operations != nil {
+			for op, om := range m.Operations {
+				mergeOperationMetrics(merged.Operations[op], om)
+			}
+		} else {
+			mergeOperationMetrics(merged.Operations[m.Operation], &OperationMetrics{
+				Requests: m.TotalRequests,
+				Failed:   m.FailedRequests,
+				Bytes:    m.TotalBytes,
+				Latency:  m.Latency,
+			})
+		}
+	}
+
+	merged.TimeSeries = mergeTimeSeries(merged.StartTime, parts)
+	merged.TotalDuration = merged.EndTime.Sub(merged.StartTime)
+	if merged.TotalDuration > 0 {
+		merged.RequestsPerSecond = float64(merged.TotalRequests) / merged.TotalDuration.Seconds()
+		merged.BytesPerSecond = float64(merged.TotalBytes) / merged.TotalDuration.Seconds()
+	}
+	return merged
+}
+
+func mergeOperationMetrics(dst, src *OperationMetrics) {
+	dst.Requests += src.Requests
+	dst.Failed += src.Failed
+	dst.Bytes += src.Bytes
+	dst.Latency.Merge(src.Latency)
+}
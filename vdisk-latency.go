@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/histogram"
+)
+
+var (
+	latencyHistogramFile   = flag.String("latency_histogram_file", "", "Write the throughput latency histogram to this file (empty to disable)")
+	latencyHistogramFormat = flag.String("latency_histogram_format", "hlog", "Latency histogram file format: hlog (HdrHistogram interval log) or json (final histogram)")
+)
+
+// formatPercentiles renders the standard percentiles of h on one line
+func formatPercentiles(h *histogram.Histogram) string {
+	var parts []string
+	for _, p := range h.Percentiles(histogram.StandardPercentiles) {
+		parts = append(parts, fmt.Sprintf("p%g=%v", p.Percentile, time.Duration(p.Value)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// latencyLog writes the -latency_histogram_file output
+type latencyLog struct {
+	file     *os.File
+	format   string
+	log      *histogram.LogWriter
+	interval time.Duration
+	from     time.Time // start of the interval being recorded
+}
+
+// newLatencyLog opens the histogram file and, for the hlog format, starts
+// recording interval histograms in metrics
+func newLatencyLog(path, format string, intervalSeconds int, metrics *ThroughputMetrics) (*latencyLog, error) {
+	if format != "hlog" && format != "json" {
+		return nil, fmt.Errorf("invalid latency_histogram_format: %s (must be hlog or json)", format)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create latency histogram file: %v", err)
+	}
+
+	l := &latencyLog{
+		file:     f,
+		format:   format,
+		interval: time.Duration(max(intervalSeconds, 1)) * time.Second,
+		from:     metrics.StartTime,
+	}
+	if format == "hlog" {
+		if l.log, err = histogram.NewLogWriter(f, metrics.StartTime); err != nil {
+			f.Close()
+			return nil, err
+		}
+		metrics.IntervalLatency = histogram.NewLatency()
+	}
+	return l, nil
+}
+
+// run appends one interval histogram per interval until ctx is done
+func (l *latencyLog) run(ctx context.Context, metrics *ThroughputMetrics, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if l.log == nil {
+		return
+	}
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.writeInterval(metrics, now)
+		}
+	}
+}
+
+// writeInterval logs the values recorded since the previous interval
+func (l *latencyLog) writeInterval(metrics *ThroughputMetrics, now time.Time) {
+	interval := metrics.IntervalLatency.Snapshot(true)
+	if err := l.log.WriteInterval(interval, l.from, now.Sub(l.from)); err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to write latency histogram log: %v\n", err)
+	}
+	l.from = now
+}
+
+// Close writes the last partial interval (hlog) or the final histogram
+// (json) and closes the file. It must be called after run has returned.
+func (l *latencyLog) Close(metrics *ThroughputMetrics) {
+	if l.log != nil {
+		l.writeInterval(metrics, time.Now())
+	}
+	if l.format == "json" {
+		if err := metrics.Latency.WriteJSON(l.file, "ns"); err != nil {
+			fmt.Fprintf(logOutput, "Warning: failed to write latency histogram: %v\n", err)
+		}
+	}
+	l.file.Close()
+	fmt.Fprintf(logOutput, "Latency histogram written to %s (%s)\n", l.file.Name(), l.format)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+var (
+	writeDataEncoding = flag.String("write_data_encoding", "hex", "Encoding of -write_data (hex, base64, raw)")
+	writeSource       = flag.String("write_source", "data", "Write payload source (data, file, stdin, zeros, random, incrementing, compressible)")
+	writeFile         = flag.String("write_file", "", "Local file to use as the write payload (\"-\" for stdin); implies -write_source=file")
+	writeSeed         = flag.Int64("write_seed", 1, "Seed for -write_source=random and compressible")
+	writeCompressible = flag.Float64("write_compress_ratio", 2.0, "Target compression ratio for -write_source=compressible")
+)
+
+// writePayload holds the decoded write payload shared by every write
+var writePayload []byte
+
+// loadWritePayload resolves the payload selected by the write flags and
+// validates -write_length against it. A zero -write_length is set to the
+// payload size for data, file and stdin sources.
+func loadWritePayload() ([]byte, error) {
+	source := *writeSource
+	if *writeFile != "" {
+		source = "file"
+	}
+
+	var data []byte
+	var err error
+	switch source {
+	case "data":
+		if *writeData == "" {
+			return nil, fmt.Errorf("write_data is required for write operation")
+		}
+		data, err = decodeWriteData(*writeData, *writeDataEncoding)
+	case "file":
+		data, err = readPayloadFile(*writeFile)
+	case "stdin":
+		data, err = io.ReadAll(os.Stdin)
+	case "zeros", "random", "incrementing", "compressible":
+		if *writeLength <= 0 {
+			return nil, fmt.Errorf("write_length is required for write_source=%s", source)
+		}
+		return generatePayload(source, *writeLength, *writeSeed, *writeCompressible)
+	default:
+		return nil, fmt.Errorf("invalid write_source: %s", source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if *writeLength == 0 {
+		*writeLength = int64(len(data))
+	}
+	if *writeLength != int64(len(data)) {
+		return nil, fmt.Errorf("write_length %d does not match the %d byte %s payload", *writeLength, len(data), source)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("write payload is empty")
+	}
+	return data, nil
+}
+
+// decodeWriteData decodes the -write_data flag value
+func decodeWriteData(value string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "hex":
+		value = strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("write_data is not valid hex (use -write_data_encoding=raw for text): %v", err)
+		}
+		return data, nil
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("write_data is not valid base64: %v", err)
+		}
+		return data, nil
+	case "raw":
+		return []byte(value), nil
+	}
+	return nil, fmt.Errorf("invalid write_data_encoding: %s (must be hex, base64 or raw)", encoding)
+}
+
+func readPayloadFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, fmt.Errorf("write_file is required for write_source=file")
+	}
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read write_file: %v", err)
+	}
+	return data, nil
+}
+
+// generatePayload produces length bytes of a synthetic pattern
+func generatePayload(pattern string, length int64, seed int64, ratio float64) ([]byte, error) {
+	data := make([]byte, length)
+
+	switch pattern {
+	case "zeros":
+	case "random":
+		rand.New(rand.NewSource(seed)).Read(data)
+	case "incrementing":
+		for i := range data {
+			data[i] = byte(i)
+		}
+	case "compressible":
+		if ratio < 1 {
+			return nil, fmt.Errorf("write_compress_ratio must be >= 1, got %.2f", ratio)
+		}
+		// Each 4 KiB block starts with random bytes and is zero filled after
+		// them, so roughly 1/ratio of the payload is incompressible
+		const block = 4096
+		rng := rand.New(rand.NewSource(seed))
+		for off := int64(0); off < length; off += block {
+			end := min(off+block, length)
+			random := int64(float64(end-off) / ratio)
+			rng.Read(data[off : off+random])
+		}
+	}
+	return data, nil
+}
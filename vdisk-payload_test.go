@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+// setFlags sets command line flags for the duration of the test
+func setFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+	for name, value := range values {
+		f := flag.Lookup(name)
+		if f == nil {
+			t.Fatalf("no flag %s", name)
+		}
+		old := f.Value.String()
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { flag.Set(name, old) })
+	}
+}
+
+func TestDecodeWriteData(t *testing.T) {
+	for _, tc := range []struct {
+		value, encoding string
+		want            string
+		err             string
+	}{
+		{value: "48656c6c6f", encoding: "hex", want: "Hello"},
+		{value: "0x48656C6C6F", encoding: "hex", want: "Hello"},
+		{value: "SGVsbG8=", encoding: "base64", want: "Hello"},
+		{value: "Hello", encoding: "raw", want: "Hello"},
+		{value: "Hello", encoding: "hex", err: "not valid hex"},
+		{value: "486", encoding: "hex", err: "not valid hex"},
+		{value: "SGVsbG8", encoding: "base64", err: "not valid base64"},
+		{value: "SGV$bG8=", encoding: "base64", err: "not valid base64"},
+		{value: "Hello", encoding: "utf8", err: "invalid write_data_encoding"},
+	} {
+		got, err := decodeWriteData(tc.value, tc.encoding)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s %q: error %v, want %q", tc.encoding, tc.value, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || string(got) != tc.want {
+			t.Errorf("%s %q: got %q, %v, want %q", tc.encoding, tc.value, got, err, tc.want)
+		}
+	}
+}
+
+func TestWriteLengthValidated(t *testing.T) {
+	for _, tc := range []struct {
+		data, encoding, length string
+		want                   int64
+		err                    string
+	}{
+		// A zero length is taken from the decoded payload, not the flag text
+		{data: "48656c6c6f", encoding: "hex", length: "0", want: 5},
+		{data: "48656c6c6f", encoding: "hex", length: "5", want: 5},
+		{data: "48656c6c6f", encoding: "hex", length: "10", err: "does not match the 5 byte"},
+		{data: "SGVsbG8=", encoding: "base64", length: "8", err: "does not match the 5 byte"},
+		{data: "Hello", encoding: "raw", length: "4", err: "does not match the 5 byte"},
+	} {
+		setFlags(t, map[string]string{
+			"write_source":        "data",
+			"write_data":          tc.data,
+			"write_data_encoding": tc.encoding,
+			"write_length":        tc.length,
+		})
+		data, err := loadWritePayload()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s %q with length %s: error %v, want %q", tc.encoding, tc.data, tc.length, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || string(data) != "Hello" || *writeLength != tc.want {
+			t.Errorf("%s %q with length %s: got %q, length %d, %v", tc.encoding, tc.data, tc.length, data, *writeLength, err)
+		}
+	}
+}
+
+func TestGeneratePayload(t *testing.T) {
+	a, err := generatePayload("random", 10000, 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := generatePayload("random", 10000, 7, 0)
+	c, _ := generatePayload("random", 10000, 8, 0)
+	if !bytes.Equal(a, b) {
+		t.Error("random payloads with the same seed differ")
+	}
+	if bytes.Equal(a, c) {
+		t.Error("random payloads with different seeds are equal")
+	}
+
+	zeros, _ := generatePayload("zeros", 100, 1, 0)
+	incrementing, _ := generatePayload("incrementing", 300, 1, 0)
+	if !bytes.Equal(zeros, make([]byte, 100)) {
+		t.Error("zeros payload is not all zero")
+	}
+	if incrementing[0] != 0 || incrementing[255] != 255 || incrementing[256] != 0 {
+		t.Errorf("incrementing payload starts % x", incrementing[:4])
+	}
+
+	if _, err := generatePayload("compressible", 4096, 1, 0.5); err == nil {
+		t.Error("compressible payload accepted a ratio below 1")
+	}
+}
+
+func TestCompressiblePayloadRatio(t *testing.T) {
+	const length = 1 << 20
+	ranges := []*protos.DiskDataRange{{Offset: proto.Int64(0), Length: proto.Int64(length)}}
+	for _, ratio := range []float64{1.5, 2, 4, 8} {
+		data, err := generatePayload("compressible", length, 1, ratio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Snappy copies at most 64 bytes per tag and does not reach higher
+		// ratios on runs of zeros
+		for _, ct := range []protos.CompressionType{protos.CompressionType_kLZ4Compression, protos.CompressionType_kZlibCompression} {
+			payload, ok, err := vdisk.CompressPayload(ct, ranges, data)
+			if err != nil || !ok {
+				t.Fatalf("%s at ratio %g: compressed %v, %v", ct, ratio, ok, err)
+			}
+			// The random part of each block does not compress, so the ratio
+			// is reached to within the framing and codec overhead
+			got := float64(length) / float64(len(payload))
+			if got < ratio*0.95 || got > ratio*1.05 {
+				t.Errorf("%s: ratio %.2f, want %g", ct, got, ratio)
+			}
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/histogram"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
+)
+
+var (
+	// Phased throughput flags
+	warmup          = flag.Duration("warmup", 0, "Throughput mode: run at the starting load for this long before measuring; warm-up requests are left out of all results")
+	rampUp          = flag.Duration("ramp_up", 0, "Throughput mode: ramp linearly from 1 concurrent request (or 1 request/sec in open loop) up to the test load over this long, reported as its own phase")
+	stepConcurrency = flag.String("step_concurrency", "", "Throughput mode: step-load stages as concurrent requests per stage, e.g. 10,20,40,80")
+	stepRPS         = flag.String("step_rps", "", "Throughput mode: open-loop step-load stages as requests/sec per stage, e.g. 500,1000,2000")
+	stepMBps        = flag.String("step_mbps", "", "Throughput mode: open-loop step-load stages as MB/s per stage, e.g. 100,200,400")
+	stepDuration    = flag.Duration("step_duration", time.Minute, "Duration of each step-load stage (replaces test_duration)")
+)
+
+// phaseTick is how often a changing concurrency limit is updated
+const phaseTick = 10 * time.Millisecond
+
+// loadPhase is one measured phase of a throughput job. The concurrency limit
+// and, in open-loop jobs, the request rate change linearly from their From
+// to their To values over the phase.
+type loadPhase struct {
+	Name     string
+	Duration time.Duration
+
+	FromConcurrency int
+	ToConcurrency   int
+	FromRate        float64 // Requests per second, zero in closed-loop jobs
+	ToRate          float64
+}
+
+// String describes the load of the phase
+func (p loadPhase) String() string {
+	if p.FromRate > 0 {
+		if p.FromRate == p.ToRate {
+			return fmt.Sprintf("%.2f req/s", p.ToRate)
+		}
+		return fmt.Sprintf("%.2f->%.2f req/s", p.FromRate, p.ToRate)
+	}
+	if p.FromConcurrency == p.ToConcurrency {
+		return fmt.Sprintf("%d concurrent", p.ToConcurrency)
+	}
+	return fmt.Sprintf("%d->%d concurrent", p.FromConcurrency, p.ToConcurrency)
+}
+
+// meanRate returns the average target rate of the phase
+func (p loadPhase) meanRate() float64 {
+	return (p.FromRate + p.ToRate) / 2
+}
+
+// loadPlan is the load of a throughput job over time: the warm-up at the
+// load the first phase starts with, then each phase in turn
+type loadPlan struct {
+	warmup time.Duration
+	phases []loadPhase
+}
+
+// duration returns the measured length of the plan, without the warm-up
+func (p loadPlan) duration() time.Duration {
+	var d time.Duration
+	for _, phase := range p.phases {
+		d += phase.Duration
+	}
+	return d
+}
+
+// meanRate returns the average target rate over the measured phases, zero
+// for closed-loop plans
+func (p loadPlan) meanRate() float64 {
+	d := p.duration()
+	if d == 0 {
+		return p.phases[0].FromRate
+	}
+	var sum float64
+	for _, phase := range p.phases {
+		sum += phase.meanRate() * phase.Duration.Seconds()
+	}
+	return sum / d.Seconds()
+}
+
+// locate returns the phase running elapsed into the job and how far through
+// it the job is, from 0 to 1. The warm-up counts as the start of the first
+// phase.
+func (p loadPlan) locate(elapsed time.Duration) (loadPhase, float64) {
+	elapsed -= p.warmup
+	if elapsed < 0 {
+		return p.phases[0], 0
+	}
+	for i, phase := range p.phases {
+		if elapsed >= phase.Duration && i < len(p.phases)-1 {
+			elapsed -= phase.Duration
+			continue
+		}
+		if phase.Duration <= 0 {
+			return phase, 1
+		}
+		return phase, min(float64(elapsed)/float64(phase.Duration), 1)
+	}
+	return p.phases[len(p.phases)-1], 1
+}
+
+// at returns the concurrency limit and rate elapsed into the job
+func (p loadPlan) at(elapsed time.Duration) (int, float64) {
+	phase, f := p.locate(elapsed)
+	concurrency := phase.FromConcurrency + int(float64(phase.ToConcurrency-phase.FromConcurrency)*f+0.5)
+	return concurrency, phase.FromRate + (phase.ToRate-phase.FromRate)*f
+}
+
+// rateAt returns the rate elapsed into the job and its slope in requests
+// per second per second
+func (p loadPlan) rateAt(elapsed time.Duration) (float64, float64) {
+	phase, f := p.locate(elapsed)
+	rate := phase.FromRate + (phase.ToRate-phase.FromRate)*f
+	if elapsed < p.warmup || f >= 1 || phase.Duration <= 0 {
+		return rate, 0
+	}
+	return rate, (phase.ToRate - phase.FromRate) / phase.Duration.Seconds()
+}
+
+// varies reports whether the concurrency limit changes during the plan
+func (p loadPlan) varies() bool {
+	for _, phase := range p.phases {
+		if phase.FromConcurrency != p.phases[0].FromConcurrency || phase.ToConcurrency != p.phases[0].FromConcurrency {
+			return true
+		}
+	}
+	return false
+}
+
+// newLoadPhases builds the phases of the flag-driven throughput test from
+// -ramp_up and the step-load flags, or returns nil for a single steady run.
+// requestBytes is the mean request size used to convert MB/s to a rate.
+func newLoadPhases(requestBytes float64) ([]loadPhase, error) {
+	var steps []float64
+	var stepFlag string
+	for _, f := range []struct {
+		name  string
+		value string
+	}{
+		{"step_concurrency", *stepConcurrency},
+		{"step_rps", *stepRPS},
+		{"step_mbps", *stepMBps},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if stepFlag != "" {
+			return nil, fmt.Errorf("%s and %s are mutually exclusive", stepFlag, f.name)
+		}
+		values, err := parseSteps(f.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", f.name, err)
+		}
+		steps, stepFlag = values, f.name
+	}
+	if stepFlag == "" && *rampUp <= 0 {
+		return nil, nil
+	}
+	if stepFlag != "" && (*targetRPS > 0 || *targetMBps > 0) {
+		return nil, fmt.Errorf("%s cannot be combined with target_rps or target_mbps", stepFlag)
+	}
+	if stepFlag != "" && *stepDuration <= 0 {
+		return nil, fmt.Errorf("step_duration must be positive")
+	}
+
+	// Steps and the steady load are given as concurrency in closed loop and
+	// as a request rate in open loop
+	openLoop := stepFlag == "step_rps" || stepFlag == "step_mbps" || (stepFlag == "" && (*targetRPS > 0 || *targetMBps > 0))
+	phase := func(name string, d time.Duration, from, to float64) loadPhase {
+		if openLoop {
+			return loadPhase{Name: name, Duration: d, FromConcurrency: *maxConcurrent, ToConcurrency: *maxConcurrent, FromRate: from, ToRate: to}
+		}
+		return loadPhase{Name: name, Duration: d, FromConcurrency: int(from), ToConcurrency: int(to)}
+	}
+
+	var loads []float64
+	switch stepFlag {
+	case "step_concurrency", "step_rps":
+		loads = steps
+	case "step_mbps":
+		for _, mbps := range steps {
+			rate, err := openLoopRate(0, mbps, requestBytes)
+			if err != nil {
+				return nil, err
+			}
+			loads = append(loads, rate)
+		}
+	default:
+		load := float64(*maxConcurrent)
+		if openLoop {
+			rate, err := openLoopRate(*targetRPS, *targetMBps, requestBytes)
+			if err != nil {
+				return nil, err
+			}
+			load = rate
+		}
+		loads = []float64{load}
+	}
+	if stepFlag == "step_concurrency" {
+		for _, c := range loads {
+			if c != float64(int(c)) {
+				return nil, fmt.Errorf("invalid step_concurrency: %g is not a whole number", c)
+			}
+		}
+	}
+
+	var phases []loadPhase
+	if *rampUp > 0 {
+		phases = append(phases, phase("ramp-up", *rampUp, min(1, loads[0]), loads[0]))
+	}
+	if stepFlag == "" {
+		return append(phases, phase("steady", *testDuration, loads[0], loads[0])), nil
+	}
+	for i, load := range loads {
+		phases = append(phases, phase(fmt.Sprintf("step %d", i+1), *stepDuration, load, load))
+	}
+	return phases, nil
+}
+
+// parseSteps parses a comma separated list of positive step loads
+func parseSteps(s string) ([]float64, error) {
+	var steps []float64
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("%q is not a positive number", field)
+		}
+		steps = append(steps, v)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no stages given")
+	}
+	return steps, nil
+}
+
+// concurrencyGate lowers the number of requests that may be in flight below
+// the capacity of the request semaphore by holding some of its slots
+type concurrencyGate struct {
+	slots    chan struct{}
+	reserved int
+}
+
+// set holds or releases slots until at most limit requests can be in
+// flight. Holding a slot waits for a request to finish; set gives up when
+// ctx is done. A limit above the capacity of the semaphore holds nothing.
+func (g *concurrencyGate) set(ctx context.Context, limit int) {
+	target := max(cap(g.slots)-max(limit, 1), 0)
+	for g.reserved > target {
+		<-g.slots
+		g.reserved--
+	}
+	for g.reserved < target {
+		select {
+		case <-ctx.Done():
+			return
+		case g.slots <- struct{}{}:
+			g.reserved++
+		}
+	}
+}
+
+// run follows the concurrency limit of plan until ctx is done
+func (g *concurrencyGate) run(ctx context.Context, plan loadPlan, start time.Time) {
+	ticker := time.NewTicker(phaseTick)
+	defer ticker.Stop()
+	for {
+		limit, _ := plan.at(time.Since(start))
+		g.set(ctx, limit)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// newPhaseMetrics returns the metrics of each phase of plan, which follow
+// one another from the start of the measured run
+func newPhaseMetrics(metrics *ThroughputMetrics, plan loadPlan) []*ThroughputMetrics {
+	phases := make([]*ThroughputMetrics, len(plan.phases))
+	start := metrics.StartTime
+	for i, phase := range plan.phases {
+		m := &ThroughputMetrics{
+			Job:            metrics.Job,
+			Disk:           metrics.Disk,
+			Phase:          phase.Name,
+			Load:           phase.String(),
+			Operation:      metrics.Operation,
+			Workload:       metrics.Workload,
+			MaxConcurrent:  max(phase.FromConcurrency, phase.ToConcurrency),
+			PipelineWindow: metrics.PipelineWindow,
+			StartTime:      start,
+			EndTime:        start.Add(phase.Duration),
+			Latency:        histogram.NewLatency(),
+			TargetRate:     phase.meanRate(),
+		}
+		if m.TargetRate > 0 {
+			m.ServiceLatency = histogram.NewLatency()
+		}
+		if metrics.Operations != nil {
+			m.Operations = map[string]*OperationMetrics{
+				workload.OpRead:  {Latency: histogram.NewLatency()},
+				workload.OpWrite: {Latency: histogram.NewLatency()},
+			}
+		}
+		phases[i] = m
+		start = m.EndTime
+	}
+	return phases
+}
+
+// phaseAt returns the phase a request started at t belongs to, or nil
+func (m *ThroughputMetrics) phaseAt(t time.Time) *ThroughputMetrics {
+	for i := len(m.Phases) - 1; i >= 0; i-- {
+		if !t.Before(m.Phases[i].StartTime) {
+			return m.Phases[i]
+		}
+	}
+	return nil
+}
+
+// finishPhases computes the rates of each phase once the job has ended
+func finishPhases(metrics *ThroughputMetrics) {
+	for _, phase := range metrics.Phases {
+		if phase.EndTime.After(metrics.EndTime) {
+			phase.EndTime = metrics.EndTime
+		}
+		phase.TotalDuration = max(phase.EndTime.Sub(phase.StartTime), 0)
+		if phase.TotalDuration > 0 {
+			phase.RequestsPerSecond = float64(phase.TotalRequests) / phase.TotalDuration.Seconds()
+			phase.BytesPerSecond = float64(phase.TotalBytes) / phase.TotalDuration.Seconds()
+		}
+	}
+}
+
+// printPhaseResults prints a report section per phase followed by a table
+// comparing the phases, which shows where the server saturates
+func printPhaseResults(metrics *ThroughputMetrics) {
+	for i, phase := range metrics.Phases {
+		fmt.Fprintf(logOutput, "\n=== Phase %d/%d: %s (%s, %v) ===\n", i+1, len(metrics.Phases),
+			phase.Phase, phase.Load, phase.TotalDuration.Truncate(time.Second))
+		fmt.Fprintf(logOutput, "Total Requests: %d\n", phase.TotalRequests)
+		fmt.Fprintf(logOutput, "Successful: %d, Failed: %d\n", phase.SuccessfulRequests, phase.FailedRequests)
+		fmt.Fprintf(logOutput, "Requests/sec: %.2f\n", phase.RequestsPerSecond)
+		fmt.Fprintf(logOutput, "Bytes/sec: %.2f (%.2f MB/s)\n", phase.BytesPerSecond, phase.BytesPerSecond/(1024*1024))
+		if phase.TargetRate > 0 {
+			fmt.Fprintf(logOutput, "Target Rate: %.2f req/s, Missed Schedule: %d, Unsent: %d, Max Dispatch Lag: %v\n",
+				phase.TargetRate, phase.MissedSchedule+phase.UnsentRequests, phase.UnsentRequests,
+				time.Duration(phase.MaxScheduleLag))
+		}
+		if phase.SuccessfulRequests > 0 {
+			fmt.Fprintf(logOutput, "Avg Latency: %v\n", time.Duration(phase.Latency.Mean()))
+			fmt.Fprintf(logOutput, "Min Latency: %v, Max Latency: %v\n",
+				time.Duration(phase.Latency.Min()), time.Duration(phase.Latency.Max()))
+			fmt.Fprintf(logOutput, "Latency Percentiles: %s\n", formatPercentiles(phase.Latency))
+			if phase.ServiceLatency != nil {
+				fmt.Fprintf(logOutput, "Service Time: avg=%v, %s\n",
+					time.Duration(phase.ServiceLatency.Mean()), formatPercentiles(phase.ServiceLatency))
+			}
+		}
+		if phase.Operations != nil {
+			fmt.Fprintf(logOutput, "Reads: %d, Writes: %d\n",
+				phase.Operations[workload.OpRead].Requests, phase.Operations[workload.OpWrite].Requests)
+		}
+	}
+
+	fmt.Fprintf(logOutput, "\nPhase Summary:\n")
+	fmt.Fprintf(logOutput, "  %-10s %-24s %12s %10s %8s %12s %12s\n", "Phase", "Load", "Req/s", "MB/s", "Failed", "p50", "p99")
+	for _, phase := range metrics.Phases {
+		var p50, p99 time.Duration
+		if phase.Latency.Count() > 0 {
+			p50 = time.Duration(phase.Latency.ValueAtPercentile(50))
+			p99 = time.Duration(phase.Latency.ValueAtPercentile(99))
+		}
+		fmt.Fprintf(logOutput, "  %-10s %-24s %12.2f %10.2f %8d %12v %12v\n", phase.Phase, phase.Load,
+			phase.RequestsPerSecond, phase.BytesPerSecond/(1024*1024), phase.FailedRequests,
+			p50.Round(time.Microsecond), p99.Round(time.Microsecond))
+	}
+}
+
+// currentPhase returns the phase running now, for intermediate reports
+func currentPhase(metrics *ThroughputMetrics) (int, *ThroughputMetrics) {
+	now := time.Now()
+	for i, phase := range metrics.Phases {
+		if now.Before(phase.EndTime) || i == len(metrics.Phases)-1 {
+			return i, phase
+		}
+	}
+	return 0, nil
+}
+
+// printCurrentPhase adds the running phase to an intermediate report
+func printCurrentPhase(metrics *ThroughputMetrics) {
+	i, phase := currentPhase(metrics)
+	if phase == nil {
+		return
+	}
+	fmt.Fprintf(logOutput, "Phase: %d/%d %s (%s), %d requests so far\n", i+1, len(metrics.Phases),
+		phase.Phase, phase.Load, atomic.LoadInt64(&phase.TotalRequests))
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLoadPhases(t *testing.T) {
+	step := func(name string, concurrency int) loadPhase {
+		return loadPhase{Name: name, Duration: 30 * time.Second, FromConcurrency: concurrency, ToConcurrency: concurrency}
+	}
+	rateStep := func(name string, rate float64) loadPhase {
+		return ratePhase(name, 30*time.Second, 64, rate, rate)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		flags map[string]string
+		want  []loadPhase
+		err   string
+	}{
+		{name: "steady", flags: map[string]string{}},
+		{
+			name:  "step concurrency",
+			flags: map[string]string{"step_concurrency": "10,20,40,80"},
+			want:  []loadPhase{step("step 1", 10), step("step 2", 20), step("step 3", 40), step("step 4", 80)},
+		},
+		{
+			name:  "ramp into steps",
+			flags: map[string]string{"step_concurrency": "10, 20", "ramp_up": "5s"},
+			want: []loadPhase{
+				{Name: "ramp-up", Duration: 5 * time.Second, FromConcurrency: 1, ToConcurrency: 10},
+				step("step 1", 10), step("step 2", 20),
+			},
+		},
+		{
+			name:  "step rps",
+			flags: map[string]string{"step_rps": "500,1000,2000"},
+			want:  []loadPhase{rateStep("step 1", 500), rateStep("step 2", 1000), rateStep("step 3", 2000)},
+		},
+		{
+			// 1 MB/s of 4 KiB requests is 256 requests per second
+			name:  "step mbps",
+			flags: map[string]string{"step_mbps": "1,2"},
+			want:  []loadPhase{rateStep("step 1", 256), rateStep("step 2", 512)},
+		},
+		{
+			name:  "closed-loop ramp",
+			flags: map[string]string{"ramp_up": "10s", "max_concurrent": "50"},
+			want: []loadPhase{
+				{Name: "ramp-up", Duration: 10 * time.Second, FromConcurrency: 1, ToConcurrency: 50},
+				{Name: "steady", Duration: time.Minute, FromConcurrency: 50, ToConcurrency: 50},
+			},
+		},
+		{
+			name:  "open-loop ramp",
+			flags: map[string]string{"ramp_up": "10s", "target_rps": "200"},
+			want: []loadPhase{
+				ratePhase("ramp-up", 10*time.Second, 64, 1, 200),
+				ratePhase("steady", time.Minute, 64, 200, 200),
+			},
+		},
+		{
+			// A ramp never starts above the load it ramps to
+			name:  "open-loop ramp below one request",
+			flags: map[string]string{"ramp_up": "10s", "target_rps": "0.5"},
+			want: []loadPhase{
+				ratePhase("ramp-up", 10*time.Second, 64, 0.5, 0.5),
+				ratePhase("steady", time.Minute, 64, 0.5, 0.5),
+			},
+		},
+		{name: "two step flags", flags: map[string]string{"step_concurrency": "10", "step_rps": "100"}, err: "step_concurrency and step_rps are mutually exclusive"},
+		{name: "steps with a target", flags: map[string]string{"step_rps": "100", "target_rps": "50"}, err: "cannot be combined with target_rps"},
+		{name: "no step duration", flags: map[string]string{"step_concurrency": "10", "step_duration": "0"}, err: "step_duration must be positive"},
+		{name: "fractional concurrency", flags: map[string]string{"step_concurrency": "10,2.5"}, err: "2.5 is not a whole number"},
+		{name: "bad step", flags: map[string]string{"step_concurrency": "10,x"}, err: `"x" is not a positive number`},
+		{name: "negative step", flags: map[string]string{"step_rps": "-5"}, err: `"-5" is not a positive number`},
+		{name: "empty steps", flags: map[string]string{"step_rps": " , "}, err: "no stages given"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			flags := map[string]string{
+				"step_concurrency": "", "step_rps": "", "step_mbps": "", "ramp_up": "0",
+				"target_rps": "0", "target_mbps": "0",
+				"step_duration": "30s", "test_duration": "1m", "max_concurrent": "64",
+			}
+			for name, value := range tc.flags {
+				flags[name] = value
+			}
+			setFlags(t, flags)
+
+			phases, err := newLoadPhases(4096)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(phases, tc.want) {
+				t.Errorf("phases\n%+v\nwant\n%+v", phases, tc.want)
+			}
+		})
+	}
+
+	// MB/s steps need a request size to be converted to a rate
+	setFlags(t, map[string]string{"step_mbps": "1", "step_concurrency": "", "step_rps": "", "target_rps": "0", "target_mbps": "0"})
+	if _, err := newLoadPhases(0); err == nil || !strings.Contains(err.Error(), "fixed request size") {
+		t.Errorf("step_mbps without a request size: %v", err)
+	}
+}
+
+// ratePhase is an open-loop phase at a fixed concurrency limit
+func ratePhase(name string, d time.Duration, concurrency int, from, to float64) loadPhase {
+	return loadPhase{Name: name, Duration: d, FromConcurrency: concurrency, ToConcurrency: concurrency, FromRate: from, ToRate: to}
+}
+
+func TestLoadPlanSteps(t *testing.T) {
+	// A 5s warm-up at the first step, then 10, 20, 40 and 80 for 10s each
+	var phases []loadPhase
+	for _, c := range []int{10, 20, 40, 80} {
+		phases = append(phases, loadPhase{Duration: 10 * time.Second, FromConcurrency: c, ToConcurrency: c})
+	}
+	plan := loadPlan{warmup: 5 * time.Second, phases: phases}
+	if plan.duration() != 40*time.Second || !plan.varies() || plan.meanRate() != 0 {
+		t.Errorf("duration %v, varies %v, mean rate %g", plan.duration(), plan.varies(), plan.meanRate())
+	}
+
+	for _, tc := range []struct {
+		elapsed time.Duration
+		want    int
+	}{
+		{0, 10},
+		{4999 * time.Millisecond, 10},
+		{5 * time.Second, 10},
+		{14999 * time.Millisecond, 10},
+		{15 * time.Second, 20},
+		{24999 * time.Millisecond, 20},
+		{25 * time.Second, 40},
+		{35 * time.Second, 80},
+		{45 * time.Second, 80},
+		{time.Hour, 80},
+	} {
+		if got, rate := plan.at(tc.elapsed); got != tc.want || rate != 0 {
+			t.Errorf("at %v: concurrency %d, rate %g, want %d", tc.elapsed, got, rate, tc.want)
+		}
+	}
+
+	// The warm-up is not part of the first phase's progress
+	for _, tc := range []struct {
+		elapsed time.Duration
+		phase   int
+		f       float64
+	}{
+		{0, 0, 0},
+		{4 * time.Second, 0, 0},
+		{5 * time.Second, 0, 0},
+		{10 * time.Second, 0, 0.5},
+		{20 * time.Second, 1, 0.5},
+		{44 * time.Second, 3, 0.9},
+		{time.Hour, 3, 1},
+	} {
+		phase, f := plan.locate(tc.elapsed)
+		if phase.FromConcurrency != phases[tc.phase].FromConcurrency || absFloat(f-tc.f) > 1e-9 {
+			t.Errorf("locate %v: phase at %d concurrent, %.2f through, want phase %d, %.2f", tc.elapsed, phase.FromConcurrency, f, tc.phase+1, tc.f)
+		}
+	}
+
+	if steady := (loadPlan{phases: phases[:1]}); steady.varies() {
+		t.Error("a plan of one steady phase varies")
+	}
+}
+
+func TestLoadPlanRamp(t *testing.T) {
+	plan := loadPlan{warmup: 2 * time.Second, phases: []loadPhase{
+		{Duration: 10 * time.Second, FromConcurrency: 1, ToConcurrency: 101, FromRate: 100, ToRate: 300},
+		{Duration: 10 * time.Second, FromConcurrency: 101, ToConcurrency: 101, FromRate: 300, ToRate: 300},
+	}}
+	for _, tc := range []struct {
+		elapsed     time.Duration
+		concurrency int
+		rate, slope float64
+	}{
+		// The warm-up runs at the starting load without a slope
+		{0, 1, 100, 0},
+		{time.Second, 1, 100, 0},
+		{2 * time.Second, 1, 100, 20},
+		{4500 * time.Millisecond, 26, 150, 20},
+		{7 * time.Second, 51, 200, 20},
+		{11 * time.Second, 91, 280, 20},
+		{12 * time.Second, 101, 300, 0},
+		{20 * time.Second, 101, 300, 0},
+		{time.Hour, 101, 300, 0},
+	} {
+		concurrency, rate := plan.at(tc.elapsed)
+		rate2, slope := plan.rateAt(tc.elapsed)
+		if concurrency != tc.concurrency || absFloat(rate-tc.rate) > 1e-9 || absFloat(rate2-tc.rate) > 1e-9 || absFloat(slope-tc.slope) > 1e-9 {
+			t.Errorf("at %v: concurrency %d, rate %g (%g), slope %g; want %d, %g, %g",
+				tc.elapsed, concurrency, rate, rate2, slope, tc.concurrency, tc.rate, tc.slope)
+		}
+	}
+
+	// The mean rate weights each phase by its length: 200 then 300
+	if got := plan.meanRate(); absFloat(got-250) > 1e-9 {
+		t.Errorf("mean rate %g, want 250", got)
+	}
+}
+
+func TestConcurrencyGate(t *testing.T) {
+	ctx := context.Background()
+	semaphore := make(chan struct{}, 8)
+	gate := &concurrencyGate{slots: semaphore}
+
+	for _, tc := range []struct{ limit, reserved int }{
+		{3, 5},
+		{6, 2},
+		{8, 0},
+		{20, 0},
+		{0, 7}, // At least one request may always run
+		{2, 6},
+	} {
+		gate.set(ctx, tc.limit)
+		if gate.reserved != tc.reserved || len(semaphore) != tc.reserved {
+			t.Errorf("limit %d: %d slots reserved, %d held, want %d", tc.limit, gate.reserved, len(semaphore), tc.reserved)
+		}
+	}
+	gate.set(ctx, 8)
+
+	// Lowering the limit while requests hold every slot waits for them to
+	// finish, and keeps the freed slots from new requests
+	for i := 0; i < cap(semaphore); i++ {
+		semaphore <- struct{}{}
+	}
+	done := make(chan struct{})
+	go func() {
+		gate.set(ctx, 5)
+		close(done)
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+			t.Fatalf("set returned after %d of 3 requests finished", i)
+		case <-time.After(10 * time.Millisecond):
+		}
+		<-semaphore // A request finishes
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("set did not return once 3 requests finished")
+	}
+	if gate.reserved != 3 || len(semaphore) != cap(semaphore) {
+		t.Errorf("%d slots reserved, %d held, want 3 and all", gate.reserved, len(semaphore))
+	}
+	for i := 0; i < 5; i++ {
+		<-semaphore // The remaining requests finish
+	}
+
+	// set gives up waiting for slots once ctx is done
+	for i := 0; i < 5; i++ {
+		semaphore <- struct{}{}
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	done = make(chan struct{})
+	go func() {
+		gate.set(cctx, 1)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("set did not return after ctx was cancelled")
+	}
+	if gate.reserved != 3 {
+		t.Errorf("%d slots reserved after cancellation, want 3", gate.reserved)
+	}
+
+	// Raising the limit releases held slots without waiting
+	gate.set(ctx, 8)
+	if gate.reserved != 0 || len(semaphore) != 5 {
+		t.Errorf("%d slots reserved, %d held after raising the limit, want 0 and 5", gate.reserved, len(semaphore))
+	}
+}
+
+func absFloat(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
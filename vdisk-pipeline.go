@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
+)
+
+// pipelinedSession is implemented by vdisk.ReadSession and vdisk.WriteSession
+type pipelinedSession interface {
+	Err() error
+	Close() error
+}
+
+// sessionPool spreads pipelined throughput requests over a fixed set of
+// long-lived streams, reopening any stream that breaks
+type sessionPool[S pipelinedSession] struct {
+	name     string
+	open     func() (S, error)
+	mu       sync.Mutex
+	sessions []S
+	next     int64
+	reopened int64
+}
+
+func newSessionPool[S pipelinedSession](name string, streams int, open func() (S, error)) (*sessionPool[S], error) {
+	pool := &sessionPool[S]{
+		name:     name,
+		open:     open,
+		sessions: make([]S, 0, streams),
+	}
+	for i := 0; i < streams; i++ {
+		session, err := open()
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to open %s session %d: %v", name, i, err)
+		}
+		pool.sessions = append(pool.sessions, session)
+	}
+	return pool, nil
+}
+
+// get returns the next session round-robin, replacing it if its stream has failed
+func (p *sessionPool[S]) get() (S, error) {
+	index := int((atomic.AddInt64(&p.next, 1) - 1) % int64(len(p.sessions)))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	session := p.sessions[index]
+	if session.Err() == nil {
+		return session, nil
+	}
+	session.Close()
+
+	session, err := p.open()
+	if err != nil {
+		return session, err
+	}
+	p.sessions[index] = session
+	atomic.AddInt64(&p.reopened, 1)
+	return session, nil
+}
+
+// Close closes every session, waiting for outstanding requests
+func (p *sessionPool[S]) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, session := range p.sessions {
+		session.Close()
+	}
+	if reopened := atomic.LoadInt64(&p.reopened); reopened > 0 {
+		fmt.Fprintf(logOutput, "Pipelined %s streams reopened after failure: %d\n", p.name, reopened)
+	}
+}
+
+// pipelineSessions holds the long-lived streams used when -pipeline_window is set
+type pipelineSessions struct {
+	reads  *sessionPool[*vdisk.ReadSession]
+	writes *sessionPool[*vdisk.WriteSession]
+}
+
+// newPipelineSessions opens the long-lived streams needed for operation
+func newPipelineSessions(client *vdisk.Client, operation string, streams int, window int) (*pipelineSessions, error) {
+	if streams < 1 {
+		streams = client.PoolSize()
+	}
+
+	var err error
+	sessions := &pipelineSessions{}
+	if operation != "read" && operation != "write" && operation != "mixed" {
+		return nil, fmt.Errorf("invalid operation: %s", operation)
+	}
+	if operation != "write" {
+		opts := vdisk.ReadSessionOptions{Window: window}
+		sessions.reads, err = newSessionPool("read", streams, func() (*vdisk.ReadSession, error) {
+			return client.NewReadSession(context.Background(), opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if operation != "read" {
+		var firstSequence int64 = *sequenceNumber
+		sessions.writes, err = newSessionPool("write", streams, func() (*vdisk.WriteSession, error) {
+			// Give every stream its own sequence number space
+			seq := atomic.AddInt64(&firstSequence, 1<<32) - 1<<32
+			return client.NewWriteSession(context.Background(), vdisk.WriteSessionOptions{
+				Window:        window,
+				FirstSequence: seq,
+			})
+		})
+		if err != nil {
+			sessions.Close()
+			return nil, err
+		}
+	}
+
+	fmt.Fprintf(logOutput, "Opened %d pipelined %s streams with window %d\n", streams, operation, window)
+	return sessions, nil
+}
+
+// Close closes all long-lived streams
+func (p *pipelineSessions) Close() {
+	if p.reads != nil {
+		p.reads.Close()
+	}
+	if p.writes != nil {
+		p.writes.Close()
+	}
+}
+
+// performPipelinedRead performs a throughput read on a shared long-lived stream
+func performPipelinedRead(sessions *pipelineSessions, ctx context.Context, disk *protos.DiskIdentifier, op workload.Op, start time.Time) ThroughputResult {
+	result := ThroughputResult{Timestamp: start, Connection: -1}
+
+	session, err := sessions.reads.get()
+	if err != nil {
+		result.Error = err
+		result.Duration = time.Since(start)
+		return result
+	}
+	result.Connection = session.ConnIndex()
+
+	r := session.Read(ctx, vdisk.ReadRequest{
+		Disk:            disk,
+		Offset:          op.Offset,
+		Length:          op.Length,
+		MaxResponseSize: *maxResponseSize,
+	})
+	result.Duration = time.Since(start)
+	if r.Err != nil {
+		result.Error = r.Err
+		return result
+	}
+
+	result.Success = true
+	result.BytesRead = r.BytesRead
+	return result
+}
+
+// performPipelinedWrite performs a throughput write on a shared long-lived stream
+func performPipelinedWrite(sessions *pipelineSessions, ctx context.Context, disk *protos.DiskIdentifier, op workload.Op, start time.Time) ThroughputResult {
+	result := ThroughputResult{Timestamp: start, Connection: -1}
+
+	session, err := sessions.writes.get()
+	if err != nil {
+		result.Error = err
+		result.Duration = time.Since(start)
+		return result
+	}
+	result.Connection = session.ConnIndex()
+
+	// Sequence numbers are assigned by the session
+	req, err := newWriteRequest(disk, op.Offset, op.Length, 0)
+	if err != nil {
+		result.Error = err
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	r := session.Write(ctx, req)
+	result.Duration = time.Since(start)
+	if r.Err != nil {
+		result.Error = r.Err
+		return result
+	}
+
+	result.Success = true
+	result.BytesWritten = r.BytesWritten
+	result.PayloadBytes = r.PayloadBytes
+	result.WireBytes = r.WireBytes
+	return result
+}
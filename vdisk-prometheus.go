@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+var metricsListen = flag.String("metrics_listen", "", "Serve live Prometheus metrics of throughput, job file and scale test runs at http://<addr>/metrics (e.g. :9100)")
+
+// latencyBuckets are the upper bounds in seconds of the request duration
+// histogram
+var latencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05,
+	0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// seriesKey labels the request series of one operation on one disk through
+// one pooled connection
+type seriesKey struct {
+	operation  string
+	disk       string
+	connection int
+}
+
+// errorKey labels the error series of a seriesKey by failure category and
+// code
+type errorKey struct {
+	seriesKey
+	category string
+	code     string
+}
+
+// requestSeries holds the counters and latency histogram of a seriesKey
+type requestSeries struct {
+	requests int64
+	retries  int64
+	bytes    int64
+	buckets  []int64 // Per bucket, not cumulative; the last is +Inf
+	sum      float64
+}
+
+// promExporter collects request results for the Prometheus endpoint. It
+// sees every result, including warm-up requests, so its counters follow the
+// live traffic rather than the measured window. A nil exporter ignores
+// everything.
+type promExporter struct {
+	client *vdisk.Client
+
+	mu      sync.Mutex
+	series  map[seriesKey]*requestSeries
+	errors  map[errorKey]int64
+	running []*ThroughputMetrics // Jobs whose in-flight counts are exported
+}
+
+// exporter is the exporter started for -metrics_listen
+var exporter *promExporter
+
+// metricsServer serves the exporter
+var metricsServer *http.Server
+
+// startMetricsServer starts serving metrics of requests made through client
+// when -metrics_listen is set
+func startMetricsServer(client *vdisk.Client) error {
+	if *metricsListen == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", *metricsListen)
+	if err != nil {
+		return fmt.Errorf("metrics_listen: %v", err)
+	}
+
+	exporter = &promExporter{
+		client: client,
+		series: make(map[seriesKey]*requestSeries),
+		errors: make(map[errorKey]int64),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter)
+	metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go metricsServer.Serve(listener)
+	fmt.Fprintf(logOutput, "Serving Prometheus metrics on http://%s/metrics\n", listener.Addr())
+	return nil
+}
+
+// stopMetricsServer stops the metrics endpoint
+func stopMetricsServer() {
+	if metricsServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	metricsServer.Shutdown(ctx)
+	metricsServer = nil
+}
+
+// track exports the in-flight count of a running job
+func (e *promExporter) track(metrics *ThroughputMetrics) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.running = append(e.running, metrics)
+}
+
+// observe adds one request result of the job with metrics
+func (e *promExporter) observe(metrics *ThroughputMetrics, result ThroughputResult) {
+	if e == nil {
+		return
+	}
+	key := seriesKey{operation: result.Operation, disk: metrics.Disk, connection: result.Connection}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.series[key]
+	if s == nil {
+		s = &requestSeries{buckets: make([]int64, len(latencyBuckets)+1)}
+		e.series[key] = s
+	}
+	s.requests++
+	s.retries += int64(result.Retries)
+	if !result.Success {
+		category, code := splitErrorType(errorType(result.Error))
+		e.errors[errorKey{key, category, code}]++
+		return
+	}
+	s.bytes += result.BytesRead + result.BytesWritten
+	seconds := result.Duration.Seconds()
+	s.buckets[sort.SearchFloat64s(latencyBuckets, seconds)]++
+	s.sum += seconds
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (e *promExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	e.mu.Lock()
+	keys := make([]seriesKey, 0, len(e.series))
+	series := make(map[seriesKey]requestSeries, len(e.series))
+	for key, s := range e.series {
+		keys = append(keys, key)
+		series[key] = requestSeries{
+			requests: s.requests,
+			retries:  s.retries,
+			bytes:    s.bytes,
+			buckets:  append([]int64(nil), s.buckets...),
+			sum:      s.sum,
+		}
+	}
+	errorKeys := make([]errorKey, 0, len(e.errors))
+	errorCounts := make(map[errorKey]int64, len(e.errors))
+	for key, n := range e.errors {
+		errorKeys = append(errorKeys, key)
+		errorCounts[key] = n
+	}
+	inFlight := make(map[seriesKey]int64)
+	for _, m := range e.running {
+		inFlight[seriesKey{operation: m.Operation, disk: m.Disk, connection: -1}] += atomic.LoadInt64(&m.InFlight)
+	}
+	e.mu.Unlock()
+
+	sortSeriesKeys(keys)
+	sort.Slice(errorKeys, func(i, j int) bool {
+		if errorKeys[i].seriesKey != errorKeys[j].seriesKey {
+			return seriesKeyLess(errorKeys[i].seriesKey, errorKeys[j].seriesKey)
+		}
+		if errorKeys[i].category != errorKeys[j].category {
+			return errorKeys[i].category < errorKeys[j].category
+		}
+		return errorKeys[i].code < errorKeys[j].code
+	})
+
+	writeMetricHeader(out, "vdisk_client_requests_total", "counter", "Requests completed, successful or not.")
+	for _, key := range keys {
+		fmt.Fprintf(out, "vdisk_client_requests_total{%s} %d\n", key.labels(), series[key].requests)
+	}
+
+	writeMetricHeader(out, "vdisk_client_request_errors_total", "counter", "Failed requests by failure category and code.")
+	for _, key := range errorKeys {
+		fmt.Fprintf(out, "vdisk_client_request_errors_total{%s,category=%s,code=%s} %d\n", key.labels(), quoteLabel(key.category), quoteLabel(key.code), errorCounts[key])
+	}
+
+	writeMetricHeader(out, "vdisk_client_retries_total", "counter", "Attempts made after the first attempt of a request.")
+	for _, key := range keys {
+		fmt.Fprintf(out, "vdisk_client_retries_total{%s} %d\n", key.labels(), series[key].retries)
+	}
+
+	writeMetricHeader(out, "vdisk_client_bytes_total", "counter", "Bytes read or written by successful requests.")
+	for _, key := range keys {
+		fmt.Fprintf(out, "vdisk_client_bytes_total{%s} %d\n", key.labels(), series[key].bytes)
+	}
+
+	writeMetricHeader(out, "vdisk_client_request_duration_seconds", "histogram", "Service time of successful requests.")
+	for _, key := range keys {
+		s := series[key]
+		labels := key.labels()
+		var cumulative int64
+		for i, bound := range latencyBuckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(out, "vdisk_client_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		cumulative += s.buckets[len(latencyBuckets)]
+		fmt.Fprintf(out, "vdisk_client_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, cumulative)
+		fmt.Fprintf(out, "vdisk_client_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(out, "vdisk_client_request_duration_seconds_count{%s} %d\n", labels, cumulative)
+	}
+
+	// In-flight requests belong to no connection until they pick one
+	inFlightKeys := make([]seriesKey, 0, len(inFlight))
+	for key := range inFlight {
+		inFlightKeys = append(inFlightKeys, key)
+	}
+	sortSeriesKeys(inFlightKeys)
+	writeMetricHeader(out, "vdisk_client_requests_in_flight", "gauge", "Requests currently in flight.")
+	for _, key := range inFlightKeys {
+		fmt.Fprintf(out, "vdisk_client_requests_in_flight{operation=%s,disk=%s} %d\n",
+			quoteLabel(key.operation), quoteLabel(key.disk), inFlight[key])
+	}
+
+	writeMetricHeader(out, "vdisk_client_connection_streams_total", "counter", "Streams opened per pooled connection.")
+	for i, n := range e.client.ConnectionUsage() {
+		fmt.Fprintf(out, "vdisk_client_connection_streams_total{connection=\"%d\"} %d\n", i, n)
+	}
+}
+
+// labels returns the label pairs of the key. Requests that never got a
+// connection are labeled connection="none".
+func (k seriesKey) labels() string {
+	conn := "none"
+	if k.connection >= 0 {
+		conn = strconv.Itoa(k.connection)
+	}
+	return fmt.Sprintf("operation=%s,disk=%s,connection=%s", quoteLabel(k.operation), quoteLabel(k.disk), quoteLabel(conn))
+}
+
+func seriesKeyLess(a, b seriesKey) bool {
+	if a.operation != b.operation {
+		return a.operation < b.operation
+	}
+	if a.disk != b.disk {
+		return a.disk < b.disk
+	}
+	return a.connection < b.connection
+}
+
+func sortSeriesKeys(keys []seriesKey) {
+	sort.Slice(keys, func(i, j int) bool { return seriesKeyLess(keys[i], keys[j]) })
+}
+
+func writeMetricHeader(out *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelEscaper escapes label values as the exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
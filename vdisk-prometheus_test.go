@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk/vdisktest"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
+)
+
+func TestQuoteLabel(t *testing.T) {
+	for _, tc := range []struct{ value, want string }{
+		{"vm_disk:uuid1", `"vm_disk:uuid1"`},
+		{"", `""`},
+		{`C:\disk`, `"C:\\disk"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{"two\nlines", `"two\nlines"`},
+		{"\\\"\n", `"\\\"\n"`},
+	} {
+		if got := quoteLabel(tc.value); got != tc.want {
+			t.Errorf("quoteLabel(%q) = %s, want %s", tc.value, got, tc.want)
+		}
+	}
+}
+
+// scrape returns the value of every sample the exporter serves, keyed by
+// the sample's name and labels as written
+func scrape(t *testing.T, e *promExporter) map[string]string {
+	t.Helper()
+	server := httptest.NewServer(e)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+
+	samples := make(map[string]string)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			t.Fatalf("malformed sample %q", line)
+		}
+		samples[line[:i]] = line[i+1:]
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+func TestPromExporter(t *testing.T) {
+	server := vdisktest.NewServer(vdisktest.ServerOptions{})
+	server.StartBufconn()
+	defer server.Stop()
+	opts := server.ClientOptions()
+	opts.PoolSize = 2
+	client, err := vdisk.NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		if _, _, err := client.Conn(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := &promExporter{
+		client: client,
+		series: make(map[seriesKey]*requestSeries),
+		errors: make(map[errorKey]int64),
+	}
+	const disk = `vm_disk:"quoted"`
+	metrics := &ThroughputMetrics{Operation: "mixed", Disk: disk}
+	e.track(metrics)
+	metrics.InFlight = 5
+
+	durations := []time.Duration{50 * time.Microsecond, 3 * time.Millisecond, 3 * time.Millisecond, 20 * time.Second}
+	for _, d := range durations {
+		e.observe(metrics, ThroughputResult{Success: true, Operation: workload.OpRead, Connection: 1, Duration: d, BytesRead: 4096, Retries: 1})
+	}
+	e.observe(metrics, ThroughputResult{Success: true, Operation: workload.OpWrite, Connection: 0, Duration: time.Millisecond, BytesWritten: 512})
+	// A request that failed before it got a connection
+	e.observe(metrics, ThroughputResult{Operation: workload.OpRead, Connection: -1, Error: status.Error(codes.Unavailable, "no connection")})
+
+	samples := scrape(t, e)
+	read := `operation="read",disk="vm_disk:\"quoted\"",connection="1"`
+	none := `operation="read",disk="vm_disk:\"quoted\"",connection="none"`
+	for name, want := range map[string]string{
+		"vdisk_client_requests_total{" + read + "}":                                               "4",
+		"vdisk_client_requests_total{" + none + "}":                                               "1",
+		"vdisk_client_request_errors_total{" + none + `,category="Transport",code="Unavailable"}`: "1",
+		"vdisk_client_retries_total{" + read + "}":                                                "4",
+		"vdisk_client_bytes_total{" + read + "}":                                                  "16384",
+		"vdisk_client_bytes_total{" + none + "}":                                                  "0",
+		"vdisk_client_request_duration_seconds_count{" + none + "}":                               "0",
+		`vdisk_client_requests_in_flight{operation="mixed",disk="vm_disk:\"quoted\""}`:            "5",
+		`vdisk_client_connection_streams_total{connection="0"}`:                                   "2",
+		`vdisk_client_connection_streams_total{connection="1"}`:                                   "1",
+	} {
+		if samples[name] != want {
+			t.Errorf("%s = %q, want %s", name, samples[name], want)
+		}
+	}
+
+	// Buckets are cumulative and the +Inf bucket equals the count
+	want := map[string]int64{"0.0001": 1, "0.001": 1, "0.0025": 1, "0.005": 3, "10": 3, "+Inf": 4}
+	var last int64
+	for _, bound := range append(boundLabels(), "+Inf") {
+		name := "vdisk_client_request_duration_seconds_bucket{" + read + `,le="` + bound + `"}`
+		n, err := strconv.ParseInt(samples[name], 10, 64)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n < last {
+			t.Errorf("bucket le=%s holds %d, less than the %d before it", bound, n, last)
+		}
+		if w, ok := want[bound]; ok && n != w {
+			t.Errorf("bucket le=%s holds %d, want %d", bound, n, w)
+		}
+		last = n
+	}
+	if count := samples["vdisk_client_request_duration_seconds_count{"+read+"}"]; count != strconv.FormatInt(last, 10) {
+		t.Errorf("count %s, +Inf bucket %d", count, last)
+	}
+	sum, err := strconv.ParseFloat(samples["vdisk_client_request_duration_seconds_sum{"+read+"}"], 64)
+	if wantSum := 20.00605; err != nil || sum < wantSum-1e-9 || sum > wantSum+1e-9 {
+		t.Errorf("sum %g, %v, want %g", sum, err, wantSum)
+	}
+
+	// A nil exporter ignores results
+	var nilExporter *promExporter
+	nilExporter.track(metrics)
+	nilExporter.observe(metrics, ThroughputResult{Success: true})
+}
+
+// boundLabels returns the le label of every finite latency bucket
+func boundLabels() []string {
+	labels := make([]string, len(latencyBuckets))
+	for i, bound := range latencyBuckets {
+		labels[i] = strconv.FormatFloat(bound, 'g', -1, 64)
+	}
+	return labels
+}
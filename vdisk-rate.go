@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Open-loop load flags
+	targetRPS     = flag.Float64("target_rps", 0, "Open-loop throughput mode: start requests on a fixed schedule at this rate (0 keeps max_concurrent requests in flight)")
+	targetMBps    = flag.Float64("target_mbps", 0, "Open-loop throughput mode: schedule requests to move this many MB/s (converted to a request rate from the request size)")
+	scheduleSlack = flag.Duration("schedule_slack", time.Millisecond, "Dispatch delay after which an open-loop request counts as having missed its schedule")
+)
+
+// openLoopRate returns the request rate for a target of rps requests or
+// mbps MB per second, or 0 for the closed-loop mode. requestBytes is the
+// mean request size used to convert mbps.
+func openLoopRate(rps, mbps, requestBytes float64) (float64, error) {
+	if rps < 0 || mbps < 0 {
+		return 0, fmt.Errorf("target_rps and target_mbps must not be negative")
+	}
+	if rps > 0 && mbps > 0 {
+		return 0, fmt.Errorf("target_rps and target_mbps are mutually exclusive")
+	}
+	if mbps == 0 {
+		return rps, nil
+	}
+	if requestBytes <= 0 {
+		return 0, fmt.Errorf("target_mbps needs a fixed request size (set read_length, write_length or block_sizes)")
+	}
+	return mbps * 1024 * 1024 / requestBytes, nil
+}
+
+// loadSchedule is the fixed timeline of an open-loop test. Requests are due
+// at intervals of 1/rate from start regardless of how long earlier requests
+// take, so a slow server cannot slow the load down. The rate may change
+// linearly over the test to ramp the load.
+type loadSchedule struct {
+	start time.Time
+	// rate returns the rate elapsed into the test and its slope in requests
+	// per second per second
+	rate   func(elapsed time.Duration) (float64, float64)
+	offset float64 // Seconds from start to the intended start of the next request
+}
+
+// newLoadSchedule returns the schedule of plan starting at start. Every
+// phase must have a positive rate, as a rate of zero leaves no next request.
+func newLoadSchedule(start time.Time, plan loadPlan) (*loadSchedule, error) {
+	for _, phase := range plan.phases {
+		if phase.FromRate <= 0 || phase.ToRate <= 0 {
+			return nil, fmt.Errorf("open-loop rate must be positive, got %g->%g req/s", phase.FromRate, phase.ToRate)
+		}
+	}
+	return &loadSchedule{start: start, rate: plan.rateAt}, nil
+}
+
+// next returns the time the next request is due
+func (s *loadSchedule) next() time.Time {
+	return s.start.Add(time.Duration(s.offset * float64(time.Second)))
+}
+
+// step returns the offset of the request after the one due at offset. On
+// a ramp the gap is the time for the rising or falling rate to add up to one
+// request, so a ramp from a low rate does not stall on its first gap.
+func (s *loadSchedule) step(offset float64) float64 {
+	rate, slope := s.rate(time.Duration(offset * float64(time.Second)))
+	if d := rate*rate + 2*slope; slope != 0 && d >= 0 {
+		return offset + 2/(rate+math.Sqrt(d))
+	}
+	return offset + 1/rate
+}
+
+// wait blocks until the next request is due and returns its intended start
+// time. Requests already overdue are returned immediately so the generator
+// catches up. It returns false once ctx is done. The request stays due
+// until advance is called.
+func (s *loadSchedule) wait(ctx context.Context) (time.Time, bool) {
+	due := s.next()
+	if d := time.Until(due); d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Time{}, false
+		case <-timer.C:
+		}
+	} else if ctx.Err() != nil {
+		return time.Time{}, false
+	}
+	return due, true
+}
+
+// advance moves on to the next request once one has been sent
+func (s *loadSchedule) advance() {
+	s.offset = s.step(s.offset)
+}
+
+// pending calls count with the intended start of every request due up to
+// end that has not been sent
+func (s *loadSchedule) pending(end time.Time, count func(time.Time)) {
+	for offset := s.offset; ; offset = s.step(offset) {
+		due := s.start.Add(time.Duration(offset * float64(time.Second)))
+		if due.After(end) {
+			return
+		}
+		count(due)
+	}
+}
+
+// recordDispatch counts a request sent lag after its intended start. A
+// request sent more than -schedule_slack late missed its slot.
+func recordDispatch(metrics *ThroughputMetrics, lag time.Duration) {
+	atomic.AddInt64(&metrics.ScheduledRequests, 1)
+	if lag > *scheduleSlack {
+		atomic.AddInt64(&metrics.MissedSchedule, 1)
+	}
+	for {
+		cur := atomic.LoadInt64(&metrics.MaxScheduleLag)
+		if int64(lag) <= cur || atomic.CompareAndSwapInt64(&metrics.MaxScheduleLag, cur, int64(lag)) {
+			return
+		}
+	}
+}
+
+// printScheduleStats prints how well an open-loop test kept to its schedule
+func printScheduleStats(metrics *ThroughputMetrics) {
+	scheduled := atomic.LoadInt64(&metrics.ScheduledRequests)
+	missed := atomic.LoadInt64(&metrics.MissedSchedule)
+	unsent := atomic.LoadInt64(&metrics.UnsentRequests)
+	var missedPct float64
+	if total := scheduled + unsent; total > 0 {
+		missedPct = float64(missed+unsent) / float64(total) * 100
+	}
+	fmt.Fprintf(logOutput, "\nSchedule (open loop, target %.2f req/s):\n", metrics.TargetRate)
+	fmt.Fprintf(logOutput, "  Sent: %d, Unsent: %d\n", scheduled, unsent)
+	fmt.Fprintf(logOutput, "  Missed Schedule: %d (%.2f%%)\n", missed+unsent, missedPct)
+	fmt.Fprintf(logOutput, "  Max Dispatch Lag: %v\n", time.Duration(atomic.LoadInt64(&metrics.MaxScheduleLag)))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/histogram"
+)
+
+// steadyPlan is an open-loop plan of one phase at rate for d
+func steadyPlan(rate float64, d time.Duration) loadPlan {
+	return loadPlan{phases: []loadPhase{{Duration: d, FromRate: rate, ToRate: rate}}}
+}
+
+// dueTimes returns the offsets from start of the requests plan schedules
+// before end
+func dueTimes(t *testing.T, plan loadPlan, end time.Duration) []time.Duration {
+	t.Helper()
+	start := time.Unix(1000, 0)
+	s, err := newLoadSchedule(start, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var due []time.Duration
+	s.pending(start.Add(end), func(t time.Time) { due = append(due, t.Sub(start)) })
+	return due
+}
+
+func TestOpenLoopRate(t *testing.T) {
+	for _, tc := range []struct {
+		rps, mbps, requestBytes float64
+		want                    float64
+		err                     string
+	}{
+		{want: 0},
+		{rps: 250, requestBytes: 4096, want: 250},
+		{mbps: 4, requestBytes: 4096, want: 1024},
+		{mbps: 1, requestBytes: 1 << 20, want: 1},
+		{rps: 100, mbps: 4, requestBytes: 4096, err: "mutually exclusive"},
+		{rps: -1, err: "must not be negative"},
+		{mbps: -1, requestBytes: 4096, err: "must not be negative"},
+		{mbps: 4, err: "fixed request size"},
+	} {
+		got, err := openLoopRate(tc.rps, tc.mbps, tc.requestBytes)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("openLoopRate(%g, %g, %g): error %v, want %q", tc.rps, tc.mbps, tc.requestBytes, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("openLoopRate(%g, %g, %g) = %g, %v, want %g", tc.rps, tc.mbps, tc.requestBytes, got, err, tc.want)
+		}
+	}
+}
+
+func TestLoadScheduleRejectsRate(t *testing.T) {
+	for _, plan := range []loadPlan{
+		steadyPlan(0, time.Second),
+		steadyPlan(-10, time.Second),
+		{phases: []loadPhase{{Duration: time.Second, FromRate: 100, ToRate: 0}}},
+		{phases: []loadPhase{{Duration: time.Second, FromRate: 100, ToRate: 100}, {Duration: time.Second}}},
+	} {
+		if _, err := newLoadSchedule(time.Now(), plan); err == nil {
+			t.Errorf("schedule accepted phases %+v", plan.phases)
+		}
+	}
+}
+
+func TestLoadScheduleSteady(t *testing.T) {
+	due := dueTimes(t, steadyPlan(100, time.Second), 995*time.Millisecond)
+	if len(due) != 100 {
+		t.Fatalf("%d requests due in 995ms at 100 req/s, want 100", len(due))
+	}
+	for i, d := range due {
+		if want := time.Duration(i) * 10 * time.Millisecond; absDuration(d-want) > time.Microsecond {
+			t.Fatalf("request %d due at %v, want %v", i, d, want)
+		}
+	}
+}
+
+func TestLoadScheduleRamp(t *testing.T) {
+	// From 10 to 30 req/s over a second: 10t+10t² requests are due by t, so
+	// request k is due at (sqrt(100+40k)-10)/20
+	ramp := loadPlan{phases: []loadPhase{{Duration: time.Second, FromRate: 10, ToRate: 30}}}
+	due := dueTimes(t, ramp, 999*time.Millisecond)
+	if len(due) != 20 {
+		t.Fatalf("%d requests due on the ramp, want 20", len(due))
+	}
+	for k, d := range due {
+		want := time.Duration((math.Sqrt(100+40*float64(k)) - 10) / 20 * float64(time.Second))
+		if absDuration(d-want) > time.Microsecond {
+			t.Errorf("request %d due at %v, want %v", k, d, want)
+		}
+	}
+
+	// Starting from 1 req/s the second request is due once the ramp has
+	// added up to one request, not a full second later
+	slow := loadPlan{phases: []loadPhase{{Duration: time.Second, FromRate: 1, ToRate: 101}}}
+	due = dueTimes(t, slow, 999*time.Millisecond)
+	want := time.Duration((math.Sqrt(1+200) - 1) / 100 * float64(time.Second))
+	if len(due) < 2 || absDuration(due[1]-want) > time.Microsecond {
+		t.Errorf("second request on a ramp from 1 req/s due at %v, want %v", due[1:2], want)
+	}
+
+	// The warm-up runs at the starting rate and the ramp follows it
+	warm := loadPlan{warmup: time.Second, phases: ramp.phases}
+	due = dueTimes(t, warm, 1999*time.Millisecond)
+	if len(due) != 30 || absDuration(due[10]-time.Second) > time.Microsecond {
+		t.Errorf("%d requests due over warm-up and ramp, request 10 at %v; want 30, 1s", len(due), due[10:11])
+	}
+}
+
+func TestLoadScheduleCatchesUp(t *testing.T) {
+	// A schedule that started in the past returns each overdue request at
+	// once, with its intended start rather than the time it was sent
+	start := time.Now().Add(-time.Second)
+	s, err := newLoadSchedule(start, steadyPlan(100, 2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 50; i++ {
+		intended, ok := s.wait(ctx)
+		if !ok {
+			t.Fatalf("request %d not returned", i)
+		}
+		if want := start.Add(time.Duration(i) * 10 * time.Millisecond); absDuration(intended.Sub(want)) > time.Microsecond {
+			t.Fatalf("request %d intended at %v, want %v", i, intended.Sub(start), want.Sub(start))
+		}
+		s.advance()
+	}
+
+	// The other 51 requests due in the first second were never sent
+	var unsent int
+	s.pending(start.Add(time.Second), func(time.Time) { unsent++ })
+	if unsent != 51 {
+		t.Errorf("%d unsent requests, want 51", unsent)
+	}
+
+	// Waiting for a request due after ctx ends gives up
+	s, _ = newLoadSchedule(time.Now().Add(time.Hour), steadyPlan(100, time.Second))
+	if _, ok := s.wait(ctx); ok {
+		t.Error("wait returned a request due after ctx was done")
+	}
+}
+
+func TestRecordDispatch(t *testing.T) {
+	setFlags(t, map[string]string{"schedule_slack": "1ms"})
+	metrics := &ThroughputMetrics{
+		TargetRate:     100,
+		Latency:        histogram.NewLatency(),
+		ServiceLatency: histogram.NewLatency(),
+	}
+
+	// Requests are due every 10ms. The generator stalls from 100ms to 150ms
+	// and then sends the requests it owes at once; every request takes 2ms.
+	const service = 2 * time.Millisecond
+	var maxLag time.Duration
+	for i := 0; i < 30; i++ {
+		intended := time.Duration(i) * 10 * time.Millisecond
+		sent := intended + 500*time.Microsecond
+		if intended >= 100*time.Millisecond && intended < 150*time.Millisecond {
+			sent = 150 * time.Millisecond
+		}
+		lag := sent - intended
+		maxLag = max(maxLag, lag)
+		recordThroughputResult(metrics, ThroughputResult{Success: true, Duration: service, ScheduleLag: lag})
+	}
+
+	// The five stalled requests were over -schedule_slack late
+	if metrics.ScheduledRequests != 30 || metrics.MissedSchedule != 5 {
+		t.Errorf("%d sent, %d missed, want 30 and 5", metrics.ScheduledRequests, metrics.MissedSchedule)
+	}
+	if time.Duration(metrics.MaxScheduleLag) != maxLag {
+		t.Errorf("max lag %v, want %v", time.Duration(metrics.MaxScheduleLag), maxLag)
+	}
+
+	// Latency counts from the intended start, service time from the send
+	if got, want := time.Duration(metrics.Latency.Max()), service+50*time.Millisecond; absDuration(got-want) > want/100 {
+		t.Errorf("max latency %v, want %v", got, want)
+	}
+	if got, want := time.Duration(metrics.Latency.Min()), service+500*time.Microsecond; absDuration(got-want) > want/100 {
+		t.Errorf("min latency %v, want %v", got, want)
+	}
+	if got := time.Duration(metrics.ServiceLatency.Max()); absDuration(got-service) > service/100 {
+		t.Errorf("max service time %v, want %v", got, service)
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
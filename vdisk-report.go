@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/histogram"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
+)
+
+var reportJSON = flag.String("report_json", "", "Write a machine-readable JSON report of batch, throughput, job file and scale test runs to this file")
+
+// reportVersion is bumped when fields of the JSON report change meaning or
+// are removed
+const reportVersion = 2
+
+// secretFlags hold credentials and are redacted from reports
+var secretFlags = map[string]bool{
+	"vdisk_auth_token": true,
+	"cookie_value":     true,
+	"basic_auth_value": true,
+}
+
+// runReport is the -report_json document. Durations and latencies are in
+// nanoseconds, rates per second and sizes in bytes.
+type runReport struct {
+	Version     int               `json:"version"`
+	Mode        string            `json:"mode"` // batch, throughput, job_file or scale_test
+	StartTime   time.Time         `json:"start_time"`
+	EndTime     time.Time         `json:"end_time"`
+	Error       string            `json:"error,omitempty"`
+	Environment reportEnvironment `json:"environment"`
+	// Config holds the value of every flag and SetFlags the ones given on
+	// the command line
+	Config   map[string]string `json:"config"`
+	SetFlags []string          `json:"set_flags"`
+
+	Batch *batchReport `json:"batch,omitempty"`
+	// Jobs holds one entry for a throughput test and one per job or disk
+	// for job files and scale tests, which also report their Aggregate
+	Jobs        []*throughputReport `json:"jobs,omitempty"`
+	Aggregate   *throughputReport   `json:"aggregate,omitempty"`
+	Connections *connectionReport   `json:"connections,omitempty"`
+}
+
+// reportEnvironment describes where a run happened
+type reportEnvironment struct {
+	Host          string `json:"host"`
+	OS            string `json:"os"`
+	Arch          string `json:"arch"`
+	GoVersion     string `json:"go_version"`
+	CPUs          int    `json:"cpus"`
+	Server        string `json:"server"`
+	FakeServer    bool   `json:"fake_server,omitempty"`
+	TLS           bool   `json:"tls"`
+	SkipTLSVerify bool   `json:"skip_tls_verify"`
+	AuthType      string `json:"auth_type"`
+	PoolSize      int    `json:"pool_size"`
+}
+
+// throughputReport holds the results of a throughput test, job or phase
+type throughputReport struct {
+	Job            string    `json:"job,omitempty"`
+	Disk           string    `json:"disk,omitempty"`
+	Phase          string    `json:"phase,omitempty"`
+	Load           string    `json:"load,omitempty"`
+	Operation      string    `json:"operation"`
+	Workload       string    `json:"workload"`
+	MaxConcurrent  int       `json:"max_concurrent"`
+	PipelineWindow int       `json:"pipeline_window,omitempty"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+	Duration       int64     `json:"duration_ns"`
+
+	Requests           int64 `json:"requests"`
+	SuccessfulRequests int64 `json:"successful_requests"`
+	FailedRequests     int64 `json:"failed_requests"`
+	WarmupRequests     int64 `json:"warmup_requests,omitempty"`
+	Retries            int64 `json:"retries,omitempty"`
+	Bytes              int64 `json:"bytes"`
+	PayloadBytes       int64 `json:"payload_bytes,omitempty"`
+	WireBytes          int64 `json:"wire_bytes,omitempty"`
+
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	BytesPerSecond    float64 `json:"bytes_per_second"`
+
+	Latency        *latencyReport  `json:"latency,omitempty"`
+	ServiceLatency *latencyReport  `json:"service_latency,omitempty"`
+	Schedule       *scheduleReport `json:"schedule,omitempty"`
+
+	// Errors counts failed requests by category and ErrorCodes by
+	// "Category/Code"
+	Errors     map[string]int64            `json:"errors,omitempty"`
+	ErrorCodes map[string]int64            `json:"error_codes,omitempty"`
+	Operations map[string]*operationReport `json:"operations,omitempty"`
+	Phases     []*throughputReport         `json:"phases,omitempty"`
+	TimeSeries []seriesSample              `json:"time_series,omitempty"`
+}
+
+// latencyReport summarizes a latency histogram
+type latencyReport struct {
+	Count       int64                  `json:"count"`
+	Mean        int64                  `json:"mean_ns"`
+	Min         int64                  `json:"min_ns"`
+	Max         int64                  `json:"max_ns"`
+	Percentiles []histogram.Percentile `json:"percentiles"`
+}
+
+// scheduleReport describes how well an open-loop test kept to its schedule
+type scheduleReport struct {
+	TargetRate     float64 `json:"target_rate"`
+	Sent           int64   `json:"sent"`
+	Missed         int64   `json:"missed"`
+	Unsent         int64   `json:"unsent"`
+	MaxDispatchLag int64   `json:"max_dispatch_lag_ns"`
+}
+
+// operationReport holds the results of one operation of a mixed workload
+type operationReport struct {
+	Requests int64          `json:"requests"`
+	Failed   int64          `json:"failed"`
+	Bytes    int64          `json:"bytes"`
+	Latency  *latencyReport `json:"latency,omitempty"`
+}
+
+// batchReport holds the results of batch mode
+type batchReport struct {
+	Operation  string                 `json:"operation"`
+	Operations int                    `json:"operations"`
+	Successful int                    `json:"successful"`
+	Failed     int                    `json:"failed"`
+	Bytes      int64                  `json:"bytes"`
+	Responses  int                    `json:"responses"`
+	Retries    int                    `json:"retries,omitempty"`
+	Duration   int64                  `json:"duration_ns"`
+	Latency    *latencyReport         `json:"latency,omitempty"`
+	Errors     map[string]int64       `json:"errors,omitempty"`
+	ErrorCodes map[string]int64       `json:"error_codes,omitempty"`
+	Results    []batchOperationReport `json:"results"`
+}
+
+// batchOperationReport is the outcome of one batch operation
+type batchOperationReport struct {
+	ID            int    `json:"id"`
+	Success       bool   `json:"success"`
+	Duration      int64  `json:"duration_ns"`
+	Bytes         int64  `json:"bytes"`
+	Responses     int    `json:"responses"`
+	Retries       int    `json:"retries,omitempty"`
+	ErrorCategory string `json:"error_category,omitempty"`
+	ErrorCode     string `json:"error_code,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// connectionReport is the per-connection stream distribution printed by
+// printConnectionDistribution
+type connectionReport struct {
+	PoolSize     int     `json:"pool_size"`
+	Streams      []int64 `json:"streams"`
+	TotalStreams int64   `json:"total_streams"`
+	Mean         float64 `json:"mean"`
+	StdDev       float64 `json:"std_dev"`
+}
+
+// seriesSample is one -metrics_interval_sec sample of a running test, with
+// counts covering the interval that ended at Time
+type seriesSample struct {
+	Time              time.Time `json:"time"`
+	Requests          int64     `json:"requests"`
+	Failed            int64     `json:"failed"`
+	Bytes             int64     `json:"bytes"`
+	RequestsPerSecond float64   `json:"requests_per_second"`
+	BytesPerSecond    float64   `json:"bytes_per_second"`
+	InFlight          int64     `json:"in_flight"`
+}
+
+// errorCounts counts failed requests by errorType
+type errorCounts struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (c *errorCounts) add(kind string, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = map[string]int64{}
+	}
+	c.counts[kind] += n
+}
+
+// count returns the failures counted for kind
+func (c *errorCounts) count(kind string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[kind]
+}
+
+// snapshot returns a copy of the counts, or nil when there are none
+func (c *errorCounts) snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.counts) == 0 {
+		return nil
+	}
+	counts := make(map[string]int64, len(c.counts))
+	for kind, n := range c.counts {
+		counts[kind] = n
+	}
+	return counts
+}
+
+// errorType names the kind of error a request failed with as
+// "Category/Code", using the category and code of vdisk.Classify
+func errorType(err error) string {
+	category, code := vdisk.Classify(err)
+	return string(category) + "/" + code
+}
+
+// splitErrorType returns the category and code of an errorType
+func splitErrorType(kind string) (string, string) {
+	category, code, _ := strings.Cut(kind, "/")
+	return category, code
+}
+
+// categoryCounts sums counts by errorType into counts by category, or
+// returns nil when there are none
+func categoryCounts(counts map[string]int64) map[string]int64 {
+	if len(counts) == 0 {
+		return nil
+	}
+	categories := map[string]int64{}
+	for kind, n := range counts {
+		category, _ := splitErrorType(kind)
+		categories[category] += n
+	}
+	return categories
+}
+
+// newRunReport starts the report of a run in mode
+func newRunReport(mode string) *runReport {
+	r := &runReport{
+		Version:   reportVersion,
+		Mode:      mode,
+		StartTime: time.Now(),
+		Config:    map[string]string{},
+		SetFlags:  []string{},
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "<redacted>"
+		}
+		r.Config[f.Name] = value
+	})
+	flag.Visit(func(f *flag.Flag) {
+		r.SetFlags = append(r.SetFlags, f.Name)
+	})
+	return r
+}
+
+// finish completes the report with the environment and connection usage of
+// client and the outcome err of the run, and writes it to -report_json. It
+// returns err, or the error writing the report when the run succeeded.
+func (r *runReport) finish(client *vdisk.Client, err error) error {
+	if *reportJSON == "" {
+		return err
+	}
+	r.EndTime = time.Now()
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	opts := client.Options()
+	host, _ := os.Hostname()
+	r.Environment = reportEnvironment{
+		Host:          host,
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		GoVersion:     runtime.Version(),
+		CPUs:          runtime.NumCPU(),
+		Server:        opts.Address,
+		FakeServer:    *fakeServer,
+		TLS:           opts.UseTLS,
+		SkipTLSVerify: opts.SkipTLSVerify,
+		AuthType:      opts.AuthType,
+		PoolSize:      client.PoolSize(),
+	}
+	r.Connections = newConnectionReport(client)
+
+	data, jsonErr := json.MarshalIndent(r, "", "  ")
+	if jsonErr == nil {
+		jsonErr = os.WriteFile(*reportJSON, append(data, '\n'), 0o644)
+	}
+	if jsonErr != nil {
+		fmt.Fprintf(logOutput, "Warning: could not write JSON report '%s': %v\n", *reportJSON, jsonErr)
+		if err == nil {
+			err = fmt.Errorf("failed to write JSON report: %v", jsonErr)
+		}
+		return err
+	}
+	fmt.Fprintf(logOutput, "JSON report written to %s\n", *reportJSON)
+	return err
+}
+
+// newLatencyReport summarizes h, returning nil when it is empty
+func newLatencyReport(h *histogram.Histogram) *latencyReport {
+	if h == nil || h.Count() == 0 {
+		return nil
+	}
+	return &latencyReport{
+		Count:       h.Count(),
+		Mean:        int64(h.Mean()),
+		Min:         h.Min(),
+		Max:         h.Max(),
+		Percentiles: h.Percentiles(histogram.StandardPercentiles),
+	}
+}
+
+// newThroughputReport converts the final metrics of a test, job or phase
+func newThroughputReport(m *ThroughputMetrics) *throughputReport {
+	r := &throughputReport{
+		Job:                m.Job,
+		Disk:               m.Disk,
+		Phase:              m.Phase,
+		Load:               m.Load,
+		Operation:          m.Operation,
+		Workload:           m.Workload,
+		MaxConcurrent:      m.MaxConcurrent,
+		PipelineWindow:     m.PipelineWindow,
+		StartTime:          m.StartTime,
+		EndTime:            m.EndTime,
+		Duration:           int64(m.TotalDuration),
+		Requests:           m.TotalRequests,
+		SuccessfulRequests: m.SuccessfulRequests,
+		FailedRequests:     m.FailedRequests,
+		WarmupRequests:     m.WarmupRequests,
+		Retries:            m.Retries,
+		Bytes:              m.TotalBytes,
+		PayloadBytes:       m.PayloadBytes,
+		WireBytes:          m.WireBytes,
+		RequestsPerSecond:  m.RequestsPerSecond,
+		BytesPerSecond:     m.BytesPerSecond,
+		Latency:            newLatencyReport(m.Latency),
+		ServiceLatency:     newLatencyReport(m.ServiceLatency),
+		ErrorCodes:         m.Errors.snapshot(),
+		TimeSeries:         m.TimeSeries,
+	}
+	r.Errors = categoryCounts(r.ErrorCodes)
+	if m.TargetRate > 0 {
+		r.Schedule = &scheduleReport{
+			TargetRate:     m.TargetRate,
+			Sent:           m.ScheduledRequests,
+			Missed:         m.MissedSchedule + m.UnsentRequests,
+			Unsent:         m.UnsentRequests,
+			MaxDispatchLag: m.MaxScheduleLag,
+		}
+	}
+	if m.Operations != nil {
+		r.Operations = map[string]*operationReport{}
+		for _, name := range []string{workload.OpRead, workload.OpWrite} {
+			op := m.Operations[name]
+			r.Operations[name] = &operationReport{
+				Requests: op.Requests,
+				Failed:   op.Failed,
+				Bytes:    op.Bytes,
+				Latency:  newLatencyReport(op.Latency),
+			}
+		}
+	}
+	for _, phase := range m.Phases {
+		r.Phases = append(r.Phases, newThroughputReport(phase))
+	}
+	return r
+}
+
+// newBatchReport converts the results of batch mode
+func newBatchReport(results []BatchOperationResult, elapsed time.Duration) *batchReport {
+	r := &batchReport{
+		Operation:  *vdiskOperation,
+		Operations: len(results),
+		Duration:   int64(elapsed),
+	}
+	latency := histogram.NewLatency()
+	var errs errorCounts
+	for _, result := range results {
+		op := batchOperationReport{
+			ID:        result.OperationID,
+			Success:   result.Success,
+			Duration:  int64(result.Duration),
+			Bytes:     int64(result.BytesRead) + result.BytesWritten,
+			Responses: result.ResponseCount,
+			Retries:   result.Retries,
+		}
+		r.Retries += result.Retries
+		if result.Success {
+			r.Successful++
+			r.Bytes += op.Bytes
+			r.Responses += result.ResponseCount
+			latency.RecordDuration(result.Duration)
+		} else {
+			r.Failed++
+			kind := errorType(result.Error)
+			op.ErrorCategory, op.ErrorCode = splitErrorType(kind)
+			op.Error = fmt.Sprint(result.Error)
+			errs.add(kind, 1)
+		}
+		r.Results = append(r.Results, op)
+	}
+	r.Latency = newLatencyReport(latency)
+	r.ErrorCodes = errs.snapshot()
+	r.Errors = categoryCounts(r.ErrorCodes)
+	return r
+}
+
+// newConnectionReport returns the stream distribution over the connections
+// of client, or nil before the pool is in use
+func newConnectionReport(client *vdisk.Client) *connectionReport {
+	usages := client.ConnectionUsage()
+	if len(usages) == 0 {
+		return nil
+	}
+	r := &connectionReport{PoolSize: len(usages), Streams: usages}
+	for _, usage := range usages {
+		r.TotalStreams += usage
+	}
+	r.Mean = float64(r.TotalStreams) / float64(len(usages))
+	var variance float64
+	for _, usage := range usages {
+		variance += (float64(usage) - r.Mean) * (float64(usage) - r.Mean)
+	}
+	r.StdDev = math.Sqrt(variance / float64(len(usages)))
+	return r
+}
+
+// recordTimeSeries appends a sample of metrics to metrics.TimeSeries every
+// interval, and a last one for the partial interval when ctx is done
+func recordTimeSeries(ctx context.Context, metrics *ThroughputMetrics, interval time.Duration, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	prevTime := time.Now()
+	prevReqs := atomic.LoadInt64(&metrics.TotalRequests)
+	prevFailed := atomic.LoadInt64(&metrics.FailedRequests)
+	prevBytes := atomic.LoadInt64(&metrics.TotalBytes)
+	sample := func(now time.Time) {
+		reqs := atomic.LoadInt64(&metrics.TotalRequests)
+		failed := atomic.LoadInt64(&metrics.FailedRequests)
+		bytes := atomic.LoadInt64(&metrics.TotalBytes)
+		secs := now.Sub(prevTime).Seconds()
+		metrics.TimeSeries = append(metrics.TimeSeries, seriesSample{
+			Time:              now,
+			Requests:          reqs - prevReqs,
+			Failed:            failed - prevFailed,
+			Bytes:             bytes - prevBytes,
+			RequestsPerSecond: float64(reqs-prevReqs) / secs,
+			BytesPerSecond:    float64(bytes-prevBytes) / secs,
+			InFlight:          atomic.LoadInt64(&metrics.InFlight),
+		})
+		prevTime, prevReqs, prevFailed, prevBytes = now, reqs, failed, bytes
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if now := time.Now(); now.Sub(prevTime) >= time.Millisecond {
+				sample(now)
+			}
+			return
+		case now := <-ticker.C:
+			sample(now)
+		}
+	}
+}
+
+// mergeTimeSeries sums the samples of parts taken in the same interval
+// since start, so the series of tests that ran side by side add up
+func mergeTimeSeries(start time.Time, parts []*ThroughputMetrics) []seriesSample {
+	interval := float64(time.Duration(max(*metricsIntervalSec, 1)) * time.Second)
+	var merged []seriesSample
+	slots := map[int64]int{}
+	for _, m := range parts {
+		for _, sample := range m.TimeSeries {
+			slot := int64(math.Round(float64(sample.Time.Sub(start)) / interval))
+			i, ok := slots[slot]
+			if !ok {
+				i = len(merged)
+				slots[slot] = i
+				merged = append(merged, seriesSample{Time: sample.Time})
+			}
+			s := &merged[i]
+			if sample.Time.After(s.Time) {
+				s.Time = sample.Time
+			}
+			s.Requests += sample.Requests
+			s.Failed += sample.Failed
+			s.Bytes += sample.Bytes
+			s.RequestsPerSecond += sample.RequestsPerSecond
+			s.BytesPerSecond += sample.BytesPerSecond
+			s.InFlight += sample.InFlight
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+	return merged
+}
+
+// sortedErrorTypes returns the error types of counts, most frequent first
+func sortedErrorTypes(counts map[string]int64) []string {
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if counts[kinds[i]] != counts[kinds[j]] {
+			return counts[kinds[i]] > counts[kinds[j]]
+		}
+		return kinds[i] < kinds[j]
+	})
+	return kinds
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/histogram"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
+)
+
+func TestRunReportRedaction(t *testing.T) {
+	setFlags(t, map[string]string{
+		"vdisk_auth_token": "token-secret",
+		"cookie_value":     "cookie-secret",
+		"basic_auth_value": "basic-secret",
+		"vdisk_server":     "server:9440",
+	})
+	r := newRunReport("batch")
+	for _, name := range []string{"vdisk_auth_token", "cookie_value", "basic_auth_value"} {
+		if r.Config[name] != "<redacted>" {
+			t.Errorf("%s reported as %q", name, r.Config[name])
+		}
+	}
+	if r.Config["vdisk_server"] != "server:9440" {
+		t.Errorf("vdisk_server reported as %q", r.Config["vdisk_server"])
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("report contains a credential: %s", data)
+	}
+
+	// Unset credentials are reported as empty rather than redacted
+	setFlags(t, map[string]string{"cookie_value": ""})
+	if r := newRunReport("batch"); r.Config["cookie_value"] != "" {
+		t.Errorf("empty cookie_value reported as %q", r.Config["cookie_value"])
+	}
+}
+
+// testMetrics returns throughput metrics with every report section filled
+func testMetrics(start time.Time) *ThroughputMetrics {
+	latency := func(values ...time.Duration) *histogram.Histogram {
+		h := histogram.NewLatency()
+		for _, v := range values {
+			h.RecordDuration(v)
+		}
+		return h
+	}
+	m := &ThroughputMetrics{
+		Job:                "job1",
+		Disk:               "vm_disk:uuid1",
+		Operation:          "mixed",
+		Workload:           "uniform 4K",
+		MaxConcurrent:      8,
+		PipelineWindow:     4,
+		StartTime:          start,
+		EndTime:            start.Add(10 * time.Second),
+		TotalDuration:      10 * time.Second,
+		TotalRequests:      100,
+		SuccessfulRequests: 95,
+		FailedRequests:     5,
+		WarmupRequests:     7,
+		Retries:            3,
+		TotalBytes:         95 * 4096,
+		PayloadBytes:       40 * 4096,
+		WireBytes:          20 * 4096,
+		RequestsPerSecond:  10,
+		BytesPerSecond:     95 * 4096 / 10,
+		Latency:            latency(time.Millisecond, 3*time.Millisecond),
+		ServiceLatency:     latency(time.Millisecond),
+		TargetRate:         10,
+		ScheduledRequests:  100,
+		MissedSchedule:     4,
+		UnsentRequests:     2,
+		MaxScheduleLag:     int64(5 * time.Millisecond),
+		Operations: map[string]*OperationMetrics{
+			workload.OpRead:  {Requests: 60, Failed: 5, Bytes: 55 * 4096, Latency: latency(time.Millisecond)},
+			workload.OpWrite: {Requests: 40, Bytes: 40 * 4096, Latency: histogram.NewLatency()},
+		},
+		TimeSeries: []seriesSample{{Time: start.Add(time.Second), Requests: 10, Bytes: 40960, RequestsPerSecond: 10, BytesPerSecond: 40960, InFlight: 2}},
+	}
+	m.Errors.add(errorType(&vdisk.ServerError{Kind: vdisk.ServerNotFound}), 2)
+	m.Errors.add(errorType(status.Error(codes.Unavailable, "reset")), 3)
+	m.Phases = []*ThroughputMetrics{{
+		Phase:     "step 1",
+		Load:      "10 concurrent",
+		Operation: "mixed",
+		StartTime: start,
+		EndTime:   start.Add(10 * time.Second),
+		Latency:   histogram.NewLatency(),
+	}}
+	return m
+}
+
+func TestThroughputReport(t *testing.T) {
+	start := time.Unix(1700000000, 0).UTC()
+	r := newThroughputReport(testMetrics(start))
+
+	if r.Job != "job1" || r.Requests != 100 || r.FailedRequests != 5 || r.Duration != int64(10*time.Second) || r.WireBytes != 20*4096 {
+		t.Errorf("report %+v", r)
+	}
+	wantCodes := map[string]int64{"InvalidRequest/NotFound": 2, "Transport/Unavailable": 3}
+	wantCategories := map[string]int64{"InvalidRequest": 2, "Transport": 3}
+	if !reflect.DeepEqual(r.ErrorCodes, wantCodes) || !reflect.DeepEqual(r.Errors, wantCategories) {
+		t.Errorf("errors %v by code %v, want %v by code %v", r.Errors, r.ErrorCodes, wantCategories, wantCodes)
+	}
+	// Missed counts the requests never sent as well as the late ones
+	if want := (scheduleReport{TargetRate: 10, Sent: 100, Missed: 6, Unsent: 2, MaxDispatchLag: int64(5 * time.Millisecond)}); r.Schedule == nil || *r.Schedule != want {
+		t.Errorf("schedule %+v, want %+v", r.Schedule, want)
+	}
+	if r.Latency == nil || r.Latency.Count != 2 || r.ServiceLatency == nil || r.ServiceLatency.Count != 1 {
+		t.Errorf("latency %+v, service latency %+v", r.Latency, r.ServiceLatency)
+	}
+	if len(r.Latency.Percentiles) != len(histogram.StandardPercentiles) {
+		t.Errorf("%d percentiles, want %d", len(r.Latency.Percentiles), len(histogram.StandardPercentiles))
+	}
+	read, write := r.Operations[workload.OpRead], r.Operations[workload.OpWrite]
+	if read == nil || read.Requests != 60 || read.Latency == nil || write == nil || write.Latency != nil {
+		t.Errorf("operations read %+v, write %+v", read, write)
+	}
+	if len(r.Phases) != 1 || r.Phases[0].Phase != "step 1" || r.Phases[0].Latency != nil || r.Phases[0].Schedule != nil {
+		t.Errorf("phases %+v", r.Phases)
+	}
+
+	// A closed-loop test without failures has no schedule or error sections
+	closed := newThroughputReport(&ThroughputMetrics{Operation: "read", Latency: histogram.NewLatency()})
+	if closed.Schedule != nil || closed.Errors != nil || closed.ErrorCodes != nil || closed.Operations != nil || closed.Latency != nil {
+		t.Errorf("closed-loop report %+v", closed)
+	}
+}
+
+func TestMergeTimeSeries(t *testing.T) {
+	setFlags(t, map[string]string{"metrics_interval_sec": "2"})
+	start := time.Unix(1700000000, 0)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	parts := []*ThroughputMetrics{
+		{TimeSeries: []seriesSample{
+			{Time: at(2 * time.Second), Requests: 10, Failed: 1, Bytes: 100, RequestsPerSecond: 5, BytesPerSecond: 50, InFlight: 1},
+			{Time: at(4 * time.Second), Requests: 20, Bytes: 200, RequestsPerSecond: 10, BytesPerSecond: 100, InFlight: 2},
+		}},
+		{TimeSeries: []seriesSample{
+			// Taken slightly later in the same intervals
+			{Time: at(2100 * time.Millisecond), Requests: 4, Bytes: 40, RequestsPerSecond: 2, BytesPerSecond: 20, InFlight: 3},
+			{Time: at(3900 * time.Millisecond), Requests: 6, Failed: 2, Bytes: 60, RequestsPerSecond: 3, BytesPerSecond: 30, InFlight: 4},
+			// The partial interval at the end of a longer test
+			{Time: at(5 * time.Second), Requests: 1, Bytes: 10, RequestsPerSecond: 1, BytesPerSecond: 10},
+		}},
+	}
+	want := []seriesSample{
+		{Time: at(2100 * time.Millisecond), Requests: 14, Failed: 1, Bytes: 140, RequestsPerSecond: 7, BytesPerSecond: 70, InFlight: 4},
+		{Time: at(4 * time.Second), Requests: 26, Failed: 2, Bytes: 260, RequestsPerSecond: 13, BytesPerSecond: 130, InFlight: 6},
+		{Time: at(5 * time.Second), Requests: 1, Bytes: 10, RequestsPerSecond: 1, BytesPerSecond: 10},
+	}
+	if got := mergeTimeSeries(start, parts); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged\n%+v\nwant\n%+v", got, want)
+	}
+	if got := mergeTimeSeries(start, nil); len(got) != 0 {
+		t.Errorf("merging nothing gave %+v", got)
+	}
+}
+
+func TestBatchReport(t *testing.T) {
+	setFlags(t, map[string]string{"vdisk_operation": "write"})
+	results := []BatchOperationResult{
+		{OperationID: 1, Success: true, Duration: time.Millisecond, BytesWritten: 4096, ResponseCount: 1},
+		{OperationID: 2, Success: true, Duration: 3 * time.Millisecond, BytesWritten: 4096, ResponseCount: 1, Retries: 2},
+		{OperationID: 3, Duration: 5 * time.Millisecond, Error: status.Error(codes.Unavailable, "reset"), Retries: 1},
+		{OperationID: 4, Duration: time.Millisecond, Error: &vdisk.ServerError{Kind: vdisk.ServerChecksumMismatch, Message: "checksum mismatch"}},
+	}
+	r := newBatchReport(results, 10*time.Millisecond)
+	if r.Operation != "write" || r.Operations != 4 || r.Successful != 2 || r.Failed != 2 || r.Retries != 3 {
+		t.Errorf("report %+v", r)
+	}
+	// Bytes, responses and latency cover successful operations only
+	if r.Bytes != 8192 || r.Responses != 2 || r.Duration != int64(10*time.Millisecond) || r.Latency == nil || r.Latency.Count != 2 {
+		t.Errorf("%d bytes, %d responses, duration %d, latency %+v", r.Bytes, r.Responses, r.Duration, r.Latency)
+	}
+	if want := map[string]int64{"Transport": 1, "Integrity": 1}; !reflect.DeepEqual(r.Errors, want) {
+		t.Errorf("errors %v, want %v", r.Errors, want)
+	}
+	if len(r.Results) != 4 {
+		t.Fatalf("%d results, want 4", len(r.Results))
+	}
+	if got := r.Results[3]; got.ErrorCategory != "Integrity" || got.ErrorCode != "ChecksumMismatch" || got.Error == "" || got.Success {
+		t.Errorf("failed result %+v", got)
+	}
+	if got := r.Results[1]; !got.Success || got.Bytes != 4096 || got.Retries != 2 || got.ErrorCode != "" {
+		t.Errorf("successful result %+v", got)
+	}
+}
+
+// reportShape is the layout of the JSON report with every section present:
+// each field's path and JSON type. It is the contract that consumers of
+// -report_json rely on. A change here that alters the meaning of a field or
+// removes one must come with a new reportVersion.
+const reportShape = `
+aggregate.bytes number
+aggregate.bytes_per_second number
+aggregate.duration_ns number
+aggregate.end_time string
+aggregate.failed_requests number
+aggregate.max_concurrent number
+aggregate.operation string
+aggregate.requests number
+aggregate.requests_per_second number
+aggregate.start_time string
+aggregate.successful_requests number
+aggregate.workload string
+batch.bytes number
+batch.duration_ns number
+batch.error_codes.* number
+batch.errors.* number
+batch.failed number
+batch.latency.count number
+batch.latency.max_ns number
+batch.latency.mean_ns number
+batch.latency.min_ns number
+batch.latency.percentiles[].percentile number
+batch.latency.percentiles[].value number
+batch.operation string
+batch.operations number
+batch.responses number
+batch.results[].bytes number
+batch.results[].duration_ns number
+batch.results[].error string
+batch.results[].error_category string
+batch.results[].error_code string
+batch.results[].id number
+batch.results[].responses number
+batch.results[].retries number
+batch.results[].success bool
+batch.retries number
+batch.successful number
+config.* string
+connections.mean number
+connections.pool_size number
+connections.std_dev number
+connections.streams[] number
+connections.total_streams number
+end_time string
+environment.arch string
+environment.auth_type string
+environment.cpus number
+environment.fake_server bool
+environment.go_version string
+environment.host string
+environment.os string
+environment.pool_size number
+environment.server string
+environment.skip_tls_verify bool
+environment.tls bool
+error string
+jobs[].bytes number
+jobs[].bytes_per_second number
+jobs[].disk string
+jobs[].duration_ns number
+jobs[].end_time string
+jobs[].error_codes.* number
+jobs[].errors.* number
+jobs[].failed_requests number
+jobs[].job string
+jobs[].latency.count number
+jobs[].latency.max_ns number
+jobs[].latency.mean_ns number
+jobs[].latency.min_ns number
+jobs[].latency.percentiles[].percentile number
+jobs[].latency.percentiles[].value number
+jobs[].max_concurrent number
+jobs[].operation string
+jobs[].operations.read.bytes number
+jobs[].operations.read.failed number
+jobs[].operations.read.latency.count number
+jobs[].operations.read.latency.max_ns number
+jobs[].operations.read.latency.mean_ns number
+jobs[].operations.read.latency.min_ns number
+jobs[].operations.read.latency.percentiles[].percentile number
+jobs[].operations.read.latency.percentiles[].value number
+jobs[].operations.read.requests number
+jobs[].operations.write.bytes number
+jobs[].operations.write.failed number
+jobs[].operations.write.requests number
+jobs[].payload_bytes number
+jobs[].phases[].bytes number
+jobs[].phases[].bytes_per_second number
+jobs[].phases[].duration_ns number
+jobs[].phases[].end_time string
+jobs[].phases[].failed_requests number
+jobs[].phases[].load string
+jobs[].phases[].max_concurrent number
+jobs[].phases[].operation string
+jobs[].phases[].phase string
+jobs[].phases[].requests number
+jobs[].phases[].requests_per_second number
+jobs[].phases[].start_time string
+jobs[].phases[].successful_requests number
+jobs[].phases[].workload string
+jobs[].pipeline_window number
+jobs[].requests number
+jobs[].requests_per_second number
+jobs[].retries number
+jobs[].schedule.max_dispatch_lag_ns number
+jobs[].schedule.missed number
+jobs[].schedule.sent number
+jobs[].schedule.target_rate number
+jobs[].schedule.unsent number
+jobs[].service_latency.count number
+jobs[].service_latency.max_ns number
+jobs[].service_latency.mean_ns number
+jobs[].service_latency.min_ns number
+jobs[].service_latency.percentiles[].percentile number
+jobs[].service_latency.percentiles[].value number
+jobs[].start_time string
+jobs[].successful_requests number
+jobs[].time_series[].bytes number
+jobs[].time_series[].bytes_per_second number
+jobs[].time_series[].failed number
+jobs[].time_series[].in_flight number
+jobs[].time_series[].requests number
+jobs[].time_series[].requests_per_second number
+jobs[].time_series[].time string
+jobs[].warmup_requests number
+jobs[].wire_bytes number
+jobs[].workload string
+mode string
+set_flags[] string
+start_time string
+version number
+`
+
+// jsonShape lists the path and type of every value in v. The keys of maps
+// named in dynamic are replaced by *.
+func jsonShape(v any, path string, dynamic map[string]bool, shape map[string]bool) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		name := path[strings.LastIndex(path, ".")+1:]
+		for key, value := range v {
+			if dynamic[name] {
+				key = "*"
+			}
+			jsonShape(value, join(key), dynamic, shape)
+		}
+	case []any:
+		for _, value := range v {
+			jsonShape(value, path+"[]", dynamic, shape)
+		}
+	case string:
+		shape[path+" string"] = true
+	case float64:
+		shape[path+" number"] = true
+	case bool:
+		shape[path+" bool"] = true
+	default:
+		shape[fmt.Sprintf("%s %T", path, v)] = true
+	}
+}
+
+func TestReportShape(t *testing.T) {
+	start := time.Unix(1700000000, 0).UTC()
+	r := newRunReport("job_file")
+	r.EndTime = start.Add(time.Minute)
+	r.Error = "job job1 failed"
+	r.Environment = reportEnvironment{
+		Host: "host", OS: "linux", Arch: "amd64", GoVersion: "go1", CPUs: 4, Server: "server:9440",
+		FakeServer: true, TLS: true, SkipTLSVerify: true, AuthType: "cookie", PoolSize: 2,
+	}
+	r.Connections = &connectionReport{PoolSize: 2, Streams: []int64{3, 5}, TotalStreams: 8, Mean: 4, StdDev: 1}
+	r.Batch = newBatchReport([]BatchOperationResult{
+		{OperationID: 1, Success: true, Duration: time.Millisecond, BytesRead: 4096, ResponseCount: 1, Retries: 1},
+		{OperationID: 2, Duration: time.Millisecond, Error: status.Error(codes.Unavailable, "reset")},
+	}, time.Second)
+	r.Jobs = []*throughputReport{newThroughputReport(testMetrics(start))}
+	r.Aggregate = newThroughputReport(&ThroughputMetrics{Operation: "mixed", Workload: "uniform 4K", MaxConcurrent: 8, StartTime: start, EndTime: start.Add(time.Minute)})
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["version"] != float64(2) {
+		t.Errorf("report version %v; reportShape describes version 2", doc["version"])
+	}
+
+	shape := map[string]bool{}
+	jsonShape(doc, "", map[string]bool{"config": true, "errors": true, "error_codes": true}, shape)
+	var got []string
+	for line := range shape {
+		got = append(got, line)
+	}
+	sort.Strings(got)
+	if want := strings.TrimSpace(reportShape); strings.Join(got, "\n") != want {
+		t.Errorf("report shape changed; update reportShape and, if a field changed meaning or was removed, reportVersion:\n%s", strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+var (
+	inputFile          = flag.String("input_file", "", "Local raw or sparse image to upload with -vdisk_operation=restore")
+	restoreBlockSize   = flag.Int64("restore_block_size", vdisk.DefaultRestoreBlockSize, "Granularity in bytes at which restore sends all-zero blocks as zero_data ranges")
+	restoreMaxPayload  = flag.Int64("restore_max_payload", vdisk.DefaultChunkSize, "Maximum non-zero bytes carried by one restore write")
+	restoreStreams     = flag.Int("restore_streams", 0, "Number of write streams used by restore (0 uses connection_pool_size)")
+	restoreVerify      = flag.Bool("restore_verify", true, "Read the disk back after restore and compare it with the image")
+	restoreMismatchMax = flag.Int("restore_max_mismatches", 20, "Maximum number of mismatched ranges listed by restore verification")
+)
+
+// runRestore uploads -input_file into the disk and optionally verifies it
+func runRestore(client *vdisk.Client) error {
+	if *inputFile == "" {
+		return fmt.Errorf("restore requires -input_file")
+	}
+	ct, err := vdisk.ParseCompressionType(*compressionType)
+	if err != nil {
+		return err
+	}
+	cs, err := vdisk.ParseChecksumType(*checksumType)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(*inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %v", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	streams := *restoreStreams
+	if streams <= 0 {
+		streams = client.PoolSize()
+	}
+	disk := createDiskIdentifier()
+	fmt.Fprintf(logOutput, "Starting restore of %s (%d bytes) to %s (%d byte blocks, %d streams)\n",
+		*inputFile, size, vdisk.DiskKey(disk), *restoreBlockSize, streams)
+
+	stats, err := client.Restore(ctx, disk, f, size, vdisk.RestoreOptions{
+		BlockSize:   *restoreBlockSize,
+		MaxPayload:  *restoreMaxPayload,
+		Streams:     streams,
+		Window:      *pipelineWindow,
+		Compression: ct,
+		Checksum:    cs,
+		Progress:    restoreProgressPrinter(),
+	})
+	printRestoreSummary(stats)
+	if err != nil {
+		return err
+	}
+
+	if !*restoreVerify {
+		return nil
+	}
+	fmt.Fprintf(logOutput, "\nVerifying %s against %s...\n", vdisk.DiskKey(disk), *inputFile)
+	verify, err := client.Verify(ctx, disk, f, size, vdisk.VerifyOptions{
+		Streams:         streams,
+		MaxResponseSize: *maxResponseSize,
+		MaxMismatches:   *restoreMismatchMax,
+	})
+	if err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	fmt.Fprintf(logOutput, "Verified %d bytes in %v\n", verify.ComparedBytes, verify.Duration)
+	if verify.Retries > 0 {
+		fmt.Fprintf(logOutput, "Stream retries: %d (reads resumed on a new stream)\n", verify.Retries)
+	}
+	if verify.MismatchBytes > 0 {
+		for _, r := range verify.Mismatches {
+			fmt.Fprintf(logOutput, "  Mismatch at offset %d, length %d\n", r.Offset, r.Length)
+		}
+		return fmt.Errorf("verification found %d mismatched bytes", verify.MismatchBytes)
+	}
+	fmt.Fprintln(logOutput, "Verification passed: disk matches image")
+	return nil
+}
+
+// restoreProgressPrinter returns a progress callback that prints at most
+// once per -report_interval
+func restoreProgressPrinter() func(vdisk.RestoreProgress) {
+	var mu sync.Mutex
+	lastReport := time.Now()
+	return func(p vdisk.RestoreProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(lastReport) < *reportInterval {
+			return
+		}
+		lastReport = time.Now()
+		fmt.Fprintf(logOutput, "[%v] Restore progress: %d/%d bytes (%.1f%%)\n", p.Elapsed.Round(time.Second),
+			p.CompletedBytes, p.ImageSize, float64(p.CompletedBytes)/float64(max(p.ImageSize, 1))*100)
+	}
+}
+
+func printRestoreSummary(stats vdisk.RestoreStats) {
+	fmt.Fprintf(logOutput, "\n=== Restore Summary ===\n")
+	fmt.Fprintf(logOutput, "Image Size: %d bytes\n", stats.ImageSize)
+	fmt.Fprintf(logOutput, "Data Bytes: %d\n", stats.DataBytes)
+	fmt.Fprintf(logOutput, "Zero Bytes: %d (sent as zero_data ranges)\n", stats.ZeroBytes)
+	fmt.Fprintf(logOutput, "Writes Acknowledged: %d\n", stats.Writes)
+	fmt.Fprintf(logOutput, "Wire Bytes: %d\n", stats.WireBytes)
+	fmt.Fprintf(logOutput, "Duration: %v\n", stats.Duration)
+	if secs := stats.Duration.Seconds(); secs > 0 {
+		fmt.Fprintf(logOutput, "Throughput: %.2f MB/s\n", float64(stats.ImageSize)/secs/(1024*1024))
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+// Retry flags. The defaults of the delays follow the server's
+// grpc_write_retry_initial_delay_ms and its doubling backoff.
+var (
+	maxRetries          = flag.Int("max_retries", 0, "Retry failed reads, and writes with -retry_writes, up to this many times (0 disables retries)")
+	retryInitialDelayMs = flag.Int("retry_initial_delay_ms", 100, "Delay before the first retry in milliseconds, multiplied by -retry_multiplier for every further retry")
+	retryMaxDelayMs     = flag.Int("retry_max_delay_ms", 5000, "Longest delay between retries in milliseconds")
+	retryMultiplier     = flag.Float64("retry_multiplier", 2, "Factor the retry delay grows by with every retry")
+	retryJitter         = flag.Float64("retry_jitter", 0.2, "Randomize retry delays by up to this fraction of themselves")
+	retryCodes          = flag.String("retry_codes", "UNAVAILABLE,RESOURCE_EXHAUSTED,ABORTED", "Comma-separated gRPC status codes that are retried")
+	retryWrites         = flag.Bool("retry_writes", false, "Also retry writes; only safe when rewriting the same data at the same offset is harmless")
+	retryBudgetTokens   = flag.Float64("retry_budget_tokens", 10, "Retry budget shared by all requests: retryable failures take a token, and retries stop below half of this (0 disables the budget)")
+	retryBudgetRatio    = flag.Float64("retry_budget_ratio", 0.1, "Tokens returned to the retry budget by every successful request")
+)
+
+// applyRetry sets the retry policy of a client built with opts when
+// -max_retries is set
+func applyRetry(opts *vdisk.Options) error {
+	if *maxRetries <= 0 {
+		return nil
+	}
+	if *retryJitter < 0 || *retryJitter > 1 {
+		return fmt.Errorf("retry_jitter must be between 0 and 1")
+	}
+
+	policy := vdisk.DefaultRetryPolicy()
+	policy.MaxRetries = *maxRetries
+	policy.InitialBackoff = time.Duration(*retryInitialDelayMs) * time.Millisecond
+	policy.MaxBackoff = time.Duration(*retryMaxDelayMs) * time.Millisecond
+	policy.Multiplier = *retryMultiplier
+	policy.Jitter = *retryJitter
+	policy.RetryWrites = *retryWrites
+	policy.BudgetTokens = *retryBudgetTokens
+	policy.BudgetRatio = *retryBudgetRatio
+
+	retryable, err := parseRetryCodes(*retryCodes)
+	if err != nil {
+		return err
+	}
+	policy.RetryableCodes = retryable
+
+	opts.Retry = &policy
+	return nil
+}
+
+// parseRetryCodes parses a list of gRPC status code names such as
+// UNAVAILABLE or numbers
+func parseRetryCodes(list string) ([]codes.Code, error) {
+	retryable := []codes.Code{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var code codes.Code
+		if n, err := strconv.Atoi(name); err == nil {
+			code = codes.Code(n)
+		} else if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name)))); err != nil {
+			return nil, fmt.Errorf("invalid retry code %q", name)
+		}
+		retryable = append(retryable, code)
+	}
+	return retryable, nil
+}
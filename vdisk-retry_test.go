@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestParseRetryCodes(t *testing.T) {
+	for _, tc := range []struct {
+		list string
+		want []codes.Code
+		err  string
+	}{
+		{list: "UNAVAILABLE,RESOURCE_EXHAUSTED,ABORTED", want: []codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.Aborted}},
+		{list: " unavailable , Deadline_Exceeded ", want: []codes.Code{codes.Unavailable, codes.DeadlineExceeded}},
+		{list: "14,4", want: []codes.Code{codes.Unavailable, codes.DeadlineExceeded}},
+		{list: "UNAVAILABLE,,", want: []codes.Code{codes.Unavailable}},
+		// An empty list retries no codes rather than the defaults
+		{list: "", want: []codes.Code{}},
+		{list: "UNAVAILABLE,NOT_A_CODE", err: `invalid retry code "NOT_A_CODE"`},
+		{list: "deadline exceeded", err: `invalid retry code "deadline exceeded"`},
+	} {
+		got, err := parseRetryCodes(tc.list)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("parseRetryCodes(%q): error %v, want %q", tc.list, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseRetryCodes(%q) = %v, %v, want %v", tc.list, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+var (
+	traceOutput      = flag.String("trace_output", "", "Write an OpenTelemetry span for every VDisk stream to this file as JSON (\"stdout\" prints them, empty disables tracing)")
+	traceSampleRatio = flag.Float64("trace_sample_ratio", 1, "Fraction of VDisk streams traced when -trace_output is set")
+)
+
+// tracing is the tracer provider started for -trace_output
+var tracing *sdktrace.TracerProvider
+
+// traceFile is the file spans are written to, nil for stdout
+var traceFile *os.File
+
+// startTracing sets up span export when -trace_output is set
+func startTracing() error {
+	if *traceOutput == "" {
+		return nil
+	}
+	if *traceSampleRatio < 0 || *traceSampleRatio > 1 {
+		return fmt.Errorf("trace_sample_ratio must be between 0 and 1")
+	}
+
+	var w io.Writer = logOutput
+	if *traceOutput != "stdout" {
+		f, err := os.Create(*traceOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create trace file: %v", err)
+		}
+		traceFile, w = f, f
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "vdisk-client"),
+	))
+	if err != nil {
+		return err
+	}
+	tracing = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*traceSampleRatio))),
+	)
+	fmt.Fprintf(logOutput, "Tracing VDisk streams to %s (sample ratio %g)\n", *traceOutput, *traceSampleRatio)
+	return nil
+}
+
+// applyTracing traces the streams of a client built with opts when tracing
+// is enabled
+func applyTracing(opts *vdisk.Options) {
+	if tracing == nil {
+		return
+	}
+	opts.TracerProvider = tracing
+}
+
+// stopTracing flushes the spans still buffered and closes the trace file
+func stopTracing() {
+	if tracing == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := tracing.Shutdown(ctx); err != nil {
+		fmt.Fprintf(logOutput, "Warning: failed to flush trace spans: %v\n", err)
+	}
+	if traceFile != nil {
+		traceFile.Close()
+	}
+}
@@ -2,11 +2,9 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/csv"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -14,18 +12,15 @@ import (
 	"sync/atomic"
 	"time"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/keepalive"
-	"google.golang.org/grpc/metadata"
-
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/histogram"
 	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
 )
 
 var (
 	vdiskServerAddress = flag.String("vdisk_server", "", "VDisk server address in ip:port format")
-	vdiskOperation     = flag.String("vdisk_operation", "", "VDisk operation (read or write)")
+	vdiskOperation     = flag.String("vdisk_operation", "", "VDisk operation (read, write, backup, restore, verify, or mixed in throughput mode)")
 	vdiskAuthToken     = flag.String("vdisk_auth_token", "", "Authentication token for VDisk service")
 	vdiskUseTLS        = flag.Bool("vdisk_use_tls", true, "Use TLS for gRPC connection (default: false)")
 	vdiskSkipTLSVerify = flag.Bool("vdisk_skip_tls_verify", true, "Skip TLS certificate verification (default: true)")
@@ -49,11 +44,15 @@ var (
 
 	// Throughput CSV metrics
 	metricsCSVPath     = flag.String("metrics_csv", "throughput_metrics.csv", "Path to write per-second throughput metrics CSV (empty to disable)")
-	metricsIntervalSec = flag.Int("metrics_interval_sec", 1, "CSV logging interval in seconds (>=1)")
+	metricsIntervalSec = flag.Int("metrics_interval_sec", 1, "CSV and latency histogram log interval in seconds (>=1)")
 
 	// Connection pool flags
 	connectionPoolSize = flag.Int("connection_pool_size", 1, "Number of gRPC connections to maintain in the pool")
 
+	// Stream pipelining flags
+	pipelineWindow  = flag.Int("pipeline_window", 0, "Requests in flight per long-lived stream in throughput mode (0 opens a stream per request)")
+	pipelineStreams = flag.Int("pipeline_streams", 0, "Number of long-lived streams for pipelined throughput mode (0 uses connection_pool_size)")
+
 	// Disk identifier flags
 	diskRecoveryPointUuid = flag.String("disk_recovery_point_uuid", "", "Disk recovery point UUID")
 	vmDiskUuid            = flag.String("vm_disk_uuid", "", "VM disk UUID")
@@ -63,13 +62,15 @@ var (
 	readOffset      = flag.Int64("read_offset", 0, "Read offset in bytes")
 	readLength      = flag.Int64("read_length", 0, "Read length in bytes (0 for entire disk)")
 	maxResponseSize = flag.Int64("max_response_size", 1024*1024, "Maximum response size in bytes")
+	outputFile      = flag.String("output_file", "", "Write read data to this file as a sparse image (\"-\" for stdout)")
 
 	// Write operation flags
 	writeOffset     = flag.Int64("write_offset", 0, "Write offset in bytes")
 	writeLength     = flag.Int64("write_length", 0, "Write length in bytes")
-	writeData       = flag.String("write_data", "", "Data to write (hex string)")
+	writeData       = flag.String("write_data", "", "Data to write, decoded per -write_data_encoding (hex string by default)")
 	compressionType = flag.String("compression_type", "none", "Compression type (none, lz4, snappy, zlib)")
 	checksumType    = flag.String("checksum_type", "none", "Checksum type (none, crc32, sha1, sha256)")
+	failOnChecksum  = flag.Bool("fail_on_checksum_mismatch", false, "Abort batch and throughput runs when the server reports a checksum mismatch")
 	sequenceNumber  = flag.Int64("sequence_number", 0, "Sequence number for write ordering")
 )
 
@@ -82,22 +83,69 @@ type BatchOperationResult struct {
 	BytesRead     int
 	BytesWritten  int64
 	ResponseCount int
+	Retries       int
 }
 
 // ThroughputMetrics tracks throughput testing metrics
 type ThroughputMetrics struct {
+	Job            string // Job name, empty for the flag-driven test
+	Disk           string // Disk key, empty when merged over several disks
+	Operation      string // read, write or mixed
+	MaxConcurrent  int
+	PipelineWindow int
+
 	TotalRequests      int64
+	WarmupRequests     int64 // Requests completed during the ramp time and left out of the results
 	SuccessfulRequests int64
 	FailedRequests     int64
 	TotalBytes         int64
+	PayloadBytes       int64 // Write payload bytes before compression
+	WireBytes          int64 // Write payload bytes sent after compression
 	TotalDuration      time.Duration
 	StartTime          time.Time
 	EndTime            time.Time
-	MinLatency         time.Duration
-	MaxLatency         time.Duration
-	TotalLatency       time.Duration
 	RequestsPerSecond  float64
 	BytesPerSecond     float64
+	InFlight           int64 // Requests currently in flight
+	Retries            int64 // Attempts made after the first, over all requests
+	Errors             errorCounts
+
+	// Latency holds the latency of every successful request. IntervalLatency
+	// is also recorded when a histogram log needs per-interval histograms.
+	// In open-loop mode latency is measured from each request's intended
+	// start, and ServiceLatency holds the time from the actual send.
+	Latency         *histogram.Histogram
+	IntervalLatency *histogram.Histogram
+	ServiceLatency  *histogram.Histogram
+
+	// Open-loop schedule tracking, used when TargetRate is set
+	TargetRate        float64 // Requests per second
+	ScheduledRequests int64   // Requests sent on the schedule
+	MissedSchedule    int64   // Requests sent more than -schedule_slack late
+	UnsentRequests    int64   // Requests due before the end of the test but never sent
+	MaxScheduleLag    int64   // Largest delay between intended and actual start, in ns
+
+	// Workload describes the workload profile, and Operations breaks the
+	// results down by operation for mixed workloads
+	Workload   string
+	Operations map[string]*OperationMetrics
+
+	// Phases holds the results of each phase of a phased test. The metrics
+	// of a phase carry its name and a description of its load.
+	Phases []*ThroughputMetrics
+	Phase  string
+	Load   string
+
+	// TimeSeries holds per-interval samples for -report_json
+	TimeSeries []seriesSample
+}
+
+// OperationMetrics tracks the results of one operation of a mixed workload
+type OperationMetrics struct {
+	Requests int64
+	Failed   int64
+	Bytes    int64
+	Latency  *histogram.Histogram
 }
 
 // csvLogger periodically records per-second throughput metrics to a CSV file
@@ -202,722 +250,305 @@ type ThroughputResult struct {
 	Duration     time.Duration
 	BytesRead    int64
 	BytesWritten int64
+	PayloadBytes int64
+	WireBytes    int64
 	Timestamp    time.Time
+	ScheduleLag  time.Duration // Delay between the intended and actual start in open-loop mode
+	Operation    string        // workload.OpRead or workload.OpWrite
+	Connection   int           // Pool index of the connection used, -1 if none
+	Retries      int           // Attempts made after the first
 }
 
-// Connection pool for throughput testing
-var (
-	connectionPool       []*grpc.ClientConn
-	connectionMutex      sync.RWMutex
-	poolInitialized      bool
-	connectionRoundRobin int64
-	connectionUsageCount []int64 // Track usage per connection for debugging
-	cachedAuthContext    context.Context
-	authContextOnce      sync.Once
-)
-
-// getCachedAuthContext returns a cached authentication context for throughput testing
-func getCachedAuthContext() context.Context {
-	authContextOnce.Do(func() {
-		cachedAuthContext = createAuthContext(context.Background())
-	})
-	return cachedAuthContext
-}
-
-// initializeConnectionPool initializes the connection pool with specified size
-func initializeConnectionPool(serverAddress string) error {
-	connectionMutex.Lock()
-	defer connectionMutex.Unlock()
-
-	if poolInitialized {
-		return nil
-	}
-
-	poolSize := *connectionPoolSize
-	if poolSize < 1 {
-		poolSize = 1
-	}
-
-	fmt.Printf("Initializing connection pool with %d connections...\n", poolSize)
-
-	connectionPool = make([]*grpc.ClientConn, poolSize)
-	connectionUsageCount = make([]int64, poolSize)
-
-	for i := 0; i < poolSize; i++ {
-		conn, err := createVDiskGrpcChannel(serverAddress)
-		if err != nil {
-			// Clean up any connections created so far
-			for j := 0; j < i; j++ {
-				if connectionPool[j] != nil {
-					connectionPool[j].Close()
-				}
-			}
-			return fmt.Errorf("failed to create connection %d: %v", i, err)
-		}
-		connectionPool[i] = conn
-		fmt.Printf("Created connection %d/%d\n", i+1, poolSize)
-	}
-
-	poolInitialized = true
-	fmt.Printf("Connection pool initialized successfully with %d connections\n", poolSize)
-	return nil
-}
-
-// getPooledConnection gets a connection from the pool using round-robin
-func getPooledConnection(serverAddress string) (*grpc.ClientConn, error) {
-	// Initialize pool if not done already
-	connectionMutex.RLock()
-	if !poolInitialized {
-		connectionMutex.RUnlock()
-		err := initializeConnectionPool(serverAddress)
-		if err != nil {
-			return nil, err
-		}
-		connectionMutex.RLock()
-	}
-
-	// Get connection using round-robin
-	if len(connectionPool) > 0 {
-		index := atomic.AddInt64(&connectionRoundRobin, 1) - 1
-		index = index % int64(len(connectionPool))
-		conn := connectionPool[index]
-
-		// Check if connection is still valid
-		if conn != nil && conn.GetState().String() != "SHUTDOWN" {
-			// Track usage for this connection
-			atomic.AddInt64(&connectionUsageCount[index], 1)
-			connectionMutex.RUnlock()
-			return conn, nil
-		}
-
-		// Connection is invalid, need to recreate it
-		connectionMutex.RUnlock()
-		return recreateConnection(serverAddress, int(index))
-	}
-
-	connectionMutex.RUnlock()
-	return nil, fmt.Errorf("connection pool is empty")
-}
-
-// recreateConnection recreates a specific connection in the pool
-func recreateConnection(serverAddress string, index int) (*grpc.ClientConn, error) {
-	connectionMutex.Lock()
-	defer connectionMutex.Unlock()
-
-	if index >= len(connectionPool) {
-		return nil, fmt.Errorf("invalid connection index %d", index)
-	}
-
-	// Close old connection if it exists
-	if connectionPool[index] != nil {
-		connectionPool[index].Close()
-	}
-
-	// Create new connection
-	conn, err := createVDiskGrpcChannel(serverAddress)
-	if err != nil {
-		return nil, fmt.Errorf("failed to recreate connection %d: %v", index, err)
+// newVDiskClient builds a pooled vdisk.Client from the command line flags
+func newVDiskClient() (*vdisk.Client, error) {
+	opts := vdisk.DefaultOptions()
+	opts.Address = *vdiskServerAddress
+	opts.UseTLS = *vdiskUseTLS
+	opts.SkipTLSVerify = *vdiskSkipTLSVerify
+	opts.AuthType = *authType
+	opts.BearerToken = *vdiskAuthToken
+	opts.Cookie = *cookieValue
+	opts.BasicAuth = *basicAuthValue
+	opts.PoolSize = *connectionPoolSize
+	opts.Logf = func(format string, args ...interface{}) {
+		fmt.Fprintf(logOutput, format, args...)
+	}
+	applyFakeServer(&opts)
+	applyTracing(&opts)
+	if err := applyRetry(&opts); err != nil {
+		return nil, err
 	}
 
-	connectionPool[index] = conn
-	fmt.Printf("Recreated connection %d\n", index)
-
-	// Track usage for the recreated connection
-	atomic.AddInt64(&connectionUsageCount[index], 1)
-	return conn, nil
+	fmt.Fprintf(logOutput, "Using authentication type: %s\n", *authType)
+	return vdisk.NewClient(opts)
 }
 
 // printConnectionDistribution prints connection usage distribution for debugging
-func printConnectionDistribution() {
-	connectionMutex.RLock()
-	defer connectionMutex.RUnlock()
-
-	if !poolInitialized || len(connectionUsageCount) == 0 {
-		fmt.Println("Connection pool not initialized or no usage data available")
+func printConnectionDistribution(client *vdisk.Client) {
+	dist := newConnectionReport(client)
+	if dist == nil {
+		fmt.Fprintln(logOutput, "Connection pool not initialized or no usage data available")
 		return
 	}
 
-	fmt.Printf("\n=== Connection Distribution Report ===\n")
-	fmt.Printf("Pool Size: %d connections\n", len(connectionPool))
+	fmt.Fprintf(logOutput, "\n=== Connection Distribution Report ===\n")
+	fmt.Fprintf(logOutput, "Pool Size: %d connections\n", dist.PoolSize)
 
-	totalUsage := int64(0)
-	usages := make([]int64, len(connectionUsageCount))
-
-	// First, collect all usage counts and calculate total
-	for i, count := range connectionUsageCount {
-		usage := atomic.LoadInt64(&count)
-		usages[i] = usage
-		totalUsage += usage
-	}
-
-	// Then print with correct percentages
-	for i, usage := range usages {
+	for i, usage := range dist.Streams {
 		percentage := 0.0
-		if totalUsage > 0 {
-			percentage = float64(usage) / float64(totalUsage) * 100
+		if dist.TotalStreams > 0 {
+			percentage = float64(usage) / float64(dist.TotalStreams) * 100
 		}
-		fmt.Printf("Connection %d: %d streams (%.1f%%)\n", i, usage, percentage)
+		fmt.Fprintf(logOutput, "Connection %d: %d streams (%.1f%%)\n", i, usage, percentage)
 	}
 
-	if totalUsage > 0 {
-		avgUsage := float64(totalUsage) / float64(len(connectionUsageCount))
-		fmt.Printf("Total streams: %d\n", totalUsage)
-		fmt.Printf("Average per connection: %.1f\n", avgUsage)
-
-		// Calculate distribution variance
-		variance := 0.0
-		for _, usage := range usages {
-			usageFloat := float64(usage)
-			variance += (usageFloat - avgUsage) * (usageFloat - avgUsage)
-		}
-		variance /= float64(len(connectionUsageCount))
-		stdDev := variance
-		if variance > 0 {
-			stdDev = variance // Simple approximation
-		}
-		fmt.Printf("Distribution std dev: %.2f (lower is more equal)\n", stdDev)
+	if dist.TotalStreams > 0 {
+		fmt.Fprintf(logOutput, "Total streams: %d\n", dist.TotalStreams)
+		fmt.Fprintf(logOutput, "Average per connection: %.1f\n", dist.Mean)
+		fmt.Fprintf(logOutput, "Distribution std dev: %.2f (lower is more equal)\n", dist.StdDev)
 	}
-	fmt.Printf("=======================================\n\n")
-}
-
-func createVDiskGrpcChannel(serverAddress string) (*grpc.ClientConn, error) {
-	var opts []grpc.DialOption
-
-	if *vdiskUseTLS {
-		// Use TLS credentials
-		creds := credentials.NewTLS(&tls.Config{
-			InsecureSkipVerify: *vdiskSkipTLSVerify,
-		})
-		opts = append(opts, grpc.WithTransportCredentials(creds))
-		fmt.Println("Connecting with TLS...")
-	} else {
-		// Use insecure connection (no TLS)
-		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
-		fmt.Println("Connecting without TLS...")
-	}
-
-	// Add call options
-	opts = append(opts, grpc.WithDefaultCallOptions(
-		grpc.MaxCallRecvMsgSize(100*1024*1024),
-		grpc.MaxCallSendMsgSize(100*1024*1024),
-	))
-
-	// Add keepalive settings for better connection management
-	kacp := keepalive.ClientParameters{
-		Time:                30 * time.Second, // Send pings every 30 seconds
-		Timeout:             5 * time.Second,  // Wait 5 seconds for ping ack
-		PermitWithoutStream: true,             // Send pings even without active streams
-	}
-	opts = append(opts, grpc.WithKeepaliveParams(kacp))
-
-	fmt.Printf("Using authentication type: %s\n", *authType)
-
-	conn, err := grpc.Dial(serverAddress, opts...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to VDisk server: %v", err)
-	}
-
-	return conn, nil
-}
-
-// createAuthContext creates a context with appropriate authentication headers
-func createAuthContext(baseCtx context.Context) context.Context {
-	ctx := baseCtx
-
-	switch *authType {
-	case "bearer":
-		if *vdiskAuthToken != "" {
-			ctx = metadata.AppendToOutgoingContext(ctx, "Authorization", fmt.Sprintf("Bearer %s", *vdiskAuthToken))
-			fmt.Println("Using Bearer token authentication")
-		} else {
-			fmt.Println("Warning: Bearer auth selected but no token provided")
-		}
-	case "basic":
-		if *basicAuthValue != "" {
-			ctx = metadata.AppendToOutgoingContext(ctx, "Authorization", fmt.Sprintf("Basic %s", *basicAuthValue))
-			fmt.Println("Using Basic authentication")
-		} else {
-			fmt.Println("Warning: Basic auth selected but no credentials provided")
-		}
-	case "cookie":
-		if *cookieValue != "" {
-			ctx = metadata.AppendToOutgoingContext(ctx, "Cookie", *cookieValue)
-			fmt.Println("Using Cookie authentication")
-		} else {
-			fmt.Println("Warning: Cookie auth selected but no cookie provided")
-		}
-	default:
-		fmt.Printf("Warning: Unknown authentication type '%s', proceeding without authentication\n", *authType)
-	}
-
-	return ctx
+	fmt.Fprintf(logOutput, "=======================================\n\n")
 }
 
 func createDiskIdentifier() *protos.DiskIdentifier {
-	diskId := &protos.DiskIdentifier{}
-
 	if *diskRecoveryPointUuid != "" {
-		diskId.Identifier = &protos.DiskIdentifier_DiskRecoveryPoint{
-			DiskRecoveryPoint: &protos.DiskRecoveryPoint{
-				RecoveryPointUuid: diskRecoveryPointUuid,
-			},
-		}
+		return vdisk.RecoveryPointDisk(*diskRecoveryPointUuid)
 	} else if *vmDiskUuid != "" {
-		diskId.Identifier = &protos.DiskIdentifier_VmDiskUuid{
-			VmDiskUuid: *vmDiskUuid,
-		}
+		return vdisk.VMDisk(*vmDiskUuid)
 	} else if *vgDiskUuid != "" {
-		diskId.Identifier = &protos.DiskIdentifier_VgDiskUuid{
-			VgDiskUuid: *vgDiskUuid,
-		}
-	}
-
-	return diskId
-}
-
-func getCompressionType(compressionStr string) protos.CompressionType {
-	switch compressionStr {
-	case "lz4":
-		return protos.CompressionType_kLZ4Compression
-	case "snappy":
-		return protos.CompressionType_kSnappyCompression
-	case "zlib":
-		return protos.CompressionType_kZlibCompression
-	default:
-		return protos.CompressionType_kNoCompression
+		return vdisk.VGDisk(*vgDiskUuid)
 	}
+	return &protos.DiskIdentifier{}
 }
 
-func getChecksumType(checksumStr string) protos.ChecksumType {
-	switch checksumStr {
-	case "crc32":
-		return protos.ChecksumType_kCRC32
-	case "sha1":
-		return protos.ChecksumType_kSHA1
-	case "sha256":
-		return protos.ChecksumType_kSHA256
-	default:
-		return protos.ChecksumType_kNoChecksum
+// newWriteRequest builds a write request to disk for the first length bytes
+// of the loaded write payload from the write flags with the given offset and
+// sequence number
+func newWriteRequest(disk *protos.DiskIdentifier, offset, length, seq int64) (vdisk.WriteRequest, error) {
+	ct, err := vdisk.ParseCompressionType(*compressionType)
+	if err != nil {
+		return vdisk.WriteRequest{}, err
 	}
+	cs, err := vdisk.ParseChecksumType(*checksumType)
+	if err != nil {
+		return vdisk.WriteRequest{}, err
+	}
+	return vdisk.WriteRequest{
+		Disk:           disk,
+		Offset:         offset,
+		Length:         length,
+		Data:           writePayload[:length],
+		Compression:    ct,
+		Checksum:       cs,
+		SequenceNumber: seq,
+	}, nil
 }
 
-func vdiskStreamRead(serverAddress string) error {
-	conn, err := createVDiskGrpcChannel(serverAddress)
+func vdiskStreamRead(client *vdisk.Client) error {
+	image, closeImage, err := openOutputImage(*outputFile, *readOffset)
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
-
-	client := protos.NewStargateVDiskRpcSvcClient(conn)
+	defer closeImage()
 
-	ctx := context.Background()
-	ctx = createAuthContext(ctx)
+	fmt.Fprintf(logOutput, "Sending read request: offset=%d, length=%d\n", *readOffset, *readLength)
+	fmt.Fprintln(logOutput, "Receiving read responses...")
 
-	stream, err := client.VDiskStreamRead(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to create read stream: %v", err)
-	}
-
-	// Send read request
-	readReq := &protos.VDiskReadArg{
-		DiskId:          createDiskIdentifier(),
-		Offset:          readOffset,
-		Length:          readLength,
-		MaxResponseSize: maxResponseSize,
-	}
-
-	fmt.Printf("Sending read request: offset=%d, length=%d\n", *readOffset, *readLength)
-	err = stream.Send(readReq)
-	if err != nil {
-		return fmt.Errorf("failed to send read request: %v", err)
-	}
-
-	// Receive responses
-	fmt.Println("Receiving read responses...")
-	totalBytesRead := 0
 	responseCount := 0
-
-	for {
-		response, err := stream.Recv()
-		if err == io.EOF {
-			fmt.Println("Stream completed normally")
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("stream error: %v", err)
-		}
-
+	next := *readOffset
+	stats, err := client.Read(context.Background(), vdisk.ReadRequest{
+		Disk:            createDiskIdentifier(),
+		Offset:          *readOffset,
+		Length:          *readLength,
+		MaxResponseSize: *maxResponseSize,
+	}, func(response *protos.VDiskReadRet) error {
 		responseCount++
-		fmt.Printf("Received response #%d\n", responseCount)
-
-		// Check for error message - but don't treat success messages as errors
-		if response.ErrorMessage != nil {
-			errorMsg := *response.ErrorMessage
-			// Only treat as error if it's not a success message
-			if errorMsg != "" && errorMsg != "Read operation successful" &&
-				errorMsg != "Operation completed successfully" {
-				return fmt.Errorf("server error: %s", errorMsg)
-			}
-			// Log success messages but don't treat as errors
-			if errorMsg == "Read operation successful" || errorMsg == "Operation completed successfully" {
-				fmt.Printf("Server message: %s\n", errorMsg)
-			}
+		fmt.Fprintf(logOutput, "Received response #%d\n", responseCount)
+
+		if msg := response.GetErrorMessage(); msg != "" {
+			fmt.Fprintf(logOutput, "Server message: %s\n", msg)
 		}
 
-		fmt.Printf("Response details: ranges=%d, data_size=%d bytes\n",
+		fmt.Fprintf(logOutput, "Response details: ranges=%d, data_size=%d bytes\n",
 			len(response.RangeVec), len(response.Data))
 
 		// Display range information
 		for i, dataRange := range response.RangeVec {
-			fmt.Printf("  Range %d: offset=%d, length=%d, zero_data=%t\n",
-				i+1, *dataRange.Offset, *dataRange.Length, *dataRange.ZeroData)
+			fmt.Fprintf(logOutput, "  Range %d: offset=%d, length=%d, zero_data=%t\n",
+				i+1, dataRange.GetOffset(), dataRange.GetLength(), dataRange.GetZeroData())
 		}
 
 		// Display total disk size if available
 		if response.TotalDiskSize != nil {
-			fmt.Printf("  Total disk size: %d bytes\n", *response.TotalDiskSize)
+			fmt.Fprintf(logOutput, "  Total disk size: %d bytes\n", *response.TotalDiskSize)
 		}
 
-		totalBytesRead += len(response.Data)
-
-		// Check if there's more data
 		if response.HasMoreData != nil {
-			fmt.Printf("  Has more data: %t\n", *response.HasMoreData)
+			fmt.Fprintf(logOutput, "  Has more data: %t\n", *response.HasMoreData)
 			if !*response.HasMoreData {
-				fmt.Println("No more data available")
-				break
+				fmt.Fprintln(logOutput, "No more data available")
 			}
 		}
-	}
-
-	fmt.Printf("Read operation completed successfully. Total bytes read: %d, responses received: %d\n",
-		totalBytesRead, responseCount)
-
-	// Close the send side of the stream
-	err = stream.CloseSend()
-	if err != nil {
-		return fmt.Errorf("failed to close send stream: %v", err)
-	}
-	return nil
-}
 
-func vdiskStreamWrite(serverAddress string) error {
-	conn, err := createVDiskGrpcChannel(serverAddress)
+		if image == nil {
+			return nil
+		}
+		extents, err := vdisk.Extents(response, next)
+		if err != nil {
+			return err
+		}
+		for _, e := range extents {
+			if err := image.WriteExtent(e); err != nil {
+				return err
+			}
+			next = max(next, e.End())
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
-
-	client := protos.NewStargateVDiskRpcSvcClient(conn)
 
-	ctx := context.Background()
-	ctx = createAuthContext(ctx)
-
-	stream, err := client.VDiskStreamWrite(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to create write stream: %v", err)
+	if image != nil {
+		end := next
+		if *readLength > 0 {
+			end = *readOffset + *readLength
+		} else if stats.TotalDiskSize >= 0 {
+			end = stats.TotalDiskSize
+		}
+		if stats.TotalDiskSize >= 0 {
+			end = min(end, stats.TotalDiskSize)
+		}
+		if err := image.Finish(end); err != nil {
+			return fmt.Errorf("failed to finish output image: %v", err)
+		}
+		imageStats := image.Stats()
+		fmt.Fprintf(logOutput, "Exported %d bytes to %s (%d data bytes, %d zero bytes)\n",
+			end-*readOffset, *outputFile, imageStats.DataBytes, imageStats.ZeroBytes)
 	}
 
-	// Create write request
-	writeReq := &protos.VDiskWriteArg{
-		DiskId: createDiskIdentifier(),
-		RangeVec: []*protos.DiskDataRange{
-			{
-				Offset:   writeOffset,
-				Length:   writeLength,
-				ZeroData: func() *bool { b := false; return &b }(),
-			},
-		},
-		CompressionType: func() *protos.CompressionType { ct := getCompressionType(*compressionType); return &ct }(),
-		ChecksumType:    func() *protos.ChecksumType { ct := getChecksumType(*checksumType); return &ct }(),
-		Data:            []byte(*writeData), // In real scenario, would decode hex string
-		SequenceNumber:  sequenceNumber,
+	fmt.Fprintf(logOutput, "Read operation completed successfully. Total bytes read: %d, responses received: %d\n",
+		stats.BytesRead, stats.Responses)
+	if stats.Retries > 0 {
+		fmt.Fprintf(logOutput, "Retries: %d\n", stats.Retries)
 	}
+	return nil
+}
 
-	fmt.Printf("Sending write request: offset=%d, length=%d, data_size=%d bytes\n",
-		*writeOffset, *writeLength, len(writeReq.Data))
-
-	err = stream.Send(writeReq)
+func vdiskStreamWrite(client *vdisk.Client) error {
+	req, err := newWriteRequest(createDiskIdentifier(), *writeOffset, *writeLength, *sequenceNumber)
 	if err != nil {
-		return fmt.Errorf("failed to send write request: %v", err)
+		return err
 	}
 
-	// Close the send side of the stream
-	err = stream.CloseSend()
-	if err != nil {
-		return fmt.Errorf("failed to close send stream: %v", err)
-	}
+	fmt.Fprintf(logOutput, "Sending write request: offset=%d, length=%d, data_size=%d bytes\n",
+		*writeOffset, *writeLength, len(req.Data))
+	fmt.Fprintln(logOutput, "Receiving write responses...")
 
-	// Receive responses
-	fmt.Println("Receiving write responses...")
 	responseCount := 0
-
-	for {
-		response, err := stream.Recv()
-		if err == io.EOF {
-			fmt.Println("Stream completed normally")
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("stream error: %v", err)
-		}
-
+	stats, err := client.Write(context.Background(), req, func(response *protos.VDiskWriteRet) error {
 		responseCount++
-		fmt.Printf("Received response #%d\n", responseCount)
-
-		// Check for error message - but don't treat success messages as errors
-		if response.ErrorMessage != nil {
-			errorMsg := *response.ErrorMessage
-			// Only treat as error if it's not a success message
-			if errorMsg != "" && errorMsg != "Write operation successful" &&
-				errorMsg != "Operation completed successfully" {
-				return fmt.Errorf("server error: %s", errorMsg)
-			}
-			// Log success messages but don't treat as errors
-			if errorMsg == "Write operation successful" || errorMsg == "Operation completed successfully" {
-				fmt.Printf("Server message: %s\n", errorMsg)
-			}
-		}
-
-		// Display response details
-		success := false
-		if response.Success != nil {
-			success = *response.Success
-		}
+		fmt.Fprintf(logOutput, "Received response #%d\n", responseCount)
 
-		offset := int64(0)
-		if response.Offset != nil {
-			offset = *response.Offset
+		if msg := response.GetErrorMessage(); msg != "" {
+			fmt.Fprintf(logOutput, "Server message: %s\n", msg)
 		}
 
-		length := int64(0)
-		if response.Length != nil {
-			length = *response.Length
-		}
-
-		bytesWritten := int64(0)
-		if response.BytesWritten != nil {
-			bytesWritten = *response.BytesWritten
-		}
-
-		sequenceNumber := int64(0)
-		if response.SequenceNumber != nil {
-			sequenceNumber = *response.SequenceNumber
-		}
-
-		fmt.Printf("Write response: success=%t, offset=%d, length=%d, bytes_written=%d, sequence=%d\n",
-			success, offset, length, bytesWritten, sequenceNumber)
+		fmt.Fprintf(logOutput, "Write response: success=%t, offset=%d, length=%d, bytes_written=%d, sequence=%d\n",
+			response.GetSuccess(), response.GetOffset(), response.GetLength(),
+			response.GetBytesWritten(), response.GetSequenceNumber())
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("Write operation completed successfully. Responses received: %d\n", responseCount)
+	if req.Compression != protos.CompressionType_kNoCompression {
+		fmt.Fprintf(logOutput, "Compression: %d -> %d bytes (ratio %.2fx)\n",
+			stats.PayloadBytes, stats.WireBytes, stats.CompressionRatio())
+	}
+	fmt.Fprintf(logOutput, "Write operation completed successfully. Responses received: %d\n", stats.Responses)
+	if stats.Retries > 0 {
+		fmt.Fprintf(logOutput, "Retries: %d\n", stats.Retries)
+	}
 	return nil
 }
 
 // vdiskStreamReadSingle performs a single read operation and returns the result
-func vdiskStreamReadSingle(serverAddress string, operationID int) BatchOperationResult {
+func vdiskStreamReadSingle(client *vdisk.Client, operationID int) BatchOperationResult {
 	start := time.Now()
 	result := BatchOperationResult{
 		OperationID: operationID,
 		Success:     false,
 	}
 
-	conn, err := createVDiskGrpcChannel(serverAddress)
-	if err != nil {
-		result.Error = err
-		result.Duration = time.Since(start)
-		return result
-	}
-	defer conn.Close()
-
-	client := protos.NewStargateVDiskRpcSvcClient(conn)
-
-	ctx := context.Background()
-	ctx = createAuthContext(ctx)
+	// Offset adjusted by operation ID for testing
+	offset := *readOffset + int64(operationID)*1024
 
-	stream, err := client.VDiskStreamRead(ctx)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to create read stream: %v", err)
-		result.Duration = time.Since(start)
-		return result
-	}
-
-	// Send read request with offset adjusted by operation ID for testing
-	readReq := &protos.VDiskReadArg{
-		DiskId:          createDiskIdentifier(),
-		Offset:          func() *int64 { offset := *readOffset + int64(operationID)*1024; return &offset }(),
-		Length:          readLength,
-		MaxResponseSize: maxResponseSize,
-	}
-
-	fmt.Printf("[Op %d] Sending read request: offset=%d, length=%d\n", operationID, *readReq.Offset, *readLength)
-	err = stream.Send(readReq)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to send read request: %v", err)
-		result.Duration = time.Since(start)
-		return result
-	}
-
-	// Receive responses
-	totalBytesRead := 0
-	responseCount := 0
-
-	for {
-		response, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			result.Error = fmt.Errorf("stream error: %v", err)
-			result.Duration = time.Since(start)
-			return result
-		}
-
-		responseCount++
-
-		if response.ErrorMessage != nil {
-			errorMsg := *response.ErrorMessage
-			if errorMsg != "" && errorMsg != "Read operation successful" &&
-				errorMsg != "Operation completed successfully" {
-				result.Error = fmt.Errorf("server error: %s", errorMsg)
-				result.Duration = time.Since(start)
-				return result
-			}
-		}
-
-		totalBytesRead += len(response.Data)
-
-		if response.HasMoreData != nil && !*response.HasMoreData {
-			break
-		}
-	}
-
-	// Close the send side of the stream
-	err = stream.CloseSend()
+	fmt.Fprintf(logOutput, "[Op %d] Sending read request: offset=%d, length=%d\n", operationID, offset, *readLength)
+	stats, err := client.Read(context.Background(), vdisk.ReadRequest{
+		Disk:            createDiskIdentifier(),
+		Offset:          offset,
+		Length:          *readLength,
+		MaxResponseSize: *maxResponseSize,
+	}, nil)
+	result.Duration = time.Since(start)
+	result.Retries = stats.Retries
 	if err != nil {
-		result.Error = fmt.Errorf("failed to close send stream: %v", err)
-		result.Duration = time.Since(start)
+		result.Error = err
 		return result
 	}
 
 	result.Success = true
-	result.BytesRead = totalBytesRead
-	result.ResponseCount = responseCount
-	result.Duration = time.Since(start)
+	result.BytesRead = int(stats.BytesRead)
+	result.ResponseCount = stats.Responses
 
-	fmt.Printf("[Op %d] Read completed: %d bytes, %d responses, %v\n",
-		operationID, totalBytesRead, responseCount, result.Duration)
+	fmt.Fprintf(logOutput, "[Op %d] Read completed: %d bytes, %d responses, %v\n",
+		operationID, stats.BytesRead, stats.Responses, result.Duration)
 
 	return result
 }
 
 // vdiskStreamWriteSingle performs a single write operation and returns the result
-func vdiskStreamWriteSingle(serverAddress string, operationID int) BatchOperationResult {
+func vdiskStreamWriteSingle(client *vdisk.Client, operationID int) BatchOperationResult {
 	start := time.Now()
 	result := BatchOperationResult{
 		OperationID: operationID,
 		Success:     false,
 	}
 
-	conn, err := createVDiskGrpcChannel(serverAddress)
+	// Offset and sequence adjusted by operation ID for testing
+	req, err := newWriteRequest(createDiskIdentifier(), *writeOffset+int64(operationID)*1024, *writeLength, *sequenceNumber+int64(operationID))
 	if err != nil {
 		result.Error = err
 		result.Duration = time.Since(start)
 		return result
 	}
-	defer conn.Close()
-
-	client := protos.NewStargateVDiskRpcSvcClient(conn)
-
-	ctx := context.Background()
-	ctx = createAuthContext(ctx)
-
-	stream, err := client.VDiskStreamWrite(ctx)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to create write stream: %v", err)
-		result.Duration = time.Since(start)
-		return result
-	}
-
-	// Create write request with offset adjusted by operation ID for testing
-	writeReq := &protos.VDiskWriteArg{
-		DiskId: createDiskIdentifier(),
-		RangeVec: []*protos.DiskDataRange{
-			{
-				Offset:   func() *int64 { offset := *writeOffset + int64(operationID)*1024; return &offset }(),
-				Length:   writeLength,
-				ZeroData: func() *bool { b := false; return &b }(),
-			},
-		},
-		CompressionType: func() *protos.CompressionType { ct := getCompressionType(*compressionType); return &ct }(),
-		ChecksumType:    func() *protos.ChecksumType { ct := getChecksumType(*checksumType); return &ct }(),
-		Data:            []byte(fmt.Sprintf("%s_op%d", *writeData, operationID)), // Unique data per operation
-		SequenceNumber:  func() *int64 { seq := *sequenceNumber + int64(operationID); return &seq }(),
-	}
 
-	fmt.Printf("[Op %d] Sending write request: offset=%d, length=%d, sequence=%d\n",
-		operationID, *writeReq.RangeVec[0].Offset, *writeLength, *writeReq.SequenceNumber)
+	fmt.Fprintf(logOutput, "[Op %d] Sending write request: offset=%d, length=%d, sequence=%d\n",
+		operationID, req.Offset, *writeLength, req.SequenceNumber)
 
-	err = stream.Send(writeReq)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to send write request: %v", err)
-		result.Duration = time.Since(start)
-		return result
-	}
-
-	// Close the send side of the stream
-	err = stream.CloseSend()
+	stats, err := client.Write(context.Background(), req, nil)
+	result.Duration = time.Since(start)
+	result.Retries = stats.Retries
 	if err != nil {
-		result.Error = fmt.Errorf("failed to close send stream: %v", err)
-		result.Duration = time.Since(start)
+		result.Error = err
 		return result
 	}
 
-	// Receive responses
-	responseCount := 0
-	var totalBytesWritten int64 = 0
-
-	for {
-		response, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			result.Error = fmt.Errorf("stream error: %v", err)
-			result.Duration = time.Since(start)
-			return result
-		}
-
-		responseCount++
-
-		if response.ErrorMessage != nil {
-			errorMsg := *response.ErrorMessage
-			if errorMsg != "" && errorMsg != "Write operation successful" &&
-				errorMsg != "Operation completed successfully" {
-				result.Error = fmt.Errorf("server error: %s", errorMsg)
-				result.Duration = time.Since(start)
-				return result
-			}
-		}
-
-		if response.BytesWritten != nil {
-			totalBytesWritten += *response.BytesWritten
-		}
-	}
-
 	result.Success = true
-	result.BytesWritten = totalBytesWritten
-	result.ResponseCount = responseCount
-	result.Duration = time.Since(start)
+	result.BytesWritten = stats.BytesWritten
+	result.ResponseCount = stats.Responses
 
-	fmt.Printf("[Op %d] Write completed: %d bytes written, %d responses, %v\n",
-		operationID, totalBytesWritten, responseCount, result.Duration)
+	fmt.Fprintf(logOutput, "[Op %d] Write completed: %d bytes written, %d responses, %v\n",
+		operationID, stats.BytesWritten, stats.Responses, result.Duration)
 
 	return result
 }
 
 // runBatchVDiskOperations runs multiple VDisk operations concurrently
-func runBatchVDiskOperations() error {
-	if *vdiskServerAddress == "" {
+func runBatchVDiskOperations(client *vdisk.Client) error {
+	if *vdiskServerAddress == "" && !*fakeServer {
 		return fmt.Errorf("vdisk_server address is required")
 	}
 
@@ -930,12 +561,9 @@ func runBatchVDiskOperations() error {
 		return fmt.Errorf("one of disk_recovery_point_uuid, vm_disk_uuid, or vg_disk_uuid is required")
 	}
 
-	if *vdiskOperation == "write" && *writeData == "" {
-		return fmt.Errorf("write_data is required for write operation")
-	}
-
-	fmt.Printf("Starting batch %s operations: %d concurrent operations\n", *vdiskOperation, *batchSize)
+	fmt.Fprintf(logOutput, "Starting batch %s operations: %d concurrent operations\n", *vdiskOperation, *batchSize)
 
+	report := newRunReport("batch")
 	start := time.Now()
 	results := make([]BatchOperationResult, *batchSize)
 
@@ -957,9 +585,9 @@ func runBatchVDiskOperations() error {
 			var result BatchOperationResult
 			switch *vdiskOperation {
 			case "read":
-				result = vdiskStreamReadSingle(*vdiskServerAddress, operationID)
+				result = vdiskStreamReadSingle(client, operationID)
 			case "write":
-				result = vdiskStreamWriteSingle(*vdiskServerAddress, operationID)
+				result = vdiskStreamWriteSingle(client, operationID)
 			}
 
 			results[operationID] = result
@@ -972,15 +600,18 @@ func runBatchVDiskOperations() error {
 	totalDuration := time.Since(start)
 
 	// Print summary results
-	fmt.Printf("\n=== Batch Operation Summary ===\n")
-	fmt.Printf("Total operations: %d\n", *batchSize)
-	fmt.Printf("Total time: %v\n", totalDuration)
+	fmt.Fprintf(logOutput, "\n=== Batch Operation Summary ===\n")
+	fmt.Fprintf(logOutput, "Total operations: %d\n", *batchSize)
+	fmt.Fprintf(logOutput, "Total time: %v\n", totalDuration)
 
 	successCount := 0
 	totalBytes := int64(0)
 	totalResponses := 0
+	totalRetries := 0
+	var failures errorCounts
 
 	for _, result := range results {
+		totalRetries += result.Retries
 		if result.Success {
 			successCount++
 			if *vdiskOperation == "read" {
@@ -990,27 +621,49 @@ func runBatchVDiskOperations() error {
 			}
 			totalResponses += result.ResponseCount
 		} else {
-			fmt.Printf("Operation %d failed: %v\n", result.OperationID, result.Error)
+			kind := errorType(result.Error)
+			if failures.count(kind) == 0 {
+				fmt.Fprintf(logOutput, "First %s failure (operation %d): %v\n", kind, result.OperationID, result.Error)
+			}
+			failures.add(kind, 1)
 		}
 	}
 
-	fmt.Printf("Successful operations: %d/%d\n", successCount, *batchSize)
-	fmt.Printf("Total bytes processed: %d\n", totalBytes)
-	fmt.Printf("Total responses: %d\n", totalResponses)
-	fmt.Printf("Average operation time: %v\n", totalDuration/time.Duration(*batchSize))
+	fmt.Fprintf(logOutput, "Successful operations: %d/%d\n", successCount, *batchSize)
+	if totalRetries > 0 {
+		fmt.Fprintf(logOutput, "Retries: %d\n", totalRetries)
+	}
+	if counts := failures.snapshot(); counts != nil {
+		fmt.Fprintf(logOutput, "Failures by category:\n")
+		printErrorBreakdown("  ", counts)
+	}
+	fmt.Fprintf(logOutput, "Total bytes processed: %d\n", totalBytes)
+	fmt.Fprintf(logOutput, "Total responses: %d\n", totalResponses)
+	fmt.Fprintf(logOutput, "Average operation time: %v\n", totalDuration/time.Duration(*batchSize))
+	report.Batch = newBatchReport(results, totalDuration)
+
+	if *failOnChecksum {
+		for _, result := range results {
+			if kind, ok := vdisk.ServerKind(result.Error); ok && kind == vdisk.ServerChecksumMismatch {
+				return report.finish(client, fmt.Errorf("operation %d: %v", result.OperationID, result.Error))
+			}
+		}
+	}
 
 	if successCount != *batchSize {
-		return fmt.Errorf("batch operation partially failed: %d/%d operations succeeded", successCount, *batchSize)
+		return report.finish(client, fmt.Errorf("batch operation partially failed: %d/%d operations succeeded", successCount, *batchSize))
 	}
 
-	fmt.Printf("All batch operations completed successfully!\n")
-	return nil
+	fmt.Fprintf(logOutput, "All batch operations completed successfully!\n")
+	return report.finish(client, nil)
 }
 
-// runThroughputSingleOperation performs a single operation for throughput testing
-func runThroughputSingleOperation(serverAddress string, operationID int64, activeSemaphoreCount int64) ThroughputResult {
+// runThroughputSingleOperation performs a single operation for throughput
+// testing. intended is the scheduled start in open-loop mode and zero
+// otherwise.
+func runThroughputSingleOperation(client *vdisk.Client, job *throughputJob, sessions *pipelineSessions, operationID int64, activeSemaphoreCount int64, intended time.Time) ThroughputResult {
 	// Log the number of active semaphores when this operation starts
-	// fmt.Printf("Operation %d starting with %d active semaphores\n", operationID, activeSemaphoreCount)
+	// fmt.Fprintf(logOutput, "Operation %d starting with %d active semaphores\n", operationID, activeSemaphoreCount)
 
 	start := time.Now()
 	result := ThroughputResult{
@@ -1018,232 +671,370 @@ func runThroughputSingleOperation(serverAddress string, operationID int64, activ
 		Timestamp: start,
 	}
 
-	conn, err := getPooledConnection(serverAddress)
-	if err != nil {
-		result.Error = err
-		result.Duration = time.Since(start)
-		return result
-	}
-	// Don't close - reuse pooled connection
-
-	client := protos.NewStargateVDiskRpcSvcClient(conn)
-
-	// Derive a per-request timeout context from the cached auth context
-	baseCtx := getCachedAuthContext()
-	ctx, cancel := context.WithTimeout(baseCtx, *requestTimeout)
+	// Derive a per-request timeout context; the client attaches cached auth headers
+	ctx, cancel := context.WithTimeout(context.Background(), *requestTimeout)
 	defer cancel()
 
-	switch *vdiskOperation {
-	case "read":
-		result = performThroughputRead(client, ctx, operationID, start)
-	case "write":
-		result = performThroughputWrite(client, ctx, operationID, start)
+	op := job.Generator.Next()
+	switch {
+	case op.Operation == workload.OpRead && sessions != nil:
+		result = performPipelinedRead(sessions, ctx, job.Disk, op, start)
+	case op.Operation == workload.OpRead:
+		result = performThroughputRead(client, ctx, job.Disk, op, start)
+	case op.Operation == workload.OpWrite && sessions != nil:
+		result = performPipelinedWrite(sessions, ctx, job.Disk, op, start)
+	case op.Operation == workload.OpWrite:
+		result = performThroughputWrite(client, ctx, job.Disk, op, operationID, start)
 	default:
-		result.Error = fmt.Errorf("invalid operation: %s", *vdiskOperation)
+		result.Error = fmt.Errorf("invalid operation: %s", op.Operation)
 		result.Duration = time.Since(start)
 	}
+	result.Operation = op.Operation
 
+	if !intended.IsZero() {
+		result.ScheduleLag = max(start.Sub(intended), 0)
+	}
 	return result
 }
 
 // performThroughputRead performs a read operation for throughput testing
-func performThroughputRead(client protos.StargateVDiskRpcSvcClient, ctx context.Context, operationID int64, start time.Time) ThroughputResult {
-	result := ThroughputResult{Timestamp: start}
-
-	stream, err := client.VDiskStreamRead(ctx)
+func performThroughputRead(client *vdisk.Client, ctx context.Context, disk *protos.DiskIdentifier, op workload.Op, start time.Time) ThroughputResult {
+	result := ThroughputResult{Timestamp: start, Connection: -1}
+
+	stats, err := client.Read(ctx, vdisk.ReadRequest{
+		Disk:            disk,
+		Offset:          op.Offset,
+		Length:          op.Length,
+		MaxResponseSize: *maxResponseSize,
+	}, nil)
+	result.Duration = time.Since(start)
+	result.Connection = stats.Connection
+	result.Retries = stats.Retries
 	if err != nil {
-		result.Error = fmt.Errorf("failed to create read stream: %v", err)
-		result.Duration = time.Since(start)
+		result.Error = err
 		return result
 	}
 
-	// Send read request with varying offset for load distribution
-	readReq := &protos.VDiskReadArg{
-		DiskId:          createDiskIdentifier(),
-		Offset:          func() *int64 { offset := *readOffset + (operationID%1000)*1024; return &offset }(),
-		Length:          readLength,
-		MaxResponseSize: maxResponseSize,
-	}
+	result.Success = true
+	result.BytesRead = stats.BytesRead
+	return result
+}
+
+// performThroughputWrite performs a write operation for throughput testing
+func performThroughputWrite(client *vdisk.Client, ctx context.Context, disk *protos.DiskIdentifier, op workload.Op, operationID int64, start time.Time) ThroughputResult {
+	result := ThroughputResult{Timestamp: start, Connection: -1}
 
-	err = stream.Send(readReq)
+	req, err := newWriteRequest(disk, op.Offset, op.Length, *sequenceNumber+operationID)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to send read request: %v", err)
+		result.Error = err
 		result.Duration = time.Since(start)
 		return result
 	}
 
-	var totalBytesRead int64 = 0
-	for {
-		response, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			result.Error = fmt.Errorf("stream error: %v", err)
-			result.Duration = time.Since(start)
-			return result
-		}
-
-		if response.ErrorMessage != nil {
-			errorMsg := *response.ErrorMessage
-			if errorMsg != "" && errorMsg != "Read operation successful" &&
-				errorMsg != "Operation completed successfully" {
-				result.Error = fmt.Errorf("server error: %s", errorMsg)
-				result.Duration = time.Since(start)
-				return result
-			}
-		}
-
-		totalBytesRead += int64(len(response.Data))
-
-		if response.HasMoreData != nil && !*response.HasMoreData {
-			break
-		}
-	}
-
-	err = stream.CloseSend()
+	stats, err := client.Write(ctx, req, nil)
+	result.Duration = time.Since(start)
+	result.Connection = stats.Connection
+	result.Retries = stats.Retries
 	if err != nil {
-		result.Error = fmt.Errorf("failed to close send stream: %v", err)
-		result.Duration = time.Since(start)
+		result.Error = err
 		return result
 	}
 
 	result.Success = true
-	result.BytesRead = totalBytesRead
-	result.Duration = time.Since(start)
+	result.BytesWritten = stats.BytesWritten
+	result.PayloadBytes = stats.PayloadBytes
+	result.WireBytes = stats.WireBytes
 	return result
 }
 
-// performThroughputWrite performs a write operation for throughput testing
-func performThroughputWrite(client protos.StargateVDiskRpcSvcClient, ctx context.Context, operationID int64, start time.Time) ThroughputResult {
-	result := ThroughputResult{Timestamp: start}
+// runThroughputTest runs continuous throughput testing for specified duration
+func runThroughputTest(client *vdisk.Client) error {
+	if *vdiskServerAddress == "" && !*fakeServer {
+		return fmt.Errorf("vdisk_server address is required")
+	}
 
-	stream, err := client.VDiskStreamWrite(ctx)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to create write stream: %v", err)
-		result.Duration = time.Since(start)
-		return result
+	if *vdiskOperation == "" {
+		return fmt.Errorf("vdisk_operation is required (read, write or mixed)")
 	}
 
-	// Create write request with varying offset and sequence for load distribution
-	writeReq := &protos.VDiskWriteArg{
-		DiskId: createDiskIdentifier(),
-		RangeVec: []*protos.DiskDataRange{
-			{
-				Offset:   func() *int64 { offset := *writeOffset + (operationID%1000)*1024; return &offset }(),
-				Length:   writeLength,
-				ZeroData: func() *bool { b := false; return &b }(),
-			},
-		},
-		CompressionType: func() *protos.CompressionType { ct := getCompressionType(*compressionType); return &ct }(),
-		ChecksumType:    func() *protos.ChecksumType { ct := getChecksumType(*checksumType); return &ct }(),
-		Data:            []byte(fmt.Sprintf("%s_throughput_%d", *writeData, operationID)),
-		SequenceNumber:  func() *int64 { seq := *sequenceNumber + operationID; return &seq }(),
+	// Validate disk identifier
+	if *diskRecoveryPointUuid == "" && *vmDiskUuid == "" && *vgDiskUuid == "" {
+		return fmt.Errorf("one of disk_recovery_point_uuid, vm_disk_uuid, or vg_disk_uuid is required")
 	}
 
-	err = stream.Send(writeReq)
+	disk := createDiskIdentifier()
+	gen, err := newWorkloadGenerator(client, disk)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to send write request: %v", err)
-		result.Duration = time.Since(start)
-		return result
+		return err
 	}
 
-	err = stream.CloseSend()
-	if err != nil {
-		result.Error = fmt.Errorf("failed to close send stream: %v", err)
-		result.Duration = time.Since(start)
-		return result
+	job := &throughputJob{
+		Operation:       *vdiskOperation,
+		Disk:            disk,
+		Generator:       gen,
+		Duration:        *testDuration,
+		MaxConcurrent:   *maxConcurrent,
+		TargetRPS:       *targetRPS,
+		TargetMBps:      *targetMBps,
+		PipelineWindow:  *pipelineWindow,
+		PipelineStreams: *pipelineStreams,
+		MetricsCSV:      *metricsCSVPath,
+		LatencyFile:     *latencyHistogramFile,
+		RampTime:        *warmup,
 	}
 
-	var totalBytesWritten int64 = 0
-	for {
-		response, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			result.Error = fmt.Errorf("stream error: %v", err)
-			result.Duration = time.Since(start)
-			return result
+	// Ramp-up and step-load stages replace the steady run of -test_duration
+	phases, err := newLoadPhases(gen.Profile().MeanBlockSize())
+	if err != nil {
+		return err
+	}
+	if phases != nil {
+		job.Phases = phases
+		job.TargetRPS, job.TargetMBps = 0, 0
+		job.Duration = loadPlan{phases: phases}.duration()
+		job.MaxConcurrent = 0
+		for _, phase := range phases {
+			job.MaxConcurrent = max(job.MaxConcurrent, phase.FromConcurrency, phase.ToConcurrency)
 		}
+	}
+	report := newRunReport("throughput")
+	metrics, err := runThroughputJob(context.Background(), client, job)
+	if metrics == nil {
+		return report.finish(client, err)
+	}
 
-		if response.ErrorMessage != nil {
-			errorMsg := *response.ErrorMessage
-			if errorMsg != "" && errorMsg != "Write operation successful" &&
-				errorMsg != "Operation completed successfully" {
-				result.Error = fmt.Errorf("server error: %s", errorMsg)
-				result.Duration = time.Since(start)
-				return result
-			}
-		}
+	// Print final results
+	printFinalThroughputResults(metrics)
 
-		if response.BytesWritten != nil {
-			totalBytesWritten += *response.BytesWritten
-		}
+	// Print connection distribution report
+	printConnectionDistribution(client)
+
+	report.Jobs = []*throughputReport{newThroughputReport(metrics)}
+	return report.finish(client, err)
+}
+
+// throughputJob is one throughput test: the workload, the disk it runs
+// against and how hard to drive it. The flag-driven throughput mode runs a
+// single job and job files run several.
+type throughputJob struct {
+	Name      string // Labels reports and output files; empty for the flag-driven test
+	Operation string // read, write or mixed
+	Disk      *protos.DiskIdentifier
+	Generator *workload.Generator
+
+	Duration      time.Duration
+	RampTime      time.Duration // Run before Duration without recording results
+	Phases        []loadPhase   // Measured phases replacing Duration, empty for a steady load
+	MaxConcurrent int
+	TargetRPS     float64
+	TargetMBps    float64
+
+	PipelineWindow  int
+	PipelineStreams int
+
+	MetricsCSV  string // Per-second CSV path, empty to disable
+	LatencyFile string // Latency histogram file, empty to disable
+
+	// Quiet suppresses progress messages and intermediate reports, for
+	// callers that report on many jobs at once. OnStart, when set, receives
+	// the live metrics as soon as the job starts.
+	Quiet   bool
+	OnStart func(*ThroughputMetrics)
+}
+
+// label returns the prefix used for the job in progress messages
+func (j *throughputJob) label() string {
+	if j.Name == "" {
+		return ""
 	}
+	return "[" + j.Name + "] "
+}
 
-	result.Success = true
-	result.BytesWritten = totalBytesWritten
-	result.Duration = time.Since(start)
-	return result
+// loadPlan returns the warm-up and phases of the job. Without explicit
+// phases the job runs for Duration at MaxConcurrent requests or at rate.
+func (j *throughputJob) loadPlan(rate float64) loadPlan {
+	phases := j.Phases
+	if len(phases) == 0 {
+		phases = []loadPhase{{
+			Duration:        j.Duration,
+			FromConcurrency: j.MaxConcurrent,
+			ToConcurrency:   j.MaxConcurrent,
+			FromRate:        rate,
+			ToRate:          rate,
+		}}
+	}
+	return loadPlan{warmup: j.RampTime, phases: phases}
 }
 
-// runThroughputTest runs continuous throughput testing for specified duration
-func runThroughputTest() error {
-	if *vdiskServerAddress == "" {
-		return fmt.Errorf("vdisk_server address is required")
+// logf prints a progress message for the job unless it is quiet
+func (j *throughputJob) logf(format string, args ...any) {
+	if j.Quiet {
+		return
 	}
+	fmt.Fprint(logOutput, j.label())
+	fmt.Fprintf(logOutput, format, args...)
+}
 
-	if *vdiskOperation == "" {
-		return fmt.Errorf("vdisk_operation is required (read or write)")
+// runThroughputJob runs job until its duration elapses or ctx is done and
+// returns its metrics. A non-nil error with metrics means the job was
+// aborted after it started.
+func runThroughputJob(parent context.Context, client *vdisk.Client, job *throughputJob) (*ThroughputMetrics, error) {
+	gen := job.Generator
+	job.logf("Starting throughput test: %s operations for %v\n", job.Operation, job.Duration)
+	if job.RampTime > 0 {
+		job.logf("Warm-up: %v (not recorded)\n", job.RampTime)
 	}
+	job.logf("Workload: %s\n", gen.Profile())
+	job.logf("Max concurrent requests: %d\n", job.MaxConcurrent)
+	job.logf("Report interval: %v\n", *reportInterval)
 
-	// Validate disk identifier
-	if *diskRecoveryPointUuid == "" && *vmDiskUuid == "" && *vgDiskUuid == "" {
-		return fmt.Errorf("one of disk_recovery_point_uuid, vm_disk_uuid, or vg_disk_uuid is required")
+	rate, err := openLoopRate(job.TargetRPS, job.TargetMBps, gen.Profile().MeanBlockSize())
+	if err != nil {
+		return nil, err
+	}
+	if rate > 0 {
+		job.logf("Target rate: %.2f requests/sec (open loop)\n", rate)
 	}
+	plan := job.loadPlan(rate)
+	for i, phase := range job.Phases {
+		job.logf("Phase %d/%d: %s, %s for %v\n", i+1, len(job.Phases), phase.Name, phase, phase.Duration)
+	}
+	rate = plan.meanRate()
 
-	if *vdiskOperation == "write" && *writeData == "" {
-		return fmt.Errorf("write_data is required for write operation")
+	// Optional long-lived streams shared by all requests
+	var sessions *pipelineSessions
+	if job.PipelineWindow > 0 {
+		sessions, err = newPipelineSessions(client, job.Operation, job.PipelineStreams, job.PipelineWindow)
+		if err != nil {
+			return nil, err
+		}
+		defer sessions.Close()
 	}
 
-	fmt.Printf("Starting throughput test: %s operations for %v\n", *vdiskOperation, *testDuration)
-	fmt.Printf("Max concurrent requests: %d\n", *maxConcurrent)
-	fmt.Printf("Report interval: %v\n", *reportInterval)
+	// In open-loop mode requests start on a fixed schedule; when
+	// max_concurrent requests are already in flight the generator falls
+	// behind and the delay is charged to the late requests' latency
+	start := time.Now()
+	var schedule *loadSchedule
+	if rate > 0 {
+		if schedule, err = newLoadSchedule(start, plan); err != nil {
+			return nil, err
+		}
+	}
 
-	// Metrics tracking
-	var metrics ThroughputMetrics
-	metrics.StartTime = time.Now()
-	metrics.MinLatency = time.Hour // Initialize to high value
+	// Metrics tracking; results start counting once the ramp time is over
+	metrics := &ThroughputMetrics{
+		Job:            job.Name,
+		Disk:           vdisk.DiskKey(job.Disk),
+		Operation:      job.Operation,
+		MaxConcurrent:  job.MaxConcurrent,
+		PipelineWindow: job.PipelineWindow,
+	}
+	metrics.StartTime = start.Add(job.RampTime)
+	metrics.Latency = histogram.NewLatency()
+	metrics.TargetRate = rate
+	if rate > 0 {
+		metrics.ServiceLatency = histogram.NewLatency()
+	}
+	metrics.Workload = gen.Profile().String()
+	if job.Operation == "mixed" {
+		metrics.Operations = map[string]*OperationMetrics{
+			workload.OpRead:  {Latency: histogram.NewLatency()},
+			workload.OpWrite: {Latency: histogram.NewLatency()},
+		}
+	}
+	if len(job.Phases) > 0 {
+		metrics.Phases = newPhaseMetrics(metrics, plan)
+	}
+	exporter.track(metrics)
 
 	// Semaphore to limit concurrent requests
-	semaphore := make(chan struct{}, *maxConcurrent)
+	semaphore := make(chan struct{}, job.MaxConcurrent)
 
 	// Channel for results
-	resultChan := make(chan ThroughputResult, *maxConcurrent*2)
+	resultChan := make(chan ThroughputResult, job.MaxConcurrent*2)
 
 	// WaitGroup for goroutines
 	var wg sync.WaitGroup
 
 	// Context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), *testDuration)
+	ctx, cancel := context.WithTimeout(parent, job.RampTime+plan.duration())
 	defer cancel()
 
+	// A gate holds back semaphore slots while the phases call for fewer
+	// requests in flight than the semaphore allows
+	var gate *concurrencyGate
+	gateDone := make(chan struct{})
+	if plan.varies() {
+		gate = &concurrencyGate{slots: semaphore}
+		limit, _ := plan.at(0)
+		gate.set(ctx, limit)
+		go func() {
+			defer close(gateDone)
+			gate.run(ctx, plan, start)
+		}()
+	}
+
+	// abort stops the test early, recording the first reason given
+	var abortErr error
+	var abortOnce sync.Once
+	abort := func(err error) {
+		abortOnce.Do(func() {
+			abortErr = err
+			fmt.Fprintf(logOutput, "%sAborting throughput test: %v\n", job.label(), err)
+			cancel()
+		})
+	}
+
 	// Optional CSV logger for per-second throughput
 	var logger *csvLogger
-	var err error
-	if metricsCSVPath != nil && strings.TrimSpace(*metricsCSVPath) != "" {
-		logger, err = newCSVLogger(*metricsCSVPath, *metricsIntervalSec)
+	if strings.TrimSpace(job.MetricsCSV) != "" {
+		logger, err = newCSVLogger(job.MetricsCSV, *metricsIntervalSec)
 		if err != nil {
-			fmt.Printf("Warning: could not open metrics CSV '%s': %v\n", *metricsCSVPath, err)
+			fmt.Fprintf(logOutput, "Warning: could not open metrics CSV '%s': %v\n", job.MetricsCSV, err)
 		} else {
 			// Run logger goroutine bound to the same context
 			wg.Add(1)
-			go logger.run(ctx, &metrics, &wg)
+			go func() {
+				if !waitUntil(ctx, metrics.StartTime) {
+					wg.Done()
+					return
+				}
+				logger.run(ctx, metrics, &wg)
+			}()
 			defer logger.Close()
 		}
 	}
 
+	// Optional time series for the JSON report
+	if *reportJSON != "" {
+		wg.Add(1)
+		go func() {
+			if !waitUntil(ctx, metrics.StartTime) {
+				wg.Done()
+				return
+			}
+			recordTimeSeries(ctx, metrics, time.Duration(max(*metricsIntervalSec, 1))*time.Second, &wg)
+		}()
+	}
+
+	// Optional latency histogram file
+	var latencyFile *latencyLog
+	if job.LatencyFile != "" {
+		latencyFile, err = newLatencyLog(job.LatencyFile, *latencyHistogramFormat, *metricsIntervalSec, metrics)
+		if err != nil {
+			return nil, err
+		}
+		wg.Add(1)
+		go func() {
+			if !waitUntil(ctx, metrics.StartTime) {
+				wg.Done()
+				return
+			}
+			latencyFile.run(ctx, metrics, &wg)
+		}()
+	}
+
 	// Operation counter
 	var operationID int64 = 0
 
@@ -1251,48 +1042,83 @@ func runThroughputTest() error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		processThroughputResults(resultChan, &metrics)
+		processThroughputResults(resultChan, metrics, abort)
 	}()
 
 	// Start periodic reporting goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		reportThroughputPeriodically(ctx, &metrics)
-	}()
+	if !job.Quiet {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if waitUntil(ctx, metrics.StartTime) {
+				reportThroughputPeriodically(ctx, metrics)
+			}
+		}()
+	}
+	if job.OnStart != nil {
+		job.OnStart(metrics)
+	}
 
 	// Main request generation loop
-	fmt.Println("Throughput test started...")
-	var activeSemaphores int64 // Thread-safe counter using atomic operations
-	
+	job.logf("Throughput test started...\n")
+
 	for {
+		var intended time.Time
+		if schedule != nil {
+			var ok bool
+			if intended, ok = schedule.wait(ctx); !ok {
+				job.logf("Test duration completed, stopping new requests...\n")
+				goto cleanup
+			}
+		}
+
 		select {
 		case <-ctx.Done():
-			fmt.Println("Test duration completed, stopping new requests...")
+			job.logf("Test duration completed, stopping new requests...\n")
 			goto cleanup
 		case semaphore <- struct{}{}:
+			if schedule != nil {
+				schedule.advance()
+			}
 			opID := atomic.AddInt64(&operationID, 1)
-			activeCount := atomic.AddInt64(&activeSemaphores, 1) // Increment active count
+			activeCount := atomic.AddInt64(&metrics.InFlight, 1) // Increment active count
 			go func(id int64, currentActive int64) {
-				defer func() { 
-					atomic.AddInt64(&activeSemaphores, -1) // Decrement when done
-					<-semaphore // Release semaphore slot
+				defer func() {
+					atomic.AddInt64(&metrics.InFlight, -1) // Decrement when done
+					<-semaphore                            // Release semaphore slot
 				}()
-				result := runThroughputSingleOperation(*vdiskServerAddress, id, currentActive)
+				result := runThroughputSingleOperation(client, job, sessions, id, currentActive, intended)
 				resultChan <- result
 			}(opID, activeCount)
-		default:
-			// Semaphore is full, add small delay to prevent tight loop
-			time.Sleep(1 * time.Millisecond)
 		}
 	}
 
 cleanup:
+	if schedule != nil {
+		end := time.Now()
+		if deadline := metrics.StartTime.Add(plan.duration()); end.After(deadline) {
+			end = deadline
+		}
+		schedule.pending(end, func(due time.Time) {
+			metrics.UnsentRequests++
+			if phase := metrics.phaseAt(due); phase != nil {
+				phase.UnsentRequests++
+			}
+		})
+	}
+
+	// Slots held by the gate are not in use by requests
+	held := 0
+	if gate != nil {
+		<-gateDone
+		held = gate.reserved
+	}
+
 	// Wait for remaining operations to complete (with timeout)
 	done := make(chan struct{})
 	go func() {
 		// Wait for semaphore to be empty (all operations done)
-		for i := 0; i < *maxConcurrent; i++ {
+		for i := held; i < job.MaxConcurrent; i++ {
 			semaphore <- struct{}{}
 		}
 		close(done)
@@ -1300,9 +1126,9 @@ cleanup:
 
 	select {
 	case <-done:
-		fmt.Println("All operations completed")
+		job.logf("All operations completed\n")
 	case <-time.After(30 * time.Second):
-		fmt.Println("Timeout waiting for operations to complete")
+		fmt.Fprintf(logOutput, "%sTimeout waiting for operations to complete\n", job.label())
 	}
 
 	// Signal completion and wait for processors
@@ -1318,44 +1144,118 @@ cleanup:
 		metrics.RequestsPerSecond = float64(metrics.TotalRequests) / metrics.TotalDuration.Seconds()
 		metrics.BytesPerSecond = float64(metrics.TotalBytes) / metrics.TotalDuration.Seconds()
 	}
+	finishPhases(metrics)
 
-	// Print final results
-	printFinalThroughputResults(&metrics)
-
-	// Print connection distribution report
-	printConnectionDistribution()
+	if latencyFile != nil {
+		latencyFile.Close(metrics)
+	}
+	return metrics, abortErr
+}
 
-	// Cleanup connection pool
-	fmt.Println("Cleaning up connection pool...")
-	cleanupConnectionPool()
+// waitUntil blocks until t, reporting false if ctx is done first
+func waitUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
 
-	return nil
+// printOperationBreakdown prints per-operation results of a mixed workload
+func printOperationBreakdown(metrics *ThroughputMetrics) {
+	fmt.Fprintf(logOutput, "\nOperation Breakdown:\n")
+	for _, name := range []string{workload.OpRead, workload.OpWrite} {
+		op := metrics.Operations[name]
+		var share float64
+		if metrics.TotalRequests > 0 {
+			share = float64(op.Requests) / float64(metrics.TotalRequests) * 100
+		}
+		fmt.Fprintf(logOutput, "  %s: %d requests (%.2f%%), %d failed, %.2f MB\n",
+			name, op.Requests, share, op.Failed, float64(op.Bytes)/(1024*1024))
+		if op.Latency.Count() > 0 {
+			fmt.Fprintf(logOutput, "    Latency: avg=%v, %s\n", time.Duration(op.Latency.Mean()), formatPercentiles(op.Latency))
+		}
+	}
 }
 
-// processThroughputResults processes incoming results and updates metrics
-func processThroughputResults(resultChan <-chan ThroughputResult, metrics *ThroughputMetrics) {
+// processThroughputResults processes incoming results and updates metrics,
+// calling abort for failures that must stop the test
+func processThroughputResults(resultChan <-chan ThroughputResult, metrics *ThroughputMetrics, abort func(error)) {
 	for result := range resultChan {
-		atomic.AddInt64(&metrics.TotalRequests, 1)
-
-		if result.Success {
-			atomic.AddInt64(&metrics.SuccessfulRequests, 1)
-			atomic.AddInt64(&metrics.TotalBytes, result.BytesRead+result.BytesWritten)
+		exporter.observe(metrics, result)
+		if result.Timestamp.Before(metrics.StartTime) {
+			atomic.AddInt64(&metrics.WarmupRequests, 1)
+			continue
+		}
+		recordThroughputResult(metrics, result)
+		if phase := metrics.phaseAt(result.Timestamp); phase != nil {
+			recordThroughputResult(phase, result)
+		}
 
-			// Update latency metrics (not thread-safe, but close enough for reporting)
-			if metrics.MinLatency == 0 || result.Duration < metrics.MinLatency {
-				metrics.MinLatency = result.Duration
+		if !result.Success {
+			// Failures are broken down by kind in the reports; only the first
+			// of each kind is printed in full
+			if kind := errorType(result.Error); metrics.Errors.count(kind) == 1 {
+				if metrics.Job != "" {
+					fmt.Fprintf(logOutput, "[%s] First %s failure: %v\n", metrics.Job, kind, result.Error)
+				} else {
+					fmt.Fprintf(logOutput, "First %s failure: %v\n", kind, result.Error)
+				}
 			}
-			if result.Duration > metrics.MaxLatency {
-				metrics.MaxLatency = result.Duration
+			if kind, ok := vdisk.ServerKind(result.Error); *failOnChecksum && ok && kind == vdisk.ServerChecksumMismatch {
+				abort(result.Error)
 			}
-			metrics.TotalLatency += result.Duration
-		} else {
-			atomic.AddInt64(&metrics.FailedRequests, 1)
-			fmt.Printf("Operation failed: %v\n", result.Error)
 		}
 	}
 }
 
+// recordThroughputResult adds one result to metrics
+func recordThroughputResult(metrics *ThroughputMetrics, result ThroughputResult) {
+	atomic.AddInt64(&metrics.TotalRequests, 1)
+	atomic.AddInt64(&metrics.Retries, int64(result.Retries))
+	if metrics.TargetRate > 0 {
+		recordDispatch(metrics, result.ScheduleLag)
+	}
+	op := metrics.Operations[result.Operation]
+	if op != nil {
+		atomic.AddInt64(&op.Requests, 1)
+	}
+
+	if !result.Success {
+		atomic.AddInt64(&metrics.FailedRequests, 1)
+		metrics.Errors.add(errorType(result.Error), 1)
+		if op != nil {
+			atomic.AddInt64(&op.Failed, 1)
+		}
+		return
+	}
+
+	atomic.AddInt64(&metrics.SuccessfulRequests, 1)
+	atomic.AddInt64(&metrics.TotalBytes, result.BytesRead+result.BytesWritten)
+	atomic.AddInt64(&metrics.PayloadBytes, result.PayloadBytes)
+	atomic.AddInt64(&metrics.WireBytes, result.WireBytes)
+
+	latency := result.Duration + result.ScheduleLag
+	metrics.Latency.RecordDuration(latency)
+	if metrics.IntervalLatency != nil {
+		metrics.IntervalLatency.RecordDuration(latency)
+	}
+	if metrics.ServiceLatency != nil {
+		metrics.ServiceLatency.RecordDuration(result.Duration)
+	}
+	if op != nil {
+		atomic.AddInt64(&op.Bytes, result.BytesRead+result.BytesWritten)
+		op.Latency.RecordDuration(latency)
+	}
+}
+
 // reportThroughputPeriodically prints intermediate throughput reports
 func reportThroughputPeriodically(ctx context.Context, metrics *ThroughputMetrics) {
 	ticker := time.NewTicker(*reportInterval)
@@ -1386,72 +1286,162 @@ func printIntermediateThroughputReport(metrics *ThroughputMetrics) {
 		bps = float64(totalBytes) / elapsed.Seconds()
 	}
 
-	fmt.Printf("\n=== Intermediate Throughput Report (Elapsed: %v) ===\n", elapsed.Truncate(time.Second))
-	fmt.Printf("Total Requests: %d\n", totalReqs)
-	fmt.Printf("Successful: %d, Failed: %d\n", successReqs, failedReqs)
-	fmt.Printf("Requests/sec: %.2f\n", rps)
-	fmt.Printf("Bytes/sec: %.2f (%.2f MB/s)\n", bps, bps/(1024*1024))
-	fmt.Printf("Total Data: %d bytes (%.2f MB)\n", totalBytes, float64(totalBytes)/(1024*1024))
+	if metrics.Job != "" {
+		fmt.Fprintf(logOutput, "\n=== Intermediate Throughput Report [%s] (Elapsed: %v) ===\n", metrics.Job, elapsed.Truncate(time.Second))
+	} else {
+		fmt.Fprintf(logOutput, "\n=== Intermediate Throughput Report (Elapsed: %v) ===\n", elapsed.Truncate(time.Second))
+	}
+	fmt.Fprintf(logOutput, "Total Requests: %d\n", totalReqs)
+	fmt.Fprintf(logOutput, "Successful: %d, Failed: %d\n", successReqs, failedReqs)
+	if retries := atomic.LoadInt64(&metrics.Retries); retries > 0 {
+		fmt.Fprintf(logOutput, "Retries: %d\n", retries)
+	}
+	if categories := categoryCounts(metrics.Errors.snapshot()); categories != nil {
+		parts := make([]string, 0, len(categories))
+		for _, category := range sortedErrorTypes(categories) {
+			parts = append(parts, fmt.Sprintf("%s=%d", category, categories[category]))
+		}
+		fmt.Fprintf(logOutput, "Failures by category: %s\n", strings.Join(parts, ", "))
+	}
+	fmt.Fprintf(logOutput, "Requests/sec: %.2f\n", rps)
+	fmt.Fprintf(logOutput, "Bytes/sec: %.2f (%.2f MB/s)\n", bps, bps/(1024*1024))
+	fmt.Fprintf(logOutput, "Total Data: %d bytes (%.2f MB)\n", totalBytes, float64(totalBytes)/(1024*1024))
 
 	if successReqs > 0 {
-		avgLatency := metrics.TotalLatency / time.Duration(successReqs)
-		fmt.Printf("Avg Latency: %v\n", avgLatency)
-		fmt.Printf("Min Latency: %v, Max Latency: %v\n", metrics.MinLatency, metrics.MaxLatency)
+		fmt.Fprintf(logOutput, "Avg Latency: %v\n", time.Duration(metrics.Latency.Mean()))
+		fmt.Fprintf(logOutput, "Min Latency: %v, Max Latency: %v\n",
+			time.Duration(metrics.Latency.Min()), time.Duration(metrics.Latency.Max()))
+		fmt.Fprintf(logOutput, "Latency Percentiles: %s\n", formatPercentiles(metrics.Latency))
+	}
+	if metrics.Operations != nil {
+		fmt.Fprintf(logOutput, "Reads: %d, Writes: %d\n",
+			atomic.LoadInt64(&metrics.Operations[workload.OpRead].Requests),
+			atomic.LoadInt64(&metrics.Operations[workload.OpWrite].Requests))
+	}
+	if metrics.TargetRate > 0 {
+		fmt.Fprintf(logOutput, "Target Rate: %.2f req/s, Missed Schedule: %d, Max Dispatch Lag: %v\n",
+			metrics.TargetRate, atomic.LoadInt64(&metrics.MissedSchedule),
+			time.Duration(atomic.LoadInt64(&metrics.MaxScheduleLag)))
+	}
+	if metrics.Phases != nil {
+		printCurrentPhase(metrics)
+	}
+	fmt.Fprintln(logOutput, "========================================")
+}
+
+// printErrorBreakdown prints failure counts by errorType grouped by
+// category, most frequent first
+func printErrorBreakdown(indent string, counts map[string]int64) {
+	categories := categoryCounts(counts)
+	codes := map[string][]string{}
+	for _, kind := range sortedErrorTypes(counts) {
+		category, code := splitErrorType(kind)
+		codes[category] = append(codes[category], fmt.Sprintf("%s: %d", code, counts[kind]))
+	}
+	for _, category := range sortedErrorTypes(categories) {
+		fmt.Fprintf(logOutput, "%s%s: %d (%s)\n", indent, category, categories[category], strings.Join(codes[category], ", "))
 	}
-	fmt.Println("========================================")
 }
 
 // printFinalThroughputResults prints comprehensive final throughput results
 func printFinalThroughputResults(metrics *ThroughputMetrics) {
-	fmt.Printf("\n" + strings.Repeat("=", 60) + "\n")
-	fmt.Printf("FINAL THROUGHPUT TEST RESULTS\n")
-	fmt.Printf(strings.Repeat("=", 60) + "\n")
+	fmt.Fprintf(logOutput, "\n"+strings.Repeat("=", 60)+"\n")
+	if metrics.Job != "" {
+		fmt.Fprintf(logOutput, "FINAL THROUGHPUT TEST RESULTS [%s]\n", metrics.Job)
+	} else {
+		fmt.Fprintf(logOutput, "FINAL THROUGHPUT TEST RESULTS\n")
+	}
+	fmt.Fprintf(logOutput, strings.Repeat("=", 60)+"\n")
 
-	fmt.Printf("Test Duration: %v\n", metrics.TotalDuration.Truncate(time.Second))
-	fmt.Printf("Operation Type: %s\n", *vdiskOperation)
-	fmt.Printf("Max Concurrent: %d\n", *maxConcurrent)
+	fmt.Fprintf(logOutput, "Test Duration: %v\n", metrics.TotalDuration.Truncate(time.Second))
+	fmt.Fprintf(logOutput, "Operation Type: %s\n", metrics.Operation)
+	fmt.Fprintf(logOutput, "Workload: %s\n", metrics.Workload)
+	fmt.Fprintf(logOutput, "Max Concurrent: %d\n", metrics.MaxConcurrent)
+	if metrics.PipelineWindow > 0 {
+		fmt.Fprintf(logOutput, "Pipeline Window: %d per stream\n", metrics.PipelineWindow)
+	}
+	if metrics.WarmupRequests > 0 {
+		fmt.Fprintf(logOutput, "Warm-up Requests (not counted): %d\n", metrics.WarmupRequests)
+	}
+	if metrics.TargetRate > 0 {
+		printScheduleStats(metrics)
+	}
 
-	fmt.Printf("\nRequest Statistics:\n")
-	fmt.Printf("  Total Requests: %d\n", metrics.TotalRequests)
-	fmt.Printf("  Successful: %d (%.2f%%)\n", metrics.SuccessfulRequests,
+	fmt.Fprintf(logOutput, "\nRequest Statistics:\n")
+	fmt.Fprintf(logOutput, "  Total Requests: %d\n", metrics.TotalRequests)
+	fmt.Fprintf(logOutput, "  Successful: %d (%.2f%%)\n", metrics.SuccessfulRequests,
 		float64(metrics.SuccessfulRequests)/float64(metrics.TotalRequests)*100)
-	fmt.Printf("  Failed: %d (%.2f%%)\n", metrics.FailedRequests,
+	fmt.Fprintf(logOutput, "  Failed: %d (%.2f%%)\n", metrics.FailedRequests,
 		float64(metrics.FailedRequests)/float64(metrics.TotalRequests)*100)
+	printErrorBreakdown("    ", metrics.Errors.snapshot())
+	if metrics.Retries > 0 {
+		fmt.Fprintf(logOutput, "  Retries: %d (%.2f per request)\n", metrics.Retries,
+			float64(metrics.Retries)/float64(metrics.TotalRequests))
+	}
+
+	fmt.Fprintf(logOutput, "\nThroughput Metrics:\n")
+	fmt.Fprintf(logOutput, "  Requests/sec: %.2f\n", metrics.RequestsPerSecond)
+	fmt.Fprintf(logOutput, "  Bytes/sec: %.2f\n", metrics.BytesPerSecond)
+	fmt.Fprintf(logOutput, "  MB/sec: %.2f\n", metrics.BytesPerSecond/(1024*1024))
+	fmt.Fprintf(logOutput, "  GB/sec: %.4f\n", metrics.BytesPerSecond/(1024*1024*1024))
 
-	fmt.Printf("\nThroughput Metrics:\n")
-	fmt.Printf("  Requests/sec: %.2f\n", metrics.RequestsPerSecond)
-	fmt.Printf("  Bytes/sec: %.2f\n", metrics.BytesPerSecond)
-	fmt.Printf("  MB/sec: %.2f\n", metrics.BytesPerSecond/(1024*1024))
-	fmt.Printf("  GB/sec: %.4f\n", metrics.BytesPerSecond/(1024*1024*1024))
+	fmt.Fprintf(logOutput, "\nData Transfer:\n")
+	fmt.Fprintf(logOutput, "  Total Bytes: %d\n", metrics.TotalBytes)
+	fmt.Fprintf(logOutput, "  Total MB: %.2f\n", float64(metrics.TotalBytes)/(1024*1024))
+	fmt.Fprintf(logOutput, "  Total GB: %.4f\n", float64(metrics.TotalBytes)/(1024*1024*1024))
 
-	fmt.Printf("\nData Transfer:\n")
-	fmt.Printf("  Total Bytes: %d\n", metrics.TotalBytes)
-	fmt.Printf("  Total MB: %.2f\n", float64(metrics.TotalBytes)/(1024*1024))
-	fmt.Printf("  Total GB: %.4f\n", float64(metrics.TotalBytes)/(1024*1024*1024))
+	if metrics.Operation != "read" && *compressionType != "none" && metrics.WireBytes > 0 {
+		fmt.Fprintf(logOutput, "\nCompression (%s):\n", *compressionType)
+		fmt.Fprintf(logOutput, "  Payload Bytes: %d\n", metrics.PayloadBytes)
+		fmt.Fprintf(logOutput, "  Wire Bytes: %d\n", metrics.WireBytes)
+		fmt.Fprintf(logOutput, "  Ratio: %.2fx\n", float64(metrics.PayloadBytes)/float64(metrics.WireBytes))
+	}
 
 	if metrics.SuccessfulRequests > 0 {
-		avgLatency := metrics.TotalLatency / time.Duration(metrics.SuccessfulRequests)
-		fmt.Printf("\nLatency Statistics:\n")
-		fmt.Printf("  Average: %v\n", avgLatency)
-		fmt.Printf("  Minimum: %v\n", metrics.MinLatency)
-		fmt.Printf("  Maximum: %v\n", metrics.MaxLatency)
+		if metrics.TargetRate > 0 {
+			fmt.Fprintf(logOutput, "\nLatency Statistics (from intended start):\n")
+		} else {
+			fmt.Fprintf(logOutput, "\nLatency Statistics:\n")
+		}
+		fmt.Fprintf(logOutput, "  Average: %v\n", time.Duration(metrics.Latency.Mean()))
+		fmt.Fprintf(logOutput, "  Minimum: %v\n", time.Duration(metrics.Latency.Min()))
+		fmt.Fprintf(logOutput, "  Maximum: %v\n", time.Duration(metrics.Latency.Max()))
+		for _, p := range metrics.Latency.Percentiles(histogram.StandardPercentiles) {
+			fmt.Fprintf(logOutput, "  p%g: %v\n", p.Percentile, time.Duration(p.Value))
+		}
+		if metrics.ServiceLatency != nil {
+			fmt.Fprintf(logOutput, "  Service Time (from actual send): avg=%v, %s\n",
+				time.Duration(metrics.ServiceLatency.Mean()), formatPercentiles(metrics.ServiceLatency))
+		}
+
+		if metrics.Operations != nil {
+			printOperationBreakdown(metrics)
+		}
 
-		fmt.Printf("\nEfficiency Metrics:\n")
-		fmt.Printf("  Avg bytes per request: %.2f\n", float64(metrics.TotalBytes)/float64(metrics.SuccessfulRequests))
-		fmt.Printf("  Requests per minute: %.2f\n", metrics.RequestsPerSecond*60)
-		fmt.Printf("  MB per minute: %.2f\n", metrics.BytesPerSecond*60/(1024*1024))
+		fmt.Fprintf(logOutput, "\nEfficiency Metrics:\n")
+		fmt.Fprintf(logOutput, "  Avg bytes per request: %.2f\n", float64(metrics.TotalBytes)/float64(metrics.SuccessfulRequests))
+		fmt.Fprintf(logOutput, "  Requests per minute: %.2f\n", metrics.RequestsPerSecond*60)
+		fmt.Fprintf(logOutput, "  MB per minute: %.2f\n", metrics.BytesPerSecond*60/(1024*1024))
 	}
 
-	fmt.Printf("\n" + strings.Repeat("=", 60) + "\n")
+	if metrics.Phases != nil {
+		printPhaseResults(metrics)
+	}
+
+	fmt.Fprintf(logOutput, "\n"+strings.Repeat("=", 60)+"\n")
 }
 
 func runVDiskOperation() error {
-	if *vdiskServerAddress == "" {
+	if *jobFile != "" {
+		return runJobFile()
+	}
+
+	if *vdiskServerAddress == "" && !*fakeServer {
 		return fmt.Errorf("vdisk_server address is required")
 	}
 
 	if *vdiskOperation == "" {
-		return fmt.Errorf("vdisk_operation is required (read or write)")
+		return fmt.Errorf("vdisk_operation is required (read, write, backup, restore or verify)")
 	}
 
 	// Validate disk identifier
@@ -1459,57 +1449,86 @@ func runVDiskOperation() error {
 		return fmt.Errorf("one of disk_recovery_point_uuid, vm_disk_uuid, or vg_disk_uuid is required")
 	}
 
+	if *vdiskOperation == "mixed" && !*throughputMode {
+		return fmt.Errorf("mixed is only supported in throughput mode")
+	}
+	if (*vdiskOperation == "backup" || *vdiskOperation == "restore" || *vdiskOperation == "verify") && (*batchMode || *throughputMode) {
+		return fmt.Errorf("%s cannot be combined with batch or throughput mode", *vdiskOperation)
+	}
+	if *outputFile != "" && *vdiskOperation != "backup" && (*vdiskOperation != "read" || *batchMode || *throughputMode) {
+		return fmt.Errorf("output_file is only supported for single read and backup operations")
+	}
+	redirectLogsForStdoutImage()
+
+	if *vdiskOperation == "write" || *vdiskOperation == "mixed" {
+		if *throughputMode {
+			if err := workloadWriteLength(); err != nil {
+				return err
+			}
+		}
+		payload, err := loadWritePayload()
+		if err != nil {
+			return err
+		}
+		writePayload = payload
+	}
+
+	if *fakeServer {
+		if err := startFakeServer(); err != nil {
+			return err
+		}
+		defer stopFakeServer()
+	}
+
+	if err := startTracing(); err != nil {
+		return err
+	}
+	defer stopTracing()
+
+	client, err := newVDiskClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		fmt.Fprintln(logOutput, "Cleaning up connection pool...")
+		client.Close()
+	}()
+
 	// Check if throughput mode is enabled
 	if *throughputMode {
-		return runThroughputTest()
+		if err := startMetricsServer(client); err != nil {
+			return err
+		}
+		defer stopMetricsServer()
+		return runThroughputTest(client)
 	}
 
 	// Check if batch mode is enabled
 	if *batchMode {
-		return runBatchVDiskOperations()
+		return runBatchVDiskOperations(client)
 	}
 
 	start := time.Now()
-	var err error
 
 	switch *vdiskOperation {
 	case "read":
-		err = vdiskStreamRead(*vdiskServerAddress)
+		err = vdiskStreamRead(client)
 	case "write":
-		if *writeData == "" {
-			return fmt.Errorf("write_data is required for write operation")
-		}
-		err = vdiskStreamWrite(*vdiskServerAddress)
+		err = vdiskStreamWrite(client)
+	case "backup":
+		err = runBackup(client)
+	case "restore":
+		err = runRestore(client)
+	case "verify":
+		err = runVerify(client)
 	default:
-		return fmt.Errorf("invalid vdisk_operation: %s (must be 'read' or 'write')", *vdiskOperation)
+		return fmt.Errorf("invalid vdisk_operation: %s (must be 'read', 'write', 'backup', 'restore' or 'verify')", *vdiskOperation)
 	}
 
 	if err != nil {
 		return fmt.Errorf("VDisk operation failed: %v", err)
 	}
 
-	fmt.Printf("VDisk %s operation completed in %v\n", *vdiskOperation, time.Since(start))
+	fmt.Fprintf(logOutput, "VDisk %s operation completed in %v\n", *vdiskOperation, time.Since(start))
 	return nil
 }
-
-// cleanupConnectionPool closes all pooled connections
-func cleanupConnectionPool() {
-	connectionMutex.Lock()
-	defer connectionMutex.Unlock()
-
-	fmt.Printf("Cleaning up connection pool (%d connections)...\n", len(connectionPool))
-
-	for i, conn := range connectionPool {
-		if conn != nil {
-			conn.Close()
-			fmt.Printf("Closed connection %d\n", i)
-		}
-	}
-
-	connectionPool = nil
-	connectionUsageCount = nil
-	poolInitialized = false
-	connectionRoundRobin = 0
-
-	fmt.Println("Connection pool cleanup completed")
-}
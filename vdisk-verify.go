@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/histogram"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/verify"
+)
+
+var (
+	// Data verification flags
+	verifyMode       = flag.String("verify_mode", "write-read", "Verify workload: write-read (write the region, then read it back), concurrent (checked reads mixed with writes for test_duration) or read (check blocks written by an earlier run)")
+	verifyBlockSize  = flag.Int64("verify_block_size", 4096, "Size of the self-describing blocks written and checked by the verify workload (multiple of 512)")
+	verifyGeneration = flag.Uint64("verify_generation", 1, "Generation written by the first verify pass, and the generation expected by -verify_mode=read")
+	verifyPasses     = flag.Int("verify_passes", 1, "Number of write-read passes, each writing the next generation")
+	verifyMaxReports = flag.Int("verify_max_reports", 20, "Maximum number of mismatches and I/O errors reported in detail")
+)
+
+// maxConcurrentVerifyBlocks bounds the region of -verify_mode=concurrent,
+// which keeps the acknowledged and issued generation of every block
+const maxConcurrentVerifyBlocks = 16 << 20
+
+// verifyStats counts the I/O and outcomes of a verify run
+type verifyStats struct {
+	BlocksWritten int64
+	WriteErrors   int64
+	BlocksRead    int64
+	ReadErrors    int64
+	Skipped       int64    // Blocks not checked because their last write failed
+	Results       [4]int64 // Checked blocks by verify.Kind
+	WriteLatency  *histogram.Histogram
+	ReadLatency   *histogram.Histogram
+}
+
+// verifier writes and checks the verify blocks of a disk region
+type verifier struct {
+	client    *vdisk.Client
+	disk      *protos.DiskIdentifier
+	diskID    uint64
+	start     int64 // Disk offset of block 0
+	blocks    int64
+	blockSize int64
+	request   vdisk.WriteRequest // Template for block writes
+	seq       int64
+
+	stats verifyStats
+
+	mu       sync.Mutex
+	bad      []verify.Result // First verify_max_reports mismatches
+	ioErrors []string        // First verify_max_reports I/O errors
+}
+
+// offset returns the disk offset of block i
+func (v *verifier) offset(i int64) int64 {
+	return v.start + i*v.blockSize
+}
+
+// write writes generation gen of block i
+func (v *verifier) write(ctx context.Context, i int64, gen uint64) error {
+	off := v.offset(i)
+	block := make([]byte, v.blockSize)
+	if err := verify.Fill(block, verify.Header{DiskID: v.diskID, Offset: off, Generation: gen, BlockSize: int(v.blockSize)}); err != nil {
+		return err
+	}
+
+	req := v.request
+	req.Offset, req.Length, req.Data = off, v.blockSize, block
+	req.SequenceNumber = *sequenceNumber + atomic.AddInt64(&v.seq, 1)
+
+	ctx, cancel := context.WithTimeout(ctx, *requestTimeout)
+	defer cancel()
+	start := time.Now()
+	stats, err := v.client.Write(ctx, req, nil)
+	if err == nil && stats.BytesWritten < v.blockSize {
+		err = fmt.Errorf("server acknowledged %d of %d bytes", stats.BytesWritten, v.blockSize)
+	}
+	if err != nil {
+		atomic.AddInt64(&v.stats.WriteErrors, 1)
+		v.ioError("write", off, err)
+		return err
+	}
+	v.stats.WriteLatency.RecordDuration(time.Since(start))
+	atomic.AddInt64(&v.stats.BlocksWritten, 1)
+	return nil
+}
+
+// read returns the current content of block i
+func (v *verifier) read(ctx context.Context, i int64) ([]byte, error) {
+	off := v.offset(i)
+	block := make([]byte, v.blockSize)
+
+	ctx, cancel := context.WithTimeout(ctx, *requestTimeout)
+	defer cancel()
+	start := time.Now()
+	reader := v.client.NewRangeReader(ctx)
+	defer reader.Close()
+	_, err := reader.ReadRange(v.disk, off, v.blockSize, *maxResponseSize, func(e vdisk.Extent) error {
+		dst := block[e.Offset-off : e.End()-off]
+		if e.Zero {
+			clear(dst)
+		} else {
+			copy(dst, e.Data)
+		}
+		return nil
+	})
+	if err != nil {
+		atomic.AddInt64(&v.stats.ReadErrors, 1)
+		v.ioError("read", off, err)
+		return nil, err
+	}
+	v.stats.ReadLatency.RecordDuration(time.Since(start))
+	atomic.AddInt64(&v.stats.BlocksRead, 1)
+	return block, nil
+}
+
+// check reads block i and checks that it holds a generation from minGen to
+// maxGen
+func (v *verifier) check(ctx context.Context, i int64, minGen, maxGen uint64) {
+	block, err := v.read(ctx, i)
+	if err != nil {
+		return
+	}
+	v.record(verify.Check(block, v.diskID, v.offset(i), minGen, maxGen))
+}
+
+// record counts a check result and keeps the first mismatches for the report
+func (v *verifier) record(r verify.Result) {
+	atomic.AddInt64(&v.stats.Results[r.Kind], 1)
+	if r.Kind == verify.OK {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.bad) < *verifyMaxReports {
+		v.bad = append(v.bad, r)
+	}
+}
+
+func (v *verifier) ioError(op string, off int64, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.ioErrors) < *verifyMaxReports {
+		v.ioErrors = append(v.ioErrors, fmt.Sprintf("%s at offset %d: %v", op, off, err))
+	}
+}
+
+// mismatches returns the number of checks that found a bad block
+func (v *verifier) mismatches() int64 {
+	var n int64
+	for kind := verify.Corrupt; kind <= verify.Misdirected; kind++ {
+		n += atomic.LoadInt64(&v.stats.Results[kind])
+	}
+	return n
+}
+
+// workers returns the number of concurrent requests used on n blocks
+func (v *verifier) workers(n int64) int {
+	return int(min(int64(max(*maxConcurrent, 1)), n))
+}
+
+// sweep calls fn for every block on max_concurrent workers and reports
+// progress every report_interval. It stops early when ctx is done.
+func (v *verifier) sweep(ctx context.Context, name string, fn func(i int64)) {
+	start := time.Now()
+	var next, done int64
+	var wg sync.WaitGroup
+	for w := 0; w < v.workers(v.blocks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				i := atomic.AddInt64(&next, 1) - 1
+				if i >= v.blocks {
+					return
+				}
+				fn(i)
+				atomic.AddInt64(&done, 1)
+			}
+		}()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	ticker := time.NewTicker(*reportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n := atomic.LoadInt64(&done)
+			fmt.Fprintf(logOutput, "[%v] %s: %d/%d blocks (%.1f%%), %d mismatches so far\n",
+				time.Since(start).Round(time.Second), name, n, v.blocks, float64(n)/float64(v.blocks)*100, v.mismatches())
+		case <-finished:
+			elapsed := time.Since(start)
+			n := atomic.LoadInt64(&done)
+			fmt.Fprintf(logOutput, "%s: %d/%d blocks in %v (%.2f MB/s)\n",
+				name, n, v.blocks, elapsed.Round(time.Millisecond), float64(n*v.blockSize)/elapsed.Seconds()/(1024*1024))
+			return
+		}
+	}
+}
+
+// runWriteRead writes every block of the region and then reads all of them
+// back, once per pass with a new generation each time
+func (v *verifier) runWriteRead(ctx context.Context) {
+	for pass := 0; pass < *verifyPasses && ctx.Err() == nil; pass++ {
+		gen := *verifyGeneration + uint64(pass)
+
+		var mu sync.Mutex
+		failed := make(map[int64]bool)
+		v.sweep(ctx, fmt.Sprintf("Writing generation %d", gen), func(i int64) {
+			if v.write(ctx, i, gen) != nil {
+				mu.Lock()
+				failed[i] = true
+				mu.Unlock()
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		v.sweep(ctx, fmt.Sprintf("Verifying generation %d", gen), func(i int64) {
+			mu.Lock()
+			skip := failed[i]
+			mu.Unlock()
+			if skip {
+				atomic.AddInt64(&v.stats.Skipped, 1)
+				return
+			}
+			v.check(ctx, i, gen, gen)
+		})
+	}
+}
+
+// runRead checks every block of the region against -verify_generation
+func (v *verifier) runRead(ctx context.Context) {
+	gen := *verifyGeneration
+	v.sweep(ctx, fmt.Sprintf("Verifying generation %d", gen), func(i int64) {
+		v.check(ctx, i, gen, gen)
+	})
+}
+
+// blockState tracks the writes of one block in -verify_mode=concurrent
+type blockState struct {
+	acked  atomic.Uint64 // Generation of the last acknowledged write
+	issued atomic.Uint64 // Generation of the last write sent
+	busy   atomic.Bool   // Set while a write is in flight, and kept after a failed write
+}
+
+// runConcurrent mixes writes of new generations with checked reads of
+// written blocks for test_duration, then checks every written block. A read
+// accepts any generation from the one acknowledged before it started to the
+// newest one issued when it ended. A block whose write failed is not written
+// again, as the failed write may still land later.
+func (v *verifier) runConcurrent(ctx context.Context) {
+	states := make([]blockState, v.blocks)
+
+	// Requests in flight at the end of the run complete rather than being
+	// cancelled, so they are not reported as I/O errors
+	start := time.Now()
+	end := start.Add(*testDuration)
+	var wg sync.WaitGroup
+	for w := 0; w < v.workers(v.blocks); w++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for ctx.Err() == nil && time.Now().Before(end) {
+				i := rng.Int63n(v.blocks)
+				s := &states[i]
+				if rng.Float64()*100 < *readPercent {
+					if lo := s.acked.Load(); lo > 0 {
+						block, err := v.read(ctx, i)
+						if err == nil {
+							v.record(verify.Check(block, v.diskID, v.offset(i), lo, s.issued.Load()))
+						}
+						continue
+					}
+				}
+				if !s.busy.CompareAndSwap(false, true) {
+					continue
+				}
+				gen := max(s.issued.Load()+1, *verifyGeneration)
+				s.issued.Store(gen)
+				if v.write(ctx, i, gen) == nil {
+					s.acked.Store(gen)
+					s.busy.Store(false)
+				}
+			}
+		}(rand.New(rand.NewSource(*workloadSeed + int64(w))))
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	ticker := time.NewTicker(*reportInterval)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-ticker.C:
+			fmt.Fprintf(logOutput, "[%v] Concurrent verify: %d writes, %d reads, %d mismatches so far\n",
+				time.Since(start).Round(time.Second), atomic.LoadInt64(&v.stats.BlocksWritten),
+				atomic.LoadInt64(&v.stats.BlocksRead), v.mismatches())
+		case <-finished:
+			running = false
+		}
+	}
+	fmt.Fprintf(logOutput, "Concurrent verify: %d writes, %d reads in %v\n",
+		atomic.LoadInt64(&v.stats.BlocksWritten), atomic.LoadInt64(&v.stats.BlocksRead), time.Since(start).Round(time.Millisecond))
+	if ctx.Err() != nil {
+		return
+	}
+
+	// With all writes settled, every written block must hold its newest
+	// acknowledged generation (or a newer one from a failed write)
+	v.sweep(ctx, "Final check", func(i int64) {
+		s := &states[i]
+		if lo := s.acked.Load(); lo > 0 {
+			v.check(ctx, i, lo, s.issued.Load())
+		} else if s.issued.Load() > 0 {
+			atomic.AddInt64(&v.stats.Skipped, 1)
+		}
+	})
+}
+
+// runVerify runs -vdisk_operation=verify: it writes self-describing blocks
+// to the disk region and reads them back, reporting every block that is
+// corrupt, stale or holds data written for another offset
+func runVerify(client *vdisk.Client) error {
+	bs := *verifyBlockSize
+	if bs < 512 || bs%512 != 0 {
+		return fmt.Errorf("verify_block_size must be a positive multiple of 512")
+	}
+	if *verifyPasses < 1 {
+		return fmt.Errorf("verify_passes must be at least 1")
+	}
+	if *verifyGeneration == 0 {
+		return fmt.Errorf("verify_generation must be at least 1")
+	}
+	switch *verifyMode {
+	case "write-read", "concurrent", "read":
+	default:
+		return fmt.Errorf("invalid verify_mode: %s (must be write-read, concurrent or read)", *verifyMode)
+	}
+
+	disk := createDiskIdentifier()
+	request, err := newWriteRequest(disk, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	start, size := *regionOffset, *regionSize
+	if start < 0 {
+		start = *writeOffset
+	}
+	if size == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *requestTimeout)
+		defer cancel()
+		diskSize, err := client.DiskSize(ctx, disk)
+		if err != nil {
+			return fmt.Errorf("failed to get disk size for the verify region: %v", err)
+		}
+		size = diskSize - start
+	}
+	blocks := size / bs
+	if blocks <= 0 {
+		return fmt.Errorf("verify region of %d bytes at offset %d holds no %d byte blocks", size, start, bs)
+	}
+	if *verifyMode == "concurrent" && blocks > maxConcurrentVerifyBlocks {
+		return fmt.Errorf("verify region of %d blocks is too large for -verify_mode=concurrent (limit %d, set region_size or verify_block_size)",
+			blocks, maxConcurrentVerifyBlocks)
+	}
+
+	v := &verifier{
+		client:    client,
+		disk:      disk,
+		diskID:    verify.DiskID(vdisk.DiskKey(disk)),
+		start:     start,
+		blocks:    blocks,
+		blockSize: bs,
+		request:   request,
+		stats: verifyStats{
+			WriteLatency: histogram.NewLatency(),
+			ReadLatency:  histogram.NewLatency(),
+		},
+	}
+
+	fmt.Fprintf(logOutput, "Starting %s verify of %s (disk id %016x): %d blocks of %d bytes at offset %d, %d workers\n",
+		*verifyMode, vdisk.DiskKey(disk), v.diskID, blocks, bs, start, v.workers(blocks))
+
+	// Stop cleanly on interrupt and report what was checked
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	began := time.Now()
+	switch *verifyMode {
+	case "write-read":
+		v.runWriteRead(ctx)
+	case "concurrent":
+		v.runConcurrent(ctx)
+	case "read":
+		v.runRead(ctx)
+	}
+	v.printSummary(time.Since(began))
+	if ctx.Err() != nil {
+		fmt.Fprintln(logOutput, "Verify interrupted before checking the whole region")
+	}
+
+	if n := v.mismatches(); n > 0 {
+		return fmt.Errorf("verification found %d mismatches", n)
+	}
+	if n := atomic.LoadInt64(&v.stats.ReadErrors); n > 0 {
+		return fmt.Errorf("verification incomplete: %d reads failed", n)
+	}
+	return nil
+}
+
+func (v *verifier) printSummary(elapsed time.Duration) {
+	s := &v.stats
+	fmt.Fprintf(logOutput, "\n=== Verify Summary ===\n")
+	fmt.Fprintf(logOutput, "Disk: %s (id %016x)\n", vdisk.DiskKey(v.disk), v.diskID)
+	fmt.Fprintf(logOutput, "Region: offset %d, %d blocks of %d bytes\n", v.start, v.blocks, v.blockSize)
+	fmt.Fprintf(logOutput, "Mode: %s\n", *verifyMode)
+	fmt.Fprintf(logOutput, "Duration: %v\n", elapsed.Round(time.Millisecond))
+
+	for _, op := range []struct {
+		name         string
+		blocks, errs int64
+		latency      *histogram.Histogram
+	}{
+		{"Writes", s.BlocksWritten, s.WriteErrors, s.WriteLatency},
+		{"Reads", s.BlocksRead, s.ReadErrors, s.ReadLatency},
+	} {
+		if op.blocks+op.errs == 0 {
+			continue
+		}
+		fmt.Fprintf(logOutput, "%s: %d blocks (%d bytes), %d failed\n", op.name, op.blocks, op.blocks*v.blockSize, op.errs)
+		if op.latency.Count() > 0 {
+			fmt.Fprintf(logOutput, "  Latency: avg=%v, %s\n", time.Duration(op.latency.Mean()), formatPercentiles(op.latency))
+		}
+	}
+
+	checked := s.Results[verify.OK] + v.mismatches()
+	fmt.Fprintf(logOutput, "Checked: %d blocks, %d ok, %d corrupt, %d stale, %d misdirected\n",
+		checked, s.Results[verify.OK], s.Results[verify.Corrupt], s.Results[verify.Stale], s.Results[verify.Misdirected])
+	if s.Skipped > 0 {
+		fmt.Fprintf(logOutput, "Skipped: %d blocks whose write failed\n", s.Skipped)
+	}
+
+	if len(v.bad) > 0 {
+		sort.Slice(v.bad, func(i, j int) bool { return v.bad[i].Offset < v.bad[j].Offset })
+		fmt.Fprintf(logOutput, "\nMismatches (%d of %d shown):\n", len(v.bad), v.mismatches())
+		for _, r := range v.bad {
+			fmt.Fprintf(logOutput, "  offset %d (block %d): %s: %s\n", r.Offset, (r.Offset-v.start)/v.blockSize, r.Kind, r.Detail)
+			for _, rg := range r.Ranges {
+				fmt.Fprintf(logOutput, "    bytes %d-%d (%d bytes)\n", rg.Offset, rg.Offset+rg.Length-1, rg.Length)
+			}
+		}
+	}
+	if len(v.ioErrors) > 0 {
+		fmt.Fprintf(logOutput, "\nI/O errors (%d of %d shown):\n", len(v.ioErrors), s.WriteErrors+s.ReadErrors)
+		for _, e := range v.ioErrors {
+			fmt.Fprintf(logOutput, "  %s\n", e)
+		}
+	}
+}
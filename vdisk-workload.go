@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/workload"
+)
+
+var (
+	// Throughput workload profile flags
+	readPercent     = flag.Float64("read_percent", 70, "Percentage of reads for -vdisk_operation=mixed")
+	accessPattern   = flag.String("access_pattern", workload.Stride, "Throughput offset pattern (stride, sequential, uniform, zipfian, hotspot)")
+	regionOffset    = flag.Int64("region_offset", -1, "Start of the disk region accessed in throughput mode (-1 uses read_offset, or write_offset for write tests)")
+	regionSize      = flag.Int64("region_size", 0, "Size of the disk region accessed in throughput mode (0 extends to the end of the disk)")
+	blockSizes      = flag.String("block_sizes", "", "Throughput block size distribution, e.g. 4K:30%,64K:50%,1M:20% (empty uses read_length or write_length)")
+	alignment       = flag.Int64("alignment", 4096, "Alignment in bytes of generated throughput offsets")
+	zipfExponent    = flag.Float64("zipf_exponent", workload.DefaultZipfExponent, "Skew of -access_pattern=zipfian (must be > 1)")
+	hotspotFraction = flag.Float64("hotspot_fraction", workload.DefaultHotspotFraction, "Fraction of the region that is hot for -access_pattern=hotspot")
+	hotspotPercent  = flag.Float64("hotspot_percent", workload.DefaultHotspotPercent, "Percentage of I/O sent to the hot fraction for -access_pattern=hotspot")
+	workloadSeed    = flag.Int64("workload_seed", 1, "Seed for throughput operation mix, offsets and block sizes")
+)
+
+// workloadProfile builds the throughput workload profile from the flags,
+// probing the size of disk when the region extends to the end of the disk
+func workloadProfile(client *vdisk.Client, disk *protos.DiskIdentifier) (workload.Profile, error) {
+	p := workload.Profile{
+		Pattern:         *accessPattern,
+		RegionOffset:    *regionOffset,
+		RegionSize:      *regionSize,
+		Alignment:       *alignment,
+		ZipfExponent:    *zipfExponent,
+		HotspotFraction: *hotspotFraction,
+		HotspotPercent:  *hotspotPercent,
+		Seed:            *workloadSeed,
+	}
+
+	length := *readLength
+	switch *vdiskOperation {
+	case "read":
+		p.ReadPercent = 100
+	case "write":
+		length = *writeLength
+	case "mixed":
+		p.ReadPercent = *readPercent
+		length = *writeLength
+	default:
+		return p, fmt.Errorf("invalid operation: %s", *vdiskOperation)
+	}
+
+	if p.RegionOffset < 0 {
+		p.RegionOffset = *readOffset
+		if *vdiskOperation == "write" {
+			p.RegionOffset = *writeOffset
+		}
+	}
+
+	if *blockSizes != "" {
+		sizes, err := workload.ParseBlockSizes(*blockSizes)
+		if err != nil {
+			return p, fmt.Errorf("invalid block_sizes: %v", err)
+		}
+		p.BlockSizes = sizes
+	} else {
+		p.BlockSizes = []workload.BlockSize{{Size: length, Weight: 1}}
+	}
+
+	if p.Pattern != workload.Stride && p.RegionSize == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *requestTimeout)
+		defer cancel()
+		size, err := client.DiskSize(ctx, disk)
+		if err != nil {
+			return p, fmt.Errorf("failed to get disk size for the workload region: %v", err)
+		}
+		p.RegionSize = size - p.RegionOffset
+	}
+	return p, nil
+}
+
+// workloadWriteLength sizes generated write payloads to cover the largest
+// block of -block_sizes when -write_length is not given
+func workloadWriteLength() error {
+	if *blockSizes == "" || *writeLength != 0 || *writeFile != "" {
+		return nil
+	}
+	switch *writeSource {
+	case "zeros", "random", "incrementing", "compressible":
+	default:
+		return nil
+	}
+	sizes, err := workload.ParseBlockSizes(*blockSizes)
+	if err != nil {
+		return fmt.Errorf("invalid block_sizes: %v", err)
+	}
+	*writeLength = workload.Profile{BlockSizes: sizes}.MaxBlockSize()
+	return nil
+}
+
+// newWorkloadGenerator returns the generator of throughput operations
+// against disk
+func newWorkloadGenerator(client *vdisk.Client, disk *protos.DiskIdentifier) (*workload.Generator, error) {
+	p, err := workloadProfile(client, disk)
+	if err != nil {
+		return nil, err
+	}
+	if p.ReadPercent < 100 && p.MaxBlockSize() > int64(len(writePayload)) {
+		return nil, fmt.Errorf("write payload of %d bytes is smaller than the largest block size %d", len(writePayload), p.MaxBlockSize())
+	}
+	return workload.NewGenerator(p)
+}
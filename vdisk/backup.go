@@ -0,0 +1,385 @@
+package vdisk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// DefaultBackupChunkSize is the amount of the disk each backup stream reads
+// before taking the next chunk
+const DefaultBackupChunkSize = 64 * 1024 * 1024
+
+// DefaultCheckpointInterval is how often a running backup syncs the image
+// and rewrites its checkpoint manifest
+const DefaultCheckpointInterval = 5 * time.Second
+
+// BackupOptions configures Client.Backup
+type BackupOptions struct {
+	// ChunkSize is the unit of work and of checkpointing. It must match the
+	// checkpoint when resuming. Defaults to DefaultBackupChunkSize.
+	ChunkSize int64
+	// Streams is the number of chunks read in parallel, each on its own
+	// stream. Defaults to the client's pool size.
+	Streams int
+	// MaxResponseSize is sent in every VDiskReadArg; 0 leaves it unset
+	MaxResponseSize int64
+	// Raw writes zero ranges as literal zeros instead of holes
+	Raw bool
+	// Checkpoint is the manifest path. An existing manifest for the same
+	// disk resumes the backup; empty disables checkpointing.
+	Checkpoint string
+	// CheckpointInterval defaults to DefaultCheckpointInterval
+	CheckpointInterval time.Duration
+	// Progress, if set, is called after every completed chunk
+	Progress func(BackupProgress)
+}
+
+// BackupProgress describes how far a backup has got
+type BackupProgress struct {
+	DiskSize        int64
+	CompletedBytes  int64
+	Chunks          int
+	CompletedChunks int
+	Elapsed         time.Duration
+}
+
+// BackupStats summarises a finished or interrupted backup
+type BackupStats struct {
+	DiskSize      int64
+	Chunks        int
+	ResumedChunks int
+	ResumedBytes  int64
+	CopiedChunks  int
+	CopiedBytes   int64
+	Retries       int64 // Reads resumed on a new stream after a failure
+	Image         ImageStats
+	Duration      time.Duration
+}
+
+// ByteRange is a half-open range of disk offsets
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// BackupManifest is the checkpoint a backup keeps next to its image. It
+// records which chunks are safely on disk so an interrupted backup can pick
+// up where it stopped.
+type BackupManifest struct {
+	Disk      string      `json:"disk"`
+	DiskSize  int64       `json:"disk_size"`
+	ChunkSize int64       `json:"chunk_size"`
+	Format    string      `json:"format"`
+	Completed []ByteRange `json:"completed"`
+	Complete  bool        `json:"complete"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// LoadBackupManifest reads the manifest at path. It returns nil without an
+// error when the file does not exist.
+func LoadBackupManifest(path string) (*BackupManifest, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m BackupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid backup checkpoint %s: %v", path, err)
+	}
+	return &m, nil
+}
+
+// Save atomically replaces the manifest at path
+func (m *BackupManifest) Save(path string) error {
+	m.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// CompletedBytes returns the number of bytes covered by Completed
+func (m *BackupManifest) CompletedBytes() int64 {
+	var total int64
+	for _, r := range m.Completed {
+		total += r.Length
+	}
+	return total
+}
+
+func backupFormat(raw bool) string {
+	if raw {
+		return "raw"
+	}
+	return "sparse"
+}
+
+// backupState tracks completed chunks and owns the checkpoint manifest
+type backupState struct {
+	mu        sync.Mutex
+	f         *os.File
+	path      string
+	interval  time.Duration
+	manifest  BackupManifest
+	done      []bool
+	completed int
+	bytes     int64
+	lastSave  time.Time
+}
+
+func (s *backupState) chunkRange(i int) (int64, int64) {
+	off := int64(i) * s.manifest.ChunkSize
+	return off, min(s.manifest.ChunkSize, s.manifest.DiskSize-off)
+}
+
+// complete marks chunk i done and checkpoints if the interval has passed
+func (s *backupState) complete(i int) (BackupProgress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, length := s.chunkRange(i)
+	s.done[i] = true
+	s.completed++
+	s.bytes += length
+
+	var err error
+	if s.path != "" && time.Since(s.lastSave) >= s.interval {
+		err = s.checkpoint()
+	}
+	return BackupProgress{
+		DiskSize:        s.manifest.DiskSize,
+		CompletedBytes:  s.bytes,
+		Chunks:          len(s.done),
+		CompletedChunks: s.completed,
+	}, err
+}
+
+// checkpoint syncs the image and records the completed chunks. The image
+// is synced first so the manifest never claims data that is not durable.
+// Callers must hold mu.
+func (s *backupState) checkpoint() error {
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync image: %v", err)
+	}
+
+	s.manifest.Completed = s.manifest.Completed[:0]
+	for i, done := range s.done {
+		if !done {
+			continue
+		}
+		off, length := s.chunkRange(i)
+		if n := len(s.manifest.Completed); n > 0 && s.manifest.Completed[n-1].Offset+s.manifest.Completed[n-1].Length == off {
+			s.manifest.Completed[n-1].Length += length
+			continue
+		}
+		s.manifest.Completed = append(s.manifest.Completed, ByteRange{Offset: off, Length: length})
+	}
+	s.manifest.Complete = s.completed == len(s.done)
+
+	if err := s.manifest.Save(s.path); err != nil {
+		return fmt.Errorf("failed to save backup checkpoint: %v", err)
+	}
+	s.lastSave = time.Now()
+	return nil
+}
+
+// Backup copies the whole of disk into the image file f. The disk size
+// comes from total_disk_size in the first VDiskReadRet. Chunks are read in
+// parallel over opts.Streams streams spread across the connection pool.
+//
+// When opts.Checkpoint names an existing manifest for the same disk, size,
+// chunk size and format, chunks recorded there are skipped and f is updated
+// in place; otherwise f is truncated and the backup starts from scratch. If
+// the backup fails or ctx is cancelled the manifest is saved so that a later
+// call can resume it.
+func (c *Client) Backup(ctx context.Context, disk *protos.DiskIdentifier, f *os.File, opts BackupOptions) (BackupStats, error) {
+	start := time.Now()
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultBackupChunkSize
+	}
+	if opts.Streams <= 0 {
+		opts.Streams = c.PoolSize()
+	}
+	if opts.CheckpointInterval <= 0 {
+		opts.CheckpointInterval = DefaultCheckpointInterval
+	}
+
+	size, err := c.DiskSize(ctx, disk)
+	if err != nil {
+		return BackupStats{}, err
+	}
+
+	chunks := int((size + opts.ChunkSize - 1) / opts.ChunkSize)
+	state := &backupState{
+		f:        f,
+		path:     opts.Checkpoint,
+		interval: opts.CheckpointInterval,
+		manifest: BackupManifest{
+			Disk:      DiskKey(disk),
+			DiskSize:  size,
+			ChunkSize: opts.ChunkSize,
+			Format:    backupFormat(opts.Raw),
+		},
+		done:     make([]bool, chunks),
+		lastSave: time.Now(),
+	}
+	stats := BackupStats{DiskSize: size, Chunks: chunks}
+
+	if err := state.resume(); err != nil {
+		return stats, err
+	}
+	stats.ResumedChunks, stats.ResumedBytes = state.completed, state.bytes
+	if stats.ResumedChunks == 0 {
+		if err := f.Truncate(0); err != nil {
+			return stats, fmt.Errorf("failed to truncate image: %v", err)
+		}
+	}
+
+	var image ImageWriter
+	if opts.Raw {
+		image = NewRawImageWriter(f, 0)
+	} else {
+		image = NewSparseImageWriter(f, 0)
+	}
+
+	err = c.copyChunks(ctx, disk, state, image, opts, start, &stats.Retries)
+
+	stats.Image = image.Stats()
+	stats.CopiedChunks = state.completed - stats.ResumedChunks
+	stats.CopiedBytes = state.bytes - stats.ResumedBytes
+	if err == nil {
+		err = image.Finish(size)
+	}
+	if state.path != "" {
+		state.mu.Lock()
+		if cerr := state.checkpoint(); err == nil {
+			err = cerr
+		}
+		state.mu.Unlock()
+	}
+	stats.Duration = time.Since(start)
+	return stats, err
+}
+
+// resume loads chunks completed by an earlier run from the checkpoint
+func (s *backupState) resume() error {
+	if s.path == "" {
+		return nil
+	}
+	prev, err := LoadBackupManifest(s.path)
+	if err != nil || prev == nil {
+		return err
+	}
+	if prev.Disk != s.manifest.Disk || prev.DiskSize != s.manifest.DiskSize ||
+		prev.ChunkSize != s.manifest.ChunkSize || prev.Format != s.manifest.Format {
+		return fmt.Errorf("checkpoint %s is for %s (%d bytes, %d byte chunks, %s), not %s (%d bytes, %d byte chunks, %s)",
+			s.path, prev.Disk, prev.DiskSize, prev.ChunkSize, prev.Format,
+			s.manifest.Disk, s.manifest.DiskSize, s.manifest.ChunkSize, s.manifest.Format)
+	}
+
+	for _, r := range prev.Completed {
+		if r.Offset%s.manifest.ChunkSize != 0 {
+			return fmt.Errorf("checkpoint %s has unaligned range at offset %d", s.path, r.Offset)
+		}
+		for i := int(r.Offset / s.manifest.ChunkSize); i < len(s.done); i++ {
+			off, length := s.chunkRange(i)
+			if off >= r.Offset+r.Length {
+				break
+			}
+			if !s.done[i] {
+				s.done[i] = true
+				s.completed++
+				s.bytes += length
+			}
+		}
+	}
+	return nil
+}
+
+// copyChunks reads every chunk not yet done, each worker reading its chunks
+// one after another on its own stream. The first failure stops all workers.
+func (c *Client) copyChunks(ctx context.Context, disk *protos.DiskIdentifier, state *backupState, image ImageWriter, opts BackupOptions, start time.Time, retries *int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var pending []int
+	for i, done := range state.done {
+		if !done {
+			pending = append(pending, i)
+		}
+	}
+	work := make(chan int, len(pending))
+	for _, i := range pending {
+		work <- i
+	}
+	close(work)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	for w := 0; w < min(opts.Streams, len(pending)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			reader := c.NewRangeReader(ctx)
+			defer func() {
+				reader.Close()
+				atomic.AddInt64(retries, int64(reader.Retries()))
+			}()
+
+			for i := range work {
+				if ctx.Err() != nil {
+					return
+				}
+
+				off, length := state.chunkRange(i)
+				if _, err := reader.ReadRange(disk, off, length, opts.MaxResponseSize, image.WriteExtent); err != nil {
+					fail(fmt.Errorf("chunk at offset %d: %w", off, err))
+					return
+				}
+
+				progress, err := state.complete(i)
+				if err != nil {
+					fail(err)
+					return
+				}
+				if opts.Progress != nil {
+					progress.Elapsed = time.Since(start)
+					opts.Progress(progress)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,226 @@
+package vdisk_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk/vdisktest"
+)
+
+// seedDisk fills some regions of disk on the server, leaving the rest
+// unwritten, and returns the expected disk image
+func seedDisk(t *testing.T, s *vdisktest.Server, disk *protos.DiskIdentifier) []byte {
+	t.Helper()
+	d, err := s.Disk(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := make([]byte, testDiskSize)
+	for _, r := range []struct{ off, length int64 }{
+		{0, 4096},
+		{100000, 300000},
+		{1 << 20, 64 << 10},
+		{testDiskSize - 5000, 5000},
+	} {
+		data := random(int(r.length))
+		copy(image[r.off:], data)
+		if _, err := d.WriteAt(data, r.off); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return image
+}
+
+func createImage(t *testing.T) *os.File {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestBackup(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   vdisk.BackupOptions
+		faults []vdisktest.Fault
+		retry  bool
+	}{
+		{name: "sparse", opts: vdisk.BackupOptions{ChunkSize: 512 << 10, Streams: 3}},
+		{name: "raw", opts: vdisk.BackupOptions{ChunkSize: 1 << 20, Raw: true}},
+		{name: "small responses", opts: vdisk.BackupOptions{ChunkSize: 256 << 10, Streams: 2, MaxResponseSize: 16 << 10}},
+		{
+			name:   "resets resumed",
+			opts:   vdisk.BackupOptions{ChunkSize: 512 << 10, Streams: 2, MaxResponseSize: 32 << 10},
+			faults: []vdisktest.Fault{{Operation: vdisktest.OpRead, Probability: 0.1, Reset: true}},
+			retry:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var configure func(*vdisk.Options)
+			if tc.retry {
+				configure = withRetry
+			}
+			s, c := startServer(t, nil, configure)
+			disk := vdisk.VMDisk("backup")
+			want := seedDisk(t, s, disk)
+			s.SetFaults(vdisktest.FaultScript{Seed: 1, Faults: tc.faults})
+
+			f := createImage(t)
+			// Progress is called from every stream
+			var mu sync.Mutex
+			var reports int
+			var completed int64
+			tc.opts.Progress = func(p vdisk.BackupProgress) {
+				mu.Lock()
+				defer mu.Unlock()
+				reports++
+				completed = max(completed, p.CompletedBytes)
+			}
+			stats, err := c.Backup(context.Background(), disk, f, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatal("image differs from the disk")
+			}
+			if stats.DiskSize != testDiskSize || stats.CopiedBytes != testDiskSize || stats.CopiedChunks != stats.Chunks {
+				t.Errorf("stats %+v", stats)
+			}
+			if reports != stats.Chunks || completed != testDiskSize {
+				t.Errorf("%d progress reports for %d chunks, %d bytes completed", reports, stats.Chunks, completed)
+			}
+			if tc.faults != nil && (s.Stats().FaultsInjected == 0 || stats.Retries == 0) {
+				t.Errorf("%d faults injected, %d retries", s.Stats().FaultsInjected, stats.Retries)
+			}
+		})
+	}
+}
+
+func TestBackupResume(t *testing.T) {
+	s, c := startServer(t, nil, nil)
+	disk := vdisk.VMDisk("resume")
+	want := seedDisk(t, s, disk)
+	f := createImage(t)
+	checkpoint := f.Name() + ".checkpoint"
+
+	// Stop the first run after its second chunk
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := vdisk.BackupOptions{ChunkSize: 256 << 10, Streams: 1, Checkpoint: checkpoint}
+	opts.Progress = func(p vdisk.BackupProgress) {
+		if p.CompletedChunks == 2 {
+			cancel()
+		}
+	}
+	if _, err := c.Backup(ctx, disk, f, opts); err == nil {
+		t.Fatal("cancelled backup succeeded")
+	}
+	manifest, err := vdisk.LoadBackupManifest(checkpoint)
+	if err != nil || manifest == nil {
+		t.Fatalf("no checkpoint after an interrupted backup: %v", err)
+	}
+	if manifest.CompletedBytes() < 2*opts.ChunkSize {
+		t.Fatalf("checkpoint covers %d bytes, want at least %d", manifest.CompletedBytes(), 2*opts.ChunkSize)
+	}
+
+	opts.Progress = nil
+	stats, err := c.Backup(context.Background(), disk, f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ResumedBytes != manifest.CompletedBytes() || stats.ResumedBytes+stats.CopiedBytes != testDiskSize {
+		t.Errorf("resumed %d bytes and copied %d, checkpoint had %d", stats.ResumedBytes, stats.CopiedBytes, manifest.CompletedBytes())
+	}
+	got, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("resumed image differs from the disk")
+	}
+}
+
+func TestRestoreVerify(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts vdisk.RestoreOptions
+	}{
+		{name: "defaults"},
+		{name: "small batches", opts: vdisk.RestoreOptions{BlockSize: 4096, MaxPayload: 32 << 10, Streams: 2, Window: 4}},
+		{name: "compressed", opts: vdisk.RestoreOptions{
+			MaxPayload:  128 << 10,
+			Compression: protos.CompressionType_kSnappyCompression,
+			Checksum:    protos.ChecksumType_kSHA256,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, c := startServer(t, nil, nil)
+			image := seedDisk(t, s, vdisk.VMDisk("source"))
+			target := vdisk.VMDisk("target")
+			ctx := context.Background()
+
+			stats, err := c.Restore(ctx, target, bytes.NewReader(image), testDiskSize, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(diskBytes(t, s, target, 0, testDiskSize), image) {
+				t.Fatal("restored disk differs from the image")
+			}
+			if stats.DataBytes+stats.ZeroBytes != testDiskSize || stats.ZeroBytes == 0 {
+				t.Errorf("restored %d data and %d zero bytes", stats.DataBytes, stats.ZeroBytes)
+			}
+
+			vstats, err := c.Verify(ctx, target, bytes.NewReader(image), testDiskSize, vdisk.VerifyOptions{ChunkSize: 512 << 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if vstats.ComparedBytes != testDiskSize || vstats.MismatchBytes != 0 {
+				t.Errorf("verify compared %d bytes, %d mismatched", vstats.ComparedBytes, vstats.MismatchBytes)
+			}
+		})
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	s, c := startServer(t, nil, nil)
+	disk := vdisk.VMDisk("verify")
+	image := seedDisk(t, s, disk)
+	d, err := s.Disk(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Change 10 bytes of data and write 3 bytes into a zero region
+	changed := make([]byte, 10)
+	for i := range changed {
+		changed[i] = ^image[200000+i]
+	}
+	d.WriteAt(changed, 200000)
+	d.WriteAt([]byte{1, 2, 3}, 2<<20)
+
+	stats, err := c.Verify(context.Background(), disk, bytes.NewReader(image), testDiskSize, vdisk.VerifyOptions{Streams: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []vdisk.ByteRange{{Offset: 200000, Length: 10}, {Offset: 2 << 20, Length: 3}}
+	if stats.MismatchBytes != 13 || len(stats.Mismatches) != len(want) {
+		t.Fatalf("%d bytes mismatched in %v, want %v", stats.MismatchBytes, stats.Mismatches, want)
+	}
+	for i := range want {
+		if stats.Mismatches[i] != want[i] {
+			t.Errorf("mismatch %d is %+v, want %+v", i, stats.Mismatches[i], want[i])
+		}
+	}
+}
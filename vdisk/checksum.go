@@ -0,0 +1,41 @@
+package vdisk
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// ErrChecksumMismatch is wrapped by errors for responses in which the server
+// reports that the payload did not match the checksum sent with it
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Checksum computes the value sent in VDiskWriteArg.checksum for payload,
+// which is the data exactly as sent (after compression).
+//
+// The field is a uint32, so CRC32 (IEEE) is carried as is while SHA1 and
+// SHA256 digests are truncated to their first four bytes, read big-endian:
+// SHA256("abc") starts ba 78 16 bf and is sent as 0xba7816bf. The field
+// cannot be widened without changing the wire format the server decodes.
+// It returns false for kNoChecksum.
+//
+// The truncation is a convention between this package and vdisktest, not
+// part of the Stargate contract, and must be agreed with a real server
+// before SHA checksums are sent to it.
+func Checksum(ct protos.ChecksumType, payload []byte) (uint32, bool) {
+	switch ct {
+	case protos.ChecksumType_kCRC32:
+		return crc32.ChecksumIEEE(payload), true
+	case protos.ChecksumType_kSHA1:
+		digest := sha1.Sum(payload)
+		return binary.BigEndian.Uint32(digest[:4]), true
+	case protos.ChecksumType_kSHA256:
+		digest := sha256.Sum256(payload)
+		return binary.BigEndian.Uint32(digest[:4]), true
+	}
+	return 0, false
+}
@@ -0,0 +1,92 @@
+package vdisk_test
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+func TestChecksumKnownAnswers(t *testing.T) {
+	for _, tc := range []struct {
+		ct      protos.ChecksumType
+		payload string
+		want    uint32
+	}{
+		{protos.ChecksumType_kCRC32, "123456789", 0xcbf43926},
+		{protos.ChecksumType_kCRC32, "", 0},
+		// First four bytes of a9993e36 4706816a ba3e2571 7850c26c 9cd0d89d
+		{protos.ChecksumType_kSHA1, "abc", 0xa9993e36},
+		// First four bytes of ba7816bf 8f01cfea 414140de 5dae2223 ...
+		{protos.ChecksumType_kSHA256, "abc", 0xba7816bf},
+		// SHA256("") starts e3b0c442
+		{protos.ChecksumType_kSHA256, "", 0xe3b0c442},
+	} {
+		got, ok := vdisk.Checksum(tc.ct, []byte(tc.payload))
+		if !ok || got != tc.want {
+			t.Errorf("Checksum(%v, %q) = %08x, %v; want %08x", tc.ct, tc.payload, got, ok, tc.want)
+		}
+	}
+	if _, ok := vdisk.Checksum(protos.ChecksumType_kNoChecksum, []byte("abc")); ok {
+		t.Error("kNoChecksum produced a checksum")
+	}
+}
+
+func TestNewWriteArgChecksum(t *testing.T) {
+	arg, err := vdisk.NewWriteArg(vdisk.WriteRequest{
+		Length:   9,
+		Data:     []byte("123456789"),
+		Checksum: protos.ChecksumType_kCRC32,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if arg.Checksum == nil || arg.GetChecksum() != 0xcbf43926 {
+		t.Errorf("checksum = %08x, want cbf43926", arg.GetChecksum())
+	}
+}
+
+func TestChecksumMismatchKind(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		mismatch bool
+	}{
+		{"checksum mismatch: request has 00000000, payload computes to cbf43926", true},
+		{"Checksum verification failed for range 0", true},
+		{"invalid checksum", true},
+		{"checksum type unsupported", false},
+		{"vdisk not found", false},
+		{"write failed", false},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			stream := &responseStream{resp: &protos.VDiskReadRet{ErrorMessage: proto.String(tc.msg)}}
+			_, err := vdisk.ReadFromStream(stream, vdisk.ReadRequest{Length: 4096}, nil)
+			kind, ok := vdisk.ServerKind(err)
+			if !ok {
+				t.Fatalf("error %v is not a ServerError", err)
+			}
+			if got := kind == vdisk.ServerChecksumMismatch; got != tc.mismatch {
+				t.Errorf("kind = %v, want checksum mismatch %v", kind, tc.mismatch)
+			}
+			if got := errors.Is(err, vdisk.ErrChecksumMismatch); got != tc.mismatch {
+				t.Errorf("errors.Is(err, ErrChecksumMismatch) = %v, want %v", got, tc.mismatch)
+			}
+			if category, _ := vdisk.Classify(err); (category == vdisk.CategoryIntegrity) != tc.mismatch {
+				t.Errorf("category = %v", category)
+			}
+		})
+	}
+}
+
+// responseStream is a read stream answering every request with resp
+type responseStream struct {
+	vdisk.ReadStream
+	resp *protos.VDiskReadRet
+}
+
+func (s *responseStream) Send(*protos.VDiskReadArg) error { return nil }
+
+func (s *responseStream) Recv() (*protos.VDiskReadRet, error) { return s.resp, nil }
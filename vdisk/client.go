@@ -0,0 +1,380 @@
+// Package vdisk provides an importable client for the Stargate VDisk gRPC
+// data API. It wraps protos.StargateVDiskRpcSvcClient with connection
+// pooling, authentication and streaming read/write helpers.
+package vdisk
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// Authentication types understood by Options.AuthType
+const (
+	AuthCookie = "cookie"
+	AuthBearer = "bearer"
+	AuthBasic  = "basic"
+	AuthNone   = "none"
+)
+
+// Options configures a Client
+type Options struct {
+	// Address is the VDisk server address in ip:port format
+	Address string
+
+	// UseTLS enables TLS transport credentials
+	UseTLS bool
+	// SkipTLSVerify disables server certificate verification when UseTLS is set
+	SkipTLSVerify bool
+
+	// AuthType selects how credentials are attached (cookie, bearer, basic, none)
+	AuthType string
+	// BearerToken is sent as "Authorization: Bearer <token>" for bearer auth
+	BearerToken string
+	// Cookie is sent as the "Cookie" header for cookie auth
+	Cookie string
+	// BasicAuth is the base64 encoded credential sent for basic auth
+	BasicAuth string
+
+	// PoolSize is the number of gRPC connections to maintain
+	PoolSize int
+
+	// Keepalive settings applied to every pooled connection
+	KeepaliveTime                time.Duration
+	KeepaliveTimeout             time.Duration
+	KeepalivePermitWithoutStream bool
+
+	// Message size limits applied as default call options
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+
+	// Dialer, if set, creates the network connection for every pooled
+	// connection instead of dialing Address over TCP
+	Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
+	// Logf receives progress messages; nil discards them
+	Logf func(format string, args ...interface{})
+
+	// TracerProvider, if set, records a span for every stream with events
+	// for its requests and responses
+	TracerProvider trace.TracerProvider
+	// Propagator injects the span context into the request metadata of
+	// traced streams; nil uses W3C trace context
+	Propagator propagation.TextMapPropagator
+
+	// Retry, if set, retries failed Read and Write requests
+	Retry *RetryPolicy
+}
+
+// DefaultOptions returns the options used by the CLI when no flags override them
+func DefaultOptions() Options {
+	return Options{
+		UseTLS:                       true,
+		SkipTLSVerify:                true,
+		AuthType:                     AuthCookie,
+		PoolSize:                     1,
+		KeepaliveTime:                30 * time.Second,
+		KeepaliveTimeout:             5 * time.Second,
+		KeepalivePermitWithoutStream: true,
+		MaxRecvMsgSize:               100 * 1024 * 1024,
+		MaxSendMsgSize:               100 * 1024 * 1024,
+	}
+}
+
+// Client is a pooled VDisk gRPC client. It is safe for concurrent use.
+type Client struct {
+	opts Options
+
+	mu         sync.RWMutex
+	conns      []*grpc.ClientConn
+	usage      []int64
+	roundRobin int64
+	closed     bool
+
+	authOnce sync.Once
+	authMD   metadata.MD
+
+	budget *retryBudget
+}
+
+// NewClient dials opts.PoolSize connections to opts.Address
+func NewClient(opts Options) (*Client, error) {
+	if opts.Address == "" {
+		return nil, fmt.Errorf("vdisk server address is required")
+	}
+	if opts.PoolSize < 1 {
+		opts.PoolSize = 1
+	}
+
+	c := &Client{
+		opts:   opts,
+		conns:  make([]*grpc.ClientConn, opts.PoolSize),
+		usage:  make([]int64, opts.PoolSize),
+		budget: newRetryBudget(opts.Retry),
+	}
+
+	c.logf("Initializing connection pool with %d connections...\n", opts.PoolSize)
+	for i := 0; i < opts.PoolSize; i++ {
+		conn, err := c.dial(i)
+		if err != nil {
+			// Clean up any connections created so far
+			for j := 0; j < i; j++ {
+				c.conns[j].Close()
+			}
+			return nil, fmt.Errorf("failed to create connection %d: %v", i, err)
+		}
+		c.conns[i] = conn
+		c.logf("Created connection %d/%d\n", i+1, opts.PoolSize)
+	}
+	c.logf("Connection pool initialized successfully with %d connections\n", opts.PoolSize)
+
+	return c, nil
+}
+
+// Options returns the options the client was created with
+func (c *Client) Options() Options {
+	return c.opts
+}
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.opts.Logf != nil {
+		c.opts.Logf(format, args...)
+	}
+}
+
+// dial creates the connection for pool index
+func (c *Client) dial(index int) (*grpc.ClientConn, error) {
+	var opts []grpc.DialOption
+
+	if c.opts.UseTLS {
+		creds := credentials.NewTLS(&tls.Config{
+			InsecureSkipVerify: c.opts.SkipTLSVerify,
+		})
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+		c.logf("Connecting with TLS...\n")
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		c.logf("Connecting without TLS...\n")
+	}
+
+	var callOpts []grpc.CallOption
+	if c.opts.MaxRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(c.opts.MaxRecvMsgSize))
+	}
+	if c.opts.MaxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(c.opts.MaxSendMsgSize))
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+
+	if c.opts.KeepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                c.opts.KeepaliveTime,
+			Timeout:             c.opts.KeepaliveTimeout,
+			PermitWithoutStream: c.opts.KeepalivePermitWithoutStream,
+		}))
+	}
+
+	if c.opts.Dialer != nil {
+		opts = append(opts, grpc.WithContextDialer(c.opts.Dialer))
+	}
+
+	if tracer := newStreamTracer(c.opts, index); tracer != nil {
+		opts = append(opts, grpc.WithStreamInterceptor(tracer.intercept))
+	}
+
+	conn, err := grpc.Dial(c.opts.Address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to VDisk server: %v", err)
+	}
+	return conn, nil
+}
+
+// Conn returns a pooled connection chosen round-robin along with its pool index.
+// Connections that have been shut down are transparently recreated.
+func (c *Client) Conn() (*grpc.ClientConn, int, error) {
+	return c.conn(-1)
+}
+
+// conn is Conn, skipping the connection at pool index avoid when the pool
+// has another one. Retries use it to move off a connection that just failed.
+func (c *Client) conn(avoid int) (*grpc.ClientConn, int, error) {
+	c.mu.RLock()
+	if c.closed {
+		c.mu.RUnlock()
+		return nil, -1, fmt.Errorf("client is closed")
+	}
+	if len(c.conns) == 0 {
+		c.mu.RUnlock()
+		return nil, -1, fmt.Errorf("connection pool is empty")
+	}
+
+	index := int((atomic.AddInt64(&c.roundRobin, 1) - 1) % int64(len(c.conns)))
+	if index == avoid && len(c.conns) > 1 {
+		index = (index + 1) % len(c.conns)
+	}
+	conn := c.conns[index]
+	if conn != nil && conn.GetState() != connectivity.Shutdown {
+		atomic.AddInt64(&c.usage[index], 1)
+		c.mu.RUnlock()
+		return conn, index, nil
+	}
+	c.mu.RUnlock()
+
+	conn, err := c.recreate(index)
+	if err != nil {
+		return nil, -1, err
+	}
+	return conn, index, nil
+}
+
+// recreate replaces the connection at index with a freshly dialed one
+func (c *Client) recreate(index int) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if index >= len(c.conns) {
+		return nil, fmt.Errorf("invalid connection index %d", index)
+	}
+
+	// Another caller may have already replaced it
+	if conn := c.conns[index]; conn != nil && conn.GetState() != connectivity.Shutdown {
+		atomic.AddInt64(&c.usage[index], 1)
+		return conn, nil
+	}
+	if c.conns[index] != nil {
+		c.conns[index].Close()
+	}
+
+	conn, err := c.dial(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recreate connection %d: %v", index, err)
+	}
+	c.conns[index] = conn
+	c.logf("Recreated connection %d\n", index)
+
+	atomic.AddInt64(&c.usage[index], 1)
+	return conn, nil
+}
+
+// RPC returns a service stub bound to the next pooled connection and its pool index
+func (c *Client) RPC() (protos.StargateVDiskRpcSvcClient, int, error) {
+	return c.rpc(-1)
+}
+
+// rpc is RPC over c.conn(avoid)
+func (c *Client) rpc(avoid int) (protos.StargateVDiskRpcSvcClient, int, error) {
+	conn, index, err := c.conn(avoid)
+	if err != nil {
+		return nil, -1, err
+	}
+	return protos.NewStargateVDiskRpcSvcClient(conn), index, nil
+}
+
+// PoolSize returns the number of pooled connections
+func (c *Client) PoolSize() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.conns)
+}
+
+// ConnectionUsage returns the number of streams handed out per pooled connection
+func (c *Client) ConnectionUsage() []int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	usage := make([]int64, len(c.usage))
+	for i := range c.usage {
+		usage[i] = atomic.LoadInt64(&c.usage[i])
+	}
+	return usage
+}
+
+// Close closes every pooled connection
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	var firstErr error
+	for i, conn := range c.conns {
+		if conn == nil {
+			continue
+		}
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		c.logf("Closed connection %d\n", i)
+	}
+	return firstErr
+}
+
+// AuthContext returns ctx with the configured authentication headers attached
+func (c *Client) AuthContext(ctx context.Context) context.Context {
+	c.authOnce.Do(func() {
+		c.authMD = c.buildAuthMetadata()
+	})
+	if len(c.authMD) == 0 {
+		return ctx
+	}
+	kv := make([]string, 0, 2*len(c.authMD))
+	for k, vals := range c.authMD {
+		for _, v := range vals {
+			kv = append(kv, k, v)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+func (c *Client) buildAuthMetadata() metadata.MD {
+	md := metadata.MD{}
+
+	switch c.opts.AuthType {
+	case AuthBearer:
+		if c.opts.BearerToken != "" {
+			md.Set("Authorization", fmt.Sprintf("Bearer %s", c.opts.BearerToken))
+			c.logf("Using Bearer token authentication\n")
+		} else {
+			c.logf("Warning: Bearer auth selected but no token provided\n")
+		}
+	case AuthBasic:
+		if c.opts.BasicAuth != "" {
+			md.Set("Authorization", fmt.Sprintf("Basic %s", c.opts.BasicAuth))
+			c.logf("Using Basic authentication\n")
+		} else {
+			c.logf("Warning: Basic auth selected but no credentials provided\n")
+		}
+	case AuthCookie:
+		if c.opts.Cookie != "" {
+			md.Set("Cookie", c.opts.Cookie)
+			c.logf("Using Cookie authentication\n")
+		} else {
+			c.logf("Warning: Cookie auth selected but no cookie provided\n")
+		}
+	case AuthNone, "":
+	default:
+		c.logf("Warning: Unknown authentication type '%s', proceeding without authentication\n", c.opts.AuthType)
+	}
+
+	return md
+}
@@ -0,0 +1,191 @@
+package vdisk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/pierrec/lz4/v4"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// Compressed write payloads are a sequence of frames, one per non-zero
+// range in range_vec order. Each frame is
+//
+//	uint32 big-endian uncompressed length
+//	uint32 big-endian compressed length
+//	compressed bytes
+//
+// When the payload length does not match the non-zero ranges the whole
+// payload is carried in a single frame.
+//
+// The framing is a convention between this package and vdisktest, not part
+// of the Stargate contract. Framing per range means decompressing frame i
+// yields the data of non-zero range i, as data is laid out uncompressed. The
+// uncompressed length sizes the output buffer, which a raw LZ4 block does
+// not record, and the compressed length delimits frames, which range_vec
+// cannot.
+const frameHeaderSize = 8
+
+// CompressPayload compresses data with ct, framing it per range. It reports
+// ok=false when compression would not shrink the payload, in which case the
+// caller should send data uncompressed.
+func CompressPayload(ct protos.CompressionType, ranges []*protos.DiskDataRange, data []byte) (payload []byte, ok bool, err error) {
+	if ct == protos.CompressionType_kNoCompression || len(data) == 0 {
+		return data, false, nil
+	}
+
+	var out bytes.Buffer
+	for _, chunk := range splitByRanges(ranges, data) {
+		compressed, err := compressBlock(ct, chunk)
+		if err != nil {
+			return nil, false, err
+		}
+		if compressed == nil {
+			return data, false, nil
+		}
+		var header [frameHeaderSize]byte
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(chunk)))
+		binary.BigEndian.PutUint32(header[4:8], uint32(len(compressed)))
+		out.Write(header[:])
+		out.Write(compressed)
+	}
+
+	if out.Len() >= len(data) {
+		return data, false, nil
+	}
+	return out.Bytes(), true, nil
+}
+
+// DecompressPayload reverses CompressPayload. Frames may not decompress to
+// more than the total length of ranges, so that a corrupt header cannot
+// size an arbitrary allocation.
+func DecompressPayload(ct protos.CompressionType, ranges []*protos.DiskDataRange, payload []byte) ([]byte, error) {
+	if ct == protos.CompressionType_kNoCompression {
+		return payload, nil
+	}
+
+	var limit int64
+	for _, r := range ranges {
+		limit += r.GetLength()
+	}
+
+	var out []byte
+	for len(payload) > 0 {
+		if len(payload) < frameHeaderSize {
+			return nil, fmt.Errorf("truncated compression frame header")
+		}
+		rawLen := int(binary.BigEndian.Uint32(payload[0:4]))
+		compLen := int(binary.BigEndian.Uint32(payload[4:8]))
+		payload = payload[frameHeaderSize:]
+		if compLen > len(payload) {
+			return nil, fmt.Errorf("truncated compression frame: want %d bytes, have %d", compLen, len(payload))
+		}
+		if left := limit - int64(len(out)); int64(rawLen) > left {
+			return nil, fmt.Errorf("compression frame of %d bytes exceeds the %d bytes left in range_vec", rawLen, left)
+		}
+
+		raw, err := decompressBlock(ct, payload[:compLen], rawLen)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) != rawLen {
+			return nil, fmt.Errorf("decompressed frame is %d bytes, header says %d", len(raw), rawLen)
+		}
+		out = append(out, raw...)
+		payload = payload[compLen:]
+	}
+	return out, nil
+}
+
+// splitByRanges splits data into the chunks belonging to each non-zero range
+func splitByRanges(ranges []*protos.DiskDataRange, data []byte) [][]byte {
+	var total int64
+	for _, r := range ranges {
+		if !r.GetZeroData() {
+			total += r.GetLength()
+		}
+	}
+	if total != int64(len(data)) {
+		return [][]byte{data}
+	}
+
+	chunks := make([][]byte, 0, len(ranges))
+	for _, r := range ranges {
+		if r.GetZeroData() || r.GetLength() == 0 {
+			continue
+		}
+		chunks = append(chunks, data[:r.GetLength()])
+		data = data[r.GetLength():]
+	}
+	return chunks
+}
+
+// compressBlock compresses one frame, returning nil when src is incompressible
+func compressBlock(ct protos.CompressionType, src []byte) ([]byte, error) {
+	switch ct {
+	case protos.CompressionType_kLZ4Compression:
+		dst := make([]byte, lz4.CompressBlockBound(len(src)))
+		var c lz4.Compressor
+		n, err := c.CompressBlock(src, dst)
+		if err != nil {
+			return nil, fmt.Errorf("lz4 compression failed: %v", err)
+		}
+		if n == 0 {
+			// Incompressible
+			return nil, nil
+		}
+		return dst[:n], nil
+	case protos.CompressionType_kSnappyCompression:
+		return snappy.Encode(nil, src), nil
+	case protos.CompressionType_kZlibCompression:
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		if _, err := w.Write(src); err != nil {
+			return nil, fmt.Errorf("zlib compression failed: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("zlib compression failed: %v", err)
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported compression type %v", ct)
+}
+
+func decompressBlock(ct protos.CompressionType, src []byte, rawLen int) ([]byte, error) {
+	switch ct {
+	case protos.CompressionType_kLZ4Compression:
+		dst := make([]byte, rawLen)
+		n, err := lz4.UncompressBlock(src, dst)
+		if err != nil {
+			return nil, fmt.Errorf("lz4 decompression failed: %v", err)
+		}
+		return dst[:n], nil
+	case protos.CompressionType_kSnappyCompression:
+		if n, err := snappy.DecodedLen(src); err == nil && n != rawLen {
+			return nil, fmt.Errorf("decompressed frame is %d bytes, header says %d", n, rawLen)
+		}
+		dst, err := snappy.Decode(nil, src)
+		if err != nil {
+			return nil, fmt.Errorf("snappy decompression failed: %v", err)
+		}
+		return dst, nil
+	case protos.CompressionType_kZlibCompression:
+		r, err := zlib.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, fmt.Errorf("zlib decompression failed: %v", err)
+		}
+		defer r.Close()
+		// One byte past rawLen is enough to detect an oversized frame
+		dst, err := io.ReadAll(io.LimitReader(r, int64(rawLen)+1))
+		if err != nil {
+			return nil, fmt.Errorf("zlib decompression failed: %v", err)
+		}
+		return dst, nil
+	}
+	return nil, fmt.Errorf("unsupported compression type %v", ct)
+}
@@ -0,0 +1,223 @@
+package vdisk_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+var compressionTypes = []protos.CompressionType{
+	protos.CompressionType_kLZ4Compression,
+	protos.CompressionType_kSnappyCompression,
+	protos.CompressionType_kZlibCompression,
+}
+
+func dataRange(off, length int64, zero bool) *protos.DiskDataRange {
+	return &protos.DiskDataRange{Offset: proto.Int64(off), Length: proto.Int64(length), ZeroData: proto.Bool(zero)}
+}
+
+func compressible(n int) []byte {
+	return bytes.Repeat([]byte("vdisk compression round trip "), n/29+1)[:n]
+}
+
+func random(n int) []byte {
+	p := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(p)
+	return p
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	for _, ct := range compressionTypes {
+		for _, tc := range []struct {
+			name   string
+			ranges []*protos.DiskDataRange
+			data   []byte
+			frames []int // uncompressed length of each frame
+		}{
+			{
+				name:   "single range",
+				ranges: []*protos.DiskDataRange{dataRange(0, 8192, false)},
+				data:   compressible(8192),
+				frames: []int{8192},
+			},
+			{
+				name: "ranges around a zero range",
+				ranges: []*protos.DiskDataRange{
+					dataRange(0, 4096, false),
+					dataRange(4096, 65536, true),
+					dataRange(69632, 12288, false),
+				},
+				data:   compressible(16384),
+				frames: []int{4096, 12288},
+			},
+			{
+				name:   "data not matching the ranges",
+				ranges: []*protos.DiskDataRange{dataRange(0, 4096, false), dataRange(4096, 8192, false)},
+				data:   compressible(10000),
+				frames: []int{10000},
+			},
+		} {
+			t.Run(ct.String()+"/"+tc.name, func(t *testing.T) {
+				payload, ok, err := vdisk.CompressPayload(ct, tc.ranges, tc.data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					t.Fatal("compressible payload was not compressed")
+				}
+				if len(payload) >= len(tc.data) {
+					t.Errorf("payload of %d bytes did not shrink from %d", len(payload), len(tc.data))
+				}
+
+				// Walk the frames as compress.go documents them
+				rest := payload
+				for i, want := range tc.frames {
+					if len(rest) < 8 {
+						t.Fatalf("frame %d: %d bytes left for the header", i, len(rest))
+					}
+					rawLen := binary.BigEndian.Uint32(rest[0:4])
+					compLen := binary.BigEndian.Uint32(rest[4:8])
+					if int(rawLen) != want {
+						t.Errorf("frame %d: uncompressed length %d, want %d", i, rawLen, want)
+					}
+					if int(compLen) > len(rest)-8 {
+						t.Fatalf("frame %d: compressed length %d exceeds the %d bytes left", i, compLen, len(rest)-8)
+					}
+					rest = rest[8+compLen:]
+				}
+				if len(rest) != 0 {
+					t.Errorf("%d bytes after the last frame", len(rest))
+				}
+
+				got, err := vdisk.DecompressPayload(ct, tc.ranges, payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, tc.data) {
+					t.Error("decompressed payload differs from the original")
+				}
+			})
+		}
+	}
+}
+
+func TestCompressFallback(t *testing.T) {
+	ranges := []*protos.DiskDataRange{dataRange(0, 4096, false)}
+	for _, ct := range compressionTypes {
+		t.Run(ct.String(), func(t *testing.T) {
+			data := random(4096)
+			payload, ok, err := vdisk.CompressPayload(ct, ranges, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				t.Fatalf("random payload compressed to %d bytes", len(payload))
+			}
+			if !bytes.Equal(payload, data) {
+				t.Error("fallback payload differs from the data")
+			}
+			// Sent as kNoCompression, the payload passes through unchanged
+			got, err := vdisk.DecompressPayload(protos.CompressionType_kNoCompression, ranges, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Error("kNoCompression payload was changed")
+			}
+		})
+	}
+
+	t.Run("kNoCompression", func(t *testing.T) {
+		data := compressible(4096)
+		payload, ok, err := vdisk.CompressPayload(protos.CompressionType_kNoCompression, ranges, data)
+		if err != nil || ok || !bytes.Equal(payload, data) {
+			t.Errorf("CompressPayload = %d bytes, %v, %v; want the data unchanged", len(payload), ok, err)
+		}
+	})
+}
+
+func TestDecompressTruncated(t *testing.T) {
+	ranges := []*protos.DiskDataRange{dataRange(0, 8192, false)}
+	for _, ct := range compressionTypes {
+		payload, ok, err := vdisk.CompressPayload(ct, ranges, compressible(8192))
+		if err != nil || !ok {
+			t.Fatalf("%v: CompressPayload: %v, %v", ct, ok, err)
+		}
+		for _, n := range []int{4, 8, len(payload) - 1} {
+			if _, err := vdisk.DecompressPayload(ct, ranges, payload[:n]); err == nil {
+				t.Errorf("%v: payload truncated to %d bytes decompressed", ct, n)
+			}
+		}
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	for _, ct := range compressionTypes {
+		payload, ok, err := vdisk.CompressPayload(ct, nil, compressible(8192))
+		if err != nil || !ok {
+			t.Fatalf("%v: CompressPayload: %v, %v", ct, ok, err)
+		}
+		for _, tc := range []struct {
+			name    string
+			ranges  []*protos.DiskDataRange
+			payload []byte
+		}{
+			{"no ranges", nil, payload},
+			{"ranges shorter than the frame", []*protos.DiskDataRange{dataRange(0, 4096, false)}, payload},
+			{"frames beyond the ranges", []*protos.DiskDataRange{dataRange(0, 12288, false)}, append(append([]byte{}, payload...), payload...)},
+			{"forged uncompressed length", []*protos.DiskDataRange{dataRange(0, 8192, false)}, append([]byte{0xff, 0xff, 0xff, 0xff}, payload[4:]...)},
+		} {
+			if _, err := vdisk.DecompressPayload(ct, tc.ranges, tc.payload); err == nil {
+				t.Errorf("%v: %s: decompressed", ct, tc.name)
+			}
+		}
+	}
+
+	// A frame shorter than its header claims is rejected rather than padded
+	ranges := []*protos.DiskDataRange{dataRange(0, 16384, false)}
+	for _, ct := range compressionTypes {
+		payload, _, _ := vdisk.CompressPayload(ct, nil, compressible(8192))
+		forged := append([]byte{0, 0, 0x40, 0}, payload[4:]...)
+		if _, err := vdisk.DecompressPayload(ct, ranges, forged); err == nil {
+			t.Errorf("%v: frame claiming 16384 bytes decompressed", ct)
+		}
+	}
+}
+
+func TestNewWriteArgCompression(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want protos.CompressionType
+	}{
+		{"compressible", compressible(4096), protos.CompressionType_kZlibCompression},
+		{"incompressible", random(4096), protos.CompressionType_kNoCompression},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			arg, err := vdisk.NewWriteArg(vdisk.WriteRequest{
+				Length:      int64(len(tc.data)),
+				Data:        tc.data,
+				Compression: protos.CompressionType_kZlibCompression,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if arg.GetCompressionType() != tc.want {
+				t.Fatalf("compression_type = %v, want %v", arg.GetCompressionType(), tc.want)
+			}
+			got, err := vdisk.DecompressPayload(arg.GetCompressionType(), arg.GetRangeVec(), arg.GetData())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.data) {
+				t.Error("decompressed payload differs from the original")
+			}
+		})
+	}
+}
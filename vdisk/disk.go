@@ -0,0 +1,103 @@
+package vdisk
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// VMDisk returns a DiskIdentifier for a VM disk UUID
+func VMDisk(uuid string) *protos.DiskIdentifier {
+	return &protos.DiskIdentifier{
+		Identifier: &protos.DiskIdentifier_VmDiskUuid{VmDiskUuid: uuid},
+	}
+}
+
+// VGDisk returns a DiskIdentifier for a volume group disk UUID
+func VGDisk(uuid string) *protos.DiskIdentifier {
+	return &protos.DiskIdentifier{
+		Identifier: &protos.DiskIdentifier_VgDiskUuid{VgDiskUuid: uuid},
+	}
+}
+
+// RecoveryPointDisk returns a DiskIdentifier for a disk recovery point UUID
+func RecoveryPointDisk(uuid string) *protos.DiskIdentifier {
+	return &protos.DiskIdentifier{
+		Identifier: &protos.DiskIdentifier_DiskRecoveryPoint{
+			DiskRecoveryPoint: &protos.DiskRecoveryPoint{
+				RecoveryPointUuid: proto.String(uuid),
+			},
+		},
+	}
+}
+
+// DiskKey returns a stable human readable key for a DiskIdentifier, such as
+// "vm_disk:<uuid>". It is suitable for map keys and log labels.
+func DiskKey(disk *protos.DiskIdentifier) string {
+	switch {
+	case disk == nil:
+		return ""
+	case disk.GetVmDiskUuid() != "":
+		return "vm_disk:" + disk.GetVmDiskUuid()
+	case disk.GetVgDiskUuid() != "":
+		return "vg_disk:" + disk.GetVgDiskUuid()
+	case disk.GetDiskRecoveryPoint() != nil:
+		return "recovery_point:" + disk.GetDiskRecoveryPoint().GetRecoveryPointUuid()
+	}
+	return ""
+}
+
+// ParseDiskKey parses a disk key as returned by DiskKey. The short prefixes
+// vm, vg and rp are accepted too, and a bare UUID is a VM disk.
+func ParseDiskKey(key string) (*protos.DiskIdentifier, error) {
+	kind, uuid, ok := strings.Cut(strings.TrimSpace(key), ":")
+	if !ok {
+		kind, uuid = "vm_disk", kind
+	}
+	uuid = strings.TrimSpace(uuid)
+	if uuid == "" {
+		return nil, fmt.Errorf("invalid disk %q: empty UUID", key)
+	}
+	switch kind {
+	case "vm_disk", "vm":
+		return VMDisk(uuid), nil
+	case "vg_disk", "vg":
+		return VGDisk(uuid), nil
+	case "recovery_point", "rp":
+		return RecoveryPointDisk(uuid), nil
+	}
+	return nil, fmt.Errorf("invalid disk %q: unknown kind %q (must be vm_disk, vg_disk or recovery_point)", key, kind)
+}
+
+// ParseCompressionType maps a CLI compression name to its proto enum
+func ParseCompressionType(name string) (protos.CompressionType, error) {
+	switch name {
+	case "", "none":
+		return protos.CompressionType_kNoCompression, nil
+	case "lz4":
+		return protos.CompressionType_kLZ4Compression, nil
+	case "snappy":
+		return protos.CompressionType_kSnappyCompression, nil
+	case "zlib":
+		return protos.CompressionType_kZlibCompression, nil
+	}
+	return protos.CompressionType_kNoCompression, fmt.Errorf("unknown compression type %q (must be none, lz4, snappy or zlib)", name)
+}
+
+// ParseChecksumType maps a CLI checksum name to its proto enum
+func ParseChecksumType(name string) (protos.ChecksumType, error) {
+	switch name {
+	case "", "none":
+		return protos.ChecksumType_kNoChecksum, nil
+	case "crc32":
+		return protos.ChecksumType_kCRC32, nil
+	case "sha1":
+		return protos.ChecksumType_kSHA1, nil
+	case "sha256":
+		return protos.ChecksumType_kSHA256, nil
+	}
+	return protos.ChecksumType_kNoChecksum, fmt.Errorf("unknown checksum type %q (must be none, crc32, sha1 or sha256)", name)
+}
@@ -0,0 +1,275 @@
+package vdisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// ErrServer is wrapped by every *ServerError, for callers that only need to
+// know that the server reported a failure
+var ErrServer = errors.New("server error")
+
+// ErrProtocol is wrapped by errors for streams that do not follow the
+// protocol, such as a write stream that ends without a VDiskWriteRet
+var ErrProtocol = errors.New("protocol error")
+
+// ServerErrorKind classifies a failure reported in a response
+type ServerErrorKind int
+
+const (
+	// ServerInternal is any failure not recognized as one of the other kinds
+	ServerInternal ServerErrorKind = iota
+	// ServerChecksumMismatch means the payload did not match its checksum
+	ServerChecksumMismatch
+	// ServerNotFound means the disk or recovery point does not exist
+	ServerNotFound
+	// ServerInvalidRequest means the server rejected the request arguments
+	ServerInvalidRequest
+	// ServerBusy means the server was overloaded or timed out and the
+	// request may succeed if retried
+	ServerBusy
+	// ServerWriteFailed means a VDiskWriteRet reported success=false without
+	// an error message
+	ServerWriteFailed
+)
+
+func (k ServerErrorKind) String() string {
+	switch k {
+	case ServerInternal:
+		return "Internal"
+	case ServerChecksumMismatch:
+		return "ChecksumMismatch"
+	case ServerNotFound:
+		return "NotFound"
+	case ServerInvalidRequest:
+		return "InvalidRequest"
+	case ServerBusy:
+		return "Busy"
+	case ServerWriteFailed:
+		return "WriteFailed"
+	}
+	return fmt.Sprintf("ServerErrorKind(%d)", int(k))
+}
+
+// ServerError is a failure the server reported in a response, either in
+// error_message or with VDiskWriteRet.success=false. It wraps ErrServer,
+// and ErrChecksumMismatch for checksum failures.
+type ServerError struct {
+	Kind ServerErrorKind
+	// Message is error_message as sent, empty when the server gave none
+	Message string
+	// Offset, Length and SequenceNumber are copied from a VDiskWriteRet;
+	// they are zero for reads
+	Offset         int64
+	Length         int64
+	SequenceNumber int64
+	RetryCount     int32
+}
+
+func (e *ServerError) Error() string {
+	switch {
+	case e.Kind == ServerChecksumMismatch:
+		return fmt.Sprintf("%v: %v: %s", ErrServer, ErrChecksumMismatch, e.Message)
+	case e.Message != "":
+		return fmt.Sprintf("%v: %s", ErrServer, e.Message)
+	}
+	return fmt.Sprintf("%v: write failed: sequence=%d, offset=%d, length=%d", ErrServer, e.SequenceNumber, e.Offset, e.Length)
+}
+
+func (e *ServerError) Unwrap() []error {
+	if e.Kind == ServerChecksumMismatch {
+		return []error{ErrServer, ErrChecksumMismatch}
+	}
+	return []error{ErrServer}
+}
+
+// parseServerKind classifies a failure from the wording of error_message,
+// the only description of a failure the server sends. Everything else
+// looks at the resulting kind.
+func parseServerKind(msg string) ServerErrorKind {
+	m := strings.ToLower(msg)
+	contains := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(m, w) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case strings.Contains(m, "checksum") && contains("mismatch", "invalid", "fail"):
+		return ServerChecksumMismatch
+	case contains("not found", "does not exist", "no such"):
+		return ServerNotFound
+	case contains("invalid", "required", "out of range", "exceeds", "unsupported", "bad request"):
+		return ServerInvalidRequest
+	case contains("busy", "overload", "throttl", "too many", "try again", "retry", "timed out", "timeout"):
+		return ServerBusy
+	}
+	return ServerInternal
+}
+
+// isSuccessMessage reports whether error_message holds the informational
+// text the server sends with successful responses, such as "Write operation
+// successful". Any message mentioning success and no failure counts, so a
+// change of wording does not turn successes into failures.
+func isSuccessMessage(msg string) bool {
+	m := strings.ToLower(msg)
+	return strings.Contains(m, "success") &&
+		!strings.Contains(m, "unsuccess") && !strings.Contains(m, "fail") && !strings.Contains(m, "error")
+}
+
+// ServerKind returns the kind of the *ServerError in err's chain. It
+// reports false when err is not a failure reported by the server.
+func ServerKind(err error) (ServerErrorKind, bool) {
+	var serr *ServerError
+	if errors.As(err, &serr) {
+		return serr.Kind, true
+	}
+	return 0, false
+}
+
+// readError returns the failure reported by a read response, or nil.
+// VDiskReadRet has no success flag. A response carrying range_vec or data
+// is never failed by its message. One carrying only a message fails when
+// parseServerKind recognizes it or it says it failed or is an error; other
+// messages, such as "Read completed", are informational.
+func readError(resp *protos.VDiskReadRet) error {
+	msg := resp.GetErrorMessage()
+	if msg == "" || len(resp.RangeVec) > 0 || len(resp.Data) > 0 {
+		return nil
+	}
+	kind := parseServerKind(msg)
+	if kind == ServerInternal && !isFailureMessage(msg) {
+		return nil
+	}
+	return &ServerError{Kind: kind, Message: msg}
+}
+
+// isFailureMessage reports whether msg says in so many words that the
+// request failed
+func isFailureMessage(msg string) bool {
+	m := strings.ToLower(msg)
+	return strings.Contains(m, "fail") || strings.Contains(m, "error") || strings.Contains(m, "unsuccess")
+}
+
+// writeError returns the failure reported by a write response, or nil.
+// The success flag decides when the server sets it; error_message is only
+// consulted for its absence or to classify a failure.
+func writeError(resp *protos.VDiskWriteRet) error {
+	msg := resp.GetErrorMessage()
+	if resp.Success != nil && *resp.Success {
+		return nil
+	}
+	if resp.Success == nil && (msg == "" || isSuccessMessage(msg)) {
+		return nil
+	}
+
+	e := &ServerError{
+		Kind:           ServerWriteFailed,
+		Offset:         resp.GetOffset(),
+		Length:         resp.GetLength(),
+		SequenceNumber: resp.GetSequenceNumber(),
+		RetryCount:     resp.GetRetryCount(),
+	}
+	if msg != "" && !isSuccessMessage(msg) {
+		e.Kind = parseServerKind(msg)
+		e.Message = msg
+	}
+	return e
+}
+
+// Category groups request failures by what went wrong, for reports
+type Category string
+
+// Failure categories returned by Classify
+const (
+	// CategoryTransport is a connection or stream failure: gRPC UNAVAILABLE
+	// or ABORTED, or a pipelined session that ended
+	CategoryTransport Category = "Transport"
+	// CategoryTimeout is a deadline exceeded on the client or server
+	CategoryTimeout Category = "Timeout"
+	// CategoryCanceled is a request canceled by the caller
+	CategoryCanceled Category = "Canceled"
+	// CategoryAuth is a request rejected for its credentials
+	CategoryAuth Category = "Auth"
+	// CategoryInvalidRequest is a request the server rejected as invalid,
+	// including unknown disks
+	CategoryInvalidRequest Category = "InvalidRequest"
+	// CategoryOverload is a request refused for lack of resources
+	CategoryOverload Category = "Overload"
+	// CategoryIntegrity is a checksum mismatch or data loss
+	CategoryIntegrity Category = "Integrity"
+	// CategoryServer is any other failure reported by the server
+	CategoryServer Category = "Server"
+	// CategoryProtocol is a stream that broke the protocol
+	CategoryProtocol Category = "Protocol"
+	// CategoryClient is a failure on the client side, such as a payload
+	// that could not be encoded
+	CategoryClient Category = "Client"
+)
+
+// Classify returns the category of a request failure and a code naming it
+// more precisely: the gRPC status code name, the ServerErrorKind of a
+// server reported failure, or one of SessionClosed, Protocol,
+// DeadlineExceeded, Canceled and Other.
+func Classify(err error) (Category, string) {
+	var serr *ServerError
+	if errors.As(err, &serr) {
+		switch serr.Kind {
+		case ServerChecksumMismatch:
+			return CategoryIntegrity, serr.Kind.String()
+		case ServerNotFound, ServerInvalidRequest:
+			return CategoryInvalidRequest, serr.Kind.String()
+		case ServerBusy:
+			return CategoryOverload, serr.Kind.String()
+		}
+		return CategoryServer, serr.Kind.String()
+	}
+	switch {
+	case errors.Is(err, ErrProtocol):
+		return CategoryProtocol, "Protocol"
+	case errors.Is(err, ErrSessionClosed):
+		return CategoryTransport, "SessionClosed"
+	}
+
+	if st, ok := status.FromError(err); ok {
+		return codeCategory(st.Code()), st.Code().String()
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return CategoryTimeout, "DeadlineExceeded"
+	case errors.Is(err, context.Canceled):
+		return CategoryCanceled, "Canceled"
+	}
+	return CategoryClient, "Other"
+}
+
+// codeCategory maps a gRPC status code onto a failure category
+func codeCategory(code codes.Code) Category {
+	switch code {
+	case codes.Unavailable, codes.Aborted:
+		return CategoryTransport
+	case codes.DeadlineExceeded:
+		return CategoryTimeout
+	case codes.Canceled:
+		return CategoryCanceled
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return CategoryAuth
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition,
+		codes.NotFound, codes.AlreadyExists, codes.Unimplemented:
+		return CategoryInvalidRequest
+	case codes.ResourceExhausted:
+		return CategoryOverload
+	case codes.DataLoss:
+		return CategoryIntegrity
+	}
+	return CategoryServer
+}
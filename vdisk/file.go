@@ -0,0 +1,283 @@
+package vdisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// DefaultChunkSize is the per-message payload size used when none is configured
+const DefaultChunkSize = 1024 * 1024
+
+// FileOptions configures a File
+type FileOptions struct {
+	// MaxResponseSize is sent as max_response_size on every read
+	MaxResponseSize int64
+	// WriteChunkSize bounds the payload of each VDiskWriteArg
+	WriteChunkSize int64
+	// Compression and Checksum are applied to every write
+	Compression protos.CompressionType
+	Checksum    protos.ChecksumType
+}
+
+// File exposes a vdisk as a random-access file. It implements io.ReaderAt,
+// io.WriterAt, io.ReadWriteSeeker and is safe for concurrent ReadAt/WriteAt.
+type File struct {
+	ctx    context.Context
+	client *Client
+	disk   *protos.DiskIdentifier
+	opts   FileOptions
+	size   int64
+	seq    int64
+
+	mu     sync.Mutex
+	offset int64
+}
+
+var (
+	_ io.ReaderAt        = (*File)(nil)
+	_ io.WriterAt        = (*File)(nil)
+	_ io.ReadWriteSeeker = (*File)(nil)
+)
+
+// OpenFile opens disk as a File. The disk size is learned with DiskSize.
+// ctx bounds every operation performed on the returned File.
+func (c *Client) OpenFile(ctx context.Context, disk *protos.DiskIdentifier, opts FileOptions) (*File, error) {
+	if opts.MaxResponseSize <= 0 {
+		opts.MaxResponseSize = DefaultChunkSize
+	}
+	if opts.WriteChunkSize <= 0 {
+		opts.WriteChunkSize = DefaultChunkSize
+	}
+
+	size, err := c.DiskSize(ctx, disk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		ctx:    ctx,
+		client: c,
+		disk:   disk,
+		opts:   opts,
+		size:   size,
+	}, nil
+}
+
+// Size returns the disk size reported by the server
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// Disk returns the identifier of the underlying disk
+func (f *File) Disk() *protos.DiskIdentifier {
+	return f.disk
+}
+
+// ReadAt reads len(p) bytes starting at off. Zero ranges reported by the
+// server are filled with zeros. It returns io.EOF when the read reaches the
+// end of the disk.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("vdisk: negative offset %d", off)
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	want := p
+	if remaining := f.size - off; int64(len(want)) > remaining {
+		want = want[:remaining]
+	}
+
+	n, err := f.readFull(want, off)
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readFull fills p from off, re-issuing VDiskReadArg continuations until p
+// is full or the server stops making progress. A broken stream is resumed
+// on a new one as the client's retry policy allows.
+func (f *File) readFull(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// A per-call context releases the streams of the read once it returns
+	ctx, cancel := context.WithCancel(f.ctx)
+	defer cancel()
+	reader := f.client.NewRangeReader(ctx)
+	defer reader.Close()
+
+	end := off + int64(len(p))
+	next, err := reader.ReadRange(f.disk, off, int64(len(p)), f.opts.MaxResponseSize, func(e Extent) error {
+		dst := p[e.Offset-off : e.End()-off]
+		if e.Zero {
+			clear(dst)
+		} else {
+			copy(dst, e.Data)
+		}
+		return nil
+	})
+	return int(min(next, end) - off), err
+}
+
+// ReadRange reads [off, off+length) on an open stream, re-issuing
+// VDiskReadArg continuations until the range is covered. fn receives every
+// extent clipped to the range. It returns the offset up to which the range
+// was contiguously received, and io.ErrUnexpectedEOF if the server stops
+// making progress before the end.
+func ReadRange(stream ReadStream, disk *protos.DiskIdentifier, off, length, maxResponseSize int64, fn func(Extent) error) (int64, error) {
+	end := off + length
+	next := off
+	for next < end {
+		before := next
+		_, err := ReadFromStream(stream, ReadRequest{
+			Disk:            disk,
+			Offset:          next,
+			Length:          end - next,
+			MaxResponseSize: maxResponseSize,
+		}, func(resp *protos.VDiskReadRet) error {
+			extents, err := Extents(resp, next)
+			if err != nil {
+				return err
+			}
+			for _, e := range extents {
+				if e = clipExtent(e, off, end); e.Length == 0 {
+					continue
+				}
+				if err := fn(e); err != nil {
+					return err
+				}
+				// Ranges are expected in order; only contiguous data advances next
+				if e.Offset <= next && e.End() > next {
+					next = e.End()
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return next, err
+		}
+		if next == before {
+			return next, io.ErrUnexpectedEOF
+		}
+	}
+	return next, nil
+}
+
+// clipExtent restricts e to [lo, hi), returning a zero length extent when
+// they do not overlap
+func clipExtent(e Extent, lo, hi int64) Extent {
+	start, end := max(e.Offset, lo), min(e.End(), hi)
+	if start >= end {
+		return Extent{Offset: start}
+	}
+	if !e.Zero {
+		e.Data = e.Data[start-e.Offset : end-e.Offset]
+	}
+	e.Offset, e.Length = start, end-start
+	return e
+}
+
+// DiskSize returns the total_disk_size reported in the first response to a
+// one byte read of disk
+func (c *Client) DiskSize(ctx context.Context, disk *protos.DiskIdentifier) (int64, error) {
+	stats, err := c.Read(ctx, ReadRequest{Disk: disk, Offset: 0, Length: 1}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to probe disk size: %v", err)
+	}
+	if stats.TotalDiskSize < 0 {
+		return 0, fmt.Errorf("server did not report total_disk_size for %s", DiskKey(disk))
+	}
+	return stats.TotalDiskSize, nil
+}
+
+// WriteAt writes p at off in WriteChunkSize requests. Each is sent with
+// Client.Write, so failed chunks are retried as Options.Retry allows. Writes
+// past the end of the disk are rejected.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("vdisk: negative offset %d", off)
+	}
+	if off+int64(len(p)) > f.size {
+		return 0, fmt.Errorf("vdisk: write of %d bytes at offset %d exceeds disk size %d", len(p), off, f.size)
+	}
+
+	var written int64
+	for pos := int64(0); pos < int64(len(p)); pos += f.opts.WriteChunkSize {
+		chunk := p[pos:min(pos+f.opts.WriteChunkSize, int64(len(p)))]
+		stats, err := f.client.Write(f.ctx, WriteRequest{
+			Disk:           f.disk,
+			Offset:         off + pos,
+			Length:         int64(len(chunk)),
+			Data:           chunk,
+			Compression:    f.opts.Compression,
+			Checksum:       f.opts.Checksum,
+			SequenceNumber: atomic.AddInt64(&f.seq, 1),
+		}, nil)
+		if err != nil {
+			return int(written + min(stats.BytesWritten, int64(len(chunk)))), err
+		}
+		// bytes_written is optional, so a successful write that leaves it
+		// unset is taken to have written the whole chunk
+		if stats.BytesWrittenReported && stats.BytesWritten < int64(len(chunk)) {
+			return int(written + stats.BytesWritten), io.ErrShortWrite
+		}
+		written += int64(len(chunk))
+	}
+	return len(p), nil
+}
+
+// Read implements io.Reader at the current offset
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// Write implements io.Writer at the current offset
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.offset + offset
+	case io.SeekEnd:
+		abs = f.size + offset
+	default:
+		return 0, errors.New("vdisk: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("vdisk: negative position")
+	}
+	f.offset = abs
+	return abs, nil
+}
@@ -0,0 +1,138 @@
+package vdisk_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk/vdisktest"
+)
+
+func TestFile(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   vdisk.FileOptions
+		faults []vdisktest.Fault
+		retry  bool
+	}{
+		{name: "defaults"},
+		{name: "small chunks", opts: vdisk.FileOptions{MaxResponseSize: 4096, WriteChunkSize: 5000}},
+		{name: "compressed with checksums", opts: vdisk.FileOptions{
+			WriteChunkSize: 16 << 10,
+			Compression:    protos.CompressionType_kLZ4Compression,
+			Checksum:       protos.ChecksumType_kCRC32,
+		}},
+		{
+			name:   "bytes_written unset",
+			opts:   vdisk.FileOptions{WriteChunkSize: 16 << 10},
+			faults: []vdisktest.Fault{{Operation: vdisktest.OpWrite, OmitBytesWritten: true}},
+		},
+		{
+			name:   "resets",
+			opts:   vdisk.FileOptions{MaxResponseSize: 8192, WriteChunkSize: 16 << 10},
+			faults: []vdisktest.Fault{{Probability: 0.2, Reset: true}},
+			retry:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var configure func(*vdisk.Options)
+			if tc.retry {
+				configure = withRetry
+			}
+			s, c := startServer(t, tc.faults, configure)
+			disk := vdisk.VMDisk("file")
+			f, err := c.OpenFile(context.Background(), disk, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Size() != testDiskSize {
+				t.Fatalf("size %d, want %d", f.Size(), testDiskSize)
+			}
+
+			data := append(random(50000), compressible(50000)...)
+			const off = 7777
+			if n, err := f.WriteAt(data, off); err != nil || n != len(data) {
+				t.Fatalf("WriteAt = %d, %v", n, err)
+			}
+			if !bytes.Equal(diskBytes(t, s, disk, off, int64(len(data))), data) {
+				t.Fatal("disk differs from the data written")
+			}
+
+			got := make([]byte, len(data)+2000)
+			if n, err := f.ReadAt(got, off-1000); err != nil || n != len(got) {
+				t.Fatalf("ReadAt = %d, %v", n, err)
+			}
+			if !bytes.Equal(got[1000:1000+len(data)], data) || !bytes.Equal(got[:1000], make([]byte, 1000)) {
+				t.Error("ReadAt returned different data")
+			}
+
+			// Sequential access through Seek, Write and Read
+			if _, err := f.Seek(testDiskSize-3000, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write(data[:3000]); err != nil {
+				t.Fatal(err)
+			}
+			if pos, _ := f.Seek(-3000, io.SeekCurrent); pos != testDiskSize-3000 {
+				t.Fatalf("position %d after seeking back", pos)
+			}
+			tail, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tail, data[:3000]) {
+				t.Error("Read returned different data")
+			}
+			if tc.faults != nil && s.Stats().FaultsInjected == 0 {
+				t.Error("no faults were injected")
+			}
+		})
+	}
+}
+
+func TestFileBounds(t *testing.T) {
+	_, c := startServer(t, nil, nil)
+	f, err := c.OpenFile(context.Background(), vdisk.VMDisk("bounds"), vdisk.FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 4096)
+
+	if n, err := f.ReadAt(p, testDiskSize-100); n != 100 || err != io.EOF {
+		t.Errorf("ReadAt across the end = %d, %v; want 100, io.EOF", n, err)
+	}
+	if n, err := f.ReadAt(p, testDiskSize); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt at the end = %d, %v; want 0, io.EOF", n, err)
+	}
+	if _, err := f.ReadAt(p, -1); err == nil {
+		t.Error("ReadAt at a negative offset succeeded")
+	}
+	if _, err := f.WriteAt(p, testDiskSize-100); err == nil {
+		t.Error("WriteAt past the end succeeded")
+	}
+	if _, err := f.WriteAt(p, -1); err == nil {
+		t.Error("WriteAt at a negative offset succeeded")
+	}
+}
+
+func TestFileTruncatedResponse(t *testing.T) {
+	s, c := startServer(t, []vdisktest.Fault{{Operation: vdisktest.OpRead, Truncate: 10, After: 1}}, withRetry)
+	disk := vdisk.VMDisk("trunc")
+	d, err := s.Disk(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.WriteAt(random(8192), 0)
+
+	// The first read is the size probe of OpenFile
+	f, err := c.OpenFile(context.Background(), disk, vdisk.FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ReadAt(make([]byte, 8192), 0); err == nil {
+		t.Error("ReadAt of a truncated response succeeded")
+	}
+}
@@ -0,0 +1,161 @@
+package vdisk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// ImageWriter stores read extents in a local disk image. Extent offsets are
+// disk offsets; the writer subtracts its base so an image can start at any
+// disk offset.
+type ImageWriter interface {
+	// WriteExtent stores one extent of read data
+	WriteExtent(e Extent) error
+	// Finish extends the image to the disk offset end and flushes it
+	Finish(end int64) error
+	// Stats returns the number of data and zero bytes stored so far
+	Stats() ImageStats
+}
+
+// ImageStats counts the bytes an ImageWriter has stored
+type ImageStats struct {
+	DataBytes int64
+	ZeroBytes int64
+}
+
+// sparseImageWriter writes extents at their offsets in a seekable file,
+// leaving zero ranges as holes unless raw is set. It is safe for concurrent
+// use by writers of disjoint extents.
+type sparseImageWriter struct {
+	f         *os.File
+	base      int64
+	raw       bool
+	dataBytes atomic.Int64
+	zeroBytes atomic.Int64
+}
+
+// NewSparseImageWriter returns an ImageWriter that writes data extents at
+// their offsets in f and punches holes for zero_data extents, producing a
+// sparse file
+func NewSparseImageWriter(f *os.File, base int64) ImageWriter {
+	return &sparseImageWriter{f: f, base: base}
+}
+
+// NewRawImageWriter returns an ImageWriter like NewSparseImageWriter that
+// writes zero_data extents as literal zeros, fully allocating the image
+func NewRawImageWriter(f *os.File, base int64) ImageWriter {
+	return &sparseImageWriter{f: f, base: base, raw: true}
+}
+
+func (w *sparseImageWriter) WriteExtent(e Extent) error {
+	off := e.Offset - w.base
+	if off < 0 {
+		return fmt.Errorf("extent at offset %d precedes image base %d", e.Offset, w.base)
+	}
+	if e.Zero {
+		if w.raw {
+			if err := writeZeros(w.f, off, e.Length); err != nil {
+				return fmt.Errorf("failed to write zeros at %d: %v", off, err)
+			}
+		} else if err := punchHole(w.f, off, e.Length); err != nil {
+			return fmt.Errorf("failed to punch hole at %d: %v", off, err)
+		}
+		w.zeroBytes.Add(e.Length)
+		return nil
+	}
+	if _, err := w.f.WriteAt(e.Data, off); err != nil {
+		return fmt.Errorf("failed to write image at %d: %v", off, err)
+	}
+	w.dataBytes.Add(e.Length)
+	return nil
+}
+
+func (w *sparseImageWriter) Finish(end int64) error {
+	fi, err := w.f.Stat()
+	if err != nil {
+		return err
+	}
+	// Trailing zero ranges leave the file short; extend it with a hole
+	if size := end - w.base; size > fi.Size() {
+		if err := w.f.Truncate(size); err != nil {
+			return fmt.Errorf("failed to extend image to %d bytes: %v", size, err)
+		}
+	}
+	return w.f.Sync()
+}
+
+func (w *sparseImageWriter) Stats() ImageStats {
+	return ImageStats{DataBytes: w.dataBytes.Load(), ZeroBytes: w.zeroBytes.Load()}
+}
+
+// sequentialImageWriter writes extents to a non-seekable stream, filling
+// zero ranges and gaps with literal zeros
+type sequentialImageWriter struct {
+	w     io.Writer
+	pos   int64
+	stats ImageStats
+}
+
+// NewSequentialImageWriter returns an ImageWriter for non-seekable outputs
+// such as stdout. Extents must arrive in ascending offset order.
+func NewSequentialImageWriter(w io.Writer, base int64) ImageWriter {
+	return &sequentialImageWriter{w: w, pos: base}
+}
+
+func (w *sequentialImageWriter) WriteExtent(e Extent) error {
+	if e.Offset < w.pos {
+		return fmt.Errorf("out of order extent at offset %d, output is at %d", e.Offset, w.pos)
+	}
+	if err := w.zeroFill(e.Offset); err != nil {
+		return err
+	}
+	if e.Zero {
+		if err := w.zeroFill(e.End()); err != nil {
+			return err
+		}
+		w.stats.ZeroBytes += e.Length
+		return nil
+	}
+	if _, err := w.w.Write(e.Data); err != nil {
+		return err
+	}
+	w.pos = e.End()
+	w.stats.DataBytes += e.Length
+	return nil
+}
+
+func (w *sequentialImageWriter) zeroFill(end int64) error {
+	var zeros [64 * 1024]byte
+	for w.pos < end {
+		n := min(end-w.pos, int64(len(zeros)))
+		if _, err := w.w.Write(zeros[:n]); err != nil {
+			return err
+		}
+		w.pos += n
+	}
+	return nil
+}
+
+func (w *sequentialImageWriter) Finish(end int64) error {
+	return w.zeroFill(end)
+}
+
+func (w *sequentialImageWriter) Stats() ImageStats {
+	return w.stats
+}
+
+// writeZeros overwrites [off, off+length) in f with zeros
+func writeZeros(f *os.File, off, length int64) error {
+	var zeros [64 * 1024]byte
+	for length > 0 {
+		n := min(length, int64(len(zeros)))
+		if _, err := f.WriteAt(zeros[:n], off); err != nil {
+			return err
+		}
+		off += n
+		length -= n
+	}
+	return nil
+}
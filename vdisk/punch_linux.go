@@ -0,0 +1,28 @@
+//go:build linux
+
+package vdisk
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// punchHole deallocates [off, off+length) in f, leaving a hole that reads
+// back as zeros. Holes beyond the end of the file are left for Finish.
+func punchHole(f *os.File, off, length int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if off >= fi.Size() || length <= 0 {
+		return nil
+	}
+	length = min(length, fi.Size()-off)
+
+	err = unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, off, length)
+	if err == unix.EOPNOTSUPP {
+		return writeZeros(f, off, length)
+	}
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package vdisk
+
+import "os"
+
+// punchHole zero fills [off, off+length) in f on platforms without hole
+// punching. Ranges beyond the end of the file are left for Finish.
+func punchHole(f *os.File, off, length int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if off >= fi.Size() || length <= 0 {
+		return nil
+	}
+	return writeZeros(f, off, min(length, fi.Size()-off))
+}
@@ -0,0 +1,102 @@
+package vdisk
+
+import (
+	"context"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// RangeReader reads disk ranges over one VDiskStreamRead stream, reused
+// from range to range. When the stream breaks with an error Options.Retry
+// allows retrying, such as UNAVAILABLE after a proxy restart or a keepalive
+// timeout, it opens a new stream, on another pooled connection if there is
+// one, and continues the range from the last offset received. Resuming is
+// subject to the client's retry budget, and MaxRetries bounds the
+// consecutive failures that make no progress. Without Options.Retry the
+// first error ends the range, as with ReadRange. A RangeReader is not safe
+// for concurrent use.
+type RangeReader struct {
+	client  *Client
+	ctx     context.Context
+	stream  ReadStream
+	cancel  context.CancelFunc
+	conn    int
+	retries int
+}
+
+// NewRangeReader returns a RangeReader whose streams are bound to ctx. The
+// first stream is opened by the first ReadRange.
+func (c *Client) NewRangeReader(ctx context.Context) *RangeReader {
+	return &RangeReader{client: c, ctx: ctx, conn: -1}
+}
+
+// ReadRange reads [off, off+length) like ReadRange, resuming on a new
+// stream after retryable failures. fn receives every byte of the range
+// once, except that extents received past a gap in the data may be passed
+// again after a resume. It returns the offset up to which the range was
+// contiguously received.
+func (r *RangeReader) ReadRange(disk *protos.DiskIdentifier, off, length, maxResponseSize int64, fn func(Extent) error) (int64, error) {
+	end := off + length
+	next := off
+	failures := 0
+	for {
+		var err error
+		if r.stream == nil {
+			err = r.openStream()
+		}
+		if err == nil {
+			var got int64
+			got, err = ReadRange(r.stream, disk, next, end-next, maxResponseSize, fn)
+			if got > next {
+				failures = 0
+			}
+			next = got
+			if err == nil {
+				r.client.budget.success()
+				return next, nil
+			}
+			// The stream may be mid-response, so it is not reused
+			r.closeStream()
+		}
+
+		if !r.client.retry(r.ctx, err, false, failures) {
+			return next, err
+		}
+		failures++
+		r.retries++
+	}
+}
+
+// Retries returns the number of times a range was resumed on a new stream
+func (r *RangeReader) Retries() int {
+	return r.retries
+}
+
+// Close closes the current stream
+func (r *RangeReader) Close() error {
+	return r.closeStream()
+}
+
+// openStream opens a stream under its own context, so that closeStream can
+// release it without waiting for the server to end it
+func (r *RangeReader) openStream() error {
+	ctx, cancel := context.WithCancel(r.ctx)
+	stream, conn, err := r.client.openReadStream(ctx, r.conn)
+	r.conn = conn
+	if err != nil {
+		cancel()
+		return err
+	}
+	r.stream, r.cancel = stream, cancel
+	return nil
+}
+
+func (r *RangeReader) closeStream() error {
+	if r.stream == nil {
+		return nil
+	}
+	err := r.stream.CloseSend()
+	r.cancel()
+	r.stream, r.cancel = nil, nil
+	return err
+}
@@ -0,0 +1,69 @@
+package vdisk
+
+import (
+	"fmt"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// Extent is a contiguous piece of a read response mapped onto the disk
+type Extent struct {
+	Offset int64
+	Length int64
+	// Zero is set for ranges reported with zero_data; Data is nil for them
+	Zero bool
+	Data []byte
+}
+
+// End returns the offset just past the extent
+func (e Extent) End() int64 {
+	return e.Offset + e.Length
+}
+
+// Extents maps the data of a VDiskReadRet onto its range_vec. The bytes of
+// non-zero ranges are concatenated in data in range_vec order; zero_data
+// ranges may either be omitted from data or carried as literal zeros. When
+// the response has no range_vec its data is assumed to start at next.
+func Extents(resp *protos.VDiskReadRet, next int64) ([]Extent, error) {
+	if len(resp.RangeVec) == 0 {
+		if len(resp.Data) == 0 {
+			return nil, nil
+		}
+		return []Extent{{Offset: next, Length: int64(len(resp.Data)), Data: resp.Data}}, nil
+	}
+
+	var dataLen, allLen int64
+	for _, r := range resp.RangeVec {
+		if r.GetLength() < 0 {
+			return nil, fmt.Errorf("invalid range: offset=%d, length=%d", r.GetOffset(), r.GetLength())
+		}
+		allLen += r.GetLength()
+		if !r.GetZeroData() {
+			dataLen += r.GetLength()
+		}
+	}
+
+	zerosInline := false
+	switch int64(len(resp.Data)) {
+	case dataLen:
+	case allLen:
+		zerosInline = true
+	default:
+		return nil, fmt.Errorf("response data length %d does not match range_vec (%d data bytes in %d ranges)",
+			len(resp.Data), dataLen, len(resp.RangeVec))
+	}
+
+	extents := make([]Extent, 0, len(resp.RangeVec))
+	pos := int64(0)
+	for _, r := range resp.RangeVec {
+		e := Extent{Offset: r.GetOffset(), Length: r.GetLength(), Zero: r.GetZeroData()}
+		if !e.Zero {
+			e.Data = resp.Data[pos : pos+e.Length]
+			pos += e.Length
+		} else if zerosInline {
+			pos += e.Length
+		}
+		extents = append(extents, e)
+	}
+	return extents, nil
+}
@@ -0,0 +1,296 @@
+package vdisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// DefaultPipelineWindow is the in-flight request limit used when none is configured
+const DefaultPipelineWindow = 8
+
+// ErrSessionClosed is reported for requests outstanding when a session's
+// stream is closed
+var ErrSessionClosed = errors.New("vdisk: session closed")
+
+// ReadSessionOptions configures a ReadSession
+type ReadSessionOptions struct {
+	// Window is the maximum number of requests in flight on the stream
+	Window int
+	// KeepData retains the response extents in each ReadResult. When unset
+	// only byte counts are reported.
+	KeepData bool
+}
+
+// ReadResult is the outcome of one request submitted to a ReadSession
+type ReadResult struct {
+	Request       ReadRequest
+	Extents       []Extent
+	Responses     int
+	BytesRead     int64
+	TotalDiskSize int64
+	Latency       time.Duration
+	Err           error
+}
+
+type pendingRead struct {
+	req     ReadRequest
+	start   time.Time
+	next    int64
+	covered int64
+	result  ReadResult
+	done    chan ReadResult
+}
+
+// ReadSession pipelines many VDiskReadArg messages over a single long-lived
+// VDiskStreamRead stream. Responses are matched to requests using the
+// offsets in their range_vec; responses without ranges are attributed to the
+// oldest outstanding request.
+type ReadSession struct {
+	stream    ReadStream
+	cancel    context.CancelFunc
+	connIndex int
+	opts      ReadSessionOptions
+	window    chan struct{}
+
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	pending []*pendingRead
+	err     error
+
+	done chan struct{}
+}
+
+// NewReadSession opens a VDiskStreamRead stream on the next pooled connection
+// and starts receiving responses for it
+func (c *Client) NewReadSession(ctx context.Context, opts ReadSessionOptions) (*ReadSession, error) {
+	if opts.Window < 1 {
+		opts.Window = DefaultPipelineWindow
+	}
+
+	// The session's own context releases the stream once it fails or is closed
+	ctx, cancel := context.WithCancel(ctx)
+	stream, index, err := c.OpenReadStream(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s := &ReadSession{
+		stream:    stream,
+		cancel:    cancel,
+		connIndex: index,
+		opts:      opts,
+		window:    make(chan struct{}, opts.Window),
+		done:      make(chan struct{}),
+	}
+	go s.receive()
+	return s, nil
+}
+
+// ConnIndex returns the index of the pooled connection carrying the stream
+func (s *ReadSession) ConnIndex() int {
+	return s.connIndex
+}
+
+// InFlight returns the number of requests awaiting responses
+func (s *ReadSession) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// Submit sends req on the stream, blocking while the window is full. The
+// returned channel receives exactly one ReadResult.
+func (s *ReadSession) Submit(ctx context.Context, req ReadRequest) (<-chan ReadResult, error) {
+	select {
+	case s.window <- struct{}{}:
+	case <-s.done:
+		return nil, s.failure()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p := &pendingRead{
+		req:   req,
+		start: time.Now(),
+		next:  req.Offset,
+		done:  make(chan ReadResult, 1),
+	}
+	p.result = ReadResult{Request: req, TotalDiskSize: -1}
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		<-s.window
+		return nil, s.err
+	}
+	s.pending = append(s.pending, p)
+	s.mu.Unlock()
+
+	s.sendMu.Lock()
+	err := s.stream.Send(NewReadArg(req))
+	s.sendMu.Unlock()
+	if err != nil {
+		s.fail(fmt.Errorf("failed to send read request: %w", err))
+		return nil, s.failure()
+	}
+	return p.done, nil
+}
+
+// Read submits req and waits for its result or for ctx to expire
+func (s *ReadSession) Read(ctx context.Context, req ReadRequest) ReadResult {
+	ch, err := s.Submit(ctx, req)
+	if err != nil {
+		return ReadResult{Request: req, TotalDiskSize: -1, Err: err}
+	}
+	select {
+	case r := <-ch:
+		return r
+	case <-ctx.Done():
+		return ReadResult{Request: req, TotalDiskSize: -1, Err: ctx.Err()}
+	}
+}
+
+// Close half-closes the stream and waits for outstanding requests to finish
+func (s *ReadSession) Close() error {
+	s.sendMu.Lock()
+	err := s.stream.CloseSend()
+	s.sendMu.Unlock()
+
+	<-s.done
+	s.cancel()
+	if err != nil {
+		return fmt.Errorf("failed to close send stream: %w", err)
+	}
+	return nil
+}
+
+// Err returns the error that terminated the session, or ErrSessionClosed
+// once the stream has ended normally
+func (s *ReadSession) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *ReadSession) failure() error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return ErrSessionClosed
+}
+
+// fail terminates the session and completes all outstanding requests with err
+func (s *ReadSession) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	// Ends a Recv still blocked on a stream whose Send failed
+	s.cancel()
+
+	for _, p := range pending {
+		s.complete(p, err)
+	}
+}
+
+func (s *ReadSession) complete(p *pendingRead, err error) {
+	p.result.Err = err
+	p.result.Latency = time.Since(p.start)
+	p.done <- p.result
+	<-s.window
+}
+
+func (s *ReadSession) receive() {
+	defer close(s.done)
+
+	for {
+		resp, err := s.stream.Recv()
+		if err == io.EOF {
+			s.fail(ErrSessionClosed)
+			return
+		}
+		if err != nil {
+			s.fail(fmt.Errorf("stream error: %w", err))
+			return
+		}
+		s.dispatch(resp)
+	}
+}
+
+// match returns the outstanding request a response belongs to: the oldest
+// one whose next expected offset is the response's first offset. Overlapping
+// requests share offsets, so matching on containment would hand a request
+// data meant for another. A response without range_vec carries no offset of
+// its own and continues whichever request it is matched to, the oldest.
+func (s *ReadSession) match(resp *protos.VDiskReadRet) (int, *pendingRead, error) {
+	if len(s.pending) == 0 {
+		return -1, nil, nil
+	}
+	if len(resp.RangeVec) == 0 {
+		return 0, s.pending[0], nil
+	}
+	offset := resp.RangeVec[0].GetOffset()
+	for i, p := range s.pending {
+		if p.next == offset {
+			return i, p, nil
+		}
+	}
+	return -1, nil, fmt.Errorf("%w: response at offset %d does not continue any outstanding request", ErrProtocol, offset)
+}
+
+func (s *ReadSession) dispatch(resp *protos.VDiskReadRet) {
+	s.mu.Lock()
+	i, p, err := s.match(resp)
+	if p == nil {
+		s.mu.Unlock()
+		if err != nil {
+			s.fail(err)
+		}
+		return
+	}
+
+	p.result.Responses++
+	finished := resp.HasMoreData != nil && !*resp.HasMoreData
+
+	err = readError(resp)
+	if err == nil {
+		var extents []Extent
+		extents, err = Extents(resp, p.next)
+		for _, e := range extents {
+			p.covered += e.Length
+			if e.End() > p.next {
+				p.next = e.End()
+			}
+		}
+		if s.opts.KeepData {
+			p.result.Extents = append(p.result.Extents, extents...)
+		}
+	}
+	p.result.BytesRead += int64(len(resp.Data))
+	if resp.TotalDiskSize != nil {
+		p.result.TotalDiskSize = *resp.TotalDiskSize
+	}
+	if p.req.Length > 0 && p.covered >= p.req.Length {
+		finished = true
+	}
+
+	if err == nil && !finished {
+		s.mu.Unlock()
+		return
+	}
+	s.pending = append(s.pending[:i], s.pending[i+1:]...)
+	s.mu.Unlock()
+
+	s.complete(p, err)
+}
@@ -0,0 +1,416 @@
+package vdisk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// DefaultRestoreBlockSize is the granularity at which a restore looks for
+// all-zero blocks
+const DefaultRestoreBlockSize = 64 * 1024
+
+// DefaultRestoreSpan bounds the disk range covered by one restore
+// VDiskWriteArg, which matters for images with long zero runs
+const DefaultRestoreSpan = 64 * 1024 * 1024
+
+// RestoreOptions configures Client.Restore
+type RestoreOptions struct {
+	// BlockSize is the zero detection granularity. Defaults to
+	// DefaultRestoreBlockSize.
+	BlockSize int64
+	// MaxPayload caps the non-zero bytes carried by one VDiskWriteArg.
+	// Defaults to DefaultChunkSize.
+	MaxPayload int64
+	// MaxSpan caps the disk range covered by one VDiskWriteArg. Defaults to
+	// DefaultRestoreSpan.
+	MaxSpan int64
+	// Streams is the number of write streams. Defaults to the client's pool
+	// size.
+	Streams int
+	// Window is the number of writes in flight per stream
+	Window      int
+	Compression protos.CompressionType
+	Checksum    protos.ChecksumType
+	// Progress, if set, is called after every acknowledged write
+	Progress func(RestoreProgress)
+}
+
+// RestoreProgress describes how far a restore has got
+type RestoreProgress struct {
+	ImageSize      int64
+	CompletedBytes int64
+	Elapsed        time.Duration
+}
+
+// RestoreStats summarises a restore
+type RestoreStats struct {
+	ImageSize int64
+	Writes    int
+	DataBytes int64
+	ZeroBytes int64
+	WireBytes int64
+	Duration  time.Duration
+}
+
+// restoreBatch accumulates consecutive blocks into one multi-range write
+type restoreBatch struct {
+	offset int64
+	end    int64
+	ranges []*protos.DiskDataRange
+	data   []byte
+}
+
+// add appends a block to the batch, merging it with the previous range
+// when both are data or both are zeros
+func (b *restoreBatch) add(length int64, zero bool) {
+	if n := len(b.ranges); n > 0 && b.ranges[n-1].GetZeroData() == zero {
+		b.ranges[n-1].Length = proto.Int64(b.ranges[n-1].GetLength() + length)
+	} else {
+		b.ranges = append(b.ranges, &protos.DiskDataRange{
+			Offset:   proto.Int64(b.end),
+			Length:   proto.Int64(length),
+			ZeroData: proto.Bool(zero),
+		})
+	}
+	b.end += length
+}
+
+// Restore writes the first size bytes of r to disk. r is scanned in
+// opts.BlockSize blocks; all-zero blocks are sent as zero_data ranges
+// without payload and the rest as data ranges, with consecutive blocks
+// batched into multi-range VDiskWriteArg messages. Batches are pipelined
+// over opts.Streams write sessions spread across the connection pool.
+//
+// The disk must be at least size bytes long.
+func (c *Client) Restore(ctx context.Context, disk *protos.DiskIdentifier, r io.ReaderAt, size int64, opts RestoreOptions) (RestoreStats, error) {
+	start := time.Now()
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = DefaultRestoreBlockSize
+	}
+	if opts.MaxPayload <= 0 {
+		opts.MaxPayload = DefaultChunkSize
+	}
+	if opts.MaxPayload < opts.BlockSize {
+		opts.MaxPayload = opts.BlockSize
+	}
+	if opts.MaxSpan <= 0 {
+		opts.MaxSpan = DefaultRestoreSpan
+	}
+	if opts.Streams <= 0 {
+		opts.Streams = c.PoolSize()
+	}
+	stats := RestoreStats{ImageSize: size}
+
+	diskSize, err := c.DiskSize(ctx, disk)
+	if err != nil {
+		return stats, err
+	}
+	if size > diskSize {
+		return stats, fmt.Errorf("image is %d bytes but %s is only %d bytes", size, DiskKey(disk), diskSize)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		acked    int64
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+	onResult := func(res WriteResult) {
+		if res.Err != nil {
+			fail(fmt.Errorf("write at offset %d: %w", res.Offset, res.Err))
+			return
+		}
+		mu.Lock()
+		stats.Writes++
+		stats.WireBytes += res.WireBytes
+		acked += res.Length
+		progress := RestoreProgress{
+			ImageSize:      size,
+			CompletedBytes: acked,
+			Elapsed:        time.Since(start),
+		}
+		mu.Unlock()
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	sessions := make([]*WriteSession, 0, opts.Streams)
+	for i := 0; i < opts.Streams; i++ {
+		session, err := c.NewWriteSession(ctx, WriteSessionOptions{
+			Window:        opts.Window,
+			FirstSequence: int64(i) << 32,
+			OnResult:      onResult,
+		})
+		if err != nil {
+			cancel()
+			for _, s := range sessions {
+				s.Close()
+			}
+			return stats, fmt.Errorf("failed to open restore stream %d: %v", i, err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	batches := make(chan WriteRequest, opts.Streams)
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *WriteSession) {
+			defer wg.Done()
+			for req := range batches {
+				if _, err := session.Submit(ctx, req); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}(session)
+	}
+
+	err = scanImage(ctx, r, size, opts, func(b *restoreBatch) {
+		mu.Lock()
+		for _, rg := range b.ranges {
+			if rg.GetZeroData() {
+				stats.ZeroBytes += rg.GetLength()
+			} else {
+				stats.DataBytes += rg.GetLength()
+			}
+		}
+		mu.Unlock()
+
+		select {
+		case batches <- WriteRequest{
+			Disk:        disk,
+			Ranges:      b.ranges,
+			Data:        b.data,
+			Compression: opts.Compression,
+			Checksum:    opts.Checksum,
+		}:
+		case <-ctx.Done():
+		}
+	})
+	close(batches)
+	wg.Wait()
+
+	// Closing waits for the acknowledgements still in flight
+	for _, session := range sessions {
+		if cerr := session.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	stats.Duration = time.Since(start)
+	if firstErr != nil {
+		return stats, firstErr
+	}
+	return stats, err
+}
+
+// scanImage reads r in blocks, classifies each as data or zeros and passes
+// batches that respect the payload and span limits to emit
+func scanImage(ctx context.Context, r io.ReaderAt, size int64, opts RestoreOptions, emit func(*restoreBatch)) error {
+	zeros := make([]byte, opts.BlockSize)
+	batch := &restoreBatch{data: make([]byte, 0, opts.MaxPayload)}
+
+	flush := func() {
+		if len(batch.ranges) > 0 {
+			emit(batch)
+		}
+		batch = &restoreBatch{offset: batch.end, end: batch.end, data: make([]byte, 0, opts.MaxPayload)}
+	}
+
+	for off := int64(0); off < size; off += opts.BlockSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := min(opts.BlockSize, size-off)
+		if int64(len(batch.data))+n > opts.MaxPayload || batch.end-batch.offset+n > opts.MaxSpan {
+			flush()
+		}
+
+		// Read straight into the batch payload and drop the block if zero
+		block := batch.data[len(batch.data) : int64(len(batch.data))+n]
+		if _, err := r.ReadAt(block, off); err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read image at %d: %v", off, err)
+		}
+		zero := bytes.Equal(block, zeros[:n])
+		if !zero {
+			batch.data = batch.data[:int64(len(batch.data))+n]
+		}
+		batch.add(n, zero)
+	}
+	flush()
+	return nil
+}
+
+// VerifyOptions configures Client.Verify
+type VerifyOptions struct {
+	// ChunkSize is the amount compared per read. Defaults to
+	// DefaultChunkSize.
+	ChunkSize int64
+	// Streams is the number of chunks compared in parallel. Defaults to the
+	// client's pool size.
+	Streams         int
+	MaxResponseSize int64
+	// MaxMismatches limits the ranges recorded in VerifyStats.Mismatches;
+	// 0 records them all
+	MaxMismatches int
+}
+
+// VerifyStats is the outcome of comparing a disk with a local image
+type VerifyStats struct {
+	ComparedBytes int64
+	MismatchBytes int64
+	// Mismatches holds the differing ranges in the order they were found
+	Mismatches []ByteRange
+	// Retries counts the reads resumed on a new stream after a failure
+	Retries  int64
+	Duration time.Duration
+}
+
+// Verify reads the first size bytes of disk and compares them with r,
+// reporting every range in which they differ. A non-nil error means the
+// comparison could not be completed; differences are reported in the stats.
+func (c *Client) Verify(ctx context.Context, disk *protos.DiskIdentifier, r io.ReaderAt, size int64, opts VerifyOptions) (VerifyStats, error) {
+	start := time.Now()
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	if opts.Streams <= 0 {
+		opts.Streams = c.PoolSize()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		stats    VerifyStats
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+	mismatch := func(off, length int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		stats.MismatchBytes += length
+		if n := len(stats.Mismatches); n > 0 && stats.Mismatches[n-1].Offset+stats.Mismatches[n-1].Length == off {
+			stats.Mismatches[n-1].Length += length
+			return
+		}
+		if opts.MaxMismatches == 0 || len(stats.Mismatches) < opts.MaxMismatches {
+			stats.Mismatches = append(stats.Mismatches, ByteRange{Offset: off, Length: length})
+		}
+	}
+
+	work := make(chan int64)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Streams; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reader := c.NewRangeReader(ctx)
+			defer func() {
+				reader.Close()
+				mu.Lock()
+				stats.Retries += int64(reader.Retries())
+				mu.Unlock()
+			}()
+
+			local := make([]byte, opts.ChunkSize)
+			var zeros []byte
+			for off := range work {
+				length := min(opts.ChunkSize, size-off)
+				if _, err := r.ReadAt(local[:length], off); err != nil && err != io.EOF {
+					fail(fmt.Errorf("failed to read image at %d: %v", off, err))
+					return
+				}
+				_, err := reader.ReadRange(disk, off, length, opts.MaxResponseSize, func(e Extent) error {
+					got := e.Data
+					if e.Zero {
+						if int64(len(zeros)) < e.Length {
+							zeros = make([]byte, e.Length)
+						}
+						got = zeros[:e.Length]
+					}
+					diffRanges(e.Offset, local[e.Offset-off:e.End()-off], got, mismatch)
+					return nil
+				})
+				if err != nil {
+					fail(fmt.Errorf("chunk at offset %d: %w", off, err))
+					return
+				}
+				mu.Lock()
+				stats.ComparedBytes += length
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for off := int64(0); off < size; off += opts.ChunkSize {
+		select {
+		case work <- off:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	stats.Duration = time.Since(start)
+	if firstErr != nil {
+		return stats, firstErr
+	}
+	return stats, ctx.Err()
+}
+
+// diffRanges calls fn for every run of bytes in which want and got differ.
+// base is the disk offset of both slices.
+func diffRanges(base int64, want, got []byte, fn func(off, length int64)) {
+	if bytes.Equal(want, got) {
+		return
+	}
+	start := -1
+	for i := range want {
+		if want[i] != got[i] {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			fn(base+int64(start), int64(i-start))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		fn(base+int64(start), int64(len(want)-start))
+	}
+}
@@ -0,0 +1,171 @@
+package vdisk
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultRetryableCodes are the gRPC codes retried when
+// RetryPolicy.RetryableCodes is nil
+var DefaultRetryableCodes = []codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.Aborted}
+
+// RetryPolicy configures how Client.Read, Client.Write and RangeReader
+// retry failed requests. Reads resume from the end of the data already
+// received, so the handler sees every byte once. Pipelined sessions are not
+// retried.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt; 0
+	// disables retries. RangeReader counts only the failures in a row that
+	// received no new data.
+	MaxRetries int
+
+	// The delay before retry n is InitialBackoff*Multiplier^(n-1), capped
+	// at MaxBackoff and randomized by up to ±Jitter of itself
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+
+	// RetryableCodes lists the gRPC status codes worth retrying; nil uses
+	// DefaultRetryableCodes. Server errors of kind ServerBusy are always
+	// retryable.
+	RetryableCodes []codes.Code
+
+	// RetryWrites retries writes as well as reads. Only set it when writes
+	// are idempotent: a write that failed in transit may have been applied,
+	// and is sent again with the same ranges, data and sequence number.
+	// Writes answered with success=false are retryable too.
+	RetryWrites bool
+
+	// BudgetTokens and BudgetRatio limit retries across all requests of the
+	// client, like gRPC retry throttling: every retryable failure takes a
+	// token, every success returns BudgetRatio tokens, and no retries are
+	// made while half or fewer of the BudgetTokens remain. 0 BudgetTokens
+	// disables the budget.
+	BudgetTokens float64
+	BudgetRatio  float64
+}
+
+// DefaultRetryPolicy returns the policy the CLI uses when retries are
+// enabled. The attempts and initial delay match the server's
+// grpc_write_retry_max_attempts and grpc_write_retry_initial_delay_ms
+// defaults, with the delay doubling on every retry as it does there.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:     3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		BudgetTokens:   10,
+		BudgetRatio:    0.1,
+	}
+}
+
+// Backoff returns the jittered delay before retry n, counting from 1
+func (p *RetryPolicy) Backoff(n int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(n-1))
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// Retryable reports whether a request that failed with err may succeed if
+// sent again
+func (p *RetryPolicy) Retryable(err error, write bool) bool {
+	if write && !p.RetryWrites {
+		return false
+	}
+	var serr *ServerError
+	if errors.As(err, &serr) {
+		return serr.Kind == ServerBusy || write && serr.Kind == ServerWriteFailed
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	retryable := p.RetryableCodes
+	if retryable == nil {
+		retryable = DefaultRetryableCodes
+	}
+	for _, code := range retryable {
+		if st.Code() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBudget is the token bucket of RetryPolicy.BudgetTokens
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+func newRetryBudget(p *RetryPolicy) *retryBudget {
+	if p == nil || p.BudgetTokens <= 0 {
+		return nil
+	}
+	return &retryBudget{tokens: p.BudgetTokens, max: p.BudgetTokens, ratio: p.BudgetRatio}
+}
+
+// success returns tokens for a successful request
+func (b *retryBudget) success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, b.max)
+}
+
+// failure takes a token for a retryable failure and reports whether the
+// budget still allows a retry
+func (b *retryBudget) failure() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = max(b.tokens-1, 0)
+	return b.tokens > b.max/2
+}
+
+// retry decides whether to make retry n+1 of a request that failed with
+// err, and waits for its backoff if so
+func (c *Client) retry(ctx context.Context, err error, write bool, n int) bool {
+	p := c.opts.Retry
+	if p == nil || ctx.Err() != nil || !p.Retryable(err, write) {
+		return false
+	}
+	// Every retryable failure counts against the budget, also the last
+	if !c.budget.failure() || n >= p.MaxRetries {
+		return false
+	}
+
+	timer := time.NewTimer(p.Backoff(n + 1))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,181 @@
+package vdisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   RetryPolicy
+		n        int
+		min, max time.Duration
+	}{
+		{name: "first", policy: RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 2}, n: 1, min: 100 * time.Millisecond, max: 100 * time.Millisecond},
+		{name: "doubling", policy: RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 2}, n: 4, min: 800 * time.Millisecond, max: 800 * time.Millisecond},
+		{name: "multiplier 3", policy: RetryPolicy{InitialBackoff: 10 * time.Millisecond, Multiplier: 3}, n: 3, min: 90 * time.Millisecond, max: 90 * time.Millisecond},
+		// A multiplier below 1 would shrink the delay, so it counts as 1
+		{name: "shrinking multiplier", policy: RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 0.5}, n: 3, min: 100 * time.Millisecond, max: 100 * time.Millisecond},
+		{name: "capped", policy: RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 500 * time.Millisecond, Multiplier: 2}, n: 4, min: 500 * time.Millisecond, max: 500 * time.Millisecond},
+		{name: "capped far out", policy: RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 500 * time.Millisecond, Multiplier: 2}, n: 100, min: 500 * time.Millisecond, max: 500 * time.Millisecond},
+		{name: "jitter", policy: RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 2, Jitter: 0.2}, n: 2, min: 160 * time.Millisecond, max: 240 * time.Millisecond},
+		// Jitter applies after the cap, so it may exceed MaxBackoff
+		{name: "jitter on the cap", policy: RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 500 * time.Millisecond, Multiplier: 2, Jitter: 0.5}, n: 10, min: 250 * time.Millisecond, max: 750 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lowest, highest := time.Duration(1<<63-1), time.Duration(0)
+			for i := 0; i < 1000; i++ {
+				d := tc.policy.Backoff(tc.n)
+				lowest, highest = min(lowest, d), max(highest, d)
+			}
+			if lowest < tc.min || highest > tc.max {
+				t.Errorf("Backoff(%d) between %v and %v, want %v to %v", tc.n, lowest, highest, tc.min, tc.max)
+			}
+			// Jitter spreads the delays over most of the range
+			if tc.policy.Jitter > 0 && highest-lowest < (tc.max-tc.min)/2 {
+				t.Errorf("Backoff(%d) only spread from %v to %v", tc.n, lowest, highest)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	reads := RetryPolicy{}
+	writes := RetryPolicy{RetryWrites: true}
+	deadlines := RetryPolicy{RetryableCodes: []codes.Code{codes.DeadlineExceeded}}
+	none := RetryPolicy{RetryableCodes: []codes.Code{}}
+
+	for _, tc := range []struct {
+		name   string
+		policy RetryPolicy
+		err    error
+		write  bool
+		want   bool
+	}{
+		{"unavailable", reads, status.Error(codes.Unavailable, "reset"), false, true},
+		{"resource exhausted", reads, status.Error(codes.ResourceExhausted, "quota"), false, true},
+		{"aborted", reads, status.Error(codes.Aborted, "aborted"), false, true},
+		{"wrapped status", reads, fmt.Errorf("stream error: %w", status.Error(codes.Unavailable, "reset")), false, true},
+		{"deadline exceeded", reads, status.Error(codes.DeadlineExceeded, "slow"), false, false},
+		{"invalid argument", reads, status.Error(codes.InvalidArgument, "bad"), false, false},
+		{"listed code", deadlines, status.Error(codes.DeadlineExceeded, "slow"), false, true},
+		{"unlisted default code", deadlines, status.Error(codes.Unavailable, "reset"), false, false},
+		{"empty code list", none, status.Error(codes.Unavailable, "reset"), false, false},
+		{"server busy", none, &ServerError{Kind: ServerBusy}, false, true},
+		{"server not found", reads, &ServerError{Kind: ServerNotFound}, false, false},
+		{"checksum mismatch", reads, &ServerError{Kind: ServerChecksumMismatch}, false, false},
+		{"write failed on a read policy", reads, &ServerError{Kind: ServerWriteFailed}, true, false},
+		{"unavailable write without RetryWrites", reads, status.Error(codes.Unavailable, "reset"), true, false},
+		{"unavailable write", writes, status.Error(codes.Unavailable, "reset"), true, true},
+		{"write failed", writes, &ServerError{Kind: ServerWriteFailed}, true, true},
+		{"protocol error", writes, fmt.Errorf("%w: no response", ErrProtocol), false, false},
+		{"client error", reads, errors.New("encode failed"), false, false},
+		{"canceled", reads, context.Canceled, false, false},
+	} {
+		if got := tc.policy.Retryable(tc.err, tc.write); got != tc.want {
+			t.Errorf("%s: Retryable = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	if newRetryBudget(nil) != nil || newRetryBudget(&RetryPolicy{MaxRetries: 3}) != nil {
+		t.Error("budget created without BudgetTokens")
+	}
+	var unlimited *retryBudget
+	unlimited.success()
+	if !unlimited.failure() {
+		t.Error("a nil budget refused a retry")
+	}
+
+	// Retries stop at half of the 4 tokens and resume once successes
+	// refill the budget above it
+	b := newRetryBudget(&RetryPolicy{BudgetTokens: 4, BudgetRatio: 0.5})
+	for i, step := range []struct {
+		success bool
+		allowed bool
+		tokens  float64
+	}{
+		{success: false, allowed: true, tokens: 3},
+		{success: false, allowed: false, tokens: 2},
+		{success: true, tokens: 2.5},
+		{success: false, allowed: false, tokens: 1.5},
+		{success: false, allowed: false, tokens: 0.5},
+		{success: false, allowed: false, tokens: 0},
+		{success: false, allowed: false, tokens: 0},
+		{success: true, tokens: 0.5},
+		{success: true, tokens: 1},
+		{success: true, tokens: 1.5},
+		{success: true, tokens: 2},
+		{success: true, tokens: 2.5},
+		{success: true, tokens: 3},
+		{success: false, allowed: false, tokens: 2},
+		{success: true, tokens: 2.5},
+		{success: true, tokens: 3},
+		{success: true, tokens: 3.5},
+		{success: true, tokens: 4},
+		{success: true, tokens: 4},
+		{success: false, allowed: true, tokens: 3},
+	} {
+		if step.success {
+			b.success()
+		} else if allowed := b.failure(); allowed != step.allowed {
+			t.Errorf("step %d: failure allowed a retry %v, want %v", i, allowed, step.allowed)
+		}
+		if b.tokens != step.tokens {
+			t.Errorf("step %d: %g tokens, want %g", i, b.tokens, step.tokens)
+		}
+	}
+}
+
+func TestRetryBudgetExhausted(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 10, InitialBackoff: time.Microsecond, Multiplier: 1, BudgetTokens: 4, BudgetRatio: 1}
+	c := &Client{opts: Options{Retry: &policy}, budget: newRetryBudget(&policy)}
+	ctx := context.Background()
+	unavailable := status.Error(codes.Unavailable, "reset")
+
+	// retries counts the retries of one request that keeps failing
+	retries := func() int {
+		n := 0
+		for c.retry(ctx, unavailable, false, n) {
+			n++
+		}
+		return n
+	}
+
+	// The first failure leaves 3 of 4 tokens, the second 2 which stops
+	// retries well before MaxRetries
+	if n := retries(); n != 1 {
+		t.Errorf("%d retries from a full budget, want 1", n)
+	}
+	if n := retries(); n != 0 {
+		t.Errorf("%d retries from an exhausted budget, want 0", n)
+	}
+	// Failures that are not retried take no tokens
+	tokens := c.budget.tokens
+	if c.retry(ctx, status.Error(codes.InvalidArgument, "bad"), false, 0) || c.budget.tokens != tokens {
+		t.Errorf("non-retryable failure retried or took a token: %g tokens, had %g", c.budget.tokens, tokens)
+	}
+
+	// Successful requests refill the budget
+	for i := 0; i < 4; i++ {
+		c.budget.success()
+	}
+	if n := retries(); n != 1 {
+		t.Errorf("%d retries after the budget refilled, want 1", n)
+	}
+
+	// Without a budget only MaxRetries limits a request
+	policy.BudgetTokens = 0
+	c = &Client{opts: Options{Retry: &policy}, budget: newRetryBudget(&policy)}
+	if n := retries(); n != policy.MaxRetries {
+		t.Errorf("%d retries without a budget, want %d", n, policy.MaxRetries)
+	}
+}
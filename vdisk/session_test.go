@@ -0,0 +1,394 @@
+package vdisk_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk/vdisktest"
+)
+
+func TestReadSession(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   vdisk.ReadSessionOptions
+		length int64
+	}{
+		{name: "default window", opts: vdisk.ReadSessionOptions{KeepData: true}, length: 64 << 10},
+		{name: "window of one", opts: vdisk.ReadSessionOptions{Window: 1, KeepData: true}, length: 4096},
+		{name: "multiple responses", opts: vdisk.ReadSessionOptions{Window: 4, KeepData: true}, length: 200 << 10},
+		{name: "counts only", opts: vdisk.ReadSessionOptions{Window: 4}, length: 32 << 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, c := startServer(t, nil, nil)
+			disk := vdisk.VMDisk("rs")
+			want := seedDisk(t, s, disk)
+			ctx := context.Background()
+			session, err := c.NewReadSession(ctx, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Submit from several goroutines so requests overlap on the stream
+			var wg sync.WaitGroup
+			for g := 0; g < 4; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < 5; i++ {
+						off := int64(g*5+i) * tc.length % (testDiskSize - tc.length)
+						r := session.Read(ctx, vdisk.ReadRequest{Disk: disk, Offset: off, Length: tc.length})
+						if r.Err != nil {
+							t.Errorf("read at %d: %v", off, r.Err)
+							return
+						}
+						// BytesRead counts data payload only, not zero ranges
+						if r.Responses == 0 || r.BytesRead > tc.length {
+							t.Errorf("read at %d: %d responses, %d bytes", off, r.Responses, r.BytesRead)
+						}
+						if !tc.opts.KeepData {
+							if len(r.Extents) != 0 {
+								t.Errorf("read at %d kept %d extents", off, len(r.Extents))
+							}
+							continue
+						}
+						got := make([]byte, tc.length)
+						var covered, data int64
+						for _, e := range r.Extents {
+							covered += e.Length
+							if !e.Zero {
+								data += e.Length
+								copy(got[e.Offset-off:], e.Data)
+							}
+						}
+						if covered != tc.length || data != r.BytesRead {
+							t.Errorf("read at %d: extents cover %d bytes with %d of data, BytesRead %d", off, covered, data, r.BytesRead)
+						}
+						if !bytes.Equal(got, want[off:off+tc.length]) {
+							t.Errorf("read at %d returned different data", off)
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+
+			if err := session.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if r := session.Read(ctx, vdisk.ReadRequest{Disk: disk, Length: 4096}); !errors.Is(r.Err, vdisk.ErrSessionClosed) {
+				t.Errorf("read after Close: %v, want ErrSessionClosed", r.Err)
+			}
+		})
+	}
+}
+
+// reorderServer answers the first two read requests on a stream in two
+// halves each, starting the second request before the first so that the
+// responses to overlapping requests arrive interleaved
+type reorderServer struct {
+	protos.UnimplementedStargateVDiskRpcSvcServer
+	data []byte
+	// skew moves every response by this many bytes
+	skew int64
+}
+
+func (s *reorderServer) VDiskStreamRead(stream protos.StargateVDiskRpcSvc_VDiskStreamReadServer) error {
+	var args []*protos.VDiskReadArg
+	for len(args) < 2 {
+		arg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		args = append(args, arg)
+	}
+	half := func(arg *protos.VDiskReadArg, second bool) *protos.VDiskReadRet {
+		n := arg.GetLength() / 2
+		off := arg.GetOffset() + s.skew
+		if second {
+			off += n
+		}
+		return &protos.VDiskReadRet{
+			RangeVec:    []*protos.DiskDataRange{{Offset: proto.Int64(off), Length: proto.Int64(n)}},
+			Data:        s.data[off : off+n],
+			HasMoreData: proto.Bool(!second),
+		}
+	}
+	for _, resp := range []*protos.VDiskReadRet{
+		half(args[1], false), half(args[0], false), half(args[0], true), half(args[1], true),
+	} {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// startReorderServer serves rs over bufconn and returns a client for it
+func startReorderServer(t *testing.T, rs *reorderServer) *vdisk.Client {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	protos.RegisterStargateVDiskRpcSvcServer(srv, rs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	opts := vdisk.DefaultOptions()
+	opts.UseTLS = false
+	opts.AuthType = vdisk.AuthNone
+	opts.Address = "passthrough:///bufconn"
+	opts.Dialer = func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }
+	c, err := vdisk.NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// overlappingReads are two reads of which the second starts where the
+// first request's second half does
+var overlappingReads = []vdisk.ReadRequest{
+	{Disk: vdisk.VMDisk("overlap"), Offset: 0, Length: 8 << 10},
+	{Disk: vdisk.VMDisk("overlap"), Offset: 4 << 10, Length: 8 << 10},
+}
+
+func TestReadSessionOverlapping(t *testing.T) {
+	rs := &reorderServer{data: random(16 << 10)}
+	c := startReorderServer(t, rs)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := c.NewReadSession(ctx, vdisk.ReadSessionOptions{Window: 2, KeepData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := overlappingReads
+	var chans []<-chan vdisk.ReadResult
+	for _, req := range reqs {
+		ch, err := session.Submit(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chans = append(chans, ch)
+	}
+	for i, ch := range chans {
+		r := <-ch
+		if r.Err != nil {
+			t.Fatalf("read %d: %v", i, r.Err)
+		}
+		off := reqs[i].Offset
+		got := make([]byte, reqs[i].Length)
+		for _, e := range r.Extents {
+			if e.Offset < off || e.End() > off+reqs[i].Length {
+				t.Fatalf("read %d: extent [%d, %d) outside the request", i, e.Offset, e.End())
+			}
+			copy(got[e.Offset-off:], e.Data)
+		}
+		if r.Responses != 2 || !bytes.Equal(got, rs.data[off:off+reqs[i].Length]) {
+			t.Errorf("read %d: %d responses, data matches %v", i, r.Responses, bytes.Equal(got, rs.data[off:off+reqs[i].Length]))
+		}
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadSessionUnmatchedResponse(t *testing.T) {
+	// Responses that do not continue any request fail the session rather
+	// than being given to one of them
+	c := startReorderServer(t, &reorderServer{data: random(20 << 10), skew: 512})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := c.NewReadSession(ctx, vdisk.ReadSessionOptions{Window: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	var chans []<-chan vdisk.ReadResult
+	for _, req := range overlappingReads {
+		ch, err := session.Submit(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chans = append(chans, ch)
+	}
+	for i, ch := range chans {
+		if r := <-ch; !errors.Is(r.Err, vdisk.ErrProtocol) {
+			t.Errorf("read %d: %v, want ErrProtocol", i, r.Err)
+		}
+	}
+}
+
+func TestWriteSession(t *testing.T) {
+	s, c := startServer(t, nil, nil)
+	disk := vdisk.VMDisk("ws")
+	ctx := context.Background()
+	var mu sync.Mutex
+	var results []vdisk.WriteResult
+	session, err := c.NewWriteSession(ctx, vdisk.WriteSessionOptions{
+		Window:        3,
+		FirstSequence: 100,
+		OnResult: func(r vdisk.WriteResult) {
+			mu.Lock()
+			results = append(results, r)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n, size = 10, 8192
+	data := random(n * size)
+	var chans []<-chan vdisk.WriteResult
+	for i := 0; i < n; i++ {
+		ch, err := session.Submit(ctx, vdisk.WriteRequest{Disk: disk, Offset: int64(i * size), Length: size, Data: data[i*size : (i+1)*size]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		chans = append(chans, ch)
+	}
+	for i, ch := range chans {
+		r := <-ch
+		if r.Err != nil {
+			t.Fatalf("write %d: %v", i, r.Err)
+		}
+		if r.SequenceNumber != int64(100+i) || r.Offset != int64(i*size) || r.BytesWritten != size {
+			t.Errorf("write %d: sequence %d, offset %d, %d bytes written", i, r.SequenceNumber, r.Offset, r.BytesWritten)
+		}
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != n {
+		t.Errorf("OnResult called %d times, want %d", len(results), n)
+	}
+	if !bytes.Equal(diskBytes(t, s, disk, 0, n*size), data) {
+		t.Error("disk differs from the data written")
+	}
+}
+
+func TestWriteSessionSendAfterReset(t *testing.T) {
+	// Writes submitted after the server ends the stream fail with its status
+	// rather than the io.EOF returned by Send
+	_, c := startServer(t, []vdisktest.Fault{{Operation: vdisktest.OpWrite, Reset: true}}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := c.NewWriteSession(ctx, vdisk.WriteSessionOptions{Window: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	var chans []<-chan vdisk.WriteResult
+	for i := 0; i < 64; i++ {
+		ch, err := session.Submit(ctx, vdisk.WriteRequest{Disk: vdisk.VMDisk("eof"), Offset: int64(i * 4096), Length: 4096, Data: random(4096)})
+		if err != nil {
+			// The session has already failed with the stream's status
+			if _, code := vdisk.Classify(err); code != "Unavailable" {
+				t.Errorf("submit %d: %v classified as %s, want Unavailable", i, err, code)
+			}
+			break
+		}
+		chans = append(chans, ch)
+	}
+	for i, ch := range chans {
+		r := <-ch
+		if r.Err == nil {
+			continue
+		}
+		if _, code := vdisk.Classify(r.Err); code != "Unavailable" {
+			t.Errorf("write %d: %v classified as %s, want Unavailable", i, r.Err, code)
+		}
+	}
+}
+
+func TestSessionFaults(t *testing.T) {
+	write := func(ctx context.Context, c *vdisk.Client) (<-chan error, func() error, error) {
+		session, err := c.NewWriteSession(ctx, vdisk.WriteSessionOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		errs := make(chan error, 1)
+		go func() {
+			errs <- session.Write(ctx, vdisk.WriteRequest{Disk: vdisk.VMDisk("sf"), Length: 4096, Data: random(4096)}).Err
+		}()
+		return errs, session.Close, nil
+	}
+	read := func(ctx context.Context, c *vdisk.Client) (<-chan error, func() error, error) {
+		session, err := c.NewReadSession(ctx, vdisk.ReadSessionOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		errs := make(chan error, 1)
+		go func() {
+			errs <- session.Read(ctx, vdisk.ReadRequest{Disk: vdisk.VMDisk("sf"), Length: 256 << 10}).Err
+		}()
+		return errs, session.Close, nil
+	}
+
+	for _, tc := range []struct {
+		name  string
+		fault vdisktest.Fault
+		start func(context.Context, *vdisk.Client) (<-chan error, func() error, error)
+		// closeFirst closes the session before the request completes
+		closeFirst bool
+		code       string
+	}{
+		{name: "read reset", fault: vdisktest.Fault{Operation: vdisktest.OpRead, Reset: true}, start: read, code: "Unavailable"},
+		{name: "write reset", fault: vdisktest.Fault{Operation: vdisktest.OpWrite, Reset: true}, start: write, code: "Unavailable"},
+		{name: "read truncate", fault: vdisktest.Fault{Operation: vdisktest.OpRead, Truncate: 100}, start: read, code: "Other"},
+		{name: "write drop_ack", fault: vdisktest.Fault{Operation: vdisktest.OpWrite, DropAck: true}, start: write, closeFirst: true, code: "SessionClosed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, c := startServer(t, []vdisktest.Fault{tc.fault}, withRetry)
+			d, err := s.Disk(vdisk.VMDisk("sf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Seed several responses of data, as a read reset follows the first
+			d.WriteAt(random(256<<10), 0)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			errs, closeSession, err := tc.start(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.closeFirst {
+				// Wait for the write to reach the server so Close finds it outstanding
+				for s.Stats().FaultsInjected == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				closeSession()
+			}
+			err = <-errs
+			if !tc.closeFirst {
+				closeSession()
+			}
+			if s.Stats().FaultsInjected == 0 {
+				t.Fatal("the fault was not injected")
+			}
+			if err == nil {
+				t.Fatalf("succeeded, want a %s failure", tc.code)
+			}
+			if _, code := vdisk.Classify(err); code != tc.code {
+				t.Errorf("failure %v classified as %s, want %s", err, code, tc.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package vdisk
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// ReadStream is the client side of a VDiskStreamRead call
+type ReadStream = protos.StargateVDiskRpcSvc_VDiskStreamReadClient
+
+// WriteStream is the client side of a VDiskStreamWrite call
+type WriteStream = protos.StargateVDiskRpcSvc_VDiskStreamWriteClient
+
+// ReadRequest describes a single VDiskReadArg
+type ReadRequest struct {
+	Disk            *protos.DiskIdentifier
+	Offset          int64
+	Length          int64 // 0 reads to the end of the disk
+	MaxResponseSize int64 // 0 leaves the server default
+}
+
+// ReadHandler is invoked for every VDiskReadRet received for a request
+type ReadHandler func(resp *protos.VDiskReadRet) error
+
+// ReadStats summarizes a completed read request
+type ReadStats struct {
+	Responses     int
+	BytesRead     int64
+	TotalDiskSize int64 // -1 when the server did not report it
+	// Connection is the pool index of the connection Client.Read used, or
+	// -1 when no stream was opened
+	Connection int
+	// Retries counts the attempts Client.Read made after the first
+	Retries int
+}
+
+// WriteRequest describes a single VDiskWriteArg
+type WriteRequest struct {
+	Disk *protos.DiskIdentifier
+	// Offset and Length describe a single data range. They are ignored when
+	// Ranges is set.
+	Offset int64
+	Length int64
+	Ranges []*protos.DiskDataRange
+
+	Data           []byte
+	Compression    protos.CompressionType
+	Checksum       protos.ChecksumType
+	SequenceNumber int64
+}
+
+// WriteHandler is invoked for every VDiskWriteRet received for a request
+type WriteHandler func(resp *protos.VDiskWriteRet) error
+
+// WriteStats summarizes a completed write request
+type WriteStats struct {
+	Responses    int
+	BytesWritten int64
+	// BytesWrittenReported is whether any response set the optional
+	// bytes_written field that BytesWritten sums
+	BytesWrittenReported bool
+	// Connection is the pool index of the connection Client.Write used, or
+	// -1 when no stream was opened
+	Connection int
+	// Retries counts the attempts Client.Write made after the first
+	Retries int
+	// PayloadBytes is the size of the data before compression and WireBytes
+	// the size actually sent
+	PayloadBytes int64
+	WireBytes    int64
+}
+
+// CompressionRatio returns PayloadBytes/WireBytes, or 1 when nothing was sent
+func (s WriteStats) CompressionRatio() float64 {
+	if s.WireBytes == 0 {
+		return 1
+	}
+	return float64(s.PayloadBytes) / float64(s.WireBytes)
+}
+
+// NewReadArg builds the VDiskReadArg for req
+func NewReadArg(req ReadRequest) *protos.VDiskReadArg {
+	arg := &protos.VDiskReadArg{
+		DiskId: req.Disk,
+		Offset: proto.Int64(req.Offset),
+		Length: proto.Int64(req.Length),
+	}
+	if req.MaxResponseSize > 0 {
+		arg.MaxResponseSize = proto.Int64(req.MaxResponseSize)
+	}
+	return arg
+}
+
+// NewWriteArg builds the VDiskWriteArg for req, compressing its payload with
+// req.Compression. Payloads that do not shrink are sent uncompressed and
+// marked kNoCompression. The req.Checksum checksum is computed over the
+// payload as sent.
+func NewWriteArg(req WriteRequest) (*protos.VDiskWriteArg, error) {
+	ranges := req.Ranges
+	if len(ranges) == 0 {
+		ranges = []*protos.DiskDataRange{{
+			Offset:   proto.Int64(req.Offset),
+			Length:   proto.Int64(req.Length),
+			ZeroData: proto.Bool(false),
+		}}
+	}
+
+	data, compressed, err := CompressPayload(req.Compression, ranges, req.Data)
+	if err != nil {
+		return nil, err
+	}
+	ct := protos.CompressionType_kNoCompression
+	if compressed {
+		ct = req.Compression
+	}
+
+	arg := &protos.VDiskWriteArg{
+		DiskId:          req.Disk,
+		RangeVec:        ranges,
+		CompressionType: ct.Enum(),
+		ChecksumType:    req.Checksum.Enum(),
+		Data:            data,
+		SequenceNumber:  proto.Int64(req.SequenceNumber),
+	}
+	if sum, ok := Checksum(req.Checksum, data); ok {
+		arg.Checksum = proto.Uint32(sum)
+	}
+	return arg, nil
+}
+
+// OpenReadStream opens a VDiskStreamRead stream on the next pooled connection.
+// The returned index identifies the pooled connection used.
+func (c *Client) OpenReadStream(ctx context.Context) (ReadStream, int, error) {
+	return c.openReadStream(ctx, -1)
+}
+
+// openReadStream is OpenReadStream, avoiding the connection at pool index
+// avoid when another one is available
+func (c *Client) openReadStream(ctx context.Context, avoid int) (ReadStream, int, error) {
+	rpc, index, err := c.rpc(avoid)
+	if err != nil {
+		return nil, -1, err
+	}
+	stream, err := rpc.VDiskStreamRead(c.AuthContext(ctx))
+	if err != nil {
+		return nil, index, fmt.Errorf("failed to create read stream: %w", err)
+	}
+	return stream, index, nil
+}
+
+// OpenWriteStream opens a VDiskStreamWrite stream on the next pooled connection.
+// The returned index identifies the pooled connection used.
+func (c *Client) OpenWriteStream(ctx context.Context) (WriteStream, int, error) {
+	return c.openWriteStream(ctx, -1)
+}
+
+// openWriteStream is OpenWriteStream, avoiding the connection at pool index
+// avoid when another one is available
+func (c *Client) openWriteStream(ctx context.Context, avoid int) (WriteStream, int, error) {
+	rpc, index, err := c.rpc(avoid)
+	if err != nil {
+		return nil, -1, err
+	}
+	stream, err := rpc.VDiskStreamWrite(c.AuthContext(ctx))
+	if err != nil {
+		return nil, index, fmt.Errorf("failed to create write stream: %w", err)
+	}
+	return stream, index, nil
+}
+
+// Read issues req on a new stream and receives responses until the server
+// reports no more data or closes the stream. handler may be nil. Failures
+// are retried as Options.Retry allows, resuming after the last range
+// received.
+func (c *Client) Read(ctx context.Context, req ReadRequest, handler ReadHandler) (ReadStats, error) {
+	stats := ReadStats{TotalDiskSize: -1, Connection: -1}
+
+	next := req.Offset
+	var handlerErr error
+	track := func(resp *protos.VDiskReadRet) error {
+		next = resumeOffset(resp, next)
+		if handler != nil {
+			handlerErr = handler(resp)
+		}
+		return handlerErr
+	}
+
+	for {
+		attempt, err := c.readOnce(ctx, req, track, stats.Connection)
+		stats.Responses += attempt.Responses
+		stats.BytesRead += attempt.BytesRead
+		stats.Connection = attempt.Connection
+		if attempt.TotalDiskSize >= 0 {
+			stats.TotalDiskSize = attempt.TotalDiskSize
+		}
+		if err == nil {
+			c.budget.success()
+			return stats, nil
+		}
+		if handlerErr != nil || !c.retry(ctx, err, false, stats.Retries) {
+			return stats, err
+		}
+		stats.Retries++
+
+		// Only ask for what is still missing
+		if req.Length > 0 {
+			end := req.Offset + req.Length
+			if next >= end {
+				return stats, nil
+			}
+			req.Length = end - next
+		}
+		req.Offset = next
+	}
+}
+
+// readOnce makes one attempt of Client.Read, on another connection than
+// the one at pool index avoid if possible
+func (c *Client) readOnce(ctx context.Context, req ReadRequest, handler ReadHandler, avoid int) (ReadStats, error) {
+	// The read ends at has_more_data=false without waiting for the server
+	// to close the stream, so cancelling is what releases it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, index, err := c.openReadStream(ctx, avoid)
+	if err != nil {
+		return ReadStats{TotalDiskSize: -1, Connection: index}, err
+	}
+
+	stats, err := ReadFromStream(stream, req, handler)
+	stats.Connection = index
+	if err != nil {
+		return stats, err
+	}
+	if stats.Responses == 0 {
+		return stats, fmt.Errorf("%w: read stream ended without a response", ErrProtocol)
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return stats, fmt.Errorf("failed to close send stream: %w", err)
+	}
+	return stats, nil
+}
+
+// resumeOffset returns the disk offset following the data of resp, which
+// was sent for the data starting at next
+func resumeOffset(resp *protos.VDiskReadRet, next int64) int64 {
+	if ranges := resp.GetRangeVec(); len(ranges) > 0 {
+		last := ranges[len(ranges)-1]
+		return last.GetOffset() + last.GetLength()
+	}
+	return next + int64(len(resp.GetData()))
+}
+
+// ReadFromStream sends req on an open stream and receives its responses
+// until has_more_data is false or the stream ends. The send side is left open.
+func ReadFromStream(stream ReadStream, req ReadRequest, handler ReadHandler) (ReadStats, error) {
+	stats := ReadStats{TotalDiskSize: -1}
+
+	// Send fails with io.EOF once the server has ended the stream, whose
+	// status is then returned by Recv
+	if err := stream.Send(NewReadArg(req)); err != nil && err != io.EOF {
+		return stats, fmt.Errorf("failed to send read request: %w", err)
+	}
+
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("stream error: %w", err)
+		}
+
+		stats.Responses++
+		if err := readError(response); err != nil {
+			return stats, err
+		}
+
+		stats.BytesRead += int64(len(response.Data))
+		if response.TotalDiskSize != nil {
+			stats.TotalDiskSize = *response.TotalDiskSize
+		}
+
+		if handler != nil {
+			if err := handler(response); err != nil {
+				return stats, err
+			}
+		}
+
+		if response.HasMoreData != nil && !*response.HasMoreData {
+			break
+		}
+	}
+
+	return stats, nil
+}
+
+// Write issues req on a new stream, half-closes it and receives responses
+// until the server closes the stream. handler may be nil. Failures are
+// retried as Options.Retry allows, sending the same VDiskWriteArg again.
+func (c *Client) Write(ctx context.Context, req WriteRequest, handler WriteHandler) (WriteStats, error) {
+	stats := WriteStats{Connection: -1}
+
+	arg, err := NewWriteArg(req)
+	if err != nil {
+		return stats, err
+	}
+	stats.PayloadBytes = int64(len(req.Data))
+	stats.WireBytes = int64(len(arg.Data))
+
+	var handlerErr error
+	track := func(resp *protos.VDiskWriteRet) error {
+		if handler != nil {
+			handlerErr = handler(resp)
+		}
+		return handlerErr
+	}
+
+	for {
+		stats.Responses, stats.BytesWritten, stats.BytesWrittenReported = 0, 0, false
+		err := c.writeOnce(ctx, arg, track, &stats, stats.Connection)
+		if err == nil {
+			c.budget.success()
+			return stats, nil
+		}
+		if handlerErr != nil || !c.retry(ctx, err, true, stats.Retries) {
+			return stats, err
+		}
+		stats.Retries++
+	}
+}
+
+// writeOnce makes one attempt of Client.Write, counting its responses and
+// connection in stats. It uses another connection than the one at pool
+// index avoid if possible.
+func (c *Client) writeOnce(ctx context.Context, arg *protos.VDiskWriteArg, handler WriteHandler, stats *WriteStats, avoid int) error {
+	// Cancelling releases the stream when the attempt ends before the
+	// server closes it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, index, err := c.openWriteStream(ctx, avoid)
+	stats.Connection = index
+	if err != nil {
+		return err
+	}
+
+	// Send fails with io.EOF once the server has ended the stream, whose
+	// status is then returned by Recv
+	if err := stream.Send(arg); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to send write request: %w", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return fmt.Errorf("failed to close send stream: %w", err)
+	}
+
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("stream error: %w", err)
+		}
+
+		stats.Responses++
+		if err := writeError(response); err != nil {
+			return err
+		}
+
+		if response.BytesWritten != nil {
+			stats.BytesWritten += *response.BytesWritten
+			stats.BytesWrittenReported = true
+		}
+
+		if err := handler(response); err != nil {
+			return err
+		}
+	}
+
+	if stats.Responses == 0 {
+		return fmt.Errorf("%w: write stream ended without a response", ErrProtocol)
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package vdisk_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk/vdisktest"
+)
+
+const testDiskSize = 4 << 20
+
+// testRetry retries quickly enough for tests, without a budget
+var testRetry = vdisk.RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, Multiplier: 1, RetryWrites: true}
+
+// startServer starts a vdisktest server on an in-memory connection and
+// returns it with a client for it. configure, if set, adjusts the client
+// options.
+func startServer(t *testing.T, faults []vdisktest.Fault, configure func(*vdisk.Options)) (*vdisktest.Server, *vdisk.Client) {
+	t.Helper()
+	s := vdisktest.NewServer(vdisktest.ServerOptions{
+		DiskSize:        testDiskSize,
+		MaxResponseSize: 64 << 10,
+		Faults:          vdisktest.FaultScript{Seed: 1, Faults: faults},
+	})
+	s.StartBufconn()
+	t.Cleanup(s.Stop)
+
+	opts := s.ClientOptions()
+	if configure != nil {
+		configure(&opts)
+	}
+	c, err := vdisk.NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return s, c
+}
+
+func withRetry(opts *vdisk.Options) {
+	policy := testRetry
+	opts.Retry = &policy
+}
+
+// diskBytes returns [off, off+n) of disk as the server holds it
+func diskBytes(t *testing.T, s *vdisktest.Server, disk *protos.DiskIdentifier, off, n int64) []byte {
+	t.Helper()
+	d, err := s.Disk(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, n)
+	if _, err := d.ReadAt(p, off); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// readAll reads [off, off+n) with Client.Read, placing every extent
+func readAll(ctx context.Context, c *vdisk.Client, disk *protos.DiskIdentifier, off, n int64) ([]byte, vdisk.ReadStats, error) {
+	p := make([]byte, n)
+	stats, err := c.Read(ctx, vdisk.ReadRequest{Disk: disk, Offset: off, Length: n}, func(resp *protos.VDiskReadRet) error {
+		extents, err := vdisk.Extents(resp, off)
+		if err != nil {
+			return err
+		}
+		for _, e := range extents {
+			if !e.Zero {
+				copy(p[e.Offset-off:], e.Data)
+			}
+		}
+		return nil
+	})
+	return p, stats, err
+}
+
+func TestReadWrite(t *testing.T) {
+	_, c := startServer(t, nil, nil)
+	ctx := context.Background()
+
+	for i, tc := range []struct {
+		name        string
+		offset      int64
+		data        []byte
+		compression protos.CompressionType
+		checksum    protos.ChecksumType
+	}{
+		{"plain", 0, random(4096), protos.CompressionType_kNoCompression, protos.ChecksumType_kNoChecksum},
+		{"unaligned", 12345, random(1000), protos.CompressionType_kNoCompression, protos.ChecksumType_kCRC32},
+		{"several responses", 1 << 20, random(200 << 10), protos.CompressionType_kNoCompression, protos.ChecksumType_kSHA1},
+		{"lz4", 2 << 20, compressible(64 << 10), protos.CompressionType_kLZ4Compression, protos.ChecksumType_kSHA256},
+		{"snappy", 3 << 20, compressible(8192), protos.CompressionType_kSnappyCompression, protos.ChecksumType_kCRC32},
+		{"zlib incompressible", 3<<20 + 65536, random(8192), protos.CompressionType_kZlibCompression, protos.ChecksumType_kCRC32},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			disk := vdisk.VMDisk("rw")
+			stats, err := c.Write(ctx, vdisk.WriteRequest{
+				Disk:           disk,
+				Offset:         tc.offset,
+				Length:         int64(len(tc.data)),
+				Data:           tc.data,
+				Compression:    tc.compression,
+				Checksum:       tc.checksum,
+				SequenceNumber: int64(i + 1),
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.BytesWritten != int64(len(tc.data)) || stats.PayloadBytes != int64(len(tc.data)) {
+				t.Errorf("wrote %d bytes of a %d byte payload, want %d", stats.BytesWritten, stats.PayloadBytes, len(tc.data))
+			}
+			if tc.compression == protos.CompressionType_kLZ4Compression && stats.WireBytes >= stats.PayloadBytes {
+				t.Errorf("compressible payload sent as %d bytes", stats.WireBytes)
+			}
+
+			got, rstats, err := readAll(ctx, c, disk, tc.offset, int64(len(tc.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tc.data) {
+				t.Error("read back different data")
+			}
+			if rstats.TotalDiskSize != testDiskSize {
+				t.Errorf("total_disk_size %d, want %d", rstats.TotalDiskSize, testDiskSize)
+			}
+			if want := (len(tc.data) + 64<<10 - 1) / (64 << 10); len(tc.data) > 64<<10 && rstats.Responses < want {
+				t.Errorf("%d responses, want at least %d", rstats.Responses, want)
+			}
+		})
+	}
+}
+
+func TestReadUnwritten(t *testing.T) {
+	_, c := startServer(t, nil, nil)
+	got, stats, err := readAll(context.Background(), c, vdisk.VMDisk("empty"), 0, 256<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, make([]byte, 256<<10)) {
+		t.Error("unwritten disk read as non-zero")
+	}
+	// All-zero blocks are sent as zero_data ranges without data
+	if stats.BytesRead != 0 {
+		t.Errorf("%d data bytes received for an unwritten disk", stats.BytesRead)
+	}
+}
+
+func TestReadInvalidRange(t *testing.T) {
+	_, c := startServer(t, nil, nil)
+	_, err := c.Read(context.Background(), vdisk.ReadRequest{Disk: vdisk.VMDisk("a"), Offset: 2 * testDiskSize, Length: 1}, nil)
+	if kind, ok := vdisk.ServerKind(err); !ok || kind != vdisk.ServerInvalidRequest {
+		t.Fatalf("error %v, want an InvalidRequest server error", err)
+	}
+	if category, _ := vdisk.Classify(err); category != vdisk.CategoryInvalidRequest {
+		t.Errorf("category %v", category)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	read := func(ctx context.Context, c *vdisk.Client) error {
+		_, _, err := readAll(ctx, c, vdisk.VMDisk("f"), 0, 256<<10)
+		return err
+	}
+	rangeRead := func(ctx context.Context, c *vdisk.Client) error {
+		r := c.NewRangeReader(ctx)
+		defer r.Close()
+		_, err := r.ReadRange(vdisk.VMDisk("f"), 0, 256<<10, 64<<10, func(vdisk.Extent) error { return nil })
+		return err
+	}
+	write := func(ctx context.Context, c *vdisk.Client) error {
+		_, err := c.Write(ctx, vdisk.WriteRequest{Disk: vdisk.VMDisk("f"), Length: 4096, Data: random(4096)}, nil)
+		return err
+	}
+
+	for _, tc := range []struct {
+		name  string
+		fault vdisktest.Fault
+		op    func(context.Context, *vdisk.Client) error
+		retry bool
+		// code is the Classify code of the failure, empty for success
+		code string
+	}{
+		{"read reset", vdisktest.Fault{Operation: vdisktest.OpRead, Reset: true, Count: 1}, read, false, "Unavailable"},
+		{"read reset retried", vdisktest.Fault{Operation: vdisktest.OpRead, Reset: true, Count: 2}, read, true, ""},
+		{"range read reset resumed", vdisktest.Fault{Operation: vdisktest.OpRead, Reset: true, Count: 2}, rangeRead, true, ""},
+		{"read code", vdisktest.Fault{Operation: vdisktest.OpRead, Code: codes.ResourceExhausted, Count: 1}, read, false, "ResourceExhausted"},
+		{"read code retried", vdisktest.Fault{Operation: vdisktest.OpRead, Code: codes.ResourceExhausted, Count: 1}, read, true, ""},
+		{"read truncate", vdisktest.Fault{Operation: vdisktest.OpRead, Truncate: 100, Count: 1}, read, false, "Other"},
+		// A short response is not retryable
+		{"range read truncate", vdisktest.Fault{Operation: vdisktest.OpRead, Truncate: 100, Count: 1}, rangeRead, true, "Other"},
+		{"write reset", vdisktest.Fault{Operation: vdisktest.OpWrite, Reset: true, Count: 1}, write, false, "Unavailable"},
+		{"write reset retried", vdisktest.Fault{Operation: vdisktest.OpWrite, Reset: true, Count: 1}, write, true, ""},
+		{"write drop_ack", vdisktest.Fault{Operation: vdisktest.OpWrite, DropAck: true, Count: 1}, write, false, "Protocol"},
+		{"write fail_write", vdisktest.Fault{Operation: vdisktest.OpWrite, FailWrite: true, Count: 1}, write, false, "WriteFailed"},
+		{"write fail_write retried", vdisktest.Fault{Operation: vdisktest.OpWrite, FailWrite: true, Count: 1}, write, true, ""},
+		{"informational error_message", vdisktest.Fault{Operation: vdisktest.OpWrite, ErrorMessage: "Write operation successful"}, write, false, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var configure func(*vdisk.Options)
+			if tc.retry {
+				configure = withRetry
+			}
+			s, c := startServer(t, []vdisktest.Fault{tc.fault}, configure)
+			// Seed the disk on the server so that read responses carry data
+			d, err := s.Disk(vdisk.VMDisk("f"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.WriteAt(random(256<<10), 0)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			err = tc.op(ctx, c)
+			if s.Stats().FaultsInjected == 0 {
+				t.Fatal("the fault was not injected")
+			}
+			if tc.code == "" {
+				if err != nil {
+					t.Fatalf("failed: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("succeeded, want a %s failure", tc.code)
+			}
+			if _, code := vdisk.Classify(err); code != tc.code {
+				t.Errorf("failure %v classified as %s, want %s", err, code, tc.code)
+			}
+		})
+	}
+}
+
+// TestReadMessages checks that read responses carrying data succeed
+// whatever error_message says, and that a message alone fails only when it
+// describes a failure
+func TestReadMessages(t *testing.T) {
+	for _, msg := range []string{
+		"Read completed",
+		"OK",
+		"done",
+		"Read operation successful",
+		"served from cache",
+		"served after retry",
+		"vdisk not found",
+		"server busy, try again",
+		"checksum mismatch on extent",
+		"internal error",
+	} {
+		t.Run(msg, func(t *testing.T) {
+			s, c := startServer(t, []vdisktest.Fault{{Operation: vdisktest.OpRead, ErrorMessage: msg}}, nil)
+			disk := vdisk.VMDisk("msg")
+			d, err := s.Disk(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.WriteAt(random(64<<10), 0)
+			ctx := context.Background()
+			req := vdisk.ReadRequest{Disk: disk, Length: 64 << 10}
+
+			_, readErr := c.Read(ctx, req, nil)
+			session, err := c.NewReadSession(ctx, vdisk.ReadSessionOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessionErr := session.Read(ctx, req).Err
+			session.Close()
+
+			for name, err := range map[string]error{"Client.Read": readErr, "ReadSession": sessionErr} {
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+			}
+		})
+	}
+
+	// A message alone, with neither range_vec nor data, is a failure when
+	// it is a known kind or says it failed
+	for _, tc := range []struct {
+		msg string
+		// kind is the ServerErrorKind of the failure, or -1 for success
+		kind vdisk.ServerErrorKind
+	}{
+		{"Read completed", -1},
+		{"OK", -1},
+		{"Read operation successful", -1},
+		{"internal error", vdisk.ServerInternal},
+		{"read failed at offset 0", vdisk.ServerInternal},
+		{"vdisk not found", vdisk.ServerNotFound},
+		{"offset out of range", vdisk.ServerInvalidRequest},
+		{"disk_id is required", vdisk.ServerInvalidRequest},
+		{"server busy, try again", vdisk.ServerBusy},
+		{"checksum mismatch on extent", vdisk.ServerChecksumMismatch},
+	} {
+		stream := &responseStream{resp: &protos.VDiskReadRet{ErrorMessage: proto.String(tc.msg), HasMoreData: proto.Bool(false)}}
+		_, err := vdisk.ReadFromStream(stream, vdisk.ReadRequest{Length: 4096}, nil)
+		if tc.kind < 0 {
+			if err != nil {
+				t.Errorf("empty response with %q: %v", tc.msg, err)
+			}
+			continue
+		}
+		if kind, ok := vdisk.ServerKind(err); !ok || kind != tc.kind {
+			t.Errorf("empty response with %q: error %v, want a %v ServerError", tc.msg, err, tc.kind)
+		}
+	}
+}
+
+func TestDropAckAppliesWrite(t *testing.T) {
+	s, c := startServer(t, []vdisktest.Fault{{Operation: vdisktest.OpWrite, DropAck: true}}, nil)
+	data := random(4096)
+	_, err := c.Write(context.Background(), vdisk.WriteRequest{Disk: vdisk.VMDisk("d"), Offset: 8192, Length: 4096, Data: data}, nil)
+	if !errors.Is(err, vdisk.ErrProtocol) {
+		t.Fatalf("error %v, want ErrProtocol", err)
+	}
+	if !bytes.Equal(diskBytes(t, s, vdisk.VMDisk("d"), 8192, 4096), data) {
+		t.Error("write without an acknowledgement was not applied")
+	}
+}
+
+func TestRetryDisabledForWrites(t *testing.T) {
+	policy := testRetry
+	policy.RetryWrites = false
+	_, c := startServer(t, []vdisktest.Fault{{Operation: vdisktest.OpWrite, Reset: true, Count: 1}}, func(opts *vdisk.Options) {
+		opts.Retry = &policy
+	})
+	_, err := c.Write(context.Background(), vdisk.WriteRequest{Disk: vdisk.VMDisk("w"), Length: 512, Data: random(512)}, nil)
+	if _, code := vdisk.Classify(err); code != "Unavailable" {
+		t.Fatalf("error %v, want the reset to be returned", err)
+	}
+}
+
+// TestStreamsReleased checks that finished requests leave no stream
+// goroutines behind
+func TestStreamsReleased(t *testing.T) {
+	_, c := startServer(t, nil, nil)
+	ctx := context.Background()
+	disk := vdisk.VMDisk("leak")
+	f, err := c.OpenFile(ctx, disk, vdisk.FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	run := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, _, err := readAll(ctx, c, disk, 0, 4096); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteAt(buf, 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.ReadAt(buf, 0); err != nil {
+				t.Fatal(err)
+			}
+			r := c.NewRangeReader(ctx)
+			if _, err := r.ReadRange(disk, 0, 4096, 0, func(vdisk.Extent) error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+			r.Close()
+		}
+	}
+
+	run(5)
+	before := runtime.NumGoroutine()
+	run(200)
+	// Stream goroutines end shortly after their context is canceled
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before+10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before+10 {
+		t.Errorf("%d goroutines after 800 requests, %d before", after, before)
+	}
+}
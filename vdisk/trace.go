@@ -0,0 +1,286 @@
+package vdisk
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// tracerName identifies the spans of this package
+const tracerName = "github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+
+// Span attributes describing VDisk requests
+const (
+	attrDisk           = attribute.Key("vdisk.disk")
+	attrOffset         = attribute.Key("vdisk.offset")
+	attrLength         = attribute.Key("vdisk.length")
+	attrSequenceNumber = attribute.Key("vdisk.sequence_number")
+	attrBytes          = attribute.Key("vdisk.bytes")
+	attrConnection     = attribute.Key("vdisk.connection")
+	attrRequests       = attribute.Key("vdisk.requests")
+	attrResponses      = attribute.Key("vdisk.responses")
+	attrHasMoreData    = attribute.Key("vdisk.has_more_data")
+)
+
+// streamTracer starts a span for every stream opened on one pooled
+// connection and propagates its context to the server in the request
+// metadata
+type streamTracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	connection int
+}
+
+// newStreamTracer returns the tracer for connection index, or nil when
+// opts.TracerProvider is unset
+func newStreamTracer(opts Options, index int) *streamTracer {
+	if opts.TracerProvider == nil {
+		return nil
+	}
+	propagator := opts.Propagator
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+	return &streamTracer{
+		tracer:     opts.TracerProvider.Tracer(tracerName),
+		propagator: propagator,
+		connection: index,
+	}
+}
+
+// intercept is a grpc.StreamClientInterceptor. The span ends when the
+// server closes the stream or ctx is done, and also once no request is
+// outstanding on a read stream that was half-closed or on any stream that
+// received a server error, as callers abandon those streams without
+// waiting for the server to close them.
+func (t *streamTracer) intercept(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	name := strings.TrimPrefix(method, "/")
+	service, rpcMethod, _ := strings.Cut(name, "/")
+	ctx, span := t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", rpcMethod),
+			attrConnection.Int(t.connection),
+		))
+
+	// The trace context travels next to the authentication headers
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	t.propagator.Inject(ctx, metadataCarrier(md))
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(status.Code(err))))
+		span.End()
+		return nil, err
+	}
+
+	s := &tracedStream{ClientStream: stream, span: span, read: rpcMethod == "VDiskStreamRead"}
+	s.stop = context.AfterFunc(ctx, func() { s.end(nil) })
+	return s, nil
+}
+
+// tracedStream records the requests and responses of a stream as events
+// on its span
+type tracedStream struct {
+	grpc.ClientStream
+	span trace.Span
+	stop func() bool
+	read bool
+
+	mu        sync.Mutex
+	requests  int
+	finished  int // Requests fully answered or failed by the server
+	responses int
+	bytes     int64
+	halfClose bool
+	once      sync.Once
+}
+
+func (s *tracedStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.requests++
+	first := s.requests == 1
+	s.mu.Unlock()
+
+	var attrs []attribute.KeyValue
+	switch arg := m.(type) {
+	case *protos.VDiskReadArg:
+		attrs = []attribute.KeyValue{
+			attrDisk.String(DiskKey(arg.GetDiskId())),
+			attrOffset.Int64(arg.GetOffset()),
+			attrLength.Int64(arg.GetLength()),
+		}
+	case *protos.VDiskWriteArg:
+		var offset, length int64
+		for i, r := range arg.GetRangeVec() {
+			if i == 0 {
+				offset = r.GetOffset()
+			}
+			length += r.GetLength()
+		}
+		attrs = []attribute.KeyValue{
+			attrDisk.String(DiskKey(arg.GetDiskId())),
+			attrOffset.Int64(offset),
+			attrLength.Int64(length),
+			attrSequenceNumber.Int64(arg.GetSequenceNumber()),
+		}
+	}
+	// The span describes the first request; pipelined requests after it
+	// are only events
+	if first {
+		s.span.SetAttributes(attrs...)
+	}
+	s.span.AddEvent("request sent", trace.WithAttributes(attrs...))
+	return nil
+}
+
+func (s *tracedStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		s.end(nil)
+		return err
+	}
+	if err != nil {
+		s.end(err)
+		return err
+	}
+
+	s.mu.Lock()
+	s.responses++
+	first := s.responses == 1
+	s.mu.Unlock()
+	if first {
+		s.span.AddEvent("first response")
+	}
+
+	var n int64
+	var failure error
+	last := true
+	switch ret := m.(type) {
+	case *protos.VDiskReadRet:
+		n = int64(len(ret.GetData()))
+		failure = readError(ret)
+		last = ret.HasMoreData != nil && !*ret.HasMoreData
+		var offset int64
+		if ranges := ret.GetRangeVec(); len(ranges) > 0 {
+			offset = ranges[0].GetOffset()
+		}
+		s.span.AddEvent("read chunk", trace.WithAttributes(
+			attrOffset.Int64(offset),
+			attrBytes.Int64(n),
+			attrHasMoreData.Bool(ret.GetHasMoreData()),
+		))
+	case *protos.VDiskWriteRet:
+		n = ret.GetBytesWritten()
+		failure = writeError(ret)
+		s.span.AddEvent("write response", trace.WithAttributes(
+			attrOffset.Int64(ret.GetOffset()),
+			attrSequenceNumber.Int64(ret.GetSequenceNumber()),
+			attrBytes.Int64(n),
+		))
+	}
+
+	s.mu.Lock()
+	s.bytes += n
+	if last || failure != nil {
+		s.finished++
+	}
+	done := s.finished >= s.requests && (failure != nil || s.read && s.halfClose)
+	s.mu.Unlock()
+	if done {
+		s.end(failure)
+	}
+	return nil
+}
+
+func (s *tracedStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	s.span.AddEvent("send closed")
+
+	// Callers such as Client.Read stop reading once every request has its
+	// last response, without waiting for the server to end the stream
+	s.mu.Lock()
+	s.halfClose = true
+	idle := s.finished >= s.requests
+	s.mu.Unlock()
+	if err != nil || s.read && idle {
+		s.end(err)
+	}
+	return err
+}
+
+// end records how the stream finished and ends its span once
+func (s *tracedStream) end(err error) {
+	s.once.Do(func() {
+		if s.stop != nil {
+			s.stop()
+		}
+
+		s.mu.Lock()
+		// Cancelling a stream once it is done is how callers release it, so
+		// only a context ending with requests outstanding is a failure
+		if err == nil && s.finished < s.requests {
+			err = s.ClientStream.Context().Err()
+		}
+		s.span.SetAttributes(
+			attrRequests.Int(s.requests),
+			attrResponses.Int(s.responses),
+			attrBytes.Int64(s.bytes),
+		)
+		s.mu.Unlock()
+
+		if st, ok := status.FromError(err); ok {
+			s.span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(st.Code())))
+		}
+		if err != nil {
+			s.span.RecordError(err)
+			s.span.SetStatus(codes.Error, err.Error())
+		}
+		s.span.AddEvent("stream closed")
+		s.span.End()
+	})
+}
+
+// metadataCarrier adapts gRPC metadata to a propagation.TextMapCarrier
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if vals := metadata.MD(c).Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
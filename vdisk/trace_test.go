@@ -0,0 +1,179 @@
+package vdisk_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk/vdisktest"
+)
+
+func TestTracing(t *testing.T) {
+	// The server records the trace context of every stream, checking that
+	// it arrives in the same metadata as the credentials
+	var mu sync.Mutex
+	var traceparents []string
+	s := vdisktest.NewServer(vdisktest.ServerOptions{
+		DiskSize:        testDiskSize,
+		MaxResponseSize: 4096,
+		StreamMetadata: func(method string, md metadata.MD) {
+			if auth := md.Get("authorization"); len(auth) != 1 || auth[0] != "Bearer token" {
+				t.Errorf("%s: authorization %q", method, auth)
+			}
+			tp := md.Get("traceparent")
+			if len(tp) != 1 {
+				t.Errorf("%s: traceparent %q", method, tp)
+				return
+			}
+			mu.Lock()
+			traceparents = append(traceparents, tp[0])
+			mu.Unlock()
+		},
+	})
+	s.StartBufconn()
+	t.Cleanup(s.Stop)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	opts := s.ClientOptions()
+	opts.AuthType = vdisk.AuthBearer
+	opts.BearerToken = "token"
+	opts.TracerProvider = provider
+	c, err := vdisk.NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	ctx := context.Background()
+	disk := vdisk.VMDisk("traced")
+	data := bytes.Repeat([]byte{7}, 12288)
+
+	for _, tc := range []struct {
+		name   string
+		run    func() error
+		method string
+		attrs  []attribute.KeyValue
+		events []string
+	}{
+		{
+			name: "write",
+			run: func() error {
+				_, err := c.Write(ctx, vdisk.WriteRequest{Disk: disk, Offset: 8192, Length: int64(len(data)), Data: data, SequenceNumber: 7}, nil)
+				return err
+			},
+			method: "VDiskStreamWrite",
+			attrs: []attribute.KeyValue{
+				attribute.String("vdisk.disk", vdisk.DiskKey(disk)),
+				attribute.Int64("vdisk.offset", 8192),
+				attribute.Int64("vdisk.length", 12288),
+				attribute.Int64("vdisk.sequence_number", 7),
+				attribute.Int("vdisk.requests", 1),
+				attribute.Int("vdisk.responses", 1),
+				attribute.Int64("vdisk.bytes", 12288),
+			},
+			events: []string{"request sent", "send closed", "first response", "write response", "stream closed"},
+		},
+		{
+			name: "read",
+			run: func() error {
+				// Three responses of at most MaxResponseSize
+				_, err := c.Read(ctx, vdisk.ReadRequest{Disk: disk, Offset: 8192, Length: 12288}, nil)
+				return err
+			},
+			method: "VDiskStreamRead",
+			attrs: []attribute.KeyValue{
+				attribute.String("vdisk.disk", vdisk.DiskKey(disk)),
+				attribute.Int64("vdisk.offset", 8192),
+				attribute.Int64("vdisk.length", 12288),
+				attribute.Int("vdisk.requests", 1),
+				attribute.Int("vdisk.responses", 3),
+				attribute.Int64("vdisk.bytes", 12288),
+			},
+			events: []string{"request sent", "first response", "read chunk", "read chunk", "read chunk", "send closed", "stream closed"},
+		},
+		{
+			name: "read session",
+			run: func() error {
+				session, err := c.NewReadSession(ctx, vdisk.ReadSessionOptions{Window: 1})
+				if err != nil {
+					return err
+				}
+				for _, off := range []int64{8192, 16384} {
+					if r := session.Read(ctx, vdisk.ReadRequest{Disk: disk, Offset: off, Length: 4096}); r.Err != nil {
+						return r.Err
+					}
+				}
+				return session.Close()
+			},
+			method: "VDiskStreamRead",
+			// The span describes the first request
+			attrs: []attribute.KeyValue{
+				attribute.String("vdisk.disk", vdisk.DiskKey(disk)),
+				attribute.Int64("vdisk.offset", 8192),
+				attribute.Int64("vdisk.length", 4096),
+				attribute.Int("vdisk.requests", 2),
+				attribute.Int("vdisk.responses", 2),
+				attribute.Int64("vdisk.bytes", 8192),
+			},
+			events: []string{"request sent", "first response", "read chunk", "request sent", "read chunk", "send closed", "stream closed"},
+		},
+	} {
+		before := len(recorder.Ended())
+		if err := tc.run(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		// Every path ends the span before returning to the caller
+		ended := recorder.Ended()[before:]
+		if len(ended) != 1 {
+			t.Fatalf("%s: %d spans ended, want 1", tc.name, len(ended))
+		}
+		span := ended[0]
+
+		if want := "StargateVDiskRpcSvc/" + tc.method; span.Name() != want {
+			t.Errorf("%s: span %q, want %q", tc.name, span.Name(), want)
+		}
+		attrs := attribute.NewSet(span.Attributes()...)
+		for _, want := range tc.attrs {
+			if got, ok := attrs.Value(want.Key); !ok || got != want.Value {
+				t.Errorf("%s: attribute %s = %v, want %v", tc.name, want.Key, got.Emit(), want.Value.Emit())
+			}
+		}
+		// A session receives on its own goroutine, so a response may be
+		// recorded before the request event; only the close is ordered
+		var events []string
+		for _, e := range span.Events() {
+			events = append(events, e.Name)
+		}
+		if len(events) == 0 || events[len(events)-1] != "stream closed" {
+			t.Errorf("%s: events %q do not end with the stream closing", tc.name, events)
+		}
+		sort.Strings(events)
+		sort.Strings(tc.events)
+		if fmt.Sprint(events) != fmt.Sprint(tc.events) {
+			t.Errorf("%s: events %q, want %q", tc.name, events, tc.events)
+		}
+		if span.Status().Code != codes.Unset {
+			t.Errorf("%s: status %v", tc.name, span.Status())
+		}
+
+		// The server saw this span as the parent of its stream
+		mu.Lock()
+		got := traceparents
+		mu.Unlock()
+		want := fmt.Sprintf("00-%s-%s-01", span.SpanContext().TraceID(), span.SpanContext().SpanID())
+		if len(got) == 0 || got[len(got)-1] != want {
+			t.Errorf("%s: server saw traceparent %q, want %q", tc.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package vdisktest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Disk is the storage behind one fake vdisk
+type Disk interface {
+	io.ReaderAt
+	io.WriterAt
+	// Zero clears [off, off+length)
+	Zero(off, length int64) error
+	// Size returns the disk size reported as total_disk_size
+	Size() int64
+}
+
+// memBlockSize is the allocation unit of a MemDisk
+const memBlockSize = 64 * 1024
+
+// MemDisk is a sparse in-memory Disk. Only blocks holding non-zero data
+// are allocated.
+type MemDisk struct {
+	mu     sync.RWMutex
+	size   int64
+	blocks map[int64][]byte
+}
+
+// NewMemDisk returns an all-zero MemDisk of size bytes
+func NewMemDisk(size int64) *MemDisk {
+	return &MemDisk{size: size, blocks: make(map[int64][]byte)}
+}
+
+// Size returns the disk size
+func (d *MemDisk) Size() int64 {
+	return d.size
+}
+
+// AllocatedBytes returns the memory held by non-zero blocks
+func (d *MemDisk) AllocatedBytes() int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return int64(len(d.blocks)) * memBlockSize
+}
+
+// ReadAt reads from the disk, returning io.EOF for reads past its end
+func (d *MemDisk) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= d.size {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), d.size-off))
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for done := 0; done < n; {
+		pos := off + int64(done)
+		index, within := pos/memBlockSize, pos%memBlockSize
+		chunk := p[done:min(n, done+int(memBlockSize-within))]
+		if block, ok := d.blocks[index]; ok {
+			copy(chunk, block[within:])
+		} else {
+			clear(chunk)
+		}
+		done += len(chunk)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes p at off. Writes past the end of the disk fail.
+func (d *MemDisk) WriteAt(p []byte, off int64) (int, error) {
+	return len(p), d.update(off, int64(len(p)), p)
+}
+
+// Zero clears [off, off+length), releasing blocks it fully covers
+func (d *MemDisk) Zero(off, length int64) error {
+	return d.update(off, length, nil)
+}
+
+// update copies p, or zeros when p is nil, into [off, off+length)
+func (d *MemDisk) update(off, length int64, p []byte) error {
+	if off < 0 || length < 0 || off+length > d.size {
+		return fmt.Errorf("range [%d, %d) is outside the %d byte disk", off, off+length, d.size)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for done := int64(0); done < length; {
+		pos := off + done
+		index, within := pos/memBlockSize, pos%memBlockSize
+		n := min(length-done, memBlockSize-within)
+
+		block, ok := d.blocks[index]
+		if p == nil {
+			if ok {
+				clear(block[within : within+n])
+			}
+		} else {
+			if !ok {
+				block = make([]byte, memBlockSize)
+				d.blocks[index] = block
+			}
+			copy(block[within:], p[done:done+n])
+		}
+		if ok || p != nil {
+			if isZero(block) {
+				delete(d.blocks, index)
+			}
+		}
+		done += n
+	}
+	return nil
+}
+
+// FileDisk is a Disk backed by a local file, usually sparse
+type FileDisk struct {
+	f    *os.File
+	size int64
+}
+
+// OpenFileDisk opens or creates path as a disk of size bytes. An existing
+// file keeps its contents and is resized to size.
+func OpenFileDisk(path string, size int64) (*FileDisk, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileDisk{f: f, size: size}, nil
+}
+
+// Size returns the disk size
+func (d *FileDisk) Size() int64 {
+	return d.size
+}
+
+// ReadAt reads from the backing file
+func (d *FileDisk) ReadAt(p []byte, off int64) (int, error) {
+	return d.f.ReadAt(p, off)
+}
+
+// WriteAt writes to the backing file. Writes past the end of the disk fail.
+func (d *FileDisk) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > d.size {
+		return 0, fmt.Errorf("range [%d, %d) is outside the %d byte disk", off, off+int64(len(p)), d.size)
+	}
+	return d.f.WriteAt(p, off)
+}
+
+// Zero overwrites [off, off+length) with zeros
+func (d *FileDisk) Zero(off, length int64) error {
+	var zeros [memBlockSize]byte
+	for length > 0 {
+		n := min(length, int64(len(zeros)))
+		if _, err := d.WriteAt(zeros[:n], off); err != nil {
+			return err
+		}
+		off += n
+		length -= n
+	}
+	return nil
+}
+
+// Close closes the backing file
+func (d *FileDisk) Close() error {
+	return d.f.Close()
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
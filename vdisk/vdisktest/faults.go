@@ -0,0 +1,252 @@
+package vdisktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Operations matched by Fault.Operation
+const (
+	OpRead  = "read"
+	OpWrite = "write"
+)
+
+// Duration is a time.Duration that reads from JSON as a string such as
+// "5ms" or as a number of nanoseconds
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		*d = Duration(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Latency describes a delay distribution
+type Latency struct {
+	// Distribution is fixed (the default, using Mean), uniform (Min to Max),
+	// normal (Mean and StdDev) or exponential (Mean)
+	Distribution string   `json:"distribution,omitempty"`
+	Mean         Duration `json:"mean,omitempty"`
+	StdDev       Duration `json:"stddev,omitempty"`
+	Min          Duration `json:"min,omitempty"`
+	Max          Duration `json:"max,omitempty"`
+}
+
+// sample draws one delay, never negative
+func (l Latency) sample(rng *rand.Rand) time.Duration {
+	var d float64
+	switch l.Distribution {
+	case "uniform":
+		d = float64(l.Min) + rng.Float64()*float64(l.Max-l.Min)
+	case "normal":
+		d = float64(l.Mean) + rng.NormFloat64()*float64(l.StdDev)
+	case "exponential":
+		d = rng.ExpFloat64() * float64(l.Mean)
+	default:
+		d = float64(l.Mean)
+	}
+	return time.Duration(math.Max(d, 0))
+}
+
+func (l Latency) validate() error {
+	switch l.Distribution {
+	case "", "fixed", "normal", "exponential":
+		return nil
+	case "uniform":
+		if l.Max < l.Min {
+			return fmt.Errorf("uniform latency max %v is below min %v", time.Duration(l.Max), time.Duration(l.Min))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown latency distribution %q", l.Distribution)
+}
+
+// Fault is one fault injection rule. A rule matches requests by operation
+// and disk; every matching rule whose Probability roll succeeds applies
+// its effects to the request.
+type Fault struct {
+	// Operation is OpRead, OpWrite or empty for both
+	Operation string `json:"operation,omitempty"`
+	// Disk is a key as returned by vdisk.DiskKey, or empty for every disk
+	Disk string `json:"disk,omitempty"`
+	// Probability of applying the rule to a matching request; 0 means always
+	Probability float64 `json:"probability,omitempty"`
+	// After skips the first After matching requests
+	After int64 `json:"after,omitempty"`
+	// Count stops the rule after it has fired Count times; 0 is unlimited
+	Count int64 `json:"count,omitempty"`
+
+	// Latency delays the first response to the request
+	Latency *Latency `json:"latency,omitempty"`
+	// Code fails the stream with this gRPC status before responding
+	Code codes.Code `json:"code,omitempty"`
+	// Reset fails the stream with UNAVAILABLE after the first read response,
+	// or after applying a write but before acknowledging it
+	Reset bool `json:"reset,omitempty"`
+	// Truncate removes this many bytes from the end of the data of the
+	// first read response, leaving range_vec unchanged
+	Truncate int64 `json:"truncate,omitempty"`
+	// ErrorMessage is placed in the first response's error_message without
+	// otherwise changing it. A write keeps success=true unless FailWrite is
+	// also set and a read keeps its data, so clients treat the message as
+	// informational.
+	ErrorMessage string `json:"error_message,omitempty"`
+	// DropAck applies a write without sending its VDiskWriteRet
+	DropAck bool `json:"drop_ack,omitempty"`
+	// Corrupt inverts this many bytes in the middle of the data of the first
+	// read response
+	Corrupt int64 `json:"corrupt,omitempty"`
+	// Misdirect applies a write this many bytes away from the offset it was
+	// sent for
+	Misdirect int64 `json:"misdirect,omitempty"`
+	// LoseWrite acknowledges a write without applying it
+	LoseWrite bool `json:"lose_write,omitempty"`
+	// FailWrite answers a write with success=false without applying it
+	FailWrite bool `json:"fail_write,omitempty"`
+	// OmitBytesWritten acknowledges a write without setting bytes_written
+	OmitBytesWritten bool `json:"omit_bytes_written,omitempty"`
+}
+
+// FaultScript is the JSON document loaded by LoadFaultScript
+type FaultScript struct {
+	// Seed makes probability rolls and latency samples reproducible
+	Seed   int64   `json:"seed,omitempty"`
+	Faults []Fault `json:"faults"`
+}
+
+// LoadFaultScript reads a FaultScript from a JSON file
+func LoadFaultScript(path string) (FaultScript, error) {
+	var script FaultScript
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return script, err
+	}
+	if err := json.Unmarshal(data, &script); err != nil {
+		return script, fmt.Errorf("invalid fault script %s: %v", path, err)
+	}
+	return script, script.Validate()
+}
+
+// Validate checks every rule for unknown operations and distributions
+func (s FaultScript) Validate() error {
+	for i, f := range s.Faults {
+		switch f.Operation {
+		case "", OpRead, OpWrite:
+		default:
+			return fmt.Errorf("fault %d: unknown operation %q", i, f.Operation)
+		}
+		if f.Probability < 0 || f.Probability > 1 {
+			return fmt.Errorf("fault %d: probability %v is outside [0, 1]", i, f.Probability)
+		}
+		if f.Latency != nil {
+			if err := f.Latency.validate(); err != nil {
+				return fmt.Errorf("fault %d: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// injection is the combined effect of all rules fired for one request
+type injection struct {
+	latency      time.Duration
+	code         codes.Code
+	reset        bool
+	truncate     int64
+	errorMessage string
+	dropAck      bool
+	corrupt      int64
+	misdirect    int64
+	loseWrite    bool
+	failWrite    bool
+	omitBytes    bool
+}
+
+// faultInjector evaluates a FaultScript against incoming requests
+type faultInjector struct {
+	mu      sync.Mutex
+	rng     *rand.Rand
+	faults  []Fault
+	matched []int64
+	fired   []int64
+}
+
+func newFaultInjector(script FaultScript) *faultInjector {
+	return &faultInjector{
+		rng:     rand.New(rand.NewSource(script.Seed)),
+		faults:  script.Faults,
+		matched: make([]int64, len(script.Faults)),
+		fired:   make([]int64, len(script.Faults)),
+	}
+}
+
+// inject returns the faults to apply to one request and how many rules fired
+func (f *faultInjector) inject(op, disk string) (injection, int) {
+	var inj injection
+	if f == nil {
+		return inj, 0
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fired := 0
+	for i, rule := range f.faults {
+		if (rule.Operation != "" && rule.Operation != op) || (rule.Disk != "" && rule.Disk != disk) {
+			continue
+		}
+		f.matched[i]++
+		if f.matched[i] <= rule.After || (rule.Count > 0 && f.fired[i] >= rule.Count) {
+			continue
+		}
+		if rule.Probability > 0 && f.rng.Float64() >= rule.Probability {
+			continue
+		}
+		f.fired[i]++
+		fired++
+
+		if rule.Latency != nil {
+			inj.latency += rule.Latency.sample(f.rng)
+		}
+		if rule.Code != codes.OK {
+			inj.code = rule.Code
+		}
+		inj.reset = inj.reset || rule.Reset
+		inj.truncate += rule.Truncate
+		if rule.ErrorMessage != "" {
+			inj.errorMessage = rule.ErrorMessage
+		}
+		inj.dropAck = inj.dropAck || rule.DropAck
+		inj.corrupt += rule.Corrupt
+		inj.misdirect += rule.Misdirect
+		inj.loseWrite = inj.loseWrite || rule.LoseWrite
+		inj.failWrite = inj.failWrite || rule.FailWrite
+		inj.omitBytes = inj.omitBytes || rule.OmitBytesWritten
+	}
+	return inj, fired
+}
@@ -0,0 +1,484 @@
+// Package vdisktest provides an in-process fake of the Stargate VDisk gRPC
+// service for exercising the vdisk client, batch and throughput modes
+// without a cluster. Disks are created on first use and kept in sparse
+// memory or in local files.
+package vdisktest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/vdisk"
+)
+
+// Defaults applied to zero ServerOptions fields
+const (
+	DefaultDiskSize        = 1024 * 1024 * 1024
+	DefaultMaxResponseSize = 1024 * 1024
+	DefaultZeroBlockSize   = 4096
+)
+
+// ServerOptions configures a Server
+type ServerOptions struct {
+	// DiskSize is the size of disks created on first use
+	DiskSize int64
+	// MaxResponseSize caps the data in one VDiskReadRet when the request
+	// sets no max_response_size
+	MaxResponseSize int64
+	// ZeroBlockSize is the granularity at which reads report all-zero
+	// blocks as zero_data ranges
+	ZeroBlockSize int64
+	// NewDisk creates the disk for key, as returned by vdisk.DiskKey.
+	// Defaults to NewMemDisk.
+	NewDisk func(key string, size int64) (Disk, error)
+	// Faults is the initial fault injection script
+	Faults FaultScript
+	// StreamMetadata, if set, is called with the full method name and the
+	// request metadata of every stream before it is served
+	StreamMetadata func(method string, md metadata.MD)
+}
+
+// ServerStats counts the requests a Server has handled
+type ServerStats struct {
+	ReadStreams   int64
+	WriteStreams  int64
+	ReadRequests  int64
+	ReadResponses int64
+	WriteRequests int64
+	WriteFailures int64
+	BytesRead     int64
+	BytesWritten  int64
+	// FaultsInjected counts fault rules fired
+	FaultsInjected int64
+}
+
+// Server is a fake StargateVDiskRpcSvcServer
+type Server struct {
+	protos.UnimplementedStargateVDiskRpcSvcServer
+
+	opts ServerOptions
+
+	mu    sync.Mutex
+	disks map[string]Disk
+
+	stats  ServerStats
+	faults atomic.Pointer[faultInjector]
+
+	grpc     *grpc.Server
+	bufconn  *bufconn.Listener
+	listener net.Listener
+}
+
+// NewServer returns a Server that is not yet listening
+func NewServer(opts ServerOptions) *Server {
+	if opts.DiskSize <= 0 {
+		opts.DiskSize = DefaultDiskSize
+	}
+	if opts.MaxResponseSize <= 0 {
+		opts.MaxResponseSize = DefaultMaxResponseSize
+	}
+	if opts.ZeroBlockSize <= 0 {
+		opts.ZeroBlockSize = DefaultZeroBlockSize
+	}
+	if opts.NewDisk == nil {
+		opts.NewDisk = func(string, int64) (Disk, error) {
+			return NewMemDisk(opts.DiskSize), nil
+		}
+	}
+
+	serverOpts := []grpc.ServerOption{grpc.MaxRecvMsgSize(100 * 1024 * 1024), grpc.MaxSendMsgSize(100 * 1024 * 1024)}
+	if opts.StreamMetadata != nil {
+		serverOpts = append(serverOpts, grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			md, _ := metadata.FromIncomingContext(ss.Context())
+			opts.StreamMetadata(info.FullMethod, md)
+			return handler(srv, ss)
+		}))
+	}
+
+	s := &Server{
+		opts:  opts,
+		disks: make(map[string]Disk),
+		grpc:  grpc.NewServer(serverOpts...),
+	}
+	if len(opts.Faults.Faults) > 0 {
+		s.SetFaults(opts.Faults)
+	}
+	protos.RegisterStargateVDiskRpcSvcServer(s.grpc, s)
+	return s
+}
+
+// SetFaults replaces the fault injection script. An empty script disables
+// fault injection.
+func (s *Server) SetFaults(script FaultScript) {
+	if len(script.Faults) == 0 {
+		s.faults.Store(nil)
+		return
+	}
+	s.faults.Store(newFaultInjector(script))
+}
+
+// inject evaluates the fault script for one request and applies its
+// latency. A non-OK code in the returned injection must fail the stream.
+func (s *Server) inject(ctx context.Context, op string, disk string) injection {
+	inj, fired := s.faults.Load().inject(op, disk)
+	if fired == 0 {
+		return inj
+	}
+	atomic.AddInt64(&s.stats.FaultsInjected, int64(fired))
+	if inj.latency > 0 {
+		timer := time.NewTimer(inj.latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	return inj
+}
+
+// Start serves on a TCP listener at addr ("127.0.0.1:0" picks a free port)
+// and returns the address it is listening on
+func (s *Server) Start(addr string) (string, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	s.listener = lis
+	go s.grpc.Serve(lis)
+	return lis.Addr().String(), nil
+}
+
+// StartBufconn serves on an in-memory listener. Clients connect with
+// ClientOptions or by using Dialer as vdisk.Options.Dialer.
+func (s *Server) StartBufconn() {
+	s.bufconn = bufconn.Listen(4 * 1024 * 1024)
+	s.listener = s.bufconn
+	go s.grpc.Serve(s.bufconn)
+}
+
+// Dialer connects to the bufconn listener started by StartBufconn
+func (s *Server) Dialer(ctx context.Context, _ string) (net.Conn, error) {
+	if s.bufconn == nil {
+		return nil, fmt.Errorf("fake server is not listening on bufconn")
+	}
+	return s.bufconn.DialContext(ctx)
+}
+
+// ClientOptions returns vdisk.Options that reach this server without TLS
+// or authentication
+func (s *Server) ClientOptions() vdisk.Options {
+	opts := vdisk.DefaultOptions()
+	opts.UseTLS = false
+	opts.AuthType = vdisk.AuthNone
+	if s.bufconn != nil {
+		opts.Address = "passthrough:///bufconn"
+		opts.Dialer = s.Dialer
+	} else if s.listener != nil {
+		opts.Address = s.listener.Addr().String()
+	}
+	return opts
+}
+
+// Stop stops serving and closes all streams
+func (s *Server) Stop() {
+	s.grpc.Stop()
+}
+
+// Stats returns a snapshot of the request counters
+func (s *Server) Stats() ServerStats {
+	return ServerStats{
+		ReadStreams:    atomic.LoadInt64(&s.stats.ReadStreams),
+		WriteStreams:   atomic.LoadInt64(&s.stats.WriteStreams),
+		ReadRequests:   atomic.LoadInt64(&s.stats.ReadRequests),
+		ReadResponses:  atomic.LoadInt64(&s.stats.ReadResponses),
+		WriteRequests:  atomic.LoadInt64(&s.stats.WriteRequests),
+		WriteFailures:  atomic.LoadInt64(&s.stats.WriteFailures),
+		BytesRead:      atomic.LoadInt64(&s.stats.BytesRead),
+		BytesWritten:   atomic.LoadInt64(&s.stats.BytesWritten),
+		FaultsInjected: atomic.LoadInt64(&s.stats.FaultsInjected),
+	}
+}
+
+// AddDisk registers d as the disk for id, replacing any existing disk
+func (s *Server) AddDisk(id *protos.DiskIdentifier, d Disk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disks[vdisk.DiskKey(id)] = d
+}
+
+// Disk returns the disk for id, creating it on first use
+func (s *Server) Disk(id *protos.DiskIdentifier) (Disk, error) {
+	if id == nil || id.Identifier == nil {
+		return nil, fmt.Errorf("disk_id is required")
+	}
+	key := vdisk.DiskKey(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.disks[key]; ok {
+		return d, nil
+	}
+	d, err := s.opts.NewDisk(key, s.opts.DiskSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create disk %s: %v", key, err)
+	}
+	s.disks[key] = d
+	return d, nil
+}
+
+// VDiskStreamRead answers every VDiskReadArg with one or more VDiskReadRet
+// messages of at most max_response_size data bytes
+func (s *Server) VDiskStreamRead(stream protos.StargateVDiskRpcSvc_VDiskStreamReadServer) error {
+	atomic.AddInt64(&s.stats.ReadStreams, 1)
+	for {
+		arg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&s.stats.ReadRequests, 1)
+		if err := s.read(stream, arg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) read(stream protos.StargateVDiskRpcSvc_VDiskStreamReadServer, arg *protos.VDiskReadArg) error {
+	d, err := s.Disk(arg.DiskId)
+	if err != nil {
+		return stream.Send(&protos.VDiskReadRet{ErrorMessage: proto.String(err.Error())})
+	}
+	size := d.Size()
+
+	inj := s.inject(stream.Context(), OpRead, vdisk.DiskKey(arg.DiskId))
+	if inj.code != codes.OK {
+		return status.Errorf(inj.code, "fault injection: %s", inj.code)
+	}
+
+	off, length := arg.GetOffset(), arg.GetLength()
+	if off < 0 || off > size || length < 0 {
+		return stream.Send(&protos.VDiskReadRet{
+			ErrorMessage:  proto.String(fmt.Sprintf("invalid read range: offset=%d, length=%d, disk size=%d", off, length, size)),
+			TotalDiskSize: proto.Int64(size),
+		})
+	}
+	end := size
+	if length > 0 {
+		end = min(off+length, size)
+	}
+	maxResponse := s.opts.MaxResponseSize
+	if arg.GetMaxResponseSize() > 0 {
+		maxResponse = arg.GetMaxResponseSize()
+	}
+
+	for pos, first := off, true; ; first = false {
+		n := min(end-pos, maxResponse)
+		resp, err := s.readResponse(d, pos, n)
+		if err != nil {
+			return stream.Send(&protos.VDiskReadRet{
+				ErrorMessage:  proto.String(err.Error()),
+				TotalDiskSize: proto.Int64(size),
+			})
+		}
+		pos += n
+		resp.HasMoreData = proto.Bool(pos < end)
+		resp.TotalDiskSize = proto.Int64(size)
+		if first {
+			resp.Data = resp.Data[:max(0, int64(len(resp.Data))-inj.truncate)]
+			corrupt(resp.Data, inj.corrupt)
+			if inj.errorMessage != "" {
+				resp.ErrorMessage = proto.String(inj.errorMessage)
+			}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+		atomic.AddInt64(&s.stats.ReadResponses, 1)
+		atomic.AddInt64(&s.stats.BytesRead, n)
+		if first && inj.reset {
+			return status.Error(codes.Unavailable, "fault injection: stream reset")
+		}
+		if pos >= end {
+			return nil
+		}
+	}
+}
+
+// readResponse reads [off, off+n) and describes it as range_vec entries,
+// with all-zero blocks marked zero_data and omitted from data
+func (s *Server) readResponse(d Disk, off, n int64) (*protos.VDiskReadRet, error) {
+	buf := make([]byte, n)
+	if _, err := d.ReadAt(buf, off); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read failed at offset %d: %v", off, err)
+	}
+
+	resp := &protos.VDiskReadRet{}
+	data := buf[:0]
+	for pos := int64(0); pos < n; {
+		// Blocks are aligned to disk offsets
+		blockEnd := min(n, (off+pos)/s.opts.ZeroBlockSize*s.opts.ZeroBlockSize+s.opts.ZeroBlockSize-off)
+		block := buf[pos:blockEnd]
+		zero := isZero(block)
+		if !zero {
+			// data never overtakes pos, so compacting in place is safe
+			data = append(data, block...)
+		}
+
+		if last := len(resp.RangeVec) - 1; last >= 0 && resp.RangeVec[last].GetZeroData() == zero {
+			resp.RangeVec[last].Length = proto.Int64(resp.RangeVec[last].GetLength() + int64(len(block)))
+		} else {
+			resp.RangeVec = append(resp.RangeVec, &protos.DiskDataRange{
+				Offset:   proto.Int64(off + pos),
+				Length:   proto.Int64(int64(len(block))),
+				ZeroData: proto.Bool(zero),
+			})
+		}
+		pos = blockEnd
+	}
+	resp.Data = data
+	return resp, nil
+}
+
+// VDiskStreamWrite applies every VDiskWriteArg in order and acknowledges
+// it with a VDiskWriteRet echoing its sequence_number
+func (s *Server) VDiskStreamWrite(stream protos.StargateVDiskRpcSvc_VDiskStreamWriteServer) error {
+	atomic.AddInt64(&s.stats.WriteStreams, 1)
+	for {
+		arg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&s.stats.WriteRequests, 1)
+
+		inj := s.inject(stream.Context(), OpWrite, vdisk.DiskKey(arg.DiskId))
+		if inj.code != codes.OK {
+			return status.Errorf(inj.code, "fault injection: %s", inj.code)
+		}
+
+		ret := &protos.VDiskWriteRet{SequenceNumber: arg.SequenceNumber}
+		if len(arg.RangeVec) > 0 {
+			ret.Offset = arg.RangeVec[0].Offset
+		}
+		var written int64
+		switch {
+		case inj.failWrite:
+		case inj.loseWrite:
+			written, err = writeLength(arg)
+		default:
+			written, err = s.write(arg, inj.misdirect)
+		}
+		switch {
+		case inj.failWrite:
+			atomic.AddInt64(&s.stats.WriteFailures, 1)
+			ret.Success = proto.Bool(false)
+		case err != nil:
+			atomic.AddInt64(&s.stats.WriteFailures, 1)
+			ret.Success = proto.Bool(false)
+			ret.ErrorMessage = proto.String(err.Error())
+		default:
+			atomic.AddInt64(&s.stats.BytesWritten, written)
+			ret.Success = proto.Bool(true)
+			ret.Length = proto.Int64(written)
+			if !inj.omitBytes {
+				ret.BytesWritten = proto.Int64(written)
+			}
+		}
+
+		if inj.reset {
+			return status.Error(codes.Unavailable, "fault injection: stream reset")
+		}
+		if inj.errorMessage != "" {
+			ret.ErrorMessage = proto.String(inj.errorMessage)
+		}
+		if inj.dropAck {
+			continue
+		}
+		if err := stream.Send(ret); err != nil {
+			return err
+		}
+	}
+}
+
+// write verifies, decompresses and applies one VDiskWriteArg shift bytes
+// away from its range_vec, returning the number of disk bytes it covered
+func (s *Server) write(arg *protos.VDiskWriteArg, shift int64) (int64, error) {
+	d, err := s.Disk(arg.DiskId)
+	if err != nil {
+		return 0, err
+	}
+	if len(arg.RangeVec) == 0 {
+		return 0, fmt.Errorf("range_vec is required")
+	}
+
+	if sum, ok := vdisk.Checksum(arg.GetChecksumType(), arg.Data); ok && sum != arg.GetChecksum() {
+		return 0, fmt.Errorf("checksum mismatch: request has %08x, payload computes to %08x", arg.GetChecksum(), sum)
+	}
+	data, err := vdisk.DecompressPayload(arg.GetCompressionType(), arg.RangeVec, arg.Data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decompress payload: %v", err)
+	}
+
+	// Writes share the read-side layout: data ranges concatenated in
+	// range_vec order, zero ranges optionally inline
+	extents, err := vdisk.Extents(&protos.VDiskReadRet{RangeVec: arg.RangeVec, Data: data}, arg.RangeVec[0].GetOffset())
+	if err != nil {
+		return 0, err
+	}
+	var written int64
+	for _, e := range extents {
+		e.Offset += shift
+		if e.Offset < 0 || e.End() > d.Size() {
+			return written, fmt.Errorf("write range [%d, %d) is beyond the end of the %d byte disk", e.Offset, e.End(), d.Size())
+		}
+		if e.Zero {
+			err = d.Zero(e.Offset, e.Length)
+		} else {
+			_, err = d.WriteAt(e.Data, e.Offset)
+		}
+		if err != nil {
+			return written, err
+		}
+		written += e.Length
+	}
+	return written, nil
+}
+
+// writeLength returns the number of disk bytes a VDiskWriteArg covers
+func writeLength(arg *protos.VDiskWriteArg) (int64, error) {
+	if len(arg.RangeVec) == 0 {
+		return 0, fmt.Errorf("range_vec is required")
+	}
+	var n int64
+	for _, r := range arg.RangeVec {
+		n += r.GetLength()
+	}
+	return n, nil
+}
+
+// corrupt inverts up to n bytes in the middle of data
+func corrupt(data []byte, n int64) {
+	n = min(n, int64(len(data)))
+	start := (int64(len(data)) - n) / 2
+	for i := start; i < start+n; i++ {
+		data[i] ^= 0xff
+	}
+}
@@ -0,0 +1,322 @@
+package vdisk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/vaibhav-ntnx/grpc-data-api-go-client/protos"
+)
+
+// WriteSessionOptions configures a WriteSession
+type WriteSessionOptions struct {
+	// Window is the maximum number of unacknowledged writes in flight
+	Window int
+	// FirstSequence is the sequence_number assigned to the first write;
+	// subsequent writes increment it by one
+	FirstSequence int64
+	// OnResult, when set, is called from the receive goroutine for every
+	// completed write in addition to the per-write channel. Calls for one
+	// session never overlap.
+	OnResult func(WriteResult)
+}
+
+// WriteResult is the outcome of one write submitted to a WriteSession
+type WriteResult struct {
+	SequenceNumber int64
+	Offset         int64
+	Length         int64
+	BytesWritten   int64
+	RetryCount     int32
+	// PayloadBytes is the size of the data before compression and WireBytes
+	// the size actually sent
+	PayloadBytes int64
+	WireBytes    int64
+	Latency      time.Duration
+	Err          error
+}
+
+type pendingWrite struct {
+	seq          int64
+	offset       int64
+	length       int64
+	payloadBytes int64
+	wireBytes    int64
+	start        time.Time
+	done         chan WriteResult
+}
+
+// WriteSession keeps one VDiskStreamWrite stream open and pipelines writes
+// on it. Each write is assigned a monotonically increasing sequence_number
+// and VDiskWriteRet acknowledgements are matched back by sequence_number,
+// falling back to offset/length and finally to the oldest outstanding write.
+type WriteSession struct {
+	stream    WriteStream
+	cancel    context.CancelFunc
+	connIndex int
+	opts      WriteSessionOptions
+	window    chan struct{}
+
+	sendMu  sync.Mutex
+	nextSeq int64
+
+	mu      sync.Mutex
+	pending []*pendingWrite
+	err     error
+
+	done chan struct{}
+}
+
+// NewWriteSession opens a VDiskStreamWrite stream on the next pooled
+// connection and starts receiving acknowledgements for it
+func (c *Client) NewWriteSession(ctx context.Context, opts WriteSessionOptions) (*WriteSession, error) {
+	if opts.Window < 1 {
+		opts.Window = DefaultPipelineWindow
+	}
+
+	// The session's own context releases the stream once it fails or is closed
+	ctx, cancel := context.WithCancel(ctx)
+	stream, index, err := c.OpenWriteStream(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s := &WriteSession{
+		stream:    stream,
+		cancel:    cancel,
+		connIndex: index,
+		opts:      opts,
+		window:    make(chan struct{}, opts.Window),
+		nextSeq:   opts.FirstSequence,
+		done:      make(chan struct{}),
+	}
+	go s.receive()
+	return s, nil
+}
+
+// ConnIndex returns the index of the pooled connection carrying the stream
+func (s *WriteSession) ConnIndex() int {
+	return s.connIndex
+}
+
+// InFlight returns the number of unacknowledged writes
+func (s *WriteSession) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// Submit sends req on the stream, blocking while the window is full. The
+// request's SequenceNumber is replaced by the session's next sequence
+// number. The returned channel receives exactly one WriteResult.
+func (s *WriteSession) Submit(ctx context.Context, req WriteRequest) (<-chan WriteResult, error) {
+	select {
+	case s.window <- struct{}{}:
+	case <-s.done:
+		return nil, s.failure()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// Hold sendMu across sequence assignment and Send so sequence numbers
+	// reach the server in order
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	req.SequenceNumber = s.nextSeq
+	arg, err := NewWriteArg(req)
+	if err != nil {
+		<-s.window
+		return nil, err
+	}
+	p := &pendingWrite{
+		seq:          req.SequenceNumber,
+		offset:       arg.RangeVec[0].GetOffset(),
+		length:       writeLength(arg),
+		payloadBytes: int64(len(req.Data)),
+		wireBytes:    int64(len(arg.Data)),
+		start:        time.Now(),
+		done:         make(chan WriteResult, 1),
+	}
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		<-s.window
+		return nil, s.err
+	}
+	s.pending = append(s.pending, p)
+	s.mu.Unlock()
+
+	// Send fails with io.EOF once the server has ended the stream. The write
+	// stays outstanding and the receive goroutine completes it with the
+	// status returned by Recv.
+	if err := s.stream.Send(arg); err != nil && err != io.EOF {
+		// The receive goroutine completes the outstanding writes, keeping
+		// OnResult off the caller's goroutine
+		s.abort(fmt.Errorf("failed to send write request: %w", err))
+		return p.done, nil
+	}
+	s.nextSeq++
+	return p.done, nil
+}
+
+// Write submits req and waits for its acknowledgement or for ctx to expire
+func (s *WriteSession) Write(ctx context.Context, req WriteRequest) WriteResult {
+	ch, err := s.Submit(ctx, req)
+	if err != nil {
+		return WriteResult{Offset: req.Offset, Length: req.Length, Err: err}
+	}
+	select {
+	case r := <-ch:
+		return r
+	case <-ctx.Done():
+		return WriteResult{Offset: req.Offset, Length: req.Length, Err: ctx.Err()}
+	}
+}
+
+// Close half-closes the stream and waits for outstanding acknowledgements
+func (s *WriteSession) Close() error {
+	s.sendMu.Lock()
+	err := s.stream.CloseSend()
+	s.sendMu.Unlock()
+
+	<-s.done
+	s.cancel()
+	if err != nil {
+		return fmt.Errorf("failed to close send stream: %w", err)
+	}
+	return nil
+}
+
+// Err returns the error that terminated the session, or ErrSessionClosed
+// once the stream has ended normally
+func (s *WriteSession) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *WriteSession) failure() error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return ErrSessionClosed
+}
+
+// abort records err as the session's failure and cancels the stream, so
+// that the receive goroutine fails the outstanding writes
+func (s *WriteSession) abort(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// fail terminates the session and completes all outstanding writes with the
+// first failure recorded, which is err unless abort came first
+func (s *WriteSession) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	err = s.err
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	s.cancel()
+
+	for _, p := range pending {
+		s.complete(p, p.result(err))
+	}
+}
+
+func (s *WriteSession) complete(p *pendingWrite, result WriteResult) {
+	result.Latency = time.Since(p.start)
+	p.done <- result
+	if s.opts.OnResult != nil {
+		s.opts.OnResult(result)
+	}
+	<-s.window
+}
+
+func (s *WriteSession) receive() {
+	defer close(s.done)
+
+	for {
+		resp, err := s.stream.Recv()
+		if err == io.EOF {
+			s.fail(ErrSessionClosed)
+			return
+		}
+		if err != nil {
+			s.fail(fmt.Errorf("stream error: %w", err))
+			return
+		}
+		s.dispatch(resp)
+	}
+}
+
+// match returns the outstanding write an acknowledgement belongs to
+func (s *WriteSession) match(resp *protos.VDiskWriteRet) int {
+	if len(s.pending) == 0 {
+		return -1
+	}
+	if resp.SequenceNumber != nil {
+		for i, p := range s.pending {
+			if p.seq == *resp.SequenceNumber {
+				return i
+			}
+		}
+	}
+	if resp.Offset != nil {
+		for i, p := range s.pending {
+			if p.offset == *resp.Offset && (resp.Length == nil || p.length == *resp.Length) {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+func (s *WriteSession) dispatch(resp *protos.VDiskWriteRet) {
+	s.mu.Lock()
+	i := s.match(resp)
+	if i < 0 {
+		s.mu.Unlock()
+		return
+	}
+	p := s.pending[i]
+	s.pending = append(s.pending[:i], s.pending[i+1:]...)
+	s.mu.Unlock()
+
+	result := p.result(writeError(resp))
+	result.BytesWritten = resp.GetBytesWritten()
+	result.RetryCount = resp.GetRetryCount()
+	s.complete(p, result)
+}
+
+func (p *pendingWrite) result(err error) WriteResult {
+	return WriteResult{
+		SequenceNumber: p.seq,
+		Offset:         p.offset,
+		Length:         p.length,
+		PayloadBytes:   p.payloadBytes,
+		WireBytes:      p.wireBytes,
+		Err:            err,
+	}
+}
+
+// writeLength returns the number of disk bytes covered by a write
+func writeLength(arg *protos.VDiskWriteArg) int64 {
+	var length int64
+	for _, r := range arg.RangeVec {
+		length += r.GetLength()
+	}
+	return length
+}
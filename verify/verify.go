@@ -0,0 +1,277 @@
+// Package verify builds self-describing data blocks for end-to-end data
+// verification and classifies what a read returned for them.
+//
+// Every block starts with a header naming the disk, offset and generation
+// it was written for, followed by a pattern derived from those fields. A
+// CRC32C over the whole block is stored in the header. Because the content
+// depends only on the header, a reader can rebuild what a block should hold
+// and pinpoint the bytes that differ without keeping a copy of the data.
+//
+// Header layout, little endian:
+//
+//	 0  magic "VDVERIFY"
+//	 8  format version (uint16)
+//	10  reserved (uint16)
+//	12  block size (uint32)
+//	16  disk id (uint64, see DiskID)
+//	24  offset (uint64)
+//	32  generation (uint64)
+//	40  CRC32C of the block with this field zeroed (uint32)
+//	44  reserved up to HeaderSize
+package verify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+)
+
+const (
+	// HeaderSize is the size of the header at the start of every block
+	HeaderSize = 64
+	// Version is the block format version
+	Version = 1
+)
+
+var magic = []byte("VDVERIFY")
+
+const checksumOffset = 40
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Header identifies the write a block came from
+type Header struct {
+	DiskID     uint64
+	Offset     int64
+	Generation uint64
+	BlockSize  int
+}
+
+// DiskID returns the disk id stored in block headers for a disk key such as
+// "vm_disk:<uuid>"
+func DiskID(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// Fill writes the block described by h into block, which must be
+// h.BlockSize bytes long and hold at least the header
+func Fill(block []byte, h Header) error {
+	if len(block) != h.BlockSize {
+		return fmt.Errorf("block of %d bytes for block size %d", len(block), h.BlockSize)
+	}
+	if len(block) < HeaderSize {
+		return fmt.Errorf("block size %d is smaller than the %d byte header", len(block), HeaderSize)
+	}
+	copy(block, magic)
+	binary.LittleEndian.PutUint16(block[8:], Version)
+	binary.LittleEndian.PutUint16(block[10:], 0)
+	binary.LittleEndian.PutUint32(block[12:], uint32(h.BlockSize))
+	binary.LittleEndian.PutUint64(block[16:], h.DiskID)
+	binary.LittleEndian.PutUint64(block[24:], uint64(h.Offset))
+	binary.LittleEndian.PutUint64(block[32:], h.Generation)
+	clear(block[checksumOffset:HeaderSize])
+	fillPattern(block[HeaderSize:], h)
+	binary.LittleEndian.PutUint32(block[checksumOffset:], checksum(block))
+	return nil
+}
+
+// fillPattern fills p with an xorshift sequence seeded from the header
+func fillPattern(p []byte, h Header) {
+	x := h.DiskID ^ uint64(h.Offset)*0x9e3779b97f4a7c15 ^ h.Generation*0xbf58476d1ce4e5b9
+	if x == 0 {
+		x = 1
+	}
+	var word [8]byte
+	for i := 0; i < len(p); i += 8 {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		binary.LittleEndian.PutUint64(word[:], x)
+		copy(p[i:], word[:])
+	}
+}
+
+// checksum returns the CRC32C of block with the checksum field taken as zero
+func checksum(block []byte) uint32 {
+	var zero [4]byte
+	crc := crc32.Update(0, castagnoli, block[:checksumOffset])
+	crc = crc32.Update(crc, castagnoli, zero[:])
+	return crc32.Update(crc, castagnoli, block[checksumOffset+4:])
+}
+
+// Parse decodes the header of block. It fails when the block has no header
+// of this format.
+func Parse(block []byte) (Header, error) {
+	if len(block) < HeaderSize || !bytes.Equal(block[:len(magic)], magic) {
+		return Header{}, fmt.Errorf("no block header")
+	}
+	if v := binary.LittleEndian.Uint16(block[8:]); v != Version {
+		return Header{}, fmt.Errorf("unknown block format version %d", v)
+	}
+	return Header{
+		BlockSize:  int(binary.LittleEndian.Uint32(block[12:])),
+		DiskID:     binary.LittleEndian.Uint64(block[16:]),
+		Offset:     int64(binary.LittleEndian.Uint64(block[24:])),
+		Generation: binary.LittleEndian.Uint64(block[32:]),
+	}, nil
+}
+
+// Kind classifies the outcome of checking a block
+type Kind int
+
+const (
+	// OK means the block holds an acceptable generation of its own data
+	OK Kind = iota
+	// Corrupt means the block content does not match its checksum or is
+	// not a block of this format
+	Corrupt
+	// Stale means the block is intact but holds an older (or unexpected)
+	// generation, or was never written
+	Stale
+	// Misdirected means the block is intact but was written for another
+	// offset or disk
+	Misdirected
+)
+
+func (k Kind) String() string {
+	switch k {
+	case OK:
+		return "ok"
+	case Corrupt:
+		return "corrupt"
+	case Stale:
+		return "stale"
+	case Misdirected:
+		return "misdirected"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Range is a run of differing bytes, as absolute disk offsets
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// maxRanges bounds the differing ranges recorded for one corrupt block
+const maxRanges = 8
+
+// Result is the outcome of checking one block
+type Result struct {
+	Kind   Kind
+	Offset int64 // Disk offset the block was read from
+	// Found is the header of the block read, when it had a valid one
+	Found Header
+	// Ranges are the differing bytes of a corrupt block and BadBytes their
+	// total length
+	Ranges   []Range
+	BadBytes int64
+	Detail   string
+}
+
+// Check classifies block, read from offset of the disk with id diskID. Any
+// generation from minGen to maxGen is accepted, which allows for writes in
+// flight while the block was read. A minGen of 0 also accepts a block that
+// was never written and reads as zeros.
+func Check(block []byte, diskID uint64, offset int64, minGen, maxGen uint64) Result {
+	r := Result{Kind: OK, Offset: offset}
+	want := Header{DiskID: diskID, Offset: offset, Generation: maxGen, BlockSize: len(block)}
+
+	if len(block) < HeaderSize {
+		r.Kind = Corrupt
+		r.Ranges = []Range{{Offset: offset, Length: int64(len(block))}}
+		r.BadBytes = int64(len(block))
+		r.Detail = fmt.Sprintf("block of %d bytes is shorter than the %d byte header", len(block), HeaderSize)
+		return r
+	}
+	if isZero(block) {
+		if minGen > 0 {
+			r.Kind = Stale
+			r.Detail = fmt.Sprintf("block reads as zeros, expected generation %s", genRange(minGen, maxGen))
+		}
+		return r
+	}
+
+	found, err := Parse(block)
+	if err == nil && found.BlockSize == len(block) && checksum(block) == binary.LittleEndian.Uint32(block[checksumOffset:]) {
+		r.Found = found
+		switch {
+		case found.DiskID != diskID:
+			r.Kind = Misdirected
+			r.Detail = fmt.Sprintf("holds generation %d of offset %d of another disk (id %016x)", found.Generation, found.Offset, found.DiskID)
+		case found.Offset != offset:
+			r.Kind = Misdirected
+			r.Detail = fmt.Sprintf("holds generation %d of offset %d", found.Generation, found.Offset)
+		case found.Generation < minGen || found.Generation > maxGen:
+			r.Kind = Stale
+			r.Detail = fmt.Sprintf("holds generation %d, expected %s", found.Generation, genRange(minGen, maxGen))
+		}
+		return r
+	}
+
+	// Rebuild what the block should hold, from its own header when that
+	// still names this block, and report the bytes that differ
+	r.Kind = Corrupt
+	if err == nil && found.DiskID == diskID && found.Offset == offset && found.BlockSize == len(block) {
+		want = found
+		r.Found = found
+	}
+	expected := make([]byte, len(block))
+	Fill(expected, want) // cannot fail, the block was checked to hold a header
+	r.Ranges, r.BadBytes = diff(offset, expected, block)
+	switch {
+	case err != nil:
+		r.Detail = fmt.Sprintf("%v, %d bytes differ from generation %d", err, r.BadBytes, want.Generation)
+	default:
+		r.Detail = fmt.Sprintf("checksum mismatch, %d bytes differ from generation %d", r.BadBytes, want.Generation)
+	}
+	return r
+}
+
+func genRange(lo, hi uint64) string {
+	if lo == hi {
+		return fmt.Sprint(lo)
+	}
+	return fmt.Sprintf("%d to %d", lo, hi)
+}
+
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// diff returns up to maxRanges runs in which want and got differ, with
+// base as the disk offset of both, and the total number of differing bytes
+func diff(base int64, want, got []byte) ([]Range, int64) {
+	var ranges []Range
+	var bad int64
+	start := -1
+	flush := func(end int) {
+		bad += int64(end - start)
+		if len(ranges) < maxRanges {
+			ranges = append(ranges, Range{Offset: base + int64(start), Length: int64(end - start)})
+		}
+		start = -1
+	}
+	for i := range want {
+		if want[i] != got[i] {
+			if start < 0 {
+				start = i
+			}
+		} else if start >= 0 {
+			flush(i)
+		}
+	}
+	if start >= 0 {
+		flush(len(want))
+	}
+	return ranges, bad
+}
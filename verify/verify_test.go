@@ -0,0 +1,211 @@
+package verify
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testDisk = 0x1234
+
+func block(t *testing.T, size int, disk uint64, off int64, gen uint64) []byte {
+	t.Helper()
+	b := make([]byte, size)
+	if err := Fill(b, Header{DiskID: disk, Offset: off, Generation: gen, BlockSize: size}); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFillParse(t *testing.T) {
+	b := block(t, 4096, testDisk, 8192, 7)
+	h, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Header{DiskID: testDisk, Offset: 8192, Generation: 7, BlockSize: 4096}
+	if h != want {
+		t.Errorf("Parse = %+v, want %+v", h, want)
+	}
+	if got := binary.LittleEndian.Uint32(b[checksumOffset:]); got != checksum(b) {
+		t.Errorf("stored checksum %08x, computed %08x", got, checksum(b))
+	}
+}
+
+func TestFillSize(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		len, size int
+	}{
+		{"shorter than header", HeaderSize - 1, HeaderSize - 1},
+		{"empty", 0, 0},
+		{"length differs from block size", 512, 4096},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := Fill(make([]byte, tc.len), Header{BlockSize: tc.size}); err == nil {
+				t.Error("Fill succeeded")
+			}
+		})
+	}
+	if err := Fill(make([]byte, HeaderSize), Header{BlockSize: HeaderSize}); err != nil {
+		t.Errorf("Fill of a header-only block: %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	const off = 4096
+	for _, tc := range []struct {
+		name           string
+		block          func(t *testing.T) []byte
+		minGen, maxGen uint64
+		kind           Kind
+		badBytes       int64
+		ranges         []Range
+		detail         string
+	}{
+		{
+			name:   "current generation",
+			block:  func(t *testing.T) []byte { return block(t, 4096, testDisk, off, 3) },
+			minGen: 3, maxGen: 3,
+			kind: OK,
+		},
+		{
+			name:   "generation in flight",
+			block:  func(t *testing.T) []byte { return block(t, 4096, testDisk, off, 4) },
+			minGen: 3, maxGen: 4,
+			kind: OK,
+		},
+		{
+			name:   "never written",
+			block:  func(t *testing.T) []byte { return make([]byte, 4096) },
+			minGen: 0, maxGen: 2,
+			kind: OK,
+		},
+		{
+			name:   "zeros where a write was acknowledged",
+			block:  func(t *testing.T) []byte { return make([]byte, 4096) },
+			minGen: 2, maxGen: 2,
+			kind:   Stale,
+			detail: "reads as zeros",
+		},
+		{
+			name:   "older generation",
+			block:  func(t *testing.T) []byte { return block(t, 4096, testDisk, off, 2) },
+			minGen: 3, maxGen: 3,
+			kind:   Stale,
+			detail: "holds generation 2, expected 3",
+		},
+		{
+			name:   "newer generation than written",
+			block:  func(t *testing.T) []byte { return block(t, 4096, testDisk, off, 9) },
+			minGen: 3, maxGen: 4,
+			kind:   Stale,
+			detail: "expected 3 to 4",
+		},
+		{
+			name:   "other offset",
+			block:  func(t *testing.T) []byte { return block(t, 4096, testDisk, 2*off, 3) },
+			minGen: 3, maxGen: 3,
+			kind:   Misdirected,
+			detail: "offset 8192",
+		},
+		{
+			name:   "other disk",
+			block:  func(t *testing.T) []byte { return block(t, 4096, testDisk+1, off, 3) },
+			minGen: 3, maxGen: 3,
+			kind:   Misdirected,
+			detail: "another disk",
+		},
+		{
+			name: "flipped payload bytes",
+			block: func(t *testing.T) []byte {
+				b := block(t, 4096, testDisk, off, 3)
+				b[100] ^= 0xff
+				b[1000] ^= 0x01
+				b[1001] ^= 0x01
+				return b
+			},
+			minGen: 3, maxGen: 3,
+			kind:     Corrupt,
+			badBytes: 3,
+			ranges:   []Range{{Offset: off + 100, Length: 1}, {Offset: off + 1000, Length: 2}},
+			detail:   "checksum mismatch",
+		},
+		{
+			name: "torn write",
+			block: func(t *testing.T) []byte {
+				b := block(t, 4096, testDisk, off, 3)
+				copy(b[2048:], block(t, 4096, testDisk, off, 2)[2048:])
+				return b
+			},
+			minGen: 2, maxGen: 3,
+			kind:   Corrupt,
+			detail: "differ from generation 3",
+		},
+		{
+			name: "header overwritten",
+			block: func(t *testing.T) []byte {
+				b := block(t, 4096, testDisk, off, 3)
+				copy(b, "GARBAGE!")
+				return b
+			},
+			minGen: 3, maxGen: 3,
+			kind:   Corrupt,
+			detail: "no block header",
+		},
+		{
+			name: "block size mismatch",
+			block: func(t *testing.T) []byte {
+				return block(t, 8192, testDisk, off, 3)[:4096]
+			},
+			minGen: 3, maxGen: 3,
+			kind: Corrupt,
+		},
+		{
+			name:   "shorter than header",
+			block:  func(t *testing.T) []byte { return []byte("VDVERIFY") },
+			minGen: 3, maxGen: 3,
+			kind:     Corrupt,
+			badBytes: 8,
+			ranges:   []Range{{Offset: off, Length: 8}},
+			detail:   "shorter than the 64 byte header",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Check(tc.block(t), testDisk, off, tc.minGen, tc.maxGen)
+			if r.Kind != tc.kind {
+				t.Fatalf("Kind = %v (%s), want %v", r.Kind, r.Detail, tc.kind)
+			}
+			if r.Offset != off {
+				t.Errorf("Offset = %d, want %d", r.Offset, off)
+			}
+			if tc.badBytes != 0 && r.BadBytes != tc.badBytes {
+				t.Errorf("BadBytes = %d, want %d", r.BadBytes, tc.badBytes)
+			}
+			if tc.kind == Corrupt && r.BadBytes == 0 {
+				t.Error("corrupt block with no differing bytes")
+			}
+			if tc.ranges != nil && !slices.Equal(r.Ranges, tc.ranges) {
+				t.Errorf("Ranges = %v, want %v", r.Ranges, tc.ranges)
+			}
+			if !strings.Contains(r.Detail, tc.detail) {
+				t.Errorf("Detail = %q, want it to contain %q", r.Detail, tc.detail)
+			}
+		})
+	}
+}
+
+func TestCheckRangeLimit(t *testing.T) {
+	b := block(t, 4096, testDisk, 0, 1)
+	for i := 0; i < 2*maxRanges; i++ {
+		b[HeaderSize+2*i] ^= 0xff
+	}
+	r := Check(b, testDisk, 0, 1, 1)
+	if len(r.Ranges) != maxRanges {
+		t.Errorf("%d ranges recorded, want %d", len(r.Ranges), maxRanges)
+	}
+	if r.BadBytes != 2*maxRanges {
+		t.Errorf("BadBytes = %d, want %d", r.BadBytes, 2*maxRanges)
+	}
+}
@@ -0,0 +1,346 @@
+// Package workload generates synthetic vdisk I/O: the mix of reads and
+// writes, the offsets they access within a disk region and their sizes.
+// A Generator is safe for concurrent use, so many workers can draw
+// operations from one shared access pattern.
+package workload
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Operations returned in Op.Operation
+const (
+	OpRead  = "read"
+	OpWrite = "write"
+)
+
+// Access patterns
+const (
+	// Stride steps through 1000 offsets 1 KiB apart from the region start,
+	// ignoring alignment and region size
+	Stride = "stride"
+	// Sequential walks the region in order, wrapping at its end
+	Sequential = "sequential"
+	// Uniform picks every aligned offset in the region with equal probability
+	Uniform = "uniform"
+	// Zipfian favours a small set of offsets, scattered over the region
+	Zipfian = "zipfian"
+	// Hotspot sends HotspotPercent of the I/O to the first HotspotFraction
+	// of the region and the rest uniformly to the remainder
+	Hotspot = "hotspot"
+)
+
+// Defaults applied by NewGenerator to unset Profile fields. A zero
+// HotspotPercent is valid, so DefaultHotspotPercent is only applied by
+// callers such as the -hotspot_percent flag.
+const (
+	DefaultAlignment       = 512
+	DefaultZipfExponent    = 1.1
+	DefaultHotspotFraction = 0.1
+	DefaultHotspotPercent  = 90
+)
+
+// BlockSize is one entry of a block size distribution
+type BlockSize struct {
+	Size   int64
+	Weight float64
+}
+
+// Profile describes a workload
+type Profile struct {
+	// ReadPercent is the share of operations that are reads, from 0 to 100
+	ReadPercent float64
+	// Pattern is one of Stride, Sequential, Uniform, Zipfian or Hotspot
+	Pattern string
+	// RegionOffset and RegionSize bound the part of the disk accessed
+	RegionOffset int64
+	RegionSize   int64
+	// BlockSizes is the distribution of request sizes. Weights are relative.
+	BlockSizes []BlockSize
+	// Alignment is the granularity of generated offsets
+	Alignment int64
+	// ZipfExponent is the skew of the Zipfian pattern and must exceed 1
+	ZipfExponent float64
+	// HotspotFraction and HotspotPercent shape the Hotspot pattern. A
+	// HotspotPercent of 0 sends all I/O outside the hot fraction.
+	HotspotFraction float64
+	HotspotPercent  float64
+	// Seed makes the generated sequence reproducible
+	Seed int64
+}
+
+// MaxBlockSize returns the largest block size of the profile
+func (p Profile) MaxBlockSize() int64 {
+	var largest int64
+	for _, b := range p.BlockSizes {
+		largest = max(largest, b.Size)
+	}
+	return largest
+}
+
+// MeanBlockSize returns the weighted mean block size of the profile
+func (p Profile) MeanBlockSize() float64 {
+	var sum, weights float64
+	for _, b := range p.BlockSizes {
+		sum += float64(b.Size) * b.Weight
+		weights += b.Weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	return sum / weights
+}
+
+// String describes the profile in one line
+func (p Profile) String() string {
+	var weights float64
+	for _, b := range p.BlockSizes {
+		weights += b.Weight
+	}
+	sizes := make([]string, len(p.BlockSizes))
+	for i, b := range p.BlockSizes {
+		sizes[i] = fmt.Sprintf("%s:%.3g%%", FormatSize(b.Size), b.Weight/weights*100)
+	}
+	region := fmt.Sprintf("[%d, %d)", p.RegionOffset, p.RegionOffset+p.RegionSize)
+	if p.Pattern == Stride {
+		region = fmt.Sprintf("from %d", p.RegionOffset)
+	}
+	desc := fmt.Sprintf("%.0f%% reads, %s over %s, block sizes %s", p.ReadPercent, p.Pattern, region, strings.Join(sizes, ","))
+	switch p.Pattern {
+	case Zipfian:
+		desc += fmt.Sprintf(", exponent %g", p.ZipfExponent)
+	case Hotspot:
+		desc += fmt.Sprintf(", %g%% of I/O to %g%% of the region", p.HotspotPercent, p.HotspotFraction*100)
+	}
+	if p.Pattern != Stride {
+		desc += fmt.Sprintf(", %s aligned", FormatSize(p.Alignment))
+	}
+	return desc
+}
+
+// Op is one generated operation
+type Op struct {
+	Operation string
+	Offset    int64
+	Length    int64
+}
+
+// Generator draws operations from a Profile
+type Generator struct {
+	mu      sync.Mutex
+	profile Profile
+	rng     *rand.Rand
+	zipf    *rand.Zipf
+
+	cumulative []float64 // Cumulative block size weights
+	start      int64     // Region start rounded up to the alignment
+	end        int64     // Region end
+	slots      int64     // Aligned offsets at which the largest block fits
+	next       int64     // Sequential cursor or stride counter
+}
+
+// NewGenerator validates p, fills in defaults and returns a Generator for it
+func NewGenerator(p Profile) (*Generator, error) {
+	if p.ReadPercent < 0 || p.ReadPercent > 100 {
+		return nil, fmt.Errorf("read percent %g is outside [0, 100]", p.ReadPercent)
+	}
+	if len(p.BlockSizes) == 0 {
+		return nil, fmt.Errorf("no block sizes given")
+	}
+	var weights float64
+	for _, b := range p.BlockSizes {
+		if b.Size < 0 || (b.Size == 0 && p.Pattern != Stride) {
+			return nil, fmt.Errorf("invalid block size %d", b.Size)
+		}
+		if b.Weight <= 0 {
+			return nil, fmt.Errorf("block size %s has non-positive weight %g", FormatSize(b.Size), b.Weight)
+		}
+		weights += b.Weight
+	}
+	if p.RegionOffset < 0 {
+		return nil, fmt.Errorf("negative region offset %d", p.RegionOffset)
+	}
+	if p.Alignment == 0 {
+		p.Alignment = DefaultAlignment
+	}
+	if p.Alignment < 0 {
+		return nil, fmt.Errorf("negative alignment %d", p.Alignment)
+	}
+	if p.ZipfExponent == 0 {
+		p.ZipfExponent = DefaultZipfExponent
+	}
+	if p.HotspotFraction == 0 {
+		p.HotspotFraction = DefaultHotspotFraction
+	}
+
+	g := &Generator{
+		profile: p,
+		rng:     rand.New(rand.NewSource(p.Seed)),
+	}
+	var total float64
+	for _, b := range p.BlockSizes {
+		total += b.Weight
+		g.cumulative = append(g.cumulative, total/weights)
+	}
+
+	switch p.Pattern {
+	case Stride:
+		return g, nil
+	case Sequential, Uniform, Zipfian, Hotspot:
+	default:
+		return nil, fmt.Errorf("unknown access pattern %q", p.Pattern)
+	}
+
+	g.start = (p.RegionOffset + p.Alignment - 1) / p.Alignment * p.Alignment
+	g.end = p.RegionOffset + p.RegionSize
+	if g.end-g.start < p.MaxBlockSize() {
+		return nil, fmt.Errorf("region [%d, %d) is too small for %s aligned %s blocks",
+			p.RegionOffset, g.end, FormatSize(p.Alignment), FormatSize(p.MaxBlockSize()))
+	}
+	g.slots = (g.end-g.start-p.MaxBlockSize())/p.Alignment + 1
+
+	switch p.Pattern {
+	case Zipfian:
+		if p.ZipfExponent <= 1 {
+			return nil, fmt.Errorf("zipf exponent must be greater than 1, got %g", p.ZipfExponent)
+		}
+		g.zipf = rand.NewZipf(g.rng, p.ZipfExponent, 1, uint64(g.slots-1))
+	case Hotspot:
+		if p.HotspotFraction <= 0 || p.HotspotFraction >= 1 {
+			return nil, fmt.Errorf("hotspot fraction %g is outside (0, 1)", p.HotspotFraction)
+		}
+		if p.HotspotPercent < 0 || p.HotspotPercent > 100 {
+			return nil, fmt.Errorf("hotspot percent %g is outside [0, 100]", p.HotspotPercent)
+		}
+	}
+	return g, nil
+}
+
+// Profile returns the profile with defaults applied
+func (g *Generator) Profile() Profile {
+	return g.profile
+}
+
+// Next returns the next operation
+func (g *Generator) Next() Op {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p := &g.profile
+	op := Op{Operation: OpWrite, Length: p.BlockSizes[0].Size}
+	if p.ReadPercent >= 100 || (p.ReadPercent > 0 && g.rng.Float64()*100 < p.ReadPercent) {
+		op.Operation = OpRead
+	}
+	if len(p.BlockSizes) > 1 {
+		i := sort.SearchFloat64s(g.cumulative, g.rng.Float64())
+		op.Length = p.BlockSizes[min(i, len(p.BlockSizes)-1)].Size
+	}
+
+	switch p.Pattern {
+	case Stride:
+		op.Offset = p.RegionOffset + (g.next%1000)*1024
+		g.next++
+	case Sequential:
+		if g.start+g.next+op.Length > g.end {
+			g.next = 0
+		}
+		op.Offset = g.start + g.next
+		g.next += (op.Length + p.Alignment - 1) / p.Alignment * p.Alignment
+	case Uniform:
+		op.Offset = g.slotOffset(g.rng.Int63n(g.slots))
+	case Zipfian:
+		// Scatter the popular ranks over the region instead of packing them
+		// at its start
+		rank := g.zipf.Uint64()
+		op.Offset = g.slotOffset(int64((rank * 0x9e3779b97f4a7c15) % uint64(g.slots)))
+	case Hotspot:
+		hot := max(int64(float64(g.slots)*p.HotspotFraction), 1)
+		if hot >= g.slots || g.rng.Float64()*100 < p.HotspotPercent {
+			op.Offset = g.slotOffset(g.rng.Int63n(hot))
+		} else {
+			op.Offset = g.slotOffset(hot + g.rng.Int63n(g.slots-hot))
+		}
+	}
+	return op
+}
+
+func (g *Generator) slotOffset(slot int64) int64 {
+	return g.start + slot*g.profile.Alignment
+}
+
+// sizeUnits are the binary suffixes accepted by ParseSize
+var sizeUnits = []struct {
+	suffix string
+	shift  uint
+}{{"T", 40}, {"G", 30}, {"M", 20}, {"K", 10}}
+
+// ParseSize parses a byte count such as 4096, 4K, 64KiB or 1M. Suffixes are
+// binary and case-insensitive.
+func ParseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "IB"), "B")
+	var shift uint
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v, shift = strings.TrimSuffix(v, u.suffix), u.shift
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("size %q overflows int64", s)
+	}
+	return n << shift, nil
+}
+
+// FormatSize formats n with the largest binary suffix that divides it
+func FormatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n != 0 && n%(1<<u.shift) == 0 {
+			return fmt.Sprintf("%d%s", n>>u.shift, u.suffix)
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// ParseBlockSizes parses a block size distribution such as
+// "4K:30%,64K:50%,1M:20%". Weights are relative and the percent sign is
+// optional; a size without a weight has weight 1.
+func ParseBlockSizes(s string) ([]BlockSize, error) {
+	var sizes []BlockSize
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		sizeStr, weightStr, hasWeight := strings.Cut(entry, ":")
+		size, err := ParseSize(sizeStr)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, fmt.Errorf("block size must be positive in %q", entry)
+		}
+		weight := 1.0
+		if hasWeight {
+			weight, err = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(weightStr), "%"), 64)
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight in %q", entry)
+			}
+		}
+		sizes = append(sizes, BlockSize{Size: size, Weight: weight})
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("empty block size distribution")
+	}
+	return sizes, nil
+}
@@ -0,0 +1,385 @@
+package workload
+
+import (
+	"math"
+	"testing"
+)
+
+// draw returns n operations from a generator for p
+func draw(t *testing.T, p Profile, n int) []Op {
+	t.Helper()
+	g, err := NewGenerator(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := make([]Op, n)
+	for i := range ops {
+		ops[i] = g.Next()
+	}
+	return ops
+}
+
+// share returns the fraction of ops for which match holds, in percent
+func share(ops []Op, match func(Op) bool) float64 {
+	var n int
+	for _, op := range ops {
+		if match(op) {
+			n++
+		}
+	}
+	return float64(n) / float64(len(ops)) * 100
+}
+
+func blocks(sizes ...int64) []BlockSize {
+	b := make([]BlockSize, len(sizes))
+	for i, size := range sizes {
+		b[i] = BlockSize{Size: size, Weight: 1}
+	}
+	return b
+}
+
+func TestReadPercent(t *testing.T) {
+	for _, percent := range []float64{0, 1, 30, 70, 99, 100} {
+		ops := draw(t, Profile{
+			ReadPercent: percent,
+			Pattern:     Uniform,
+			RegionSize:  1 << 20,
+			BlockSizes:  blocks(4096),
+			Seed:        1,
+		}, 50000)
+		got := share(ops, func(op Op) bool { return op.Operation == OpRead })
+		if (percent == 0 || percent == 100) && got != percent {
+			t.Errorf("ReadPercent %g: %g%% reads, want exactly %g%%", percent, got, percent)
+		} else if math.Abs(got-percent) > 1 {
+			t.Errorf("ReadPercent %g: %.2f%% reads", percent, got)
+		}
+	}
+}
+
+func TestBlockSizeWeights(t *testing.T) {
+	sizes, err := ParseBlockSizes("4K:30%,64K:50%,1M:20%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := draw(t, Profile{Pattern: Uniform, RegionSize: 1 << 30, BlockSizes: sizes, Seed: 1}, 50000)
+	for _, b := range sizes {
+		got := share(ops, func(op Op) bool { return op.Length == b.Size })
+		if math.Abs(got-b.Weight) > 1 {
+			t.Errorf("%s: %.2f%% of operations, want %g%%", FormatSize(b.Size), got, b.Weight)
+		}
+	}
+
+	// Weights are relative
+	ops = draw(t, Profile{Pattern: Uniform, RegionSize: 1 << 20, BlockSizes: []BlockSize{{4096, 1}, {8192, 3}}, Seed: 1}, 50000)
+	if got := share(ops, func(op Op) bool { return op.Length == 8192 }); math.Abs(got-75) > 1 {
+		t.Errorf("weights 1:3 gave %.2f%% of the larger size, want 75%%", got)
+	}
+}
+
+func TestSequentialWraps(t *testing.T) {
+	// The region starts unaligned, so the first offset is rounded up to 1024
+	p := Profile{
+		Pattern:      Sequential,
+		RegionOffset: 1000,
+		RegionSize:   20000,
+		Alignment:    512,
+		BlockSizes:   blocks(4096, 3000),
+		Seed:         1,
+	}
+	ops := draw(t, p, 200)
+	if ops[0].Offset != 1024 {
+		t.Fatalf("first offset %d, want 1024", ops[0].Offset)
+	}
+	wraps := 0
+	for i := 1; i < len(ops); i++ {
+		prev, op := ops[i-1], ops[i]
+		// Each operation starts at the aligned end of the previous one,
+		// unless that would run past the region
+		next := prev.Offset + (prev.Length+511)/512*512
+		switch {
+		case op.Offset == next:
+		case op.Offset == 1024 && next+op.Length > 21000:
+			wraps++
+		default:
+			t.Fatalf("op %d at %d after %+v", i, op.Offset, prev)
+		}
+	}
+	if wraps == 0 {
+		t.Error("sequential pattern never wrapped")
+	}
+}
+
+func TestOffsetsStayInRegion(t *testing.T) {
+	for _, pattern := range []string{Sequential, Uniform, Zipfian, Hotspot} {
+		for _, tc := range []struct {
+			name                string
+			offset, size, align int64
+			sizes               []BlockSize
+		}{
+			{"aligned", 1 << 20, 1 << 24, 4096, blocks(4096, 65536, 1<<20)},
+			{"unaligned region", 12345, 1 << 20, 4096, blocks(4096, 8192)},
+			{"exact fit", 8192, 65536, 8192, blocks(65536)},
+			{"odd alignment", 100, 50000, 3000, blocks(3000, 7000)},
+		} {
+			p := Profile{
+				Pattern:      pattern,
+				RegionOffset: tc.offset,
+				RegionSize:   tc.size,
+				Alignment:    tc.align,
+				BlockSizes:   tc.sizes,
+				Seed:         1,
+			}
+			for i, op := range draw(t, p, 5000) {
+				if op.Offset < tc.offset || op.Offset+op.Length > tc.offset+tc.size || op.Offset%tc.align != 0 {
+					t.Fatalf("%s/%s: op %d [%d, %d) is outside [%d, %d) or not %d aligned",
+						pattern, tc.name, i, op.Offset, op.Offset+op.Length, tc.offset, tc.offset+tc.size, tc.align)
+				}
+			}
+		}
+	}
+}
+
+func TestUniformCoversRegion(t *testing.T) {
+	// 64 slots, each expected 1/64 of the operations
+	ops := draw(t, Profile{Pattern: Uniform, RegionSize: 64 * 4096, Alignment: 4096, BlockSizes: blocks(4096), Seed: 1}, 64000)
+	counts := map[int64]int{}
+	for _, op := range ops {
+		counts[op.Offset]++
+	}
+	if len(counts) != 64 {
+		t.Fatalf("%d distinct offsets, want 64", len(counts))
+	}
+	for off, n := range counts {
+		if n < 800 || n > 1200 {
+			t.Errorf("offset %d drawn %d times, want about 1000", off, n)
+		}
+	}
+}
+
+func TestZipfianSkew(t *testing.T) {
+	p := Profile{Pattern: Zipfian, RegionSize: 1000 * 4096, Alignment: 4096, BlockSizes: blocks(4096), Seed: 1}
+	ops := draw(t, p, 50000)
+	counts := map[int64]int{}
+	for _, op := range ops {
+		counts[op.Offset]++
+	}
+	var top int64
+	for off, n := range counts {
+		if n > counts[top] {
+			top = off
+		}
+	}
+	// With exponent 1.1 over 1000 slots the most popular offset takes about
+	// 18% of the I/O, against 0.1% for a uniform pattern
+	if got := float64(counts[top]) / float64(len(ops)) * 100; got < 10 {
+		t.Errorf("most popular offset has %.2f%% of the I/O", got)
+	}
+	// Popular ranks are scattered rather than packed at the region start
+	var popular, upper int
+	for off, n := range counts {
+		if n >= 500 {
+			popular++
+			if off >= 500*4096 {
+				upper++
+			}
+		}
+	}
+	if popular < 5 || upper == 0 {
+		t.Errorf("%d offsets drew 10 times their uniform share, %d of them in the upper half", popular, upper)
+	}
+
+	g, err := NewGenerator(Profile{Pattern: Zipfian, RegionSize: 1 << 20, BlockSizes: blocks(4096)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Profile().ZipfExponent != DefaultZipfExponent {
+		t.Errorf("default exponent %g, want %g", g.Profile().ZipfExponent, DefaultZipfExponent)
+	}
+}
+
+func TestHotspot(t *testing.T) {
+	// 1000 slots of which the first 100 are hot
+	const slots, align = 1000, 4096
+	for _, percent := range []float64{0, 10, 50, 90, 100} {
+		ops := draw(t, Profile{
+			Pattern:         Hotspot,
+			RegionSize:      slots * align,
+			Alignment:       align,
+			BlockSizes:      blocks(align),
+			HotspotFraction: 0.1,
+			HotspotPercent:  percent,
+			Seed:            1,
+		}, 50000)
+		got := share(ops, func(op Op) bool { return op.Offset < slots/10*align })
+		if (percent == 0 || percent == 100) && got != percent {
+			t.Errorf("HotspotPercent %g: %g%% of I/O to the hot region, want exactly %g%%", percent, got, percent)
+		} else if math.Abs(got-percent) > 1 {
+			t.Errorf("HotspotPercent %g: %.2f%% of I/O to the hot region", percent, got)
+		}
+	}
+}
+
+func TestStride(t *testing.T) {
+	ops := draw(t, Profile{Pattern: Stride, RegionOffset: 777, BlockSizes: blocks(0)}, 2500)
+	for i, op := range ops {
+		if want := 777 + int64(i%1000)*1024; op.Offset != want {
+			t.Fatalf("op %d at %d, want %d", i, op.Offset, want)
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	p := Profile{
+		ReadPercent: 50,
+		Pattern:     Zipfian,
+		RegionSize:  1 << 30,
+		BlockSizes:  []BlockSize{{4096, 1}, {65536, 1}},
+		Seed:        42,
+	}
+	a, b := draw(t, p, 1000), draw(t, p, 1000)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("op %d differs with the same seed: %+v, %+v", i, a[i], b[i])
+		}
+	}
+
+	p.Seed = 43
+	c := draw(t, p, 1000)
+	same := 0
+	for i := range a {
+		if a[i] == c[i] {
+			same++
+		}
+	}
+	if same == len(a) {
+		t.Error("a different seed produced the same operations")
+	}
+}
+
+func TestNewGeneratorErrors(t *testing.T) {
+	valid := Profile{Pattern: Uniform, RegionSize: 1 << 20, BlockSizes: blocks(4096)}
+	for _, tc := range []struct {
+		name   string
+		modify func(*Profile)
+	}{
+		{"read percent below 0", func(p *Profile) { p.ReadPercent = -1 }},
+		{"read percent above 100", func(p *Profile) { p.ReadPercent = 101 }},
+		{"no block sizes", func(p *Profile) { p.BlockSizes = nil }},
+		{"zero block size", func(p *Profile) { p.BlockSizes = blocks(0) }},
+		{"negative block size", func(p *Profile) { p.BlockSizes = blocks(-4096) }},
+		{"zero weight", func(p *Profile) { p.BlockSizes = []BlockSize{{4096, 0}} }},
+		{"negative region offset", func(p *Profile) { p.RegionOffset = -1 }},
+		{"negative alignment", func(p *Profile) { p.Alignment = -512 }},
+		{"unknown pattern", func(p *Profile) { p.Pattern = "random" }},
+		{"region smaller than a block", func(p *Profile) { p.RegionSize = 2048 }},
+		{"region too small after alignment", func(p *Profile) { p.RegionOffset, p.RegionSize = 1, 4096 }},
+		{"zipf exponent of 1", func(p *Profile) { p.Pattern, p.ZipfExponent = Zipfian, 1 }},
+		{"hotspot fraction of 1", func(p *Profile) { p.Pattern, p.HotspotFraction = Hotspot, 1 }},
+		{"negative hotspot fraction", func(p *Profile) { p.Pattern, p.HotspotFraction = Hotspot, -0.1 }},
+		{"hotspot percent above 100", func(p *Profile) { p.Pattern, p.HotspotPercent = Hotspot, 101 }},
+		{"negative hotspot percent", func(p *Profile) { p.Pattern, p.HotspotPercent = Hotspot, -1 }},
+	} {
+		p := valid
+		tc.modify(&p)
+		if _, err := NewGenerator(p); err == nil {
+			t.Errorf("%s: NewGenerator succeeded", tc.name)
+		}
+	}
+
+	g, err := NewGenerator(Profile{Pattern: Hotspot, RegionSize: 1 << 20, BlockSizes: blocks(4096)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := g.Profile()
+	if p.Alignment != DefaultAlignment || p.HotspotFraction != DefaultHotspotFraction || p.HotspotPercent != 0 {
+		t.Errorf("defaults gave alignment %d, hotspot fraction %g, hotspot percent %g; want %d, %g, 0",
+			p.Alignment, p.HotspotFraction, p.HotspotPercent, DefaultAlignment, DefaultHotspotFraction)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"4096", 4096},
+		{"4k", 4096},
+		{"4K", 4096},
+		{"4KB", 4096},
+		{"64KiB", 64 << 10},
+		{"1m", 1 << 20},
+		{" 2G ", 2 << 30},
+		{"3t", 3 << 40},
+		{"512b", 512},
+		{"8388607T", 8388607 << 40},
+		{"9223372036854775807", math.MaxInt64},
+	} {
+		got, err := ParseSize(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", tc.in, got, err, tc.want)
+		}
+	}
+
+	for _, in := range []string{"", "K", "-1", "-4K", "1.5M", "4X", "abc", "8388608T", "100000000T", "8796093022208M", "9223372036854775808"} {
+		if got, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0"},
+		{1000, "1000"},
+		{4096, "4K"},
+		{6144, "6K"},
+		{1 << 20, "1M"},
+		{3 << 30, "3G"},
+		{5 << 40, "5T"},
+	} {
+		if got := FormatSize(tc.n); got != tc.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tc.n, got, tc.want)
+		}
+		if back, err := ParseSize(tc.want); err != nil || back != tc.n {
+			t.Errorf("ParseSize(FormatSize(%d)) = %d, %v", tc.n, back, err)
+		}
+	}
+}
+
+func TestParseBlockSizes(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []BlockSize
+	}{
+		{"4K", []BlockSize{{4096, 1}}},
+		{"4K:30%,64K:50%,1M:20%", []BlockSize{{4096, 30}, {65536, 50}, {1 << 20, 20}}},
+		{" 4k : 1 , 8k:3 ,", []BlockSize{{4096, 1}, {8192, 3}}},
+		{"512,4K:2.5", []BlockSize{{512, 1}, {4096, 2.5}}},
+	} {
+		got, err := ParseBlockSizes(tc.in)
+		if err != nil {
+			t.Errorf("ParseBlockSizes(%q): %v", tc.in, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("ParseBlockSizes(%q) = %v, want %v", tc.in, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("ParseBlockSizes(%q) = %v, want %v", tc.in, got, tc.want)
+				break
+			}
+		}
+	}
+
+	for _, in := range []string{"", ",", "0", "4K:0", "4K:-1", "4K:x", "x:10", "4K:10%,100000000T"} {
+		if got, err := ParseBlockSizes(in); err == nil {
+			t.Errorf("ParseBlockSizes(%q) = %v, want an error", in, got)
+		}
+	}
+}